@@ -20,6 +20,7 @@ import (
 	"github.com/restic/restic/internal/archiver"
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/filter"
 	"github.com/restic/restic/internal/fs"
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
@@ -36,6 +37,12 @@ var cmdBackup = &cobra.Command{
 The "backup" command creates a new snapshot and saves the files and directories
 given as the arguments.
 
+While the backup is running, a partial snapshot is saved periodically (see
+--checkpoint-interval). It is tagged "partial" and removed once the backup has
+completed. When a backup is interrupted, the next backup of the same files uses
+the partial snapshot as its parent, so that files which have already been saved
+are not read again.
+` + filterRulesHelp + `
 EXIT STATUS
 ===========
 
@@ -78,22 +85,28 @@ Exit status is 3 if some source data could not be read (incomplete snapshot crea
 
 // BackupOptions bundles all options for the backup command.
 type BackupOptions struct {
-	Parent              string
-	Force               bool
-	Excludes            []string
-	InsensitiveExcludes []string
-	ExcludeFiles        []string
-	ExcludeOtherFS      bool
-	ExcludeIfPresent    []string
-	ExcludeCaches       bool
-	Stdin               bool
-	StdinFilename       string
-	Tags                []string
-	Host                string
-	FilesFrom           []string
-	TimeStamp           string
-	WithAtime           bool
-	IgnoreInode         bool
+	excludePatternOptions
+
+	Parent             string
+	Force              bool
+	ExcludeOtherFS     bool
+	ExcludeIfPresent   []string
+	ExcludeCaches      bool
+	ExcludeLargerThan  string
+	ExcludeIgnoreFiles []string
+	ExcludeRules       []string
+	Stdin              bool
+	StdinFilename      string
+	Tags               []string
+	Host               string
+	FilesFrom          []string
+	TimeStamp          string
+	WithAtime          bool
+	IgnoreInode        bool
+
+	CheckpointInterval  time.Duration
+	FileChangeDetection string
+	SkipIfUnchanged     bool
 }
 
 var backupOptions BackupOptions
@@ -107,12 +120,13 @@ func init() {
 	f := cmdBackup.Flags()
 	f.StringVar(&backupOptions.Parent, "parent", "", "use this parent `snapshot` (default: last snapshot in the repo that has the same target files/directories)")
 	f.BoolVarP(&backupOptions.Force, "force", "f", false, `force re-reading the target files/directories (overrides the "parent" flag)`)
-	f.StringArrayVarP(&backupOptions.Excludes, "exclude", "e", nil, "exclude a `pattern` (can be specified multiple times)")
-	f.StringArrayVar(&backupOptions.InsensitiveExcludes, "iexclude", nil, "same as --exclude `pattern` but ignores the casing of filenames")
-	f.StringArrayVar(&backupOptions.ExcludeFiles, "exclude-file", nil, "read exclude patterns from a `file` (can be specified multiple times)")
+	initExcludePatternOptions(f, &backupOptions.excludePatternOptions)
 	f.BoolVarP(&backupOptions.ExcludeOtherFS, "one-file-system", "x", false, "exclude other file systems")
 	f.StringArrayVar(&backupOptions.ExcludeIfPresent, "exclude-if-present", nil, "takes `filename[:header]`, exclude contents of directories containing filename (except filename itself) if header of that file is as provided (can be specified multiple times)")
 	f.BoolVar(&backupOptions.ExcludeCaches, "exclude-caches", false, `excludes cache directories that are marked with a CACHEDIR.TAG file. See https://bford.info/cachedir/ for the Cache Directory Tagging Standard`)
+	f.StringArrayVar(&backupOptions.ExcludeIgnoreFiles, "exclude-ignorefile", nil, "read gitignore-style exclude patterns from files called `filename` in each directory, they apply to the directory and its subdirectories (can be specified multiple times)")
+	f.StringArrayVar(&backupOptions.ExcludeRules, "exclude-rule", nil, "exclude items matching the filter `rule`, see \"FILTER RULES\" (can be specified multiple times)")
+	f.StringVar(&backupOptions.ExcludeLargerThan, "exclude-larger-than", "", "max `size` of the files to be backed up (allowed suffixes: k/K, m/M, g/G, t/T)")
 	f.BoolVar(&backupOptions.Stdin, "stdin", false, "read backup from stdin")
 	f.StringVar(&backupOptions.StdinFilename, "stdin-filename", "stdin", "`filename` to use when reading from stdin")
 	f.StringArrayVar(&backupOptions.Tags, "tag", nil, "add a `tag` for the new snapshot (can be specified multiple times)")
@@ -125,6 +139,9 @@ func init() {
 	f.StringVar(&backupOptions.TimeStamp, "time", "", "`time` of the backup (ex. '2012-11-01 22:08:41') (default: now)")
 	f.BoolVar(&backupOptions.WithAtime, "with-atime", false, "store the atime for all files and directories")
 	f.BoolVar(&backupOptions.IgnoreInode, "ignore-inode", false, "ignore inode number changes when checking for modified files")
+	f.StringVar(&backupOptions.FileChangeDetection, "file-change-detection", "", "detect modified files by `mode`: mtime, ctime, size or content (default: compare mtime, ctime, size and inode)")
+	f.BoolVar(&backupOptions.SkipIfUnchanged, "skip-if-unchanged", false, "skip creating a snapshot if nothing has changed since the parent snapshot")
+	f.DurationVar(&backupOptions.CheckpointInterval, "checkpoint-interval", 30*time.Minute, "save a partial snapshot every `duration` while the backup is running, so that an interrupted backup can be resumed (0 disables partial snapshots)")
 }
 
 // filterExisting returns a slice of all existing items, or an error if no
@@ -230,22 +247,11 @@ func collectRejectByNameFuncs(opts BackupOptions, repo *repository.Repository, t
 		fs = append(fs, f)
 	}
 
-	// add patterns from file
-	if len(opts.ExcludeFiles) > 0 {
-		excludes, err := readExcludePatternsFromFiles(opts.ExcludeFiles)
-		if err != nil {
-			return nil, err
-		}
-		opts.Excludes = append(opts.Excludes, excludes...)
-	}
-
-	if len(opts.InsensitiveExcludes) > 0 {
-		fs = append(fs, rejectByInsensitivePattern(opts.InsensitiveExcludes))
-	}
-
-	if len(opts.Excludes) > 0 {
-		fs = append(fs, rejectByPattern(opts.Excludes))
+	fsPatterns, err := opts.excludePatternOptions.CollectPatterns()
+	if err != nil {
+		return nil, err
 	}
+	fs = append(fs, fsPatterns...)
 
 	if opts.ExcludeCaches {
 		opts.ExcludeIfPresent = append(opts.ExcludeIfPresent, "CACHEDIR.TAG:Signature: 8a477f597d28d172789f06886806bc55")
@@ -275,6 +281,32 @@ func collectRejectFuncs(opts BackupOptions, repo *repository.Repository, targets
 		fs = append(fs, f)
 	}
 
+	if !opts.Stdin {
+		for _, filename := range opts.ExcludeIgnoreFiles {
+			f, err := rejectByIgnoreFile(filename, targets)
+			if err != nil {
+				return nil, err
+			}
+			fs = append(fs, f)
+		}
+	}
+
+	if len(opts.ExcludeRules) > 0 {
+		rules, err := filter.ParseRules(opts.ExcludeRules)
+		if err != nil {
+			return nil, errors.Fatalf("%v", err)
+		}
+		fs = append(fs, rejectByRules(rules))
+	}
+
+	if len(opts.ExcludeLargerThan) != 0 && !opts.Stdin {
+		f, err := rejectBySize(opts.ExcludeLargerThan)
+		if err != nil {
+			return nil, errors.Fatalf("invalid value for --exclude-larger-than: %v", err)
+		}
+		fs = append(fs, f)
+	}
+
 	return fs, nil
 }
 
@@ -369,6 +401,35 @@ func collectTargets(opts BackupOptions, args []string) (targets []string, err er
 	return targets, nil
 }
 
+// parseChangeDetection returns the flags which configure how the archiver
+// detects modified files for the mode passed to --file-change-detection.
+func parseChangeDetection(mode string, ignoreInode bool) (archiver.ChangeDetection, error) {
+	var flags archiver.ChangeDetection
+	switch mode {
+	case "":
+		if ignoreInode {
+			flags = archiver.ChangeIgnoreCtime | archiver.ChangeIgnoreInode
+		}
+		return flags, nil
+	case "mtime":
+		flags = archiver.ChangeIgnoreCtime | archiver.ChangeIgnoreInode
+	case "ctime":
+		flags = archiver.ChangeIgnoreMtime | archiver.ChangeIgnoreInode
+	case "size":
+		flags = archiver.ChangeIgnoreMtime | archiver.ChangeIgnoreCtime | archiver.ChangeIgnoreInode
+	case "content":
+		flags = archiver.ChangeReadContent
+	default:
+		return 0, errors.Fatalf("invalid file change detection mode %q, must be one of mtime, ctime, size or content", mode)
+	}
+
+	if ignoreInode {
+		return 0, errors.Fatal("--ignore-inode cannot be combined with --file-change-detection")
+	}
+
+	return flags, nil
+}
+
 // parent returns the ID of the parent snapshot. If there is none, nil is
 // returned.
 func findParentSnapshot(ctx context.Context, repo restic.Repository, opts BackupOptions, targets []string) (parentID *restic.ID, err error) {
@@ -414,6 +475,11 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 		}
 	}
 
+	changeDetection, err := parseChangeDetection(opts.FileChangeDetection, opts.IgnoreInode)
+	if err != nil {
+		return err
+	}
+
 	var t tomb.Tomb
 
 	if gopts.verbosity >= 2 && !gopts.JSON {
@@ -431,6 +497,7 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 		CompleteBlob(filename string, bytes uint64)
 		ScannerError(item string, fi os.FileInfo, err error) error
 		ReportTotal(item string, s archiver.ScanStats)
+		ReportExcluded(item string, fi os.FileInfo)
 		SetMinUpdatePause(d time.Duration)
 		Run(ctx context.Context) error
 		Error(item string, fi os.FileInfo, err error) error
@@ -508,7 +575,12 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 	}
 
 	if !gopts.JSON && parentSnapshotID != nil {
-		p.V("using parent snapshot %v\n", parentSnapshotID.Str())
+		sn, err := restic.LoadSnapshot(gopts.ctx, repo, *parentSnapshotID)
+		if err == nil && sn.Partial {
+			p.V("resuming interrupted backup, using partial snapshot %v as parent\n", parentSnapshotID.Str())
+		} else {
+			p.V("using parent snapshot %v\n", parentSnapshotID.Str())
+		}
 	}
 
 	selectByNameFilter := func(item string) bool {
@@ -557,7 +629,14 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 
 	arch := archiver.New(repo, targetFS, archiver.Options{})
 	arch.SelectByName = selectByNameFilter
-	arch.Select = selectFilter
+	// the scanner uses the same filter, so only report excluded items once
+	arch.Select = func(item string, fi os.FileInfo) bool {
+		if !selectFilter(item, fi) {
+			p.ReportExcluded(item, fi)
+			return false
+		}
+		return true
+	}
 	arch.WithAtime = opts.WithAtime
 	success := true
 	arch.Error = func(item string, fi os.FileInfo, err error) error {
@@ -567,18 +646,29 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 	arch.CompleteItem = p.CompleteItem
 	arch.StartFile = p.StartFile
 	arch.CompleteBlob = p.CompleteBlob
-	arch.IgnoreInode = opts.IgnoreInode
+	arch.ChangeDetection = changeDetection
 
 	if parentSnapshotID == nil {
 		parentSnapshotID = &restic.ID{}
 	}
 
+	excludes := opts.Excludes
+	if opts.ExcludeLargerThan != "" && !opts.Stdin {
+		excludes = append(excludes, "--exclude-larger-than="+opts.ExcludeLargerThan)
+	}
+	for _, rule := range opts.ExcludeRules {
+		excludes = append(excludes, "--exclude-rule="+rule)
+	}
+
 	snapshotOpts := archiver.SnapshotOptions{
-		Excludes:       opts.Excludes,
+		Excludes:       excludes,
 		Tags:           opts.Tags,
 		Time:           timeStamp,
 		Hostname:       opts.Host,
 		ParentSnapshot: *parentSnapshotID,
+
+		CheckpointInterval: opts.CheckpointInterval,
+		SkipIfUnchanged:    opts.SkipIfUnchanged,
 	}
 
 	if !gopts.JSON {
@@ -598,7 +688,11 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 	// Report finished execution
 	p.Finish(id)
 	if !gopts.JSON {
-		p.P("snapshot %s saved\n", id.Str())
+		if id.IsNull() {
+			p.P("skipped creation of snapshot, nothing has changed since the parent snapshot\n")
+		} else {
+			p.P("snapshot %s saved\n", id.Str())
+		}
 	}
 	if !success {
 		return InvalidSourceData
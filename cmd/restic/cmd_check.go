@@ -22,6 +22,10 @@ var cmdCheck = &cobra.Command{
 The "check" command tests the repository for errors and reports any errors it
 finds. It can also be used to read all data and therefore simulate a restore.
 
+With --verify-checksums, the hashes of all data packs are compared with the
+pack IDs using checksums provided by the backend, without downloading the
+packs. This is supported by the local, sftp, s3 and b2 backends.
+
 By default, the "check" command will always load all data directly from the
 repository and not use a local cache.
 
@@ -41,10 +45,11 @@ Exit status is 0 if the command was successful, and non-zero if there was any er
 
 // CheckOptions bundles all options for the 'check' command.
 type CheckOptions struct {
-	ReadData       bool
-	ReadDataSubset string
-	CheckUnused    bool
-	WithCache      bool
+	ReadData        bool
+	ReadDataSubset  string
+	VerifyChecksums bool
+	CheckUnused     bool
+	WithCache       bool
 }
 
 var checkOptions CheckOptions
@@ -55,6 +60,7 @@ func init() {
 	f := cmdCheck.Flags()
 	f.BoolVar(&checkOptions.ReadData, "read-data", false, "read all data blobs")
 	f.StringVar(&checkOptions.ReadDataSubset, "read-data-subset", "", "read subset n of m data packs (format: `n/m`)")
+	f.BoolVar(&checkOptions.VerifyChecksums, "verify-checksums", false, "compare the checksums of all data packs provided by the backend with the pack IDs, without downloading the packs")
 	f.BoolVar(&checkOptions.CheckUnused, "check-unused", false, "find unused blobs")
 	f.BoolVar(&checkOptions.WithCache, "with-cache", false, "use the cache")
 }
@@ -187,6 +193,12 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 		return err
 	}
 
+	if opts.VerifyChecksums {
+		if _, ok := restic.AsChecksummer(repo.Backend()); !ok {
+			return errors.Fatal("the backend does not provide checksums, use --read-data instead")
+		}
+	}
+
 	if !gopts.NoLock {
 		Verbosef("create exclusive lock for repository\n")
 		lock, err := lockRepoExclusive(repo)
@@ -210,7 +222,7 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 	}
 
 	if dupFound {
-		Printf("This is non-critical, you can run `restic rebuild-index' to correct this\n")
+		Printf("This is non-critical, you can run `restic repair index' to correct this\n")
 	}
 
 	if len(errs) > 0 {
@@ -242,6 +254,7 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 	}
 
 	Verbosef("check snapshots, trees and blobs\n")
+
 	errChan = make(chan error)
 	go chkr.Structure(gopts.ctx, errChan)
 
@@ -264,6 +277,30 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 		}
 	}
 
+	if opts.VerifyChecksums {
+		packs := chkr.GetPacks()
+		Verbosef("verify checksums of %d data packs\n", len(packs))
+
+		p := newReadProgress(gopts, restic.Stat{Blobs: uint64(len(packs))})
+		errChan := make(chan error)
+
+		go chkr.VerifyChecksums(gopts.ctx, packs, p, errChan)
+
+		noChecksum := 0
+		for err := range errChan {
+			if checker.IsNoChecksum(err) {
+				noChecksum++
+				continue
+			}
+			errorsFound = true
+			Warnf("%v\n", err)
+		}
+
+		if noChecksum > 0 {
+			Verbosef("%d data packs have no checksum and were not verified, use --read-data to check them\n", noChecksum)
+		}
+	}
+
 	doReadData := func(bucket, totalBuckets uint) {
 		packs := restic.IDSet{}
 		for pack := range chkr.GetPacks() {
@@ -281,6 +318,13 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 			Verbosef("read all data\n")
 		}
 
+		err := restic.RestorePacks(gopts.ctx, repo.Backend(), packs)
+		if err != nil {
+			errorsFound = true
+			Warnf("%v\n", err)
+			return
+		}
+
 		p := newReadProgress(gopts, restic.Stat{Blobs: packCount})
 		errChan := make(chan error)
 
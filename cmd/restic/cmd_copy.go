@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/walker"
+
+	"github.com/spf13/cobra"
+)
+
+var cmdCopy = &cobra.Command{
+	Use:   "copy [flags] [snapshotID ...]",
+	Short: "Copy snapshots from one repository to another",
+	Long: `
+The "copy" command copies one or more snapshots from one repository to another
+repository. Note that this will have to read (download) and write (upload) the
+entire snapshot(s) due to the different encryption keys on the source and
+destination, and that transferred files are not re-chunked, which may break
+their deduplication.
+
+Only the tree and data blobs which are missing in the destination repository
+are uploaded. Snapshots which have already been copied to the destination are
+skipped.
+
+When no snapshot ID is given, all snapshots matching the host, tag and path
+filter criteria are copied.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runCopy(copyOptions, globalOptions, args)
+	},
+}
+
+// CopyOptions bundles all options for the copy command.
+type CopyOptions struct {
+	secondaryRepoOptions
+	Hosts []string
+	Tags  restic.TagLists
+	Paths []string
+}
+
+var copyOptions CopyOptions
+
+func init() {
+	cmdRoot.AddCommand(cmdCopy)
+
+	f := cmdCopy.Flags()
+	initSecondaryRepoOptions(f, &copyOptions.secondaryRepoOptions, "destination", "to copy snapshots to")
+	f.StringArrayVarP(&copyOptions.Hosts, "host", "H", nil, "only consider snapshots for this `host`, when no snapshot ID is given (can be specified multiple times)")
+	f.Var(&copyOptions.Tags, "tag", "only consider snapshots which include this `taglist`, when no snapshot ID is given")
+	f.StringArrayVar(&copyOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path`, when no snapshot ID is given")
+}
+
+func runCopy(opts CopyOptions, gopts GlobalOptions, args []string) error {
+	dstGopts, err := fillSecondaryGlobalOpts(opts.secondaryRepoOptions, gopts, "destination")
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(gopts.ctx)
+	defer cancel()
+
+	srcRepo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	dstRepo, err := OpenRepository(dstGopts)
+	if err != nil {
+		return err
+	}
+
+	if srcRepo.Config().ID == dstRepo.Config().ID {
+		return errors.Fatal("source and destination repository are the same")
+	}
+
+	if !gopts.NoLock {
+		srcLock, err := lockRepo(srcRepo)
+		defer unlockRepo(srcLock)
+		if err != nil {
+			return err
+		}
+	}
+
+	dstLock, err := lockRepo(dstRepo)
+	defer unlockRepo(dstLock)
+	if err != nil {
+		return err
+	}
+
+	debug.Log("Loading source index")
+	if err := srcRepo.LoadIndex(ctx); err != nil {
+		return err
+	}
+
+	debug.Log("Loading destination index")
+	if err := dstRepo.LoadIndex(ctx); err != nil {
+		return err
+	}
+
+	// collect the snapshots already present in the destination, indexed by
+	// their original ID
+	dstSnapshotByOriginal := make(map[restic.ID][]*restic.Snapshot)
+	for sn := range FindFilteredSnapshots(ctx, dstRepo, opts.Hosts, opts.Tags, opts.Paths, nil) {
+		if sn.Original != nil && !sn.Original.IsNull() {
+			dstSnapshotByOriginal[*sn.Original] = append(dstSnapshotByOriginal[*sn.Original], sn)
+		}
+		// the source snapshot may have been created by copying it from the
+		// destination repository before
+		dstSnapshotByOriginal[*sn.ID()] = append(dstSnapshotByOriginal[*sn.ID()], sn)
+	}
+
+	// trees which have been copied completely, shared between snapshots
+	visitedTrees := restic.NewIDSet()
+
+	for sn := range FindFilteredSnapshots(ctx, srcRepo, opts.Hosts, opts.Tags, opts.Paths, args) {
+		Verbosef("\nsnapshot %s of %v at %s\n", sn.ID().Str(), sn.Paths, sn.Time)
+
+		if sn.Tree == nil {
+			Warnf("snapshot %s has nil tree, skipping\n", sn.ID().Str())
+			continue
+		}
+
+		srcOriginal := *sn.ID()
+		if sn.Original != nil {
+			srcOriginal = *sn.Original
+		}
+
+		if dstSn := findCopiedSnapshot(dstSnapshotByOriginal[srcOriginal], sn); dstSn != nil {
+			Verbosef("skipping source snapshot %s, was already copied to snapshot %s\n", sn.ID().Str(), dstSn.ID().Str())
+			continue
+		}
+
+		Verbosef("  copy started, this may take a while...\n")
+		if err := restoreCopyPacks(ctx, srcRepo, dstRepo, *sn.Tree); err != nil {
+			return err
+		}
+		if err := copyTree(ctx, srcRepo, dstRepo, *sn.Tree, visitedTrees); err != nil {
+			return err
+		}
+		debug.Log("tree copied")
+
+		if err := dstRepo.Flush(ctx); err != nil {
+			return err
+		}
+		debug.Log("flushed packs and saved index")
+
+		// the parent snapshot does not exist in the destination repository
+		sn.Parent = nil
+		// retain the original snapshot id over all copies
+		if sn.Original == nil {
+			sn.Original = sn.ID()
+		}
+
+		newID, err := dstRepo.SaveJSONUnpacked(ctx, restic.SnapshotFile, sn)
+		if err != nil {
+			return err
+		}
+		Verbosef("snapshot %s saved\n", newID.Str())
+	}
+
+	return nil
+}
+
+// findCopiedSnapshot returns the snapshot from candidates which is a copy of
+// sn, or nil if there is none.
+func findCopiedSnapshot(candidates []*restic.Snapshot, sn *restic.Snapshot) *restic.Snapshot {
+	for _, candidate := range candidates {
+		if similarSnapshots(candidate, sn) {
+			return candidate
+		}
+	}
+	return nil
+}
+
+// similarSnapshots returns true if both snapshots refer to the same tree and
+// have the same metadata.
+func similarSnapshots(sna *restic.Snapshot, snb *restic.Snapshot) bool {
+	if sna.Tree == nil || snb.Tree == nil || !sna.Tree.Equal(*snb.Tree) {
+		return false
+	}
+	if !sna.Time.Equal(snb.Time) || sna.Hostname != snb.Hostname || sna.Username != snb.Username {
+		return false
+	}
+	if len(sna.Paths) != len(snb.Paths) || len(sna.Tags) != len(snb.Tags) {
+		return false
+	}
+	for i := range sna.Paths {
+		if sna.Paths[i] != snb.Paths[i] {
+			return false
+		}
+	}
+	for i := range sna.Tags {
+		if sna.Tags[i] != snb.Tags[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// restoreCopyPacks restores the packs in srcRepo which contain data blobs
+// referenced by the tree rootTreeID that are not yet contained in dstRepo, if
+// they are kept in cold storage.
+func restoreCopyPacks(ctx context.Context, srcRepo, dstRepo restic.Repository, rootTreeID restic.ID) error {
+	if _, ok := restic.AsColdStorage(srcRepo.Backend()); !ok {
+		return nil
+	}
+
+	blobs := restic.NewBlobSet()
+	if err := restic.FindUsedBlobs(ctx, srcRepo, rootTreeID, blobs, restic.NewBlobSet()); err != nil {
+		return err
+	}
+
+	for h := range blobs {
+		if dstRepo.Index().Has(h.ID, h.Type) {
+			blobs.Delete(h)
+		}
+	}
+
+	return restic.RestoreBlobs(ctx, srcRepo, blobs)
+}
+
+// copyTree walks the tree rootTreeID in srcRepo and saves all tree and data
+// blobs which are not yet contained in dstRepo. Trees in visitedTrees have
+// already been copied and are not walked again, copied trees are added to
+// the set.
+func copyTree(ctx context.Context, srcRepo, dstRepo restic.Repository, rootTreeID restic.ID, visitedTrees restic.IDSet) error {
+	var buf []byte
+
+	copyBlob := func(t restic.BlobType, id restic.ID) error {
+		if dstRepo.Index().Has(id, t) {
+			return nil
+		}
+
+		debug.Log("copy %v blob %v", t, id.Str())
+
+		var err error
+		buf, err = srcRepo.LoadBlob(ctx, t, id, buf)
+		if err != nil {
+			return errors.Errorf("LoadBlob(%v) returned error %v", id.Str(), err)
+		}
+
+		_, _, err = dstRepo.SaveBlob(ctx, t, buf, id, false)
+		return err
+	}
+
+	if err := copyBlob(restic.TreeBlob, rootTreeID); err != nil {
+		return err
+	}
+
+	return walker.Walk(ctx, srcRepo, rootTreeID, visitedTrees, func(_ restic.ID, nodepath string, node *restic.Node, err error) (bool, error) {
+		if err != nil {
+			return false, errors.Errorf("unable to load tree for %v: %v", nodepath, err)
+		}
+
+		if node == nil {
+			return false, nil
+		}
+
+		if node.Type == "dir" {
+			if err := copyBlob(restic.TreeBlob, *node.Subtree); err != nil {
+				return false, err
+			}
+			// the subtree is still walked, but will be skipped when it is
+			// referenced again
+			return true, nil
+		}
+
+		for _, id := range node.Content {
+			if err := copyBlob(restic.DataBlob, id); err != nil {
+				return false, err
+			}
+		}
+
+		return true, nil
+	})
+}
@@ -28,6 +28,15 @@ prints its contents to stdout.
 The special snapshot "latest" can be used to use the latest snapshot in the
 repository.
 
+If a folder is dumped, the output is an archive which contains all files in the
+folder. The format of the archive is selected with "--archive", the default is
+"tar". In a tar archive, "--sparse" stores files which contain long runs of zero
+bytes as GNU sparse files. Zip archives store the modification time, the mode
+and the owner of each file and symlinks, other metadata is lost.
+
+The output can be compressed with "--compress gzip" or "--compress zstd", the
+data is compressed in parallel. Files in zip archives are always compressed.
+
 EXIT STATUS
 ===========
 
@@ -41,9 +50,12 @@ Exit status is 0 if the command was successful, and non-zero if there was any er
 
 // DumpOptions collects all options for the dump command.
 type DumpOptions struct {
-	Hosts []string
-	Paths []string
-	Tags  restic.TagLists
+	Hosts    []string
+	Paths    []string
+	Tags     restic.TagLists
+	Sparse   bool
+	Archive  string
+	Compress string
 }
 
 var dumpOptions DumpOptions
@@ -55,6 +67,9 @@ func init() {
 	flags.StringArrayVarP(&dumpOptions.Hosts, "host", "H", nil, `only consider snapshots for this host when the snapshot ID is "latest" (can be specified multiple times)`)
 	flags.Var(&dumpOptions.Tags, "tag", "only consider snapshots which include this `taglist` for snapshot ID \"latest\"")
 	flags.StringArrayVar(&dumpOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path` for snapshot ID \"latest\"")
+	flags.BoolVar(&dumpOptions.Sparse, "sparse", false, "store files with all-zero ranges as sparse files in tar archives")
+	flags.StringVar(&dumpOptions.Archive, "archive", "tar", "set archive `format` as \"tar\" or \"zip\"")
+	flags.StringVar(&dumpOptions.Compress, "compress", "", "compress the output with `algorithm` \"gzip\" or \"zstd\"")
 }
 
 func splitPath(p string) []string {
@@ -69,7 +84,7 @@ func splitPath(p string) []string {
 	return append(s, f)
 }
 
-func printFromTree(ctx context.Context, tree *restic.Tree, repo restic.Repository, prefix string, pathComponents []string, pathToPrint string) error {
+func printFromTree(ctx context.Context, tree *restic.Tree, repo restic.Repository, prefix string, pathComponents []string, pathToPrint string, opts DumpOptions, output io.Writer) error {
 
 	if tree == nil {
 		return fmt.Errorf("called with a nil tree")
@@ -86,16 +101,32 @@ func printFromTree(ctx context.Context, tree *restic.Tree, repo restic.Repositor
 		if node.Name == pathComponents[0] || pathComponents[0] == "/" {
 			switch {
 			case l == 1 && node.Type == "file":
-				return getNodeData(ctx, os.Stdout, repo, node)
+				blobs := restic.NewBlobSet()
+				for _, id := range node.Content {
+					blobs.Insert(restic.BlobHandle{ID: id, Type: restic.DataBlob})
+				}
+				if err := restic.RestoreBlobs(ctx, repo, blobs); err != nil {
+					return err
+				}
+				return getNodeData(ctx, output, repo, node)
 			case l > 1 && node.Type == "dir":
 				subtree, err := repo.LoadTree(ctx, *node.Subtree)
 				if err != nil {
 					return errors.Wrapf(err, "cannot load subtree for %q", item)
 				}
-				return printFromTree(ctx, subtree, repo, item, pathComponents[1:], pathToPrint)
+				return printFromTree(ctx, subtree, repo, item, pathComponents[1:], pathToPrint, opts, output)
 			case node.Type == "dir":
+				if _, ok := restic.AsColdStorage(repo.Backend()); ok {
+					blobs := restic.NewBlobSet()
+					if err := restic.FindUsedBlobs(ctx, repo, *node.Subtree, blobs, restic.NewBlobSet()); err != nil {
+						return err
+					}
+					if err := restic.RestoreBlobs(ctx, repo, blobs); err != nil {
+						return err
+					}
+				}
 				node.Path = pathToPrint
-				return tarTree(ctx, repo, node, pathToPrint)
+				return dumpTree(ctx, repo, node, pathToPrint, opts, output)
 			case l > 1:
 				return fmt.Errorf("%q should be a dir, but is a %q", item, node.Type)
 			case node.Type != "file":
@@ -113,6 +144,25 @@ func runDump(opts DumpOptions, gopts GlobalOptions, args []string) error {
 		return errors.Fatal("no file and no snapshot ID specified")
 	}
 
+	switch opts.Archive {
+	case "tar":
+	case "zip":
+		if opts.Sparse {
+			return errors.Fatal("--sparse is only supported for tar archives")
+		}
+		if opts.Compress != "" {
+			return errors.Fatal("--compress cannot be used for zip archives, the files in the archive are always compressed")
+		}
+	default:
+		return errors.Fatalf("invalid archive format %q, must be one of (tar|zip)", opts.Archive)
+	}
+
+	switch opts.Compress {
+	case "", "gzip", "zstd":
+	default:
+		return errors.Fatalf("invalid compression %q, must be one of (gzip|zstd)", opts.Compress)
+	}
+
 	snapshotIDString := args[0]
 	pathToPrint := args[1]
 
@@ -162,7 +212,22 @@ func runDump(opts DumpOptions, gopts GlobalOptions, args []string) error {
 		Exitf(2, "loading tree for snapshot %q failed: %v", snapshotIDString, err)
 	}
 
-	err = printFromTree(ctx, tree, repo, "", splittedPath, pathToPrint)
+	var output io.WriteCloser = os.Stdout
+	if opts.Compress != "" {
+		if stdoutIsTerminal() {
+			return errors.Fatal("stdout is the terminal, please redirect output")
+		}
+
+		output, err = newCompressWriter(os.Stdout, opts.Compress)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = printFromTree(ctx, tree, repo, "", splittedPath, pathToPrint, opts, output)
+	if err == nil && output != os.Stdout {
+		err = output.Close()
+	}
 	if err != nil {
 		Exitf(2, "cannot dump file: %v", err)
 	}
@@ -190,15 +255,37 @@ func getNodeData(ctx context.Context, output io.Writer, repo restic.Repository,
 	return nil
 }
 
-func tarTree(ctx context.Context, repo restic.Repository, rootNode *restic.Node, rootPath string) error {
-
-	if stdoutIsTerminal() {
+// dumpTree writes rootNode and all nodes below it to output as an archive in
+// the format selected in opts.
+func dumpTree(ctx context.Context, repo restic.Repository, rootNode *restic.Node, rootPath string, opts DumpOptions, output io.Writer) error {
+	if output == os.Stdout && stdoutIsTerminal() {
 		return fmt.Errorf("stdout is the terminal, please redirect output")
 	}
 
-	tw := tar.NewWriter(os.Stdout)
-	defer tw.Close()
+	if opts.Archive == "zip" {
+		zw := newZipWriter(output)
+		err := walkTree(ctx, repo, rootNode, rootPath, func(node *restic.Node) error {
+			return zipNode(ctx, zw, node, repo)
+		})
+		if err != nil {
+			return err
+		}
+		return errors.Wrap(zw.Close(), "Close")
+	}
 
+	tw := newTarWriter(output, opts.Sparse)
+	err := walkTree(ctx, repo, rootNode, rootPath, func(node *restic.Node) error {
+		return tarNode(ctx, tw, node, repo)
+	})
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(tw.Close(), "Close")
+}
+
+// walkTree calls fn for rootNode and all files, symlinks and dirs below it,
+// node.Path is set to the path of the node in the archive.
+func walkTree(ctx context.Context, repo restic.Repository, rootNode *restic.Node, rootPath string, fn func(node *restic.Node) error) error {
 	// If we want to dump "/" we'll need to add the name of the first node, too
 	// as it would get lost otherwise.
 	if rootNode.Path == "/" {
@@ -207,12 +294,12 @@ func tarTree(ctx context.Context, repo restic.Repository, rootNode *restic.Node,
 	}
 
 	// we know that rootNode is a folder and walker.Walk will already process
-	// the next node, so we have to tar this one first, too
-	if err := tarNode(ctx, tw, rootNode, repo); err != nil {
+	// the next node, so we have to add this one first, too
+	if err := fn(rootNode); err != nil {
 		return err
 	}
 
-	err := walker.Walk(ctx, repo, *rootNode.Subtree, nil, func(_ restic.ID, nodepath string, node *restic.Node, err error) (bool, error) {
+	return walker.Walk(ctx, repo, *rootNode.Subtree, nil, func(_ restic.ID, nodepath string, node *restic.Node, err error) (bool, error) {
 		if err != nil {
 			return false, err
 		}
@@ -223,19 +310,17 @@ func tarTree(ctx context.Context, repo restic.Repository, rootNode *restic.Node,
 		node.Path = path.Join(rootPath, nodepath)
 
 		if node.Type == "file" || node.Type == "symlink" || node.Type == "dir" {
-			err := tarNode(ctx, tw, node, repo)
-			if err != err {
+			err := fn(node)
+			if err != nil {
 				return false, err
 			}
 		}
 
 		return false, nil
 	})
-
-	return err
 }
 
-func tarNode(ctx context.Context, tw *tar.Writer, node *restic.Node, repo restic.Repository) error {
+func tarNode(ctx context.Context, tw *tarWriter, node *restic.Node, repo restic.Repository) error {
 
 	header := &tar.Header{
 		Name:       node.Path,
@@ -258,6 +343,17 @@ func tarNode(ctx context.Context, tw *tar.Writer, node *restic.Node, repo restic
 		header.Typeflag = tar.TypeDir
 	}
 
+	if tw.sparse && node.Type == "file" {
+		entries, hasHoles, err := sparseMap(repo, node)
+		if err != nil {
+			return err
+		}
+
+		if hasHoles {
+			return tw.writeSparseFile(ctx, repo, header, node, entries)
+		}
+	}
+
 	err := tw.WriteHeader(header)
 
 	if err != nil {
@@ -21,7 +21,11 @@ var cmdFind = &cobra.Command{
 	Long: `
 The "find" command searches for files or directories in snapshots stored in the
 repo.
-It can also be used to search for restic blobs or trees for troubleshooting.`,
+It can also be used to search for restic blobs or trees for troubleshooting.
+
+The results can be restricted further by filter rules passed to --rule, in this
+case no PATTERN is required.
+` + filterRulesHelp,
 	Example: `restic find config.json
 restic find --json "*.yml" "*.json"
 restic find --json --blob 420f620f b46ebe8a ddd38656
@@ -54,6 +58,7 @@ type FindOptions struct {
 	Hosts              []string
 	Paths              []string
 	Tags               restic.TagLists
+	Rules              []string
 }
 
 var findOptions FindOptions
@@ -71,6 +76,7 @@ func init() {
 	f.BoolVar(&findOptions.ShowPackID, "show-pack-id", false, "display the pack-ID the blobs belong to (with --blob or --tree)")
 	f.BoolVarP(&findOptions.CaseInsensitive, "ignore-case", "i", false, "ignore case for pattern")
 	f.BoolVarP(&findOptions.ListLong, "long", "l", false, "use a long listing format showing size and mode")
+	f.StringArrayVar(&findOptions.Rules, "rule", nil, "only find files and directories matching the filter `rule`, see \"FILTER RULES\" (can be specified multiple times)")
 
 	f.StringArrayVarP(&findOptions.Hosts, "host", "H", nil, "only consider snapshots for this `host`, when no snapshot ID is given (can be specified multiple times)")
 	f.Var(&findOptions.Tags, "tag", "only consider snapshots which include this `taglist`, when no snapshot-ID is given")
@@ -81,6 +87,7 @@ type findPattern struct {
 	oldest, newest time.Time
 	pattern        []string
 	ignoreCase     bool
+	rules          filter.Rules
 }
 
 var timeFormats = []string{
@@ -282,7 +289,8 @@ func (f *Finder) findInSnapshot(ctx context.Context, sn *restic.Snapshot) error
 			normalizedNodepath = strings.ToLower(nodepath)
 		}
 
-		var foundMatch bool
+		// without patterns, all items are matched by the rules
+		foundMatch := len(f.pat.pattern) == 0
 
 		for _, pat := range f.pat.pattern {
 			found, err := filter.Match(pat, normalizedNodepath)
@@ -299,8 +307,18 @@ func (f *Finder) findInSnapshot(ctx context.Context, sn *restic.Snapshot) error
 			ignoreIfNoMatch = true
 			errIfNoMatch    error
 		)
+		var ruleMatch, ruleChildMayMatch = true, true
+		if len(f.pat.rules) > 0 {
+			var err error
+			ruleMatch, ruleChildMayMatch, err = f.pat.rules.Match(filter.ItemFromNode(nodepath, node))
+			if err != nil {
+				return false, err
+			}
+			foundMatch = foundMatch && ruleMatch
+		}
+
 		if node.Type == "dir" {
-			var childMayMatch bool
+			childMayMatch := len(f.pat.pattern) == 0
 			for _, pat := range f.pat.pattern {
 				mayMatch, err := filter.ChildMatch(pat, normalizedNodepath)
 				if err != nil {
@@ -312,7 +330,7 @@ func (f *Finder) findInSnapshot(ctx context.Context, sn *restic.Snapshot) error
 				}
 			}
 
-			if !childMayMatch {
+			if !childMayMatch || !ruleChildMayMatch {
 				ignoreIfNoMatch = true
 				errIfNoMatch = walker.SkipNode
 			} else {
@@ -490,12 +508,16 @@ func (f *Finder) findObjectsPacks(ctx context.Context) {
 }
 
 func runFind(opts FindOptions, gopts GlobalOptions, args []string) error {
-	if len(args) == 0 {
+	if len(args) == 0 && (len(opts.Rules) == 0 || opts.BlobID || opts.TreeID || opts.PackID) {
 		return errors.Fatal("wrong number of arguments")
 	}
 
-	var err error
-	pat := findPattern{pattern: args}
+	rules, err := filter.ParseRules(opts.Rules)
+	if err != nil {
+		return errors.Fatalf("%v", err)
+	}
+
+	pat := findPattern{pattern: args, rules: rules}
 	if opts.CaseInsensitive {
 		for i := range pat.pattern {
 			pat.pattern[i] = strings.ToLower(pat.pattern[i])
@@ -80,9 +80,24 @@ func init() {
 	f.BoolVar(&forgetOptions.Prune, "prune", false, "automatically run the 'prune' command if snapshots have been removed")
 
 	f.SortFlags = false
+	addPruneOptions(cmdForget)
 }
 
 func runForget(opts ForgetOptions, gopts GlobalOptions, args []string) error {
+	if !opts.DryRun {
+		if err := checkAppendOnly(gopts, "removing snapshots"); err != nil {
+			return err
+		}
+	}
+
+	// check the prune options before any snapshot is removed
+	pruneOpts := pruneOptions
+	if opts.Prune {
+		if err := verifyPruneOptions(&pruneOpts); err != nil {
+			return err
+		}
+	}
+
 	repo, err := OpenRepository(gopts)
 	if err != nil {
 		return err
@@ -218,7 +233,7 @@ func runForget(opts ForgetOptions, gopts GlobalOptions, args []string) error {
 			Verbosef("%d snapshots have been removed, running prune\n", removeSnapshots)
 		}
 		if !opts.DryRun {
-			return pruneRepository(gopts, repo)
+			return runPruneWithRepo(pruneOpts, gopts, repo)
 		}
 	}
 
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"strconv"
+	"strings"
+
+	"github.com/restic/chunker"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
 
 	"github.com/spf13/cobra"
 )
@@ -13,6 +18,20 @@ var cmdInit = &cobra.Command{
 	Long: `
 The "init" command initializes a new repository.
 
+By default, a random chunker polynomial is selected for the new repository.
+In order to deduplicate data between two repositories (e.g. when snapshots
+are transferred with the "copy" command), both need to use the same chunker
+polynomial. Use "--copy-chunker-params" together with "--repo2" to reuse the
+polynomial of an existing repository, or pass the value of
+"chunker_polynomial" as printed by "restic cat config" to
+"--chunker-polynomial".
+
+New pack files have a size of about 4 MiB by default. For backends which store
+many small files inefficiently, a larger target size of up to 128 MiB can be
+stored in the repository config with "--pack-size". It is used by all
+commands which write to the repository. For other commands, "--pack-size"
+only overrides it for the current invocation and the config is not changed.
+
 EXIT STATUS
 ===========
 
@@ -20,19 +39,72 @@ Exit status is 0 if the command was successful, and non-zero if there was any er
 `,
 	DisableAutoGenTag: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return runInit(globalOptions, args)
+		return runInit(initOptions, globalOptions, args)
 	},
 }
 
+// InitOptions bundles all options for the init command.
+type InitOptions struct {
+	secondaryRepoOptions
+	RepositoryVersion     string
+	CopyChunkerParameters bool
+	ChunkerPolynomial     string
+}
+
+var initOptions InitOptions
+
 func init() {
 	cmdRoot.AddCommand(cmdInit)
+
+	f := cmdInit.Flags()
+	f.StringVar(&initOptions.RepositoryVersion, "repository-version", "stable", "repository format version to use, allowed values are a format version, 'latest' and 'stable'")
+	initSecondaryRepoOptions(f, &initOptions.secondaryRepoOptions, "secondary", "to copy chunker parameters from")
+	f.BoolVar(&initOptions.CopyChunkerParameters, "copy-chunker-params", false, "copy chunker parameters from the secondary repository (useful with the copy command)")
+	f.StringVar(&initOptions.ChunkerPolynomial, "chunker-polynomial", "", "use the chunker `polynomial` given in hex, as printed by 'cat config'")
+}
+
+// parseRepositoryVersion returns the repository format version selected by s.
+func parseRepositoryVersion(s string) (uint, error) {
+	switch s {
+	case "latest", "":
+		return restic.MaxRepoVersion, nil
+	case "stable":
+		return restic.StableRepoVersion, nil
+	}
+
+	v, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, errors.Fatal("invalid repository version")
+	}
+
+	version := uint(v)
+	if version < restic.MinRepoVersion || version > restic.MaxRepoVersion {
+		return 0, errors.Fatalf("only repository versions between %v and %v are allowed", restic.MinRepoVersion, restic.MaxRepoVersion)
+	}
+
+	return version, nil
 }
 
-func runInit(gopts GlobalOptions, args []string) error {
+func runInit(opts InitOptions, gopts GlobalOptions, args []string) error {
 	if gopts.Repo == "" {
 		return errors.Fatal("Please specify repository location (-r)")
 	}
 
+	version, err := parseRepositoryVersion(opts.RepositoryVersion)
+	if err != nil {
+		return err
+	}
+
+	chunkerPolynomial, err := maybeReadChunkerPolynomial(opts, gopts)
+	if err != nil {
+		return err
+	}
+
+	packSize, err := parsePackSize(gopts.PackSize)
+	if err != nil {
+		return err
+	}
+
 	be, err := create(gopts.Repo, gopts.extended)
 	if err != nil {
 		return errors.Fatalf("create repository at %s failed: %v\n", gopts.Repo, err)
@@ -46,8 +118,11 @@ func runInit(gopts GlobalOptions, args []string) error {
 	}
 
 	s := repository.New(be)
+	if err = s.SetPackSize(packSize); err != nil {
+		return err
+	}
 
-	err = s.Init(gopts.ctx, gopts.password)
+	err = s.Init(gopts.ctx, version, gopts.password, chunkerPolynomial)
 	if err != nil {
 		return errors.Fatalf("create key in repository at %s failed: %v\n", gopts.Repo, err)
 	}
@@ -60,3 +135,52 @@ func runInit(gopts GlobalOptions, args []string) error {
 
 	return nil
 }
+
+// maybeReadChunkerPolynomial returns the chunker polynomial selected with
+// --copy-chunker-params or --chunker-polynomial, or nil if a random one
+// should be used.
+func maybeReadChunkerPolynomial(opts InitOptions, gopts GlobalOptions) (*chunker.Pol, error) {
+	if opts.CopyChunkerParameters && opts.ChunkerPolynomial != "" {
+		return nil, errors.Fatal("--copy-chunker-params and --chunker-polynomial are mutually exclusive")
+	}
+
+	if opts.ChunkerPolynomial != "" {
+		return parseChunkerPolynomial(opts.ChunkerPolynomial)
+	}
+
+	if !opts.CopyChunkerParameters {
+		if opts.Repo != "" {
+			return nil, errors.Fatal("secondary repository must only be specified when copying the chunker parameters")
+		}
+		return nil, nil
+	}
+
+	otherGopts, err := fillSecondaryGlobalOpts(opts.secondaryRepoOptions, gopts, "secondary")
+	if err != nil {
+		return nil, err
+	}
+
+	otherRepo, err := OpenRepository(otherGopts)
+	if err != nil {
+		return nil, err
+	}
+
+	pol := otherRepo.Config().ChunkerPolynomial
+	return &pol, nil
+}
+
+// parseChunkerPolynomial parses a polynomial given in hex, with or without
+// the "0x" prefix, and checks that it is irreducible.
+func parseChunkerPolynomial(s string) (*chunker.Pol, error) {
+	n, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(s), "0x"), 16, 64)
+	if err != nil {
+		return nil, errors.Fatalf("invalid chunker polynomial %q: %v", s, err)
+	}
+
+	pol := chunker.Pol(n)
+	if !pol.Irreducible() {
+		return nil, errors.Fatalf("chunker polynomial %q is not irreducible", s)
+	}
+
+	return &pol, nil
+}
@@ -172,6 +172,17 @@ func runKey(gopts GlobalOptions, args []string) error {
 		return errors.Fatal("wrong number of arguments")
 	}
 
+	switch args[0] {
+	case "remove":
+		if err := checkAppendOnly(gopts, "removing a key"); err != nil {
+			return err
+		}
+	case "passwd":
+		if err := checkAppendOnly(gopts, "changing the password"); err != nil {
+			return err
+		}
+	}
+
 	ctx, cancel := context.WithCancel(gopts.ctx)
 	defer cancel()
 
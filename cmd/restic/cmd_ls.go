@@ -10,6 +10,7 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/filter"
 	"github.com/restic/restic/internal/fs"
 	"github.com/restic/restic/internal/restic"
 	"github.com/restic/restic/internal/walker"
@@ -34,6 +35,9 @@ will allow traversing into matching directories' subfolders.
 Any directory paths specified must be absolute (starting with
 a path separator); paths use the forward slash '/' as separator.
 
+The --rule option lists only the files and directories matching one of
+the filter rules, directories are still traversed.
+` + filterRulesHelp + `
 EXIT STATUS
 ===========
 
@@ -52,6 +56,7 @@ type LsOptions struct {
 	Tags      restic.TagLists
 	Paths     []string
 	Recursive bool
+	Rules     []string
 }
 
 var lsOptions LsOptions
@@ -65,6 +70,7 @@ func init() {
 	flags.Var(&lsOptions.Tags, "tag", "only consider snapshots which include this `taglist`, when no snapshot ID is given")
 	flags.StringArrayVar(&lsOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path`, when no snapshot ID is given")
 	flags.BoolVar(&lsOptions.Recursive, "recursive", false, "include files in subfolders of the listed directories")
+	flags.StringArrayVar(&lsOptions.Rules, "rule", nil, "only list files and directories matching the filter `rule`, see \"FILTER RULES\" (can be specified multiple times)")
 }
 
 type lsSnapshot struct {
@@ -104,6 +110,24 @@ func runLs(opts LsOptions, gopts GlobalOptions, args []string) error {
 		}
 	}
 
+	rules, err := filter.ParseRules(opts.Rules)
+	if err != nil {
+		return errors.Fatalf("%v", err)
+	}
+
+	// matchesRules returns true if the node matches one of the rules
+	matchesRules := func(nodepath string, node *restic.Node) bool {
+		if len(rules) == 0 {
+			return true
+		}
+
+		matched, _, err := rules.Match(filter.ItemFromNode(nodepath, node))
+		if err != nil {
+			Warnf("error for rule: %v\n", err)
+		}
+		return matched
+	}
+
 	withinDir := func(nodepath string) bool {
 		if len(dirs) == 0 {
 			return true
@@ -204,7 +228,9 @@ func runLs(opts LsOptions, gopts GlobalOptions, args []string) error {
 
 			if withinDir(nodepath) {
 				// if we're within a dir, print the node
-				printNode(nodepath, node)
+				if matchesRules(nodepath, node) {
+					printNode(nodepath, node)
+				}
 
 				// if recursive listing is requested, signal the walker that it
 				// should continue walking recursively
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
-	"github.com/restic/restic/internal/index"
+	"github.com/restic/restic/internal/pack"
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 
@@ -20,6 +25,20 @@ var cmdPrune = &cobra.Command{
 The "prune" command checks the repository and removes data that is not
 referenced and therefore not needed any more.
 
+The existing index files are used to find out which data is still needed, only
+the list of pack files is requested from the backend. Packs which only contain
+unused data are deleted, packs which contain both used and unused data are
+repacked. If the index is damaged, use "--rebuild-index" to build a new index
+from the headers of all pack files first.
+
+Repacking is expensive, so by default up to 5% of the repository size may
+remain unused after prune. Use "--max-unused" to change this limit and
+"--max-repack-size" to limit the amount of data which is repacked. Packs with
+the best ratio of freed to repacked data are repacked first.
+
+Use "--dry-run" to only print what would be removed and repacked, the
+repository is not modified. With "--json", the summary is printed as JSON.
+
 EXIT STATUS
 ===========
 
@@ -27,12 +46,98 @@ Exit status is 0 if the command was successful, and non-zero if there was any er
 `,
 	DisableAutoGenTag: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return runPrune(globalOptions)
+		return runPrune(pruneOptions, globalOptions)
 	},
 }
 
+// PruneOptions collects all options for the prune command.
+type PruneOptions struct {
+	MaxUnused      string
+	maxUnusedBytes func(used uint64) (unused uint64) // calculates the number of unused bytes after repacking, according to MaxUnused
+
+	MaxRepackSize  string
+	MaxRepackBytes uint64
+
+	RepackCacheableOnly bool
+	RebuildIndex        bool
+	DryRun              bool
+}
+
+var pruneOptions PruneOptions
+
 func init() {
 	cmdRoot.AddCommand(cmdPrune)
+	addPruneOptions(cmdPrune)
+	cmdPrune.Flags().BoolVarP(&pruneOptions.DryRun, "dry-run", "n", false, "do not modify the repository, just print what would be done")
+}
+
+func addPruneOptions(c *cobra.Command) {
+	f := c.Flags()
+	f.StringVar(&pruneOptions.MaxUnused, "max-unused", "5%", "tolerate given `limit` of unused data (absolute value in bytes with suffixes k/K, m/M, g/G, t/T, a value in % or the word 'unlimited')")
+	f.StringVar(&pruneOptions.MaxRepackSize, "max-repack-size", "", "maximum `size` to repack (allowed suffixes: k/K, m/M, g/G, t/T)")
+	f.BoolVar(&pruneOptions.RepackCacheableOnly, "repack-cacheable-only", false, "only repack packs which are cacheable (tree packs), packs with data blobs are only deleted if they are completely unused")
+	f.BoolVar(&pruneOptions.RebuildIndex, "rebuild-index", false, "build a new index from the headers of all pack files before pruning, use this if the index is damaged")
+}
+
+// verifyPruneOptions checks the limits given on the command line and sets
+// maxUnusedBytes and MaxRepackBytes accordingly.
+func verifyPruneOptions(opts *PruneOptions) error {
+	if opts.DryRun && opts.RebuildIndex {
+		return errors.Fatal("--rebuild-index cannot be used together with --dry-run")
+	}
+
+	if len(opts.MaxRepackSize) > 0 {
+		size, err := restic.ParseSize(opts.MaxRepackSize)
+		if err != nil {
+			return errors.Fatalf("invalid value for --max-repack-size: %v", err)
+		}
+		opts.MaxRepackBytes = uint64(size)
+	}
+
+	maxUnused := strings.TrimSpace(opts.MaxUnused)
+	if maxUnused == "" {
+		return errors.Fatalf("invalid value for --max-unused: %q", opts.MaxUnused)
+	}
+
+	// parse MaxUnused either as unlimited, a percentage, or an absolute number of bytes
+	switch {
+	case maxUnused == "unlimited":
+		opts.maxUnusedBytes = func(used uint64) uint64 {
+			return math.MaxUint64
+		}
+
+	case strings.HasSuffix(maxUnused, "%"):
+		maxUnused = strings.TrimSuffix(maxUnused, "%")
+		p, err := strconv.ParseFloat(maxUnused, 64)
+		if err != nil {
+			return errors.Fatalf("invalid percentage %q passed for --max-unused: %v", opts.MaxUnused, err)
+		}
+
+		if p < 0 {
+			return errors.Fatal("percentage for --max-unused must be positive")
+		}
+
+		if p >= 100 {
+			return errors.Fatal("percentage for --max-unused must be below 100%")
+		}
+
+		// p% of the total size may be unused, which is p/(100-p) of the used size
+		opts.maxUnusedBytes = func(used uint64) uint64 {
+			return uint64(p / (100 - p) * float64(used))
+		}
+
+	default:
+		size, err := restic.ParseSize(maxUnused)
+		if err != nil {
+			return errors.Fatalf("invalid number of bytes %q for --max-unused: %v", opts.MaxUnused, err)
+		}
+
+		opts.maxUnusedBytes = func(used uint64) uint64 {
+			return uint64(size)
+		}
+	}
+
+	return nil
 }
 
 func shortenStatus(maxLength int, s string) string {
@@ -75,241 +180,665 @@ func newProgressMax(show bool, max uint64, description string) *restic.Progress
 	return p
 }
 
-func runPrune(gopts GlobalOptions) error {
-	repo, err := OpenRepository(gopts)
+func runPrune(opts PruneOptions, gopts GlobalOptions) error {
+	err := verifyPruneOptions(&opts)
 	if err != nil {
 		return err
 	}
 
-	lock, err := lockRepoExclusive(repo)
-	defer unlockRepo(lock)
+	if !opts.DryRun {
+		if err := checkAppendOnly(gopts, "pruning the repository"); err != nil {
+			return err
+		}
+	}
+
+	repo, err := OpenRepository(gopts)
 	if err != nil {
 		return err
 	}
 
+	if opts.DryRun {
+		// a dry run only reads from the repository
+		if !gopts.NoLock {
+			lock, err := lockRepo(repo)
+			defer unlockRepo(lock)
+			if err != nil {
+				return err
+			}
+		}
+	} else {
+		lock, err := lockRepoExclusive(repo)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	return runPruneWithRepo(opts, gopts, repo)
+}
+
+func runPruneWithRepo(opts PruneOptions, gopts GlobalOptions, repo *repository.Repository) error {
 	// we do not need index updates while pruning!
 	repo.DisableAutoIndexUpdate()
 
-	return pruneRepository(gopts, repo)
+	return pruneRepository(opts, gopts, repo)
 }
 
-func mixedBlobs(list []restic.Blob) bool {
-	var tree, data bool
-
-	for _, pb := range list {
-		switch pb.Type {
-		case restic.TreeBlob:
-			tree = true
-		case restic.DataBlob:
-			data = true
-		}
+// packInfo collects the statistics of a single pack as listed in the index.
+// The sizes include the header entries of the blobs.
+type packInfo struct {
+	usedBlobs   uint
+	unusedBlobs uint
+	usedSize    uint64
+	unusedSize  uint64
+	tpe         restic.BlobType
+	mixed       bool
+}
 
-		if tree && data {
-			return true
-		}
+// pruneStats collects the statistics which are printed by prune.
+type pruneStats struct {
+	blobs struct {
+		used      uint
+		duplicate uint
+		unused    uint
+		remove    uint
+		repack    uint
+		repackrm  uint
+		mixed     uint
+	}
+	size struct {
+		used      uint64
+		duplicate uint64
+		unused    uint64
+		remove    uint64
+		repack    uint64
+		repackrm  uint64
+		unref     uint64
+		mixed     uint64
 	}
+	skipped struct {
+		maxUnused     uint
+		maxRepackSize uint
+		size          uint64
+	}
+	packs struct {
+		used       uint
+		unused     uint
+		partlyUsed uint
+		keep       uint
+		repack     uint
+		remove     uint
+		unref      uint
+		missing    uint
+	}
+}
 
-	return false
+// prunePlan lists the packs which are deleted and repacked by prune.
+type prunePlan struct {
+	removePacksFirst restic.IDSet   // unreferenced packs, removed before repacking
+	repackPacks      restic.IDSet   // packs to repack
+	keepBlobs        restic.BlobSet // blobs to save while repacking
+	removePacks      restic.IDSet   // packs to remove after the index has been rebuilt
+	ignorePacks      restic.IDSet   // missing packs to leave out of the new index
 }
 
-func pruneRepository(gopts GlobalOptions, repo restic.Repository) error {
+func pruneRepository(opts PruneOptions, gopts GlobalOptions, repo *repository.Repository) error {
 	ctx := gopts.ctx
 
+	if opts.RebuildIndex {
+		if !gopts.JSON {
+			Verbosef("rebuilding index from pack files\n")
+		}
+		if err := rebuildIndex(ctx, RepairIndexOptions{ReadAllPacks: true}, repo, restic.NewIDSet()); err != nil {
+			return err
+		}
+	}
+
+	if !gopts.JSON {
+		Verbosef("loading indexes...\n")
+	}
 	err := repo.LoadIndex(ctx)
 	if err != nil {
 		return err
 	}
 
-	var stats struct {
-		blobs     int
-		packs     int
-		snapshots int
-		bytes     int64
-	}
-
-	Verbosef("counting files in repo\n")
-	err = repo.List(ctx, restic.DataFile, func(restic.ID, int64) error {
-		stats.packs++
-		return nil
-	})
+	usedBlobs, err := getUsedBlobs(gopts, repo)
 	if err != nil {
 		return err
 	}
 
-	Verbosef("building new index for repo\n")
-
-	bar := newProgressMax(!gopts.Quiet, uint64(stats.packs), "packs")
-	idx, invalidFiles, err := index.New(ctx, repo, restic.NewIDSet(), bar)
+	plan, stats, err := planPrune(opts, gopts, repo, usedBlobs)
 	if err != nil {
 		return err
 	}
 
-	for _, id := range invalidFiles {
-		Warnf("incomplete pack file (will be removed): %v\n", id)
+	if gopts.JSON {
+		err = printPruneJSON(gopts, opts.DryRun, stats, plan)
+		if err != nil {
+			return err
+		}
+	} else {
+		printPruneStats(gopts, stats)
+	}
+
+	if opts.DryRun {
+		if !gopts.JSON {
+			if gopts.verbosity >= 2 {
+				printPrunePlan(plan)
+			}
+			Verbosef("dry run, the repository has not been modified\n")
+		}
+		return nil
 	}
 
-	blobs := 0
-	for _, pack := range idx.Packs {
-		stats.bytes += pack.Size
-		blobs += len(pack.Entries)
+	return doPrune(gopts, repo, plan)
+}
+
+// planPrune decides, based on the index and the list of packs in the
+// backend, which packs are removed and which are repacked. Only the pack
+// sizes are requested from the backend, pack headers are not read.
+func planPrune(opts PruneOptions, gopts GlobalOptions, repo restic.Repository, usedBlobs restic.BlobSet) (prunePlan, pruneStats, error) {
+	ctx := gopts.ctx
+	var stats pruneStats
+
+	if !gopts.JSON {
+		Verbosef("searching used packs...\n")
 	}
-	Verbosef("repository contains %v packs (%v blobs) with %v\n",
-		len(idx.Packs), blobs, formatBytes(uint64(stats.bytes)))
 
-	blobCount := make(map[restic.BlobHandle]int)
-	var duplicateBlobs uint64
-	var duplicateBytes uint64
+	keepBlobs := restic.NewBlobSet()
+	indexPack := make(map[restic.ID]packInfo)
+
+	for blob := range repo.Index().Each(ctx) {
+		bh := restic.BlobHandle{ID: blob.ID, Type: blob.Type}
+		size := uint64(pack.PackedSizeOfBlob(blob.Blob))
+
+		ip, ok := indexPack[blob.PackID]
+		if !ok {
+			ip.tpe = blob.Type
+		}
+		if ip.tpe != blob.Type {
+			ip.mixed = true
+		}
+
+		switch {
+		case usedBlobs.Has(bh) && !keepBlobs.Has(bh):
+			// the first copy of a used blob is kept
+			keepBlobs.Insert(bh)
+			ip.usedBlobs++
+			ip.usedSize += size
+			stats.blobs.used++
+			stats.size.used += size
+		case usedBlobs.Has(bh):
+			// duplicate of a used blob
+			ip.unusedBlobs++
+			ip.unusedSize += size
+			stats.blobs.duplicate++
+			stats.size.duplicate += size
+		default:
+			ip.unusedBlobs++
+			ip.unusedSize += size
+			stats.blobs.unused++
+			stats.size.unused += size
+		}
 
-	// find duplicate blobs
-	for _, p := range idx.Packs {
-		for _, entry := range p.Entries {
-			stats.blobs++
-			h := restic.BlobHandle{ID: entry.ID, Type: entry.Type}
-			blobCount[h]++
+		indexPack[blob.PackID] = ip
+	}
 
-			if blobCount[h] > 1 {
-				duplicateBlobs++
-				duplicateBytes += uint64(entry.Length)
+	if len(keepBlobs) < len(usedBlobs) {
+		missing := 0
+		for bh := range usedBlobs {
+			if !keepBlobs.Has(bh) {
+				missing++
+				Warnf("blob %v is used but not contained in the index\n", bh)
 			}
 		}
+		return prunePlan{}, stats, errors.Fatalf("%d used blobs are missing from the index, run 'restic check' and 'restic prune --rebuild-index'", missing)
 	}
 
-	Verbosef("processed %d blobs: %d duplicate blobs, %v duplicate\n",
-		stats.blobs, duplicateBlobs, formatBytes(uint64(duplicateBytes)))
-	Verbosef("load all snapshots\n")
+	for _, p := range indexPack {
+		if p.mixed {
+			stats.blobs.mixed += p.usedBlobs + p.unusedBlobs
+			stats.size.mixed += p.usedSize + p.unusedSize
+		}
+	}
 
-	// find referenced blobs
-	snapshots, err := restic.LoadAllSnapshots(ctx, repo)
-	if err != nil {
-		return err
+	if !gopts.JSON {
+		Verbosef("collecting packs for deletion and repacking\n")
 	}
 
-	stats.snapshots = len(snapshots)
+	plan := prunePlan{
+		removePacksFirst: restic.NewIDSet(),
+		repackPacks:      restic.NewIDSet(),
+		keepBlobs:        keepBlobs,
+		removePacks:      restic.NewIDSet(),
+		ignorePacks:      restic.NewIDSet(),
+	}
 
-	Verbosef("find data that is still in use for %d snapshots\n", stats.snapshots)
+	// packs which should be repacked, the final decision is made after all
+	// packs have been classified
+	type repackCandidate struct {
+		id restic.ID
+		packInfo
+	}
+	var candidates []repackCandidate
+
+	var sizeMismatch bool
+	err := repo.List(ctx, restic.DataFile, func(id restic.ID, packSize int64) error {
+		p, ok := indexPack[id]
+		if !ok {
+			// the pack is neither indexed nor used, remove it right away
+			if gopts.verbosity >= 2 && !gopts.JSON {
+				Printf("will remove pack %v as it is not referenced by the index\n", id.Str())
+			}
+			plan.removePacksFirst.Insert(id)
+			stats.packs.unref++
+			stats.size.unref += uint64(packSize)
+			return nil
+		}
+		delete(indexPack, id)
+
+		size := p.usedSize + p.unusedSize + uint64(pack.HeaderSize)
+		if size != uint64(packSize) {
+			Warnf("pack %v: size %d calculated from the index does not match real size %d\n",
+				id.Str(), size, packSize)
+			sizeMismatch = true
+			return nil
+		}
 
-	usedBlobs := restic.NewBlobSet()
-	seenBlobs := restic.NewBlobSet()
+		switch {
+		case p.usedBlobs == 0:
+			stats.packs.unused++
+		case p.unusedBlobs == 0:
+			stats.packs.used++
+		default:
+			stats.packs.partlyUsed++
+		}
 
-	bar = newProgressMax(!gopts.Quiet, uint64(len(snapshots)), "snapshots")
-	bar.Start()
-	for _, sn := range snapshots {
-		debug.Log("process snapshot %v", sn.ID())
+		switch {
+		case p.usedBlobs == 0:
+			// all blobs are unused, remove the pack without repacking
+			plan.removePacks.Insert(id)
+			stats.packs.remove++
+			stats.blobs.remove += p.unusedBlobs
+			stats.size.remove += p.unusedSize
 
-		err = restic.FindUsedBlobs(ctx, repo, *sn.Tree, usedBlobs, seenBlobs)
-		if err != nil {
-			if repo.Backend().IsNotExist(err) {
-				return errors.Fatal("unable to load a tree from the repo: " + err.Error())
-			}
+		case p.unusedBlobs == 0 && !p.mixed:
+			// all blobs are used, keep the pack
+			stats.packs.keep++
 
-			return err
+		case opts.RepackCacheableOnly && p.tpe == restic.DataBlob && !p.mixed:
+			// data packs are not repacked, the unused data remains
+			stats.packs.keep++
+
+		default:
+			candidates = append(candidates, repackCandidate{id: id, packInfo: p})
 		}
 
-		debug.Log("processed snapshot %v", sn.ID())
-		bar.Report(restic.Stat{Blobs: 1})
+		return nil
+	})
+	if err != nil {
+		return prunePlan{}, stats, err
 	}
-	bar.Done()
 
-	if len(usedBlobs) > stats.blobs {
-		return errors.Fatalf("number of used blobs is larger than number of available blobs!\n" +
-			"Please report this error (along with the output of the 'prune' run) at\n" +
-			"https://github.com/restic/restic/issues/new")
+	if sizeMismatch {
+		return prunePlan{}, stats, errors.Fatal("the index does not match the pack files in the repository, run 'restic prune --rebuild-index'")
 	}
 
-	Verbosef("found %d of %d data blobs still in use, removing %d blobs\n",
-		len(usedBlobs), stats.blobs, stats.blobs-len(usedBlobs))
+	// the remaining packs are listed in the index but missing in the backend
+	for id, p := range indexPack {
+		if p.usedBlobs > 0 {
+			Warnf("pack %v is listed in the index but missing in the repository\n", id.Str())
+			return prunePlan{}, stats, errors.Fatal("packs containing used blobs are missing, run 'restic check' and 'restic prune --rebuild-index'")
+		}
+		// the pack only contained unused blobs, drop it from the index
+		plan.ignorePacks.Insert(id)
+		stats.packs.missing++
+	}
 
-	// find packs that need a rewrite
-	rewritePacks := restic.NewIDSet()
-	for _, pack := range idx.Packs {
-		if mixedBlobs(pack.Entries) {
-			rewritePacks.Insert(pack.ID)
-			continue
+	// tree packs and mixed packs are always repacked first, data packs are
+	// sorted so that the packs which free the most space per repacked byte
+	// come first
+	sort.Slice(candidates, func(i, j int) bool {
+		pi, pj := candidates[i].packInfo, candidates[j].packInfo
+		switch {
+		case pi.tpe != restic.DataBlob && pj.tpe == restic.DataBlob:
+			return true
+		case pj.tpe != restic.DataBlob && pi.tpe == restic.DataBlob:
+			return false
+		case pi.mixed != pj.mixed:
+			return pi.mixed
 		}
+		// unusedSize/usedSize is bigger for pi than for pj
+		return pi.unusedSize*pj.usedSize > pj.unusedSize*pi.usedSize
+	})
 
-		for _, blob := range pack.Entries {
-			h := restic.BlobHandle{ID: blob.ID, Type: blob.Type}
-			if !usedBlobs.Has(h) {
-				rewritePacks.Insert(pack.ID)
+	maxUnusedSize := opts.maxUnusedBytes(stats.size.used)
+	for _, c := range candidates {
+		unusedAfter := stats.size.unused + stats.size.duplicate - stats.size.remove - stats.size.repackrm
+		packSize := c.usedSize + c.unusedSize
+
+		switch {
+		case opts.MaxRepackBytes > 0 && stats.size.repack+stats.size.repackrm+packSize > opts.MaxRepackBytes:
+			// repacking this pack would exceed the limit
+			stats.packs.keep++
+			stats.skipped.maxRepackSize++
+			stats.skipped.size += c.unusedSize
+
+		case c.tpe == restic.DataBlob && !c.mixed && unusedAfter <= maxUnusedSize:
+			// enough unused data has already been removed
+			stats.packs.keep++
+			stats.skipped.maxUnused++
+			stats.skipped.size += c.unusedSize
+
+		default:
+			plan.repackPacks.Insert(c.id)
+			stats.packs.repack++
+			stats.blobs.repack += c.usedBlobs
+			stats.size.repack += c.usedSize
+			stats.blobs.repackrm += c.unusedBlobs
+			stats.size.repackrm += c.unusedSize
+		}
+	}
+
+	if len(plan.repackPacks) != 0 {
+		// blobs which are also contained in a pack that is kept do not need
+		// to be saved again while repacking
+		for blob := range repo.Index().Each(ctx) {
+			if plan.repackPacks.Has(blob.PackID) || plan.removePacks.Has(blob.PackID) || plan.ignorePacks.Has(blob.PackID) {
 				continue
 			}
+			keepBlobs.Delete(restic.BlobHandle{ID: blob.ID, Type: blob.Type})
+		}
+	}
 
-			if blobCount[h] > 1 {
-				rewritePacks.Insert(pack.ID)
-			}
+	return plan, stats, nil
+}
+
+// printPruneStats prints the statistics collected by planPrune.
+func printPruneStats(gopts GlobalOptions, stats pruneStats) {
+	totalBlobs := stats.blobs.used + stats.blobs.unused + stats.blobs.duplicate
+	totalSize := stats.size.used + stats.size.duplicate + stats.size.unused + stats.size.unref
+	unusedSize := stats.size.duplicate + stats.size.unused
+
+	Verbosef("\nused:         %10d blobs / %s\n", stats.blobs.used, formatBytes(stats.size.used))
+	if stats.blobs.duplicate > 0 {
+		Verbosef("duplicates:   %10d blobs / %s\n", stats.blobs.duplicate, formatBytes(stats.size.duplicate))
+	}
+	Verbosef("unused:       %10d blobs / %s\n", stats.blobs.unused, formatBytes(stats.size.unused))
+	if stats.blobs.mixed > 0 {
+		Verbosef("in mixed packs: %8d blobs / %s\n", stats.blobs.mixed, formatBytes(stats.size.mixed))
+	}
+	if stats.size.unref > 0 {
+		Verbosef("unreferenced:                    %s\n", formatBytes(stats.size.unref))
+	}
+	Verbosef("total:        %10d blobs / %s\n", totalBlobs, formatBytes(totalSize))
+	Verbosef("unused size: %s of total size\n", formatPercent(unusedSize, totalSize))
+
+	Verbosef("\nto repack:    %10d blobs / %s\n", stats.blobs.repack, formatBytes(stats.size.repack))
+	Verbosef("this removes  %10d blobs / %s\n", stats.blobs.repackrm, formatBytes(stats.size.repackrm))
+	Verbosef("to delete:    %10d blobs / %s\n", stats.blobs.remove, formatBytes(stats.size.remove+stats.size.unref))
+	totalPruneSize := stats.size.remove + stats.size.repackrm + stats.size.unref
+	Verbosef("total prune:  %10d blobs / %s\n", stats.blobs.remove+stats.blobs.repackrm, formatBytes(totalPruneSize))
+	Verbosef("remaining:    %10d blobs / %s\n", totalBlobs-(stats.blobs.remove+stats.blobs.repackrm), formatBytes(totalSize-totalPruneSize))
+	unusedAfter := unusedSize - stats.size.remove - stats.size.repackrm
+	Verbosef("unused size after prune: %s (%s of remaining size)\n",
+		formatBytes(unusedAfter), formatPercent(unusedAfter, totalSize-totalPruneSize))
+	if stats.skipped.maxUnused > 0 || stats.skipped.maxRepackSize > 0 {
+		Verbosef("not repacked: %10d packs / %s unused (%d due to --max-unused, %d due to --max-repack-size)\n",
+			stats.skipped.maxUnused+stats.skipped.maxRepackSize, formatBytes(stats.skipped.size),
+			stats.skipped.maxUnused, stats.skipped.maxRepackSize)
+	}
+	Verbosef("\n")
+
+	if gopts.verbosity >= 2 {
+		Printf("totally used packs: %10d\n", stats.packs.used)
+		Printf("partly used packs:  %10d\n", stats.packs.partlyUsed)
+		Printf("unused packs:       %10d\n\n", stats.packs.unused)
+
+		Printf("to keep:      %10d packs\n", stats.packs.keep)
+		Printf("to repack:    %10d packs\n", stats.packs.repack)
+		Printf("to delete:    %10d packs\n", stats.packs.remove)
+		if stats.packs.unref > 0 {
+			Printf("to delete:    %10d unreferenced packs\n", stats.packs.unref)
+		}
+		if stats.packs.missing > 0 {
+			Printf("to forget:    %10d missing packs\n", stats.packs.missing)
 		}
+		Printf("\n")
 	}
+}
 
-	removeBytes := duplicateBytes
+// pruneJSONSummary is printed by prune when the global --json flag is set.
+type pruneJSONSummary struct {
+	DryRun bool `json:"dry_run"`
+
+	UsedBlobs        uint   `json:"used_blobs"`
+	UsedSize         uint64 `json:"used_size"`
+	DuplicateBlobs   uint   `json:"duplicate_blobs"`
+	DuplicateSize    uint64 `json:"duplicate_size"`
+	UnusedBlobs      uint   `json:"unused_blobs"`
+	UnusedSize       uint64 `json:"unused_size"`
+	UnreferencedSize uint64 `json:"unreferenced_size"`
+	MixedBlobs       uint   `json:"mixed_blobs"`
+	MixedSize        uint64 `json:"mixed_size"`
+	TotalBlobs       uint   `json:"total_blobs"`
+	TotalSize        uint64 `json:"total_size"`
+
+	RepackBlobs       uint   `json:"repack_blobs"`
+	RepackSize        uint64 `json:"repack_size"`
+	RepackRemoveBlobs uint   `json:"repack_remove_blobs"`
+	RepackRemoveSize  uint64 `json:"repack_remove_size"`
+	RemoveBlobs       uint   `json:"remove_blobs"`
+	RemoveSize        uint64 `json:"remove_size"`
+	TotalPruneBlobs   uint   `json:"total_prune_blobs"`
+	TotalPruneSize    uint64 `json:"total_prune_size"`
+	RemainingBlobs    uint   `json:"remaining_blobs"`
+	RemainingSize     uint64 `json:"remaining_size"`
+	UnusedAfterPrune  uint64 `json:"unused_size_after_prune"`
+
+	KeepPacks     uint       `json:"keep_packs"`
+	SkippedPacks  uint       `json:"skipped_packs"`
+	MissingPacks  uint       `json:"missing_packs"`
+	RepackPackIDs restic.IDs `json:"repack_packs"`
+	RemovePackIDs restic.IDs `json:"remove_packs"`
+}
 
-	// find packs that are unneeded
+// printPruneJSON prints the statistics and the packs selected in plan as
+// JSON.
+func printPruneJSON(gopts GlobalOptions, dryRun bool, stats pruneStats, plan prunePlan) error {
 	removePacks := restic.NewIDSet()
+	removePacks.Merge(plan.removePacksFirst)
+	removePacks.Merge(plan.removePacks)
+
+	s := pruneJSONSummary{
+		DryRun: dryRun,
+
+		UsedBlobs:        stats.blobs.used,
+		UsedSize:         stats.size.used,
+		DuplicateBlobs:   stats.blobs.duplicate,
+		DuplicateSize:    stats.size.duplicate,
+		UnusedBlobs:      stats.blobs.unused,
+		UnusedSize:       stats.size.unused,
+		UnreferencedSize: stats.size.unref,
+		MixedBlobs:       stats.blobs.mixed,
+		MixedSize:        stats.size.mixed,
+		TotalBlobs:       stats.blobs.used + stats.blobs.unused + stats.blobs.duplicate,
+		TotalSize:        stats.size.used + stats.size.duplicate + stats.size.unused + stats.size.unref,
+
+		RepackBlobs:       stats.blobs.repack,
+		RepackSize:        stats.size.repack,
+		RepackRemoveBlobs: stats.blobs.repackrm,
+		RepackRemoveSize:  stats.size.repackrm,
+		RemoveBlobs:       stats.blobs.remove,
+		RemoveSize:        stats.size.remove + stats.size.unref,
+		TotalPruneBlobs:   stats.blobs.remove + stats.blobs.repackrm,
+		TotalPruneSize:    stats.size.remove + stats.size.repackrm + stats.size.unref,
+
+		KeepPacks:     stats.packs.keep,
+		SkippedPacks:  stats.skipped.maxUnused + stats.skipped.maxRepackSize,
+		MissingPacks:  stats.packs.missing,
+		RepackPackIDs: plan.repackPacks.List(),
+		RemovePackIDs: removePacks.List(),
+	}
+	s.RemainingBlobs = s.TotalBlobs - s.TotalPruneBlobs
+	s.RemainingSize = s.TotalSize - s.TotalPruneSize
+	s.UnusedAfterPrune = stats.size.duplicate + stats.size.unused - stats.size.remove - stats.size.repackrm
 
-	Verbosef("will remove %d invalid files\n", len(invalidFiles))
-	for _, id := range invalidFiles {
-		removePacks.Insert(id)
+	return json.NewEncoder(gopts.stdout).Encode(s)
+}
+
+// printPrunePlan lists the packs which would be deleted and repacked.
+func printPrunePlan(plan prunePlan) {
+	for _, id := range plan.removePacksFirst.List() {
+		Printf("would delete unreferenced pack %v\n", id.Str())
+	}
+	for _, id := range plan.repackPacks.List() {
+		Printf("would repack pack %v\n", id.Str())
 	}
+	for _, id := range plan.removePacks.List() {
+		Printf("would delete pack %v\n", id.Str())
+	}
+	for _, id := range plan.ignorePacks.List() {
+		Printf("would remove missing pack %v from the index\n", id.Str())
+	}
+	Printf("\n")
+}
 
-	for packID, p := range idx.Packs {
+// doPrune deletes and repacks the packs selected in plan and writes a new
+// index.
+func doPrune(gopts GlobalOptions, repo *repository.Repository, plan prunePlan) error {
+	ctx := gopts.ctx
 
-		hasActiveBlob := false
-		for _, blob := range p.Entries {
-			h := restic.BlobHandle{ID: blob.ID, Type: blob.Type}
-			if usedBlobs.Has(h) {
-				hasActiveBlob = true
-				continue
-			}
+	if len(plan.removePacksFirst) != 0 {
+		if !gopts.JSON {
+			Verbosef("deleting unreferenced packs\n")
+		}
+		deleteFiles(gopts, repo, plan.removePacksFirst, restic.DataFile, "packs deleted")
+	}
 
-			removeBytes += uint64(blob.Length)
+	if len(plan.repackPacks) != 0 {
+		if !gopts.JSON {
+			Verbosef("repacking packs\n")
+		}
+		bar := newProgressMax(!gopts.Quiet && !gopts.JSON, uint64(len(plan.repackPacks)), "packs repacked")
+		bar.Start()
+		_, err := repository.Repack(ctx, repo, plan.repackPacks, plan.keepBlobs, bar)
+		if err != nil {
+			return err
 		}
+		bar.Done()
 
-		if hasActiveBlob {
-			continue
+		// the repacked packs are removed as well
+		plan.removePacks.Merge(plan.repackPacks)
+	}
+
+	if len(plan.removePacks) == 0 && len(plan.ignorePacks) == 0 {
+		if !gopts.JSON {
+			Verbosef("done\n")
 		}
+		return nil
+	}
 
-		removePacks.Insert(packID)
+	blacklist := restic.NewIDSet()
+	blacklist.Merge(plan.removePacks)
+	blacklist.Merge(plan.ignorePacks)
+	if err := rebuildIndexFiles(gopts, repo, blacklist); err != nil {
+		return err
+	}
 
-		if !rewritePacks.Has(packID) {
-			return errors.Fatalf("pack %v is unneeded, but not contained in rewritePacks", packID.Str())
+	if len(plan.removePacks) != 0 {
+		if !gopts.JSON {
+			Verbosef("removing %d old packs\n", len(plan.removePacks))
 		}
+		deleteFiles(gopts, repo, plan.removePacks, restic.DataFile, "packs deleted")
+	}
 
-		rewritePacks.Delete(packID)
+	if !gopts.JSON {
+		Verbosef("done\n")
+	}
+	return nil
+}
+
+// rebuildIndexFiles writes new index files which leave out the packs in
+// removePacks and deletes the old index files.
+func rebuildIndexFiles(gopts GlobalOptions, repo *repository.Repository, removePacks restic.IDSet) error {
+	if !gopts.JSON {
+		Verbosef("rebuilding index\n")
 	}
 
-	Verbosef("will delete %d packs and rewrite %d packs, this frees %s\n",
-		len(removePacks), len(rewritePacks), formatBytes(uint64(removeBytes)))
+	mi, ok := repo.Index().(*repository.MasterIndex)
+	if !ok {
+		return errors.Errorf("unexpected index type %T", repo.Index())
+	}
 
-	var obsoletePacks restic.IDSet
-	if len(rewritePacks) != 0 {
-		bar = newProgressMax(!gopts.Quiet, uint64(len(rewritePacks)), "packs rewritten")
-		bar.Start()
-		obsoletePacks, err = repository.Repack(ctx, repo, rewritePacks, usedBlobs, bar)
+	obsoleteIndexes, err := mi.Save(gopts.ctx, repo, removePacks)
+	if err != nil {
+		return err
+	}
+
+	if !gopts.JSON {
+		Verbosef("deleting obsolete index files\n")
+	}
+	deleteFiles(gopts, repo, obsoleteIndexes, restic.IndexFile, "index files deleted")
+	return nil
+}
+
+// deleteFiles removes the files of type tpe listed in fileList, errors are
+// only reported as warnings.
+func deleteFiles(gopts GlobalOptions, repo restic.Repository, fileList restic.IDSet, tpe restic.FileType, description string) {
+	bar := newProgressMax(!gopts.Quiet && !gopts.JSON, uint64(len(fileList)), description)
+	bar.Start()
+	for id := range fileList {
+		h := restic.Handle{Type: tpe, Name: id.String()}
+		err := repo.Backend().Remove(gopts.ctx, h)
 		if err != nil {
-			return err
+			Warnf("unable to remove %v from the repository\n", h)
+		} else if gopts.verbosity >= 2 && !gopts.JSON {
+			Printf("removed %v\n", h)
 		}
-		bar.Done()
+		bar.Report(restic.Stat{Blobs: 1})
 	}
+	bar.Done()
+}
 
-	removePacks.Merge(obsoletePacks)
+// getUsedBlobs returns the set of all blobs referenced by the snapshots in
+// the repository.
+func getUsedBlobs(gopts GlobalOptions, repo restic.Repository) (usedBlobs restic.BlobSet, err error) {
+	ctx := gopts.ctx
 
-	if err = rebuildIndex(ctx, repo, removePacks); err != nil {
-		return err
+	if !gopts.JSON {
+		Verbosef("loading all snapshots...\n")
+	}
+	snapshots, err := restic.LoadAllSnapshots(ctx, repo)
+	if err != nil {
+		return nil, err
 	}
 
-	if len(removePacks) != 0 {
-		bar = newProgressMax(!gopts.Quiet, uint64(len(removePacks)), "packs deleted")
-		bar.Start()
-		for packID := range removePacks {
-			h := restic.Handle{Type: restic.DataFile, Name: packID.String()}
-			err = repo.Backend().Remove(ctx, h)
-			if err != nil {
-				Warnf("unable to remove file %v from the repository\n", packID.Str())
+	if !gopts.JSON {
+		Verbosef("finding data that is still in use for %d snapshots\n", len(snapshots))
+	}
+
+	usedBlobs = restic.NewBlobSet()
+	seenBlobs := restic.NewBlobSet()
+
+	bar := newProgressMax(!gopts.Quiet && !gopts.JSON, uint64(len(snapshots)), "snapshots")
+	bar.Start()
+	for _, sn := range snapshots {
+		debug.Log("process snapshot %v", sn.ID())
+
+		err = restic.FindUsedBlobs(ctx, repo, *sn.Tree, usedBlobs, seenBlobs)
+		if err != nil {
+			if repo.Backend().IsNotExist(err) {
+				return nil, errors.Fatal("unable to load a tree from the repo: " + err.Error())
 			}
-			bar.Report(restic.Stat{Blobs: 1})
+
+			return nil, err
 		}
-		bar.Done()
+
+		debug.Log("processed snapshot %v", sn.ID())
+		bar.Report(restic.Stat{Blobs: 1})
 	}
+	bar.Done()
 
-	Verbosef("done\n")
-	return nil
+	return usedBlobs, nil
 }
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/index"
+	"github.com/restic/restic/internal/restic"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var cmdRepairIndex = &cobra.Command{
+	Use:   "index [flags]",
+	Short: "Build a new index",
+	Long: `
+The "repair index" command creates a new index based on the pack files in the
+repository.
+
+The existing index files are loaded and reused for all pack files whose size
+matches the index entries. Only the headers of pack files which are missing
+from the index, or which are listed in an index file that cannot be loaded,
+are read. Entries for pack files which no longer exist are dropped. Use
+"--read-all-packs" to ignore the existing index and read the headers of all
+pack files instead.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runRebuildIndex(repairIndexOptions, globalOptions)
+	},
+}
+
+var cmdRebuildIndex = &cobra.Command{
+	Use:               "rebuild-index [flags]",
+	Short:             cmdRepairIndex.Short,
+	Long:              cmdRepairIndex.Long,
+	Deprecated:        `use "repair index" instead`,
+	DisableAutoGenTag: true,
+	RunE:              cmdRepairIndex.RunE,
+}
+
+// RepairIndexOptions collects all options for the repair index command.
+type RepairIndexOptions struct {
+	ReadAllPacks bool
+}
+
+var repairIndexOptions RepairIndexOptions
+
+func init() {
+	cmdRepair.AddCommand(cmdRepairIndex)
+	// add alias for old name
+	cmdRoot.AddCommand(cmdRebuildIndex)
+
+	for _, f := range []*pflag.FlagSet{cmdRepairIndex.Flags(), cmdRebuildIndex.Flags()} {
+		f.BoolVar(&repairIndexOptions.ReadAllPacks, "read-all-packs", false, "read all pack files to generate new index from scratch")
+	}
+}
+
+func runRebuildIndex(opts RepairIndexOptions, gopts GlobalOptions) error {
+	if err := checkAppendOnly(gopts, "repairing the index"); err != nil {
+		return err
+	}
+
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	lock, err := lockRepoExclusive(repo)
+	defer unlockRepo(lock)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(gopts.ctx)
+	defer cancel()
+	return rebuildIndex(ctx, opts, repo, restic.NewIDSet())
+}
+
+func rebuildIndex(ctx context.Context, opts RepairIndexOptions, repo restic.Repository, ignorePacks restic.IDSet) error {
+	var oldIndex *index.Index
+	if !opts.ReadAllPacks {
+		Verbosef("loading indexes...\n")
+
+		var invalidIndexes restic.IDs
+		var err error
+		oldIndex, invalidIndexes, err = index.LoadValid(ctx, repo, nil)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range invalidIndexes {
+			Warnf("unable to load index %v, the packs listed in it are read again\n", id.Str())
+		}
+	}
+
+	Verbosef("counting files in repo\n")
+
+	var packs uint64
+	err := repo.List(ctx, restic.DataFile, func(restic.ID, int64) error {
+		packs++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	bar := newProgressMax(!globalOptions.Quiet, packs-uint64(len(ignorePacks)), "packs")
+	idx, invalidFiles, err := index.Update(ctx, repo, oldIndex, ignorePacks, bar)
+	if err != nil {
+		return err
+	}
+
+	if globalOptions.verbosity >= 2 {
+		for _, id := range invalidFiles {
+			Printf("skipped incomplete pack file: %v\n", id)
+		}
+	}
+
+	if oldIndex != nil {
+		removed := 0
+		for id := range oldIndex.Packs {
+			if _, ok := idx.Packs[id]; !ok {
+				removed++
+				if globalOptions.verbosity >= 2 {
+					Printf("removed pack %v from the index\n", id.Str())
+				}
+			}
+		}
+		if removed > 0 {
+			Verbosef("removed %d packs which no longer exist from the index\n", removed)
+		}
+	}
+
+	Verbosef("finding old index files\n")
+
+	var supersedes restic.IDs
+	err = repo.List(ctx, restic.IndexFile, func(id restic.ID, size int64) error {
+		supersedes = append(supersedes, id)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	ids, err := idx.Save(ctx, repo, supersedes)
+	if err != nil {
+		return errors.Fatalf("unable to save index, last error was: %v", err)
+	}
+
+	Verbosef("saved new indexes as %v\n", ids)
+
+	Verbosef("remove %d old index files\n", len(supersedes))
+
+	for _, id := range supersedes {
+		if err := repo.Backend().Remove(ctx, restic.Handle{
+			Type: restic.IndexFile,
+			Name: id.String(),
+		}); err != nil {
+			Warnf("error removing old index %v: %v\n", id.Str(), err)
+		}
+	}
+
+	return nil
+}
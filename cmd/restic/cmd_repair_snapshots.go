@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/walker"
+
+	"github.com/spf13/cobra"
+)
+
+var cmdRepairSnapshots = &cobra.Command{
+	Use:   "snapshots [flags] [snapshot ID] [...]",
+	Short: "Repair snapshots",
+	Long: `
+The "repair snapshots" command repairs snapshots which reference trees or data
+blobs that cannot be loaded, for example because "check" reported missing
+blobs. It walks the trees of each snapshot and writes a new snapshot which
+only contains the remaining data:
+
+* directories whose tree cannot be loaded are replaced with empty directories
+* files are truncated before the first data blob which is missing from the
+  index, files without any remaining data are removed
+
+Each change is logged, so that you know which data was lost. Snapshots whose
+root tree cannot be loaded are removed when --forget is given.
+
+The tag "repaired" is added to the new snapshots, unless --forget is used. With
+--forget, the original snapshots are removed instead.
+
+Run "restic repair index" first if the index may be damaged, otherwise blobs
+which only are missing from the index are removed from the snapshots, too.
+Use --dry-run to see what would be changed. The data which is no longer
+referenced is removed by the next "prune" run.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runRepairSnapshots(repairSnapshotOptions, globalOptions, args)
+	},
+}
+
+// RepairSnapshotOptions collects all options for the repair snapshots command.
+type RepairSnapshotOptions struct {
+	Forget bool
+	DryRun bool
+	Hosts  []string
+	Tags   restic.TagLists
+	Paths  []string
+}
+
+var repairSnapshotOptions RepairSnapshotOptions
+
+func init() {
+	cmdRepair.AddCommand(cmdRepairSnapshots)
+
+	f := cmdRepairSnapshots.Flags()
+	f.BoolVarP(&repairSnapshotOptions.Forget, "forget", "", false, "remove the original snapshots after creating the new ones")
+	f.BoolVarP(&repairSnapshotOptions.DryRun, "dry-run", "n", false, "do not do anything, just print what would be done")
+	f.StringArrayVarP(&repairSnapshotOptions.Hosts, "host", "H", nil, "only consider snapshots for this `host`, when no snapshot ID is given (can be specified multiple times)")
+	f.Var(&repairSnapshotOptions.Tags, "tag", "only consider snapshots which include this `taglist`, when no snapshot ID is given")
+	f.StringArrayVar(&repairSnapshotOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path`, when no snapshot ID is given")
+}
+
+// repairFileNode returns node truncated before the first data blob which is
+// missing from the index, or nil if no data remains. Unmodified nodes are
+// returned as is. Lost data is reported with printf.
+func repairFileNode(repo restic.Repository, node *restic.Node, path string, printf func(string, ...interface{})) *restic.Node {
+	var size uint64
+	for i, id := range node.Content {
+		blobSize, found := repo.LookupBlobSize(id, restic.DataBlob)
+		if found {
+			size += uint64(blobSize)
+			continue
+		}
+
+		if i == 0 {
+			printf("  file %q: removed, the first data blob %v is missing\n", path, id.Str())
+			return nil
+		}
+
+		printf("  file %q: truncated to %d bytes, data blob %v and %d following blobs are not used\n",
+			path, size, id.Str(), len(node.Content)-i-1)
+
+		newNode := *node
+		newNode.Content = append(restic.IDs(nil), node.Content[:i]...)
+		newNode.Size = size
+		return &newNode
+	}
+
+	return node
+}
+
+// repairSnapshot replaces the unreadable parts of the tree of sn, see
+// filterAndReplaceSnapshot.
+func repairSnapshot(ctx context.Context, repo *repository.Repository, sn *restic.Snapshot, opts RepairSnapshotOptions) (bool, error) {
+	if sn.Tree == nil {
+		return false, errors.Errorf("snapshot %v has nil tree", sn.ID().Str())
+	}
+
+	// lost data is reported even with --quiet, so the snapshot is printed
+	// before the first loss if it has not been printed yet
+	header := fmt.Sprintf("\nsnapshot %s of %v at %s\n", sn.ID().Str(), sn.Paths, sn.Time)
+	printedHeader := globalOptions.verbosity >= 1
+	Verbosef("%s", header)
+
+	printf := func(format string, args ...interface{}) {
+		if !printedHeader {
+			Printf("%s", header)
+			printedHeader = true
+		}
+		Printf(format, args...)
+	}
+
+	filter := func(ctx context.Context, saver walker.TreeLoadSaver, sn *restic.Snapshot) (restic.ID, error) {
+		return walker.FilterTree(ctx, saver, "/", *sn.Tree, &walker.TreeFilterVisitor{
+			RewriteNode: func(node *restic.Node, path string) *restic.Node {
+				if node.Type != "file" {
+					return node
+				}
+				return repairFileNode(repo, node, path, printf)
+			},
+			RewriteFailedTree: func(nodeID restic.ID, path string, err error) (restic.ID, error) {
+				if path == "/" {
+					printf("  dir %q: root tree %v cannot be loaded: %v\n", path, nodeID.Str(), err)
+					return restic.ID{}, nil
+				}
+
+				printf("  dir %q: tree %v cannot be loaded, replaced with an empty directory: %v\n", path, nodeID.Str(), err)
+				return saver.SaveTree(ctx, restic.NewTree())
+			},
+		})
+	}
+
+	return filterAndReplaceSnapshot(ctx, repo, sn, filter, opts.DryRun, opts.Forget, "repaired")
+}
+
+func runRepairSnapshots(opts RepairSnapshotOptions, gopts GlobalOptions, args []string) error {
+	if opts.Forget && !opts.DryRun {
+		if err := checkAppendOnly(gopts, "removing the original snapshots"); err != nil {
+			return err
+		}
+	}
+
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	if !gopts.NoLock {
+		var lock *restic.Lock
+		if opts.Forget && !opts.DryRun {
+			Verbosef("create exclusive lock for repository\n")
+			lock, err = lockRepoExclusive(repo)
+		} else {
+			lock, err = lockRepo(repo)
+		}
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(gopts.ctx)
+	defer cancel()
+
+	if err = repo.LoadIndex(ctx); err != nil {
+		return err
+	}
+
+	changedCount := 0
+	for sn := range FindFilteredSnapshots(ctx, repo, opts.Hosts, opts.Tags, opts.Paths, args) {
+		changed, err := repairSnapshot(ctx, repo, sn, opts)
+		if err != nil {
+			return errors.Fatalf("unable to repair snapshot ID %q: %v", sn.ID().Str(), err)
+		}
+		if changed {
+			changedCount++
+		}
+	}
+
+	Verbosef("\n")
+	if changedCount == 0 {
+		if !opts.DryRun {
+			Verbosef("no snapshots were modified\n")
+		} else {
+			Verbosef("no snapshots would be modified\n")
+		}
+	} else {
+		if !opts.DryRun {
+			Verbosef("modified %v snapshots\n", changedCount)
+		} else {
+			Verbosef("would modify %v snapshots\n", changedCount)
+		}
+	}
+
+	return nil
+}
@@ -1,15 +1,23 @@
 package main
 
 import (
+	"context"
+	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/filter"
 	"github.com/restic/restic/internal/restic"
 	"github.com/restic/restic/internal/restorer"
+	"github.com/restic/restic/internal/ui"
+	"github.com/restic/restic/internal/ui/json"
+	"github.com/restic/restic/internal/ui/termstatus"
 
 	"github.com/spf13/cobra"
+	tomb "gopkg.in/tomb.v2"
 )
 
 var cmdRestore = &cobra.Command{
@@ -22,6 +30,13 @@ a directory.
 The special snapshot "latest" can be used to restore the latest snapshot in the
 repository.
 
+Files which already exist in the target directory are overwritten by default.
+With "--overwrite if-changed", existing files are compared with the snapshot
+and only the changed parts are downloaded and written, "--overwrite if-newer"
+only replaces files which are older than the file in the snapshot and
+"--overwrite never" keeps all existing files. "--delete" removes all files from
+the target directory which are not contained in the snapshot.
+` + filterRulesHelp + `
 EXIT STATUS
 ===========
 
@@ -39,10 +54,15 @@ type RestoreOptions struct {
 	InsensitiveExclude []string
 	Include            []string
 	InsensitiveInclude []string
+	ExcludeRules       []string
+	IncludeRules       []string
 	Target             string
 	Hosts              []string
 	Paths              []string
 	Tags               restic.TagLists
+	Sparse             bool
+	Overwrite          restorer.OverwriteBehavior
+	Delete             bool
 	Verify             bool
 }
 
@@ -56,18 +76,23 @@ func init() {
 	flags.StringArrayVar(&restoreOptions.InsensitiveExclude, "iexclude", nil, "same as `--exclude` but ignores the casing of filenames")
 	flags.StringArrayVarP(&restoreOptions.Include, "include", "i", nil, "include a `pattern`, exclude everything else (can be specified multiple times)")
 	flags.StringArrayVar(&restoreOptions.InsensitiveInclude, "iinclude", nil, "same as `--include` but ignores the casing of filenames")
+	flags.StringArrayVar(&restoreOptions.ExcludeRules, "exclude-rule", nil, "exclude items matching the filter `rule`, see \"FILTER RULES\" (can be specified multiple times)")
+	flags.StringArrayVar(&restoreOptions.IncludeRules, "include-rule", nil, "include items matching the filter `rule`, exclude everything else (can be specified multiple times)")
 	flags.StringVarP(&restoreOptions.Target, "target", "t", "", "directory to extract data to")
 
 	flags.StringArrayVarP(&restoreOptions.Hosts, "host", "H", nil, `only consider snapshots for this host when the snapshot ID is "latest" (can be specified multiple times)`)
 	flags.Var(&restoreOptions.Tags, "tag", "only consider snapshots which include this `taglist` for snapshot ID \"latest\"")
 	flags.StringArrayVar(&restoreOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path` for snapshot ID \"latest\"")
+	flags.BoolVar(&restoreOptions.Sparse, "sparse", false, "restore files as sparse files, all-zero ranges are not written to disk")
+	flags.Var(&restoreOptions.Overwrite, "overwrite", "overwrite `behavior` for existing files, one of (always|if-changed|if-newer|never)")
+	flags.BoolVar(&restoreOptions.Delete, "delete", false, "delete files from the target directory which are not contained in the snapshot")
 	flags.BoolVar(&restoreOptions.Verify, "verify", false, "verify restored files content")
 }
 
 func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
 	ctx := gopts.ctx
-	hasExcludes := len(opts.Exclude) > 0 || len(opts.InsensitiveExclude) > 0
-	hasIncludes := len(opts.Include) > 0 || len(opts.InsensitiveInclude) > 0
+	hasExcludes := len(opts.Exclude) > 0 || len(opts.InsensitiveExclude) > 0 || len(opts.ExcludeRules) > 0
+	hasIncludes := len(opts.Include) > 0 || len(opts.InsensitiveInclude) > 0 || len(opts.IncludeRules) > 0
 
 	for i, str := range opts.InsensitiveExclude {
 		opts.InsensitiveExclude[i] = strings.ToLower(str)
@@ -92,6 +117,16 @@ func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
 		return errors.Fatal("exclude and include patterns are mutually exclusive")
 	}
 
+	excludeRules, err := filter.ParseRules(opts.ExcludeRules)
+	if err != nil {
+		return errors.Fatalf("%v", err)
+	}
+
+	includeRules, err := filter.ParseRules(opts.IncludeRules)
+	if err != nil {
+		return errors.Fatalf("%v", err)
+	}
+
 	snapshotIDString := args[0]
 
 	debug.Log("restore %v to %v", snapshotIDString, opts.Target)
@@ -133,11 +168,52 @@ func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
 		Exitf(2, "creating restorer failed: %v\n", err)
 	}
 
+	res.Sparse = opts.Sparse
+	res.Overwrite = opts.Overwrite
+	res.Delete = opts.Delete
+
+	var termTomb tomb.Tomb
+	term := termstatus.New(gopts.stdout, gopts.stderr, gopts.Quiet)
+	termTomb.Go(func() error { term.Run(termTomb.Context(ctx)); return nil })
+	defer func() {
+		termTomb.Kill(nil)
+		_ = termTomb.Wait()
+	}()
+
+	type RestoreProgressReporter interface {
+		restorer.Progress
+		SetMinUpdatePause(d time.Duration)
+		Run(ctx context.Context) error
+		Error(location string, err error) error
+		Finish()
+
+		// ui.Message
+		P(msg string, args ...interface{})
+	}
+
+	var p RestoreProgressReporter
+	if gopts.JSON {
+		p = json.NewRestore(term, gopts.verbosity)
+	} else {
+		p = ui.NewRestore(term, gopts.verbosity)
+	}
+
+	if s, ok := os.LookupEnv("RESTIC_PROGRESS_FPS"); ok {
+		fps, err := strconv.Atoi(s)
+		if err == nil && fps >= 1 {
+			if fps > 60 {
+				fps = 60
+			}
+			p.SetMinUpdatePause(time.Second / time.Duration(fps))
+		}
+	}
+
+	res.Progress = p
+
 	totalErrors := 0
 	res.Error = func(location string, err error) error {
-		Warnf("ignoring error for %s: %s\n", location, err)
 		totalErrors++
-		return nil
+		return p.Error(location, err)
 	}
 
 	selectExcludeFilter := func(item string, dstpath string, node *restic.Node) (selectedForRestore bool, childMayBeSelected bool) {
@@ -151,11 +227,16 @@ func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
 			Warnf("error for iexclude pattern: %v", err)
 		}
 
+		matchedRule, _, err := excludeRules.Match(filter.ItemFromNode(item, node))
+		if err != nil {
+			Warnf("error for exclude rule: %v", err)
+		}
+
 		// An exclude filter is basically a 'wildcard but foo',
 		// so even if a childMayMatch, other children of a dir may not,
 		// therefore childMayMatch does not matter, but we should not go down
 		// unless the dir is selected for restore
-		selectedForRestore = !matched && !matchedInsensitive
+		selectedForRestore = !matched && !matchedInsensitive && !matchedRule
 		childMayBeSelected = selectedForRestore && node.Type == "dir"
 
 		return selectedForRestore, childMayBeSelected
@@ -172,8 +253,13 @@ func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
 			Warnf("error for iexclude pattern: %v", err)
 		}
 
-		selectedForRestore = matched || matchedInsensitive
-		childMayBeSelected = (childMayMatch || childMayMatchInsensitive) && node.Type == "dir"
+		matchedRule, childMayMatchRule, err := includeRules.Match(filter.ItemFromNode(item, node))
+		if err != nil {
+			Warnf("error for include rule: %v", err)
+		}
+
+		selectedForRestore = matched || matchedInsensitive || matchedRule
+		childMayBeSelected = (childMayMatch || childMayMatchInsensitive || childMayMatchRule) && node.Type == "dir"
 
 		return selectedForRestore, childMayBeSelected
 	}
@@ -184,17 +270,34 @@ func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
 		res.SelectFilter = selectIncludeFilter
 	}
 
-	Verbosef("restoring %s to %s\n", res.Snapshot(), opts.Target)
+	if !gopts.JSON {
+		p.P("restoring %s to %s\n", res.Snapshot(), opts.Target)
+	}
+
+	var t tomb.Tomb
+	t.Go(func() error { return p.Run(t.Context(ctx)) })
 
 	err = res.RestoreTo(ctx, opts.Target)
+
+	// stop the status updates and print the summary
+	t.Kill(nil)
+	if werr := t.Wait(); werr != nil && err == nil {
+		err = werr
+	}
+	p.Finish()
+
 	if err == nil && opts.Verify {
-		Verbosef("verifying files in %s\n", opts.Target)
+		if !gopts.JSON {
+			p.P("verifying files in %s\n", opts.Target)
+		}
 		var count int
 		count, err = res.VerifyFiles(ctx, opts.Target)
-		Verbosef("finished verifying %d files in %s\n", count, opts.Target)
+		if !gopts.JSON {
+			p.P("finished verifying %d files in %s\n", count, opts.Target)
+		}
 	}
-	if totalErrors > 0 {
-		Printf("There were %d errors\n", totalErrors)
+	if totalErrors > 0 && !gopts.JSON {
+		term.Printf("There were %d errors\n", totalErrors)
 	}
 	return err
 }
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/walker"
+
+	"github.com/spf13/cobra"
+)
+
+var cmdRewrite = &cobra.Command{
+	Use:   "rewrite [flags] [snapshotID ...]",
+	Short: "Rewrite snapshots to exclude unwanted files",
+	Long: `
+The "rewrite" command excludes files from existing snapshots. It creates new
+snapshots which contain the same data as the original ones, but without the
+files matching the exclude patterns. The exclude patterns are the same as for
+the "backup" command. All metadata (time, host, tags) is preserved, the new
+snapshots refer to the original ones.
+
+The snapshots to rewrite are selected using the --host, --tag and --path
+options, or by passing a list of snapshot IDs. If neither is given, all
+snapshots are rewritten.
+
+The tag "rewrite" is added to the new snapshots to distinguish them from the
+original ones, unless --forget is used. With --forget, the original snapshots
+are removed from the repository instead. This only removes the snapshots, the
+data which is no longer referenced is removed by the next "prune" run.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runRewrite(rewriteOptions, globalOptions, args)
+	},
+}
+
+// RewriteOptions collects all options for the rewrite command.
+type RewriteOptions struct {
+	excludePatternOptions
+
+	Forget bool
+	DryRun bool
+	Hosts  []string
+	Tags   restic.TagLists
+	Paths  []string
+}
+
+var rewriteOptions RewriteOptions
+
+func init() {
+	cmdRoot.AddCommand(cmdRewrite)
+
+	f := cmdRewrite.Flags()
+	f.BoolVarP(&rewriteOptions.Forget, "forget", "", false, "remove the original snapshots after creating the new ones")
+	f.BoolVarP(&rewriteOptions.DryRun, "dry-run", "n", false, "do not do anything, just print what would be done")
+	f.StringArrayVarP(&rewriteOptions.Hosts, "host", "H", nil, "only consider snapshots for this `host`, when no snapshot ID is given (can be specified multiple times)")
+	f.Var(&rewriteOptions.Tags, "tag", "only consider snapshots which include this `taglist`, when no snapshot ID is given")
+	f.StringArrayVar(&rewriteOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path`, when no snapshot ID is given")
+
+	initExcludePatternOptions(f, &rewriteOptions.excludePatternOptions)
+}
+
+// dryRunTreeSaver computes the IDs of trees without saving them.
+type dryRunTreeSaver struct {
+	restic.Repository
+}
+
+func (r dryRunTreeSaver) SaveTree(ctx context.Context, t *restic.Tree) (restic.ID, error) {
+	buf, err := json.Marshal(t)
+	if err != nil {
+		return restic.ID{}, errors.Wrap(err, "MarshalJSON")
+	}
+
+	// the same encoding as used by Repository.SaveTree
+	buf = append(buf, '\n')
+	return restic.Hash(buf), nil
+}
+
+// rewriteSnapshot saves a new snapshot which only contains the files for
+// which selectByName returns true. It returns false if the snapshot was not
+// modified.
+func rewriteSnapshot(ctx context.Context, repo *repository.Repository, sn *restic.Snapshot, opts RewriteOptions, selectByName walker.SelectByNameFunc) (bool, error) {
+	if sn.Tree == nil {
+		return false, errors.Errorf("snapshot %v has nil tree", sn.ID().Str())
+	}
+
+	filter := func(ctx context.Context, saver walker.TreeLoadSaver, sn *restic.Snapshot) (restic.ID, error) {
+		return walker.FilterTree(ctx, saver, "/", *sn.Tree, &walker.TreeFilterVisitor{
+			SelectByName: selectByName,
+			PrintExclude: func(path string) { Verbosef("excluding %s\n", path) },
+		})
+	}
+
+	return filterAndReplaceSnapshot(ctx, repo, sn, filter, opts.DryRun, opts.Forget, "rewrite")
+}
+
+// filterAndReplaceSnapshot calls filter to create a new tree for sn. If the
+// tree was modified, a new snapshot referring to the new tree is saved. The
+// new snapshot is tagged with addTag, unless forget is set, which removes the
+// old snapshot instead. If filter returns the null ID, nothing of the snapshot
+// could be kept and it is only removed, if forget is set. With dryRun, the
+// trees are not saved and the repository is not modified.
+func filterAndReplaceSnapshot(ctx context.Context, repo *repository.Repository, sn *restic.Snapshot,
+	filter func(ctx context.Context, saver walker.TreeLoadSaver, sn *restic.Snapshot) (restic.ID, error),
+	dryRun bool, forget bool, addTag string) (bool, error) {
+
+	var saver walker.TreeLoadSaver = repo
+	if dryRun {
+		saver = dryRunTreeSaver{repo}
+	}
+
+	filteredTree, err := filter(ctx, saver, sn)
+	if err != nil {
+		return false, err
+	}
+
+	if filteredTree.IsNull() {
+		if !forget {
+			Warnf("nothing of snapshot %v can be kept, use --forget to remove it\n", sn.ID().Str())
+			return false, nil
+		}
+		if dryRun {
+			Verbosef("would remove the snapshot, nothing of it can be kept\n")
+			return true, nil
+		}
+		h := restic.Handle{Type: restic.SnapshotFile, Name: sn.ID().String()}
+		if err = repo.Backend().Remove(ctx, h); err != nil {
+			return false, err
+		}
+		debug.Log("removed snapshot %v", sn.ID())
+		Verbosef("removed snapshot %v, nothing of it can be kept\n", sn.ID().Str())
+		return true, nil
+	}
+
+	if filteredTree.Equal(*sn.Tree) {
+		debug.Log("snapshot %v not modified", sn.ID())
+		return false, nil
+	}
+
+	if dryRun {
+		Verbosef("would save new snapshot\n")
+		if forget {
+			Verbosef("would remove old snapshot\n")
+		}
+		return true, nil
+	}
+
+	if err = repo.Flush(ctx); err != nil {
+		return false, err
+	}
+
+	oldID := *sn.ID()
+
+	// retain the original snapshot id over all changes
+	if sn.Original == nil {
+		sn.Original = sn.ID()
+	}
+	if !forget {
+		sn.AddTags([]string{addTag})
+	}
+	sn.Tree = &filteredTree
+
+	id, err := repo.SaveJSONUnpacked(ctx, restic.SnapshotFile, sn)
+	if err != nil {
+		return false, err
+	}
+	Verbosef("saved new snapshot %v\n", id.Str())
+
+	if forget {
+		h := restic.Handle{Type: restic.SnapshotFile, Name: oldID.String()}
+		if err = repo.Backend().Remove(ctx, h); err != nil {
+			return false, err
+		}
+		debug.Log("removed old snapshot %v", oldID)
+		Verbosef("removed old snapshot %v\n", oldID.Str())
+	}
+
+	return true, nil
+}
+
+func runRewrite(opts RewriteOptions, gopts GlobalOptions, args []string) error {
+	if opts.excludePatternOptions.Empty() {
+		return errors.Fatal("Nothing to do: no excludes provided")
+	}
+
+	if opts.Forget && !opts.DryRun {
+		if err := checkAppendOnly(gopts, "removing the original snapshots"); err != nil {
+			return err
+		}
+	}
+
+	rejectByNameFuncs, err := opts.excludePatternOptions.CollectPatterns()
+	if err != nil {
+		return err
+	}
+
+	selectByName := func(nodepath string) bool {
+		for _, reject := range rejectByNameFuncs {
+			if reject(nodepath) {
+				return false
+			}
+		}
+		return true
+	}
+
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	if !gopts.NoLock {
+		var lock *restic.Lock
+		if opts.Forget && !opts.DryRun {
+			Verbosef("create exclusive lock for repository\n")
+			lock, err = lockRepoExclusive(repo)
+		} else {
+			lock, err = lockRepo(repo)
+		}
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(gopts.ctx)
+	defer cancel()
+
+	if err = repo.LoadIndex(ctx); err != nil {
+		return err
+	}
+
+	changedCount := 0
+	for sn := range FindFilteredSnapshots(ctx, repo, opts.Hosts, opts.Tags, opts.Paths, args) {
+		Verbosef("\nsnapshot %s of %v at %s\n", sn.ID().Str(), sn.Paths, sn.Time)
+		changed, err := rewriteSnapshot(ctx, repo, sn, opts, selectByName)
+		if err != nil {
+			return errors.Fatalf("unable to rewrite snapshot ID %q: %v", sn.ID().Str(), err)
+		}
+		if changed {
+			changedCount++
+		}
+	}
+
+	Verbosef("\n")
+	if changedCount == 0 {
+		if !opts.DryRun {
+			Verbosef("no snapshots were modified\n")
+		} else {
+			Verbosef("no snapshots would be modified\n")
+		}
+	} else {
+		if !opts.DryRun {
+			Verbosef("modified %v snapshots\n", changedCount)
+		} else {
+			Verbosef("would modify %v snapshots\n", changedCount)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/restserver"
+
+	"github.com/spf13/cobra"
+)
+
+var cmdServe = &cobra.Command{
+	Use:   "serve [flags]",
+	Short: "Serve repositories via the REST protocol",
+	Long: `
+The "serve" command starts an HTTP server which provides access to the
+repository location given with "--repo" via the REST protocol. Clients access
+it with the "rest:" backend, the server does not need the repository password.
+
+Repositories below the location are served as well: the URL
+"rest:http://host:8000/foo/bar/" refers to the repository at "<location>/foo/bar",
+which is created when "restic init" is run for the URL.
+
+Users are authenticated with the entries of the file given with
+"--htpasswd-file", passwords hashed with bcrypt and SHA1 are supported. The
+server refuses to start without it, unless authentication is disabled
+explicitly with "--no-auth". Then anyone who can connect to the listen address
+can read, modify and delete the repositories. With "--private-repos", users
+may only access repositories below a directory named after their user name.
+
+In append-only mode, files in the repositories cannot be removed or
+overwritten, except for lock files. This protects the data from clients which
+are compromised.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runServe(serveOptions, globalOptions, args)
+	},
+}
+
+// ServeOptions collects all options for the serve command.
+type ServeOptions struct {
+	Listen         string
+	HtpasswdFile   string
+	NoAuth         bool
+	AppendOnly     bool
+	PrivateRepos   bool
+	NoVerifyUpload bool
+	TLSCert        string
+	TLSKey         string
+}
+
+var serveOptions ServeOptions
+
+func init() {
+	cmdRoot.AddCommand(cmdServe)
+
+	f := cmdServe.Flags()
+	f.StringVar(&serveOptions.Listen, "listen", ":8000", "listen on this `address`")
+	f.StringVar(&serveOptions.HtpasswdFile, "htpasswd-file", "", "authenticate users with the entries of this htpasswd `file`")
+	f.BoolVar(&serveOptions.NoAuth, "no-auth", false, "allow access without authentication, by anyone who can connect to the server")
+	f.BoolVar(&serveOptions.AppendOnly, "append-only", false, "refuse to remove or overwrite files, except for locks")
+	f.BoolVar(&serveOptions.PrivateRepos, "private-repos", false, "users may only access repositories below a directory named after the user")
+	f.BoolVar(&serveOptions.NoVerifyUpload, "no-verify-upload", false, "do not check that the name of uploaded files matches the hash of their content")
+	f.StringVar(&serveOptions.TLSCert, "tls-cert", "", "serve HTTPS using the certificate in this `file`")
+	f.StringVar(&serveOptions.TLSKey, "tls-key", "", "serve HTTPS using the private key in this `file`")
+}
+
+// subRepoLocation returns the location of the repository at path, relative to
+// the location base.
+func subRepoLocation(base, path string) string {
+	if path == "" {
+		return base
+	}
+	return strings.TrimSuffix(base, "/") + "/" + path
+}
+
+// newRESTServer returns a server for the repositories at the location given
+// in gopts.
+func newRESTServer(opts ServeOptions, gopts GlobalOptions) (*restserver.Server, error) {
+	if gopts.Repo == "" {
+		return nil, errors.Fatal("Please specify repository location (-r)")
+	}
+
+	if opts.HtpasswdFile == "" && !opts.NoAuth {
+		return nil, errors.Fatal("--htpasswd-file is required, use --no-auth to serve the repositories without authentication")
+	}
+
+	if opts.HtpasswdFile != "" && opts.NoAuth {
+		return nil, errors.Fatal("--htpasswd-file and --no-auth cannot be used together")
+	}
+
+	if opts.PrivateRepos && opts.HtpasswdFile == "" {
+		return nil, errors.Fatal("--private-repos requires --htpasswd-file")
+	}
+
+	srvOpts := restserver.Options{
+		Open: func(ctx context.Context, path string, newRepo bool) (restic.Backend, error) {
+			location := subRepoLocation(gopts.Repo, path)
+			if newRepo {
+				return create(location, gopts.extended)
+			}
+			return openBackend(location, gopts, gopts.extended)
+		},
+		AppendOnly:     opts.AppendOnly,
+		PrivateRepos:   opts.PrivateRepos,
+		NoVerifyUpload: opts.NoVerifyUpload,
+	}
+
+	if opts.HtpasswdFile != "" {
+		auth, err := restserver.LoadHtpasswd(opts.HtpasswdFile)
+		if err != nil {
+			return nil, errors.Fatalf("%v", err)
+		}
+		srvOpts.Auth = auth
+	}
+
+	srv, err := restserver.New(srvOpts)
+	if err != nil {
+		return nil, errors.Fatalf("%v", err)
+	}
+
+	return srv, nil
+}
+
+func runServe(opts ServeOptions, gopts GlobalOptions, args []string) error {
+	if len(args) > 0 {
+		return errors.Fatal("the serve command expects no arguments, only options - please see `restic help serve` for usage and flags")
+	}
+
+	if (opts.TLSCert == "") != (opts.TLSKey == "") {
+		return errors.Fatal("--tls-cert and --tls-key must be used together")
+	}
+
+	srv, err := newRESTServer(opts, gopts)
+	if err != nil {
+		return err
+	}
+
+	httpSrv := &http.Server{
+		Addr:    opts.Listen,
+		Handler: srv,
+	}
+
+	go func() {
+		<-gopts.ctx.Done()
+		_ = httpSrv.Close()
+	}()
+
+	if opts.TLSCert != "" {
+		Verbosef("serving HTTPS on %v\n", opts.Listen)
+		err = httpSrv.ListenAndServeTLS(opts.TLSCert, opts.TLSKey)
+	} else {
+		Verbosef("serving HTTP on %v\n", opts.Listen)
+		err = httpSrv.ListenAndServe()
+	}
+
+	if err == http.ErrServerClosed {
+		err = nil
+	}
+
+	if cerr := srv.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
@@ -213,6 +213,10 @@ func PrintSnapshots(stdout io.Writer, list restic.Snapshots, reasons []restic.Ke
 			Paths:     sn.Paths,
 		}
 
+		if sn.Partial {
+			data.ID += " (partial)"
+		}
+
 		if len(reasons) > 0 {
 			id := sn.ID()
 			data.Reasons = keepReasons[*id].Matches
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"runtime"
+	"sync"
+
+	"github.com/restic/restic/internal/errors"
+
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/zstd"
+)
+
+// The output of dump is compressed in parallel: the data is split into blocks
+// which are compressed by separate goroutines and written in order. For
+// deflate, each block is compressed with the end of the previous block as the
+// dictionary and terminated with a sync flush, so the blocks form a single
+// valid deflate stream.
+
+// compressBlockSize is the amount of data compressed by a single goroutine.
+const compressBlockSize = 1 << 20
+
+// deflateDictSize is the size of the deflate window.
+const deflateDictSize = 32 << 10
+
+// newCompressWriter returns a writer which compresses the data written to it
+// with algorithm and writes the result to out. The writer must be closed to
+// write the remaining data.
+func newCompressWriter(out io.Writer, algorithm string) (io.WriteCloser, error) {
+	switch algorithm {
+	case "gzip":
+		return newGzipWriter(out)
+	case "zstd":
+		enc, err := zstd.NewWriter(out, zstd.WithEncoderConcurrency(runtime.GOMAXPROCS(0)))
+		if err != nil {
+			return nil, errors.Wrap(err, "zstd.NewWriter")
+		}
+		return enc, nil
+	}
+
+	return nil, errors.Errorf("unknown compression %q", algorithm)
+}
+
+// deflateWriter compresses data with deflate using several goroutines.
+type deflateWriter struct {
+	out   io.Writer
+	level int
+
+	buf  []byte
+	dict []byte
+
+	// blocks contains the compressed blocks in the order they must be
+	// written, its capacity limits the number of concurrently compressed
+	// blocks
+	blocks chan chan []byte
+	done   chan struct{}
+
+	m   sync.Mutex
+	err error
+}
+
+// newDeflateWriter returns a writer which writes a raw deflate stream to out.
+func newDeflateWriter(out io.Writer, level int) *deflateWriter {
+	w := &deflateWriter{
+		out:    out,
+		level:  level,
+		blocks: make(chan chan []byte, runtime.GOMAXPROCS(0)),
+		done:   make(chan struct{}),
+	}
+
+	go w.writeBlocks()
+
+	return w
+}
+
+// writeBlocks writes the compressed blocks to the output in order.
+func (w *deflateWriter) writeBlocks() {
+	defer close(w.done)
+
+	for block := range w.blocks {
+		buf := <-block
+		if w.getErr() != nil {
+			continue
+		}
+
+		_, err := w.out.Write(buf)
+		if err != nil {
+			w.setErr(errors.Wrap(err, "Write"))
+		}
+	}
+}
+
+func (w *deflateWriter) getErr() error {
+	w.m.Lock()
+	defer w.m.Unlock()
+	return w.err
+}
+
+func (w *deflateWriter) setErr(err error) {
+	w.m.Lock()
+	defer w.m.Unlock()
+	if w.err == nil {
+		w.err = err
+	}
+}
+
+// compress starts compressing data in a new goroutine.
+func (w *deflateWriter) compress(data, dict []byte, final bool) {
+	block := make(chan []byte, 1)
+	w.blocks <- block
+
+	go func() {
+		var buf bytes.Buffer
+		fw, err := flate.NewWriterDict(&buf, w.level, dict)
+		if err == nil {
+			_, err = fw.Write(data)
+		}
+		if err == nil {
+			if final {
+				err = fw.Close()
+			} else {
+				err = fw.Flush()
+			}
+		}
+		if err != nil {
+			w.setErr(errors.Wrap(err, "deflate"))
+		}
+
+		block <- buf.Bytes()
+	}()
+}
+
+// flushBlock starts compressing the buffered data.
+func (w *deflateWriter) flushBlock(final bool) {
+	w.compress(w.buf, w.dict, final)
+
+	// all blocks but the last one are full, the end of the block is the
+	// dictionary for the next one
+	if len(w.buf) >= deflateDictSize {
+		w.dict = w.buf[len(w.buf)-deflateDictSize:]
+	}
+	w.buf = nil
+}
+
+// Write compresses p.
+func (w *deflateWriter) Write(p []byte) (n int, err error) {
+	if err := w.getErr(); err != nil {
+		return 0, err
+	}
+
+	for len(p) > 0 {
+		if w.buf == nil {
+			w.buf = make([]byte, 0, compressBlockSize)
+		}
+
+		l := len(p)
+		if free := compressBlockSize - len(w.buf); l > free {
+			l = free
+		}
+
+		w.buf = append(w.buf, p[:l]...)
+		p = p[l:]
+		n += l
+
+		if len(w.buf) == compressBlockSize {
+			w.flushBlock(false)
+		}
+	}
+
+	return n, nil
+}
+
+// Close compresses the remaining data, ends the deflate stream and waits
+// until all data has been written.
+func (w *deflateWriter) Close() error {
+	w.flushBlock(true)
+	close(w.blocks)
+	<-w.done
+
+	return w.getErr()
+}
+
+// gzipWriter writes a gzip stream with data compressed by a deflateWriter.
+type gzipWriter struct {
+	out  io.Writer
+	w    *deflateWriter
+	crc  uint32
+	size uint32
+}
+
+// newGzipWriter writes the gzip header to out and returns a writer for the
+// data.
+func newGzipWriter(out io.Writer) (*gzipWriter, error) {
+	// magic, compression method deflate, no flags, no modification time,
+	// no extra flags and unknown operating system
+	header := []byte{0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 0, 255}
+	_, err := out.Write(header)
+	if err != nil {
+		return nil, errors.Wrap(err, "Write")
+	}
+
+	return &gzipWriter{
+		out: out,
+		w:   newDeflateWriter(out, flate.DefaultCompression),
+	}, nil
+}
+
+// Write compresses p.
+func (w *gzipWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.crc = crc32.Update(w.crc, crc32.IEEETable, p[:n])
+	w.size += uint32(n)
+	return n, err
+}
+
+// Close writes the remaining data and the gzip trailer.
+func (w *gzipWriter) Close() error {
+	err := w.w.Close()
+	if err != nil {
+		return err
+	}
+
+	var trailer [8]byte
+	binary.LittleEndian.PutUint32(trailer[:4], w.crc)
+	binary.LittleEndian.PutUint32(trailer[4:], w.size)
+	_, err = w.out.Write(trailer[:])
+	return errors.Wrap(err, "Write")
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestCompressWriter(t *testing.T) {
+	rnd := rand.New(rand.NewSource(23))
+
+	for _, size := range []int{0, 1, 4096, compressBlockSize, 3*compressBlockSize + 1234} {
+		data := make([]byte, size)
+		// half random bytes, half repeated text
+		rnd.Read(data[:size/2])
+		for i := size / 2; i < size; i++ {
+			data[i] = "restic"[i%6]
+		}
+
+		for _, algorithm := range []string{"gzip", "zstd"} {
+			buf := &bytes.Buffer{}
+			wr, err := newCompressWriter(buf, algorithm)
+			rtest.OK(t, err)
+
+			// write in small pieces so that blocks are split
+			for p := data; len(p) > 0; {
+				l := rnd.Intn(100000) + 1
+				if l > len(p) {
+					l = len(p)
+				}
+				_, err = wr.Write(p[:l])
+				rtest.OK(t, err)
+				p = p[l:]
+			}
+			rtest.OK(t, wr.Close())
+
+			var out []byte
+			switch algorithm {
+			case "gzip":
+				rd, err := gzip.NewReader(buf)
+				rtest.OK(t, err)
+				out, err = ioutil.ReadAll(rd)
+				rtest.OK(t, err)
+			case "zstd":
+				dec, err := zstd.NewReader(buf)
+				rtest.OK(t, err)
+				out, err = ioutil.ReadAll(dec)
+				rtest.OK(t, err)
+				dec.Close()
+			}
+
+			if !bytes.Equal(data, out) {
+				t.Errorf("%v: wrong data for %d bytes", algorithm, size)
+			}
+		}
+	}
+
+	_, err := newCompressWriter(ioutil.Discard, "xz")
+	rtest.Assert(t, err != nil, "unknown compression was accepted")
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/binary"
+	"io"
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+
+	"github.com/klauspost/compress/flate"
+)
+
+// zipUnixExtraID is the ID of the Info-ZIP extra field which stores the owner
+// of a file.
+const zipUnixExtraID = 0x7875
+
+// newZipWriter returns a zip writer for out which compresses the files with a
+// deflateWriter.
+func newZipWriter(out io.Writer) *zip.Writer {
+	zw := zip.NewWriter(out)
+	zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+		return newDeflateWriter(w, flate.DefaultCompression), nil
+	})
+	return zw
+}
+
+// zipUnixExtra returns the extra field with the uid and gid of a file.
+func zipUnixExtra(uid, gid uint32) []byte {
+	buf := make([]byte, 15)
+	binary.LittleEndian.PutUint16(buf[0:], zipUnixExtraID)
+	binary.LittleEndian.PutUint16(buf[2:], uint16(len(buf)-4))
+	buf[4] = 1 // version
+	buf[5] = 4 // size of uid
+	binary.LittleEndian.PutUint32(buf[6:], uid)
+	buf[10] = 4 // size of gid
+	binary.LittleEndian.PutUint32(buf[11:], gid)
+	return buf
+}
+
+// zipNode adds node to the zip archive. Symlinks are stored as files which
+// contain the link target and are marked as symlinks by their mode.
+func zipNode(ctx context.Context, zw *zip.Writer, node *restic.Node, repo restic.Repository) error {
+	header := &zip.FileHeader{
+		// paths in zip archives must be relative
+		Name:     strings.TrimPrefix(node.Path, "/"),
+		Method:   zip.Store,
+		Modified: node.ModTime,
+		Extra:    zipUnixExtra(node.UID, node.GID),
+	}
+	header.SetMode(node.Mode)
+
+	switch node.Type {
+	case "file":
+		header.Method = zip.Deflate
+	case "dir":
+		header.Name += "/"
+	}
+
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return errors.Wrap(err, "ZipHeader")
+	}
+
+	switch node.Type {
+	case "file":
+		return getNodeData(ctx, w, repo, node)
+	case "symlink":
+		_, err = io.WriteString(w, node.LinkTarget)
+		return errors.Wrap(err, "Write")
+	}
+
+	return nil
+}
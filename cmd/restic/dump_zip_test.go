@@ -0,0 +1,73 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestZipNode(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	data := bytes.Repeat([]byte("foobar"), 1000)
+	id, _, err := repo.SaveBlob(ctx, restic.DataBlob, data, restic.ID{}, false)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.Flush(ctx))
+
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	nodes := []*restic.Node{
+		{Type: "dir", Path: "/dir", Mode: os.ModeDir | 0755, ModTime: mtime},
+		{Type: "file", Path: "/dir/file", Mode: 0640, ModTime: mtime, UID: 1000, GID: 100, Size: uint64(2 * len(data)), Content: restic.IDs{id, id}},
+		{Type: "symlink", Path: "/dir/link", Mode: os.ModeSymlink | 0777, ModTime: mtime, LinkTarget: "file"},
+	}
+
+	buf := &bytes.Buffer{}
+	zw := newZipWriter(buf)
+	for _, node := range nodes {
+		rtest.OK(t, zipNode(ctx, zw, node, repo))
+	}
+	rtest.OK(t, zw.Close())
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	rtest.OK(t, err)
+	rtest.Equals(t, len(nodes), len(zr.File))
+
+	for i, name := range []string{"dir/", "dir/file", "dir/link"} {
+		f := zr.File[i]
+		rtest.Equals(t, name, f.Name)
+		rtest.Equals(t, nodes[i].Mode, f.Mode())
+		rtest.Assert(t, f.Modified.Equal(mtime), "wrong mtime %v for %v", f.Modified, name)
+	}
+
+	rtest.Equals(t, zipUnixExtra(1000, 100), zr.File[1].Extra[:15])
+
+	for _, test := range []struct {
+		f    *zip.File
+		want []byte
+	}{
+		{zr.File[1], append(data, data...)},
+		{zr.File[2], []byte("file")},
+	} {
+		rd, err := test.f.Open()
+		rtest.OK(t, err)
+		content, err := ioutil.ReadAll(rd)
+		rtest.OK(t, err)
+		rtest.OK(t, rd.Close())
+
+		if !bytes.Equal(test.want, content) {
+			t.Errorf("wrong content for %v", test.f.Name)
+		}
+	}
+}
@@ -14,8 +14,56 @@ import (
 	"github.com/restic/restic/internal/filter"
 	"github.com/restic/restic/internal/fs"
 	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/textfile"
+
+	"github.com/spf13/pflag"
 )
 
+// excludePatternOptions collects the exclude patterns which are shared by
+// all commands that filter files by name.
+type excludePatternOptions struct {
+	Excludes            []string
+	InsensitiveExcludes []string
+	ExcludeFiles        []string
+}
+
+func initExcludePatternOptions(f *pflag.FlagSet, opts *excludePatternOptions) {
+	f.StringArrayVarP(&opts.Excludes, "exclude", "e", nil, "exclude a `pattern` (can be specified multiple times)")
+	f.StringArrayVar(&opts.InsensitiveExcludes, "iexclude", nil, "same as --exclude `pattern` but ignores the casing of filenames")
+	f.StringArrayVar(&opts.ExcludeFiles, "exclude-file", nil, "read exclude patterns from a `file` (can be specified multiple times)")
+}
+
+// Empty returns true if no exclude patterns have been given.
+func (opts excludePatternOptions) Empty() bool {
+	return len(opts.Excludes) == 0 && len(opts.InsensitiveExcludes) == 0 && len(opts.ExcludeFiles) == 0
+}
+
+// CollectPatterns returns the functions which reject files matching one of
+// the patterns, including the patterns read from the exclude files.
+func (opts excludePatternOptions) CollectPatterns() ([]RejectByNameFunc, error) {
+	var fs []RejectByNameFunc
+
+	// add patterns from file
+	if len(opts.ExcludeFiles) > 0 {
+		excludes, err := readExcludePatternsFromFiles(opts.ExcludeFiles)
+		if err != nil {
+			return nil, err
+		}
+		opts.Excludes = append(opts.Excludes, excludes...)
+	}
+
+	if len(opts.InsensitiveExcludes) > 0 {
+		fs = append(fs, rejectByInsensitivePattern(opts.InsensitiveExcludes))
+	}
+
+	if len(opts.Excludes) > 0 {
+		fs = append(fs, rejectByPattern(opts.Excludes))
+	}
+
+	return fs, nil
+}
+
 type rejectionCache struct {
 	m   map[string]bool
 	mtx sync.Mutex
@@ -60,6 +108,29 @@ func (rc *rejectionCache) Store(dir string, rejected bool) {
 	rc.m[dir] = rejected
 }
 
+// filterRulesHelp describes the syntax of the rules parsed by filter.ParseRule,
+// it is included in the help text of all commands which accept rules.
+const filterRulesHelp = `
+FILTER RULES
+============
+
+A filter rule consists of one or more predicates separated by white space, an
+item matches the rule if it fulfills all predicates:
+
+  glob:PATTERN  the path matches the pattern (same syntax as for --exclude)
+  regex:EXPR    the path matches the regular expression
+  size<SIZE     a regular file smaller than SIZE (allowed suffixes: k, m, g, t)
+  size>SIZE     a regular file larger than SIZE
+  mtime<TIME    modified before TIME
+  mtime>TIME    modified after TIME
+  type:TYPE     the type is file, dir, symlink, dev, chardev, fifo or socket
+
+A predicate without a prefix is a glob pattern. TIME is a date (2006-01-02 or
+2006-01-02T15:04:05) or a duration before now (e.g. 30d or 1y6m). For example,
+the rule 'regex:\.(tmp|bak)$ mtime<30d' matches all files ending in .tmp or
+.bak which have not been modified in the last 30 days.
+`
+
 // RejectByNameFunc is a function that takes a filename of a
 // file that would be included in the backup. The function returns true if it
 // should be excluded (rejected) from the backup.
@@ -269,6 +340,164 @@ func rejectByDevice(samples []string) (RejectFunc, error) {
 	}, nil
 }
 
+// rejectBySize returns a RejectFunc that rejects files which are larger than
+// maxSizeStr.
+func rejectBySize(maxSizeStr string) (RejectFunc, error) {
+	maxSize, err := restic.ParseSize(maxSizeStr)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(item string, fi os.FileInfo) bool {
+		// only regular files are excluded, directories are always descended into
+		if fi == nil || !fi.Mode().IsRegular() {
+			return false
+		}
+
+		if fi.Size() > maxSize {
+			debug.Log("file %s is oversize: %d", item, fi.Size())
+			return true
+		}
+
+		return false
+	}, nil
+}
+
+// ignoreFileCache stores the patterns of the ignore files per directory, a
+// nil entry means the directory has no (valid) ignore file.
+type ignoreFileCache struct {
+	m   map[string]*filter.IgnorePatterns
+	mtx sync.Mutex
+}
+
+// Get returns the patterns from the ignore file called filename in dir. The
+// file is read on the first call for each directory.
+func (c *ignoreFileCache) Get(dir, filename string) *filter.IgnorePatterns {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if p, ok := c.m[dir]; ok {
+		return p
+	}
+
+	if c.m == nil {
+		c.m = make(map[string]*filter.IgnorePatterns)
+	}
+
+	p := readIgnoreFile(filepath.Join(dir, filename))
+	c.m[dir] = p
+	return p
+}
+
+// readIgnoreFile reads and parses the ignore file fn. If the file does not
+// exist or cannot be parsed, nil is returned.
+func readIgnoreFile(fn string) *filter.IgnorePatterns {
+	data, err := textfile.Read(fn)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		Warnf("could not read ignore file: %v\n", err)
+		return nil
+	}
+
+	p, err := filter.ParseIgnorePatterns(data)
+	if err != nil {
+		Warnf("invalid ignore file %q: %v\n", fn, err)
+		return nil
+	}
+
+	debug.Log("using ignore file %v", fn)
+	return p
+}
+
+// rejectByIgnoreFile returns a RejectFunc which rejects items matching the
+// patterns from the ignore files called filename, using the semantics of
+// gitignore files. An ignore file applies to the directory it is found in and
+// all its subdirectories, patterns from ignore files in subdirectories take
+// precedence. Only ignore files in directories within targets are read.
+func rejectByIgnoreFile(filename string, targets []string) (RejectFunc, error) {
+	if filename == "" || strings.ContainsAny(filename, `/\`) {
+		return nil, errors.Errorf("invalid name for ignore file %q", filename)
+	}
+
+	var bases []string
+	for _, target := range targets {
+		base, err := filepath.Abs(target)
+		if err != nil {
+			return nil, err
+		}
+		bases = append(bases, base)
+	}
+
+	withinTargets := func(dir string) bool {
+		for _, base := range bases {
+			if fs.HasPathPrefix(base, dir) {
+				return true
+			}
+		}
+		return false
+	}
+
+	cache := &ignoreFileCache{}
+
+	return func(item string, fi os.FileInfo) bool {
+		if fi == nil {
+			return false
+		}
+
+		item, err := filepath.Abs(item)
+		if err != nil {
+			return false
+		}
+
+		// check the ignore files from the parent directory upwards, the
+		// first one with a matching pattern decides
+		for dir := filepath.Dir(item); withinTargets(dir); dir = filepath.Dir(dir) {
+			rel, err := filepath.Rel(dir, item)
+			if err != nil {
+				return false
+			}
+
+			ignored, matched := cache.Get(dir, filename).Match(filepath.ToSlash(rel), fi.IsDir())
+			if matched {
+				if ignored {
+					debug.Log("rejecting %v by ignore file in %v", item, dir)
+				}
+				return ignored
+			}
+
+			if dir == filepath.Dir(dir) {
+				break
+			}
+		}
+
+		return false
+	}, nil
+}
+
+// rejectByRules returns a RejectFunc which rejects items matching one of the
+// rules.
+func rejectByRules(rules filter.Rules) RejectFunc {
+	return func(item string, fi os.FileInfo) bool {
+		if fi == nil {
+			return false
+		}
+
+		matched, _, err := rules.Match(filter.ItemFromFileInfo(filepath.ToSlash(item), fi))
+		if err != nil {
+			Warnf("error for exclude rule: %v\n", err)
+		}
+
+		if matched {
+			debug.Log("path %q excluded by an exclude rule", item)
+			return true
+		}
+
+		return false
+	}
+}
+
 // rejectResticCache returns a RejectByNameFunc that rejects the restic cache
 // directory (if set).
 func rejectResticCache(repo *repository.Repository) (RejectByNameFunc, error) {
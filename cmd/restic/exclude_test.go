@@ -189,3 +189,126 @@ func TestMultipleIsExcludedByFile(t *testing.T) {
 		}
 	}
 }
+
+func TestRejectBySize(t *testing.T) {
+	tempDir, cleanup := test.TempDir(t)
+	defer cleanup()
+
+	files := map[string]int{
+		"small": 1023,
+		"exact": 1024,
+		"large": 1025,
+	}
+
+	for name, size := range files {
+		err := ioutil.WriteFile(filepath.Join(tempDir, name), make([]byte, size), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reject, err := rejectBySize("1k")
+	test.OK(t, err)
+
+	var tests = []struct {
+		filename string
+		reject   bool
+	}{
+		{filename: "small", reject: false},
+		{filename: "exact", reject: false},
+		{filename: "large", reject: true},
+		{filename: ".", reject: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.filename, func(t *testing.T) {
+			item := filepath.Join(tempDir, tc.filename)
+			fi, err := os.Lstat(item)
+			test.OK(t, err)
+
+			res := reject(item, fi)
+			if res != tc.reject {
+				t.Fatalf("wrong result for %v: want %v, got %v", tc.filename, tc.reject, res)
+			}
+		})
+	}
+
+	_, err = rejectBySize("foo")
+	if err == nil {
+		t.Fatal("invalid size was accepted")
+	}
+}
+
+func TestRejectByIgnoreFile(t *testing.T) {
+	tempDir, cleanup := test.TempDir(t)
+	defer cleanup()
+
+	files := map[string]string{
+		".resticignore":              "*.log\nbuild/\n/toplevel\n",
+		"toplevel":                   "",
+		"main.log":                   "",
+		"build/out":                  "",
+		"project/.resticignore":      "!important.log\ntmp\n",
+		"project/important.log":      "",
+		"project/debug.log":          "",
+		"project/toplevel":           "",
+		"project/tmp/x":              "",
+		"project/sub/tmp":            "",
+		"project/sub/important.log":  "",
+		"project/sub/build/file.txt": "",
+		"project/src/main.go":        "",
+	}
+
+	for name, content := range files {
+		fn := filepath.Join(tempDir, filepath.FromSlash(name))
+		test.OK(t, os.MkdirAll(filepath.Dir(fn), 0755))
+		test.OK(t, ioutil.WriteFile(fn, []byte(content), 0644))
+	}
+
+	reject, err := rejectByIgnoreFile(".resticignore", []string{tempDir})
+	test.OK(t, err)
+
+	var tests = []struct {
+		filename string
+		reject   bool
+	}{
+		{filename: ".resticignore", reject: false},
+		{filename: "toplevel", reject: true},
+		{filename: "main.log", reject: true},
+		{filename: "build", reject: true},
+		{filename: "project", reject: false},
+		{filename: "project/important.log", reject: false},
+		{filename: "project/debug.log", reject: true},
+		{filename: "project/toplevel", reject: false},
+		{filename: "project/tmp", reject: true},
+		{filename: "project/sub/tmp", reject: true},
+		{filename: "project/sub/important.log", reject: false},
+		{filename: "project/sub/build", reject: true},
+		{filename: "project/src/main.go", reject: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.filename, func(t *testing.T) {
+			item := filepath.Join(tempDir, filepath.FromSlash(tc.filename))
+			fi, err := os.Lstat(item)
+			test.OK(t, err)
+
+			res := reject(item, fi)
+			if res != tc.reject {
+				t.Fatalf("wrong result for %v: want %v, got %v", tc.filename, tc.reject, res)
+			}
+		})
+	}
+
+	// ignore files outside of the targets are not used
+	reject, err = rejectByIgnoreFile(".resticignore", []string{filepath.Join(tempDir, "project")})
+	test.OK(t, err)
+
+	item := filepath.Join(tempDir, "project", "src", "main.log")
+	test.OK(t, ioutil.WriteFile(item, nil, 0644))
+	fi, err := os.Lstat(item)
+	test.OK(t, err)
+	if reject(item, fi) {
+		t.Fatalf("file %v rejected by ignore file outside of the target", item)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"os"
@@ -15,6 +16,7 @@ import (
 	"github.com/restic/restic/internal/backend"
 	"github.com/restic/restic/internal/backend/azure"
 	"github.com/restic/restic/internal/backend/b2"
+	"github.com/restic/restic/internal/backend/ftp"
 	"github.com/restic/restic/internal/backend/gs"
 	"github.com/restic/restic/internal/backend/local"
 	"github.com/restic/restic/internal/backend/location"
@@ -23,6 +25,7 @@ import (
 	"github.com/restic/restic/internal/backend/s3"
 	"github.com/restic/restic/internal/backend/sftp"
 	"github.com/restic/restic/internal/backend/swift"
+	"github.com/restic/restic/internal/backend/webdav"
 	"github.com/restic/restic/internal/cache"
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/fs"
@@ -59,10 +62,14 @@ type GlobalOptions struct {
 	CACerts         []string
 	TLSClientCert   string
 	CleanupCache    bool
+	AppendOnly      bool
 
 	LimitUploadKb   int
 	LimitDownloadKb int
 
+	Compression repository.CompressionMode
+	PackSize    string
+
 	ctx      context.Context
 	password string
 	stdout   io.Writer
@@ -109,9 +116,12 @@ func init() {
 	f.StringSliceVar(&globalOptions.CACerts, "cacert", nil, "`file` to load root certificates from (default: use system certificates)")
 	f.StringVar(&globalOptions.TLSClientCert, "tls-client-cert", "", "path to a `file` containing PEM encoded TLS client certificate and private key")
 	f.BoolVar(&globalOptions.CleanupCache, "cleanup-cache", false, "auto remove old cache directories")
+	f.BoolVar(&globalOptions.AppendOnly, "append-only", false, "refuse to remove files from the repository, except for locks")
 	f.IntVar(&globalOptions.LimitUploadKb, "limit-upload", 0, "limits uploads to a maximum rate in KiB/s. (default: unlimited)")
 	f.IntVar(&globalOptions.LimitDownloadKb, "limit-download", 0, "limits downloads to a maximum rate in KiB/s. (default: unlimited)")
 	f.StringSliceVarP(&globalOptions.Options, "option", "o", []string{}, "set extended option (`key=value`, can be specified multiple times)")
+	f.Var(&globalOptions.Compression, "compression", "compression mode for repository version 2 (auto|off|max)")
+	f.StringVar(&globalOptions.PackSize, "pack-size", os.Getenv("RESTIC_PACK_SIZE"), "target `size` for new pack files, overrides the size from the repository config for this invocation only (allowed suffixes: k/K, m/M) (default: $RESTIC_PACK_SIZE)")
 
 	restoreTerminal()
 }
@@ -269,8 +279,10 @@ func Exitf(exitcode int, format string, args ...interface{}) {
 	Exit(exitcode)
 }
 
-// resolvePassword determines the password to be used for opening the repository.
-func resolvePassword(opts GlobalOptions) (string, error) {
+// resolvePassword determines the password to be used for opening the
+// repository. If no password file or command is set, the environment variable
+// envStr is used.
+func resolvePassword(opts GlobalOptions, envStr string) (string, error) {
 	if opts.PasswordFile != "" && opts.PasswordCommand != "" {
 		return "", errors.Fatalf("Password file and command are mutually exclusive options")
 	}
@@ -295,7 +307,7 @@ func resolvePassword(opts GlobalOptions) (string, error) {
 		return strings.TrimSpace(string(s)), errors.Wrap(err, "Readfile")
 	}
 
-	if pwd := os.Getenv("RESTIC_PASSWORD"); pwd != "" {
+	if pwd := os.Getenv(envStr); pwd != "" {
 		return pwd, nil
 	}
 
@@ -380,12 +392,47 @@ func ReadPasswordTwice(gopts GlobalOptions, prompt1, prompt2 string) (string, er
 
 const maxKeys = 20
 
+// checkAppendOnly returns an error if the repository is to be accessed in
+// append-only mode, which does not allow the operation to remove files.
+func checkAppendOnly(gopts GlobalOptions, operation string) error {
+	if gopts.AppendOnly {
+		return errors.Fatalf("%s is not possible in append-only mode", operation)
+	}
+	return nil
+}
+
+// parsePackSize parses the target size for pack files and checks that it is
+// within the range supported by the repository. An empty string selects the
+// size from the repository config.
+func parsePackSize(s string) (uint, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	size, err := restic.ParseSize(s)
+	if err != nil {
+		return 0, errors.Fatalf("invalid pack size: %v", err)
+	}
+
+	if size < repository.MinPackSize || size > repository.MaxPackSize {
+		return 0, errors.Fatalf("pack size must be between %d MiB and %d MiB",
+			repository.MinPackSize/(1024*1024), repository.MaxPackSize/(1024*1024))
+	}
+
+	return uint(size), nil
+}
+
 // OpenRepository reads the password and opens the repository.
 func OpenRepository(opts GlobalOptions) (*repository.Repository, error) {
 	if opts.Repo == "" {
 		return nil, errors.Fatal("Please specify repository location (-r)")
 	}
 
+	packSize, err := parsePackSize(opts.PackSize)
+	if err != nil {
+		return nil, err
+	}
+
 	be, err := open(opts.Repo, opts, opts.extended)
 	if err != nil {
 		return nil, err
@@ -395,7 +442,15 @@ func OpenRepository(opts GlobalOptions) (*repository.Repository, error) {
 		Warnf("%v returned error, retrying after %v: %v\n", msg, d, err)
 	})
 
+	if opts.AppendOnly {
+		be = backend.NewAppendOnlyBackend(be)
+	}
+
 	s := repository.New(be)
+	s.SetCompression(opts.Compression)
+	if err = s.SetPackSize(packSize); err != nil {
+		return nil, err
+	}
 
 	passwordTriesLeft := 1
 	if stdinIsTerminal() && opts.password == "" {
@@ -604,6 +659,39 @@ func parseConfig(loc location.Location, opts options.Options) (interface{}, erro
 
 		debug.Log("opening rest repository at %#v", cfg)
 		return cfg, nil
+	case "webdav":
+		cfg := loc.Config.(webdav.Config)
+		if cfg.User == "" {
+			cfg.User = os.Getenv("WEBDAV_USERNAME")
+		}
+
+		if cfg.Password == "" {
+			cfg.Password = os.Getenv("WEBDAV_PASSWORD")
+		}
+
+		if err := opts.Apply(loc.Scheme, &cfg); err != nil {
+			return nil, err
+		}
+
+		debug.Log("opening webdav repository at %v", cfg.URL)
+		return cfg, nil
+	case "ftp", "ftps":
+		cfg := loc.Config.(ftp.Config)
+		if cfg.User == "" {
+			cfg.User = os.Getenv("FTP_USERNAME")
+		}
+
+		if cfg.Password == "" {
+			cfg.Password = os.Getenv("FTP_PASSWORD")
+		}
+
+		// both schemes share the options
+		if err := opts.Apply("ftp", &cfg); err != nil {
+			return nil, err
+		}
+
+		debug.Log("opening ftp repository at %v/%v", cfg.Host, cfg.Path)
+		return cfg, nil
 	case "rclone":
 		cfg := loc.Config.(rclone.Config)
 		if err := opts.Apply(loc.Scheme, &cfg); err != nil {
@@ -619,6 +707,27 @@ func parseConfig(loc location.Location, opts options.Options) (interface{}, erro
 
 // Open the backend specified by a location config.
 func open(s string, gopts GlobalOptions, opts options.Options) (restic.Backend, error) {
+	be, err := openBackend(s, gopts, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// check if config is there
+	fi, err := be.Stat(globalOptions.ctx, restic.Handle{Type: restic.ConfigFile})
+	if err != nil {
+		return nil, errors.Fatalf("unable to open config file: %v\nIs there a repository at the following location?\n%v", err, s)
+	}
+
+	if fi.Size == 0 {
+		return nil, errors.New("config file has zero size, invalid repository?")
+	}
+
+	return be, nil
+}
+
+// openBackend opens the backend specified by the location s, without checking
+// whether it contains a repository.
+func openBackend(s string, gopts GlobalOptions, opts options.Options) (restic.Backend, error) {
 	debug.Log("parsing location %v", s)
 	loc, err := location.Parse(s)
 	if err != nil {
@@ -666,6 +775,16 @@ func open(s string, gopts GlobalOptions, opts options.Options) (restic.Backend,
 		be, err = b2.Open(globalOptions.ctx, cfg.(b2.Config), rt)
 	case "rest":
 		be, err = rest.Open(cfg.(rest.Config), rt)
+	case "webdav":
+		be, err = webdav.Open(cfg.(webdav.Config), rt)
+	case "ftp", "ftps":
+		var tlsConfig *tls.Config
+		if tlsConfig, err = backend.TLSConfig(tropts); err != nil {
+			return nil, err
+		}
+		be, err = ftp.Open(cfg.(ftp.Config), tlsConfig)
+		// wrap the backend in a LimitBackend so that the throughput is limited
+		be = limiter.LimitBackend(be, lim)
 	case "rclone":
 		be, err = rclone.Open(cfg.(rclone.Config), lim)
 
@@ -677,16 +796,6 @@ func open(s string, gopts GlobalOptions, opts options.Options) (restic.Backend,
 		return nil, errors.Fatalf("unable to open repo at %v: %v", s, err)
 	}
 
-	// check if config is there
-	fi, err := be.Stat(globalOptions.ctx, restic.Handle{Type: restic.ConfigFile})
-	if err != nil {
-		return nil, errors.Fatalf("unable to open config file: %v\nIs there a repository at the following location?\n%v", err, s)
-	}
-
-	if fi.Size == 0 {
-		return nil, errors.New("config file has zero size, invalid repository?")
-	}
-
 	return be, nil
 }
 
@@ -729,6 +838,14 @@ func create(s string, opts options.Options) (restic.Backend, error) {
 		return b2.Create(globalOptions.ctx, cfg.(b2.Config), rt)
 	case "rest":
 		return rest.Create(cfg.(rest.Config), rt)
+	case "webdav":
+		return webdav.Create(cfg.(webdav.Config), rt)
+	case "ftp", "ftps":
+		tlsConfig, err := backend.TLSConfig(tropts)
+		if err != nil {
+			return nil, err
+		}
+		return ftp.Create(cfg.(ftp.Config), tlsConfig)
 	case "rclone":
 		return rclone.Open(cfg.(rclone.Config), nil)
 	}
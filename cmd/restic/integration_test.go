@@ -10,6 +10,7 @@ import (
 	"io"
 	"io/ioutil"
 	mrand "math/rand"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -51,10 +52,17 @@ func testRunInit(t testing.TB, opts GlobalOptions) {
 	restic.TestDisableCheckPolynomial(t)
 	restic.TestSetLockTimeout(t, 0)
 
-	rtest.OK(t, runInit(opts, nil))
+	rtest.OK(t, runInit(InitOptions{}, opts, nil))
 	t.Logf("repository initialized at %v", opts.Repo)
 }
 
+func testSetupBackupData(t testing.TB, env *testEnvironment) string {
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+	return datafile
+}
+
 func testRunBackupAssumeFailure(t testing.TB, dir string, target []string, opts BackupOptions, gopts GlobalOptions) error {
 	ctx, cancel := context.WithCancel(gopts.ctx)
 	defer cancel()
@@ -160,7 +168,7 @@ func testRunRebuildIndex(t testing.TB, gopts GlobalOptions) {
 		globalOptions.stdout = os.Stdout
 	}()
 
-	rtest.OK(t, runRebuildIndex(gopts))
+	rtest.OK(t, runRebuildIndex(RepairIndexOptions{}, gopts))
 }
 
 func testRunLs(t testing.TB, gopts GlobalOptions, snapshotID string) []string {
@@ -256,8 +264,8 @@ func testRunForgetJSON(t testing.TB, gopts GlobalOptions, args ...string) {
 	return
 }
 
-func testRunPrune(t testing.TB, gopts GlobalOptions) {
-	rtest.OK(t, runPrune(gopts))
+func testRunPrune(t testing.TB, gopts GlobalOptions, opts PruneOptions) {
+	rtest.OK(t, runPrune(opts, gopts))
 }
 
 func TestBackup(t *testing.T) {
@@ -444,6 +452,35 @@ func TestBackupExclude(t *testing.T) {
 		"expected file %q not in first snapshot, but it's included", "passwords.txt")
 }
 
+func TestBackupExcludeLargerThan(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	datadir := filepath.Join(env.base, "testdata")
+	rtest.OK(t, os.MkdirAll(datadir, 0755))
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(datadir, "small"), make([]byte, 1024), 0644))
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(datadir, "large"), make([]byte, 1025), 0644))
+
+	opts := BackupOptions{ExcludeLargerThan: "1k"}
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	_, snapshotID := lastSnapshot(make(map[string]struct{}), loadSnapshotMap(t, env.gopts))
+	files := testRunLs(t, env.gopts, snapshotID)
+	rtest.Assert(t, includes(files, "/testdata/small"),
+		"expected file %q in snapshot, but it's not included", "small")
+	rtest.Assert(t, !includes(files, "/testdata/large"),
+		"expected file %q not in snapshot, but it's included", "large")
+
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
+	id, err := restic.ParseID(snapshotID)
+	rtest.OK(t, err)
+	sn, err := restic.LoadSnapshot(context.TODO(), repo, id)
+	rtest.OK(t, err)
+	rtest.Equals(t, []string{"--exclude-larger-than=1k"}, sn.Excludes)
+}
+
 func TestBackupErrors(t *testing.T) {
 	if runtime.GOOS == "windows" {
 		return
@@ -978,6 +1015,67 @@ func TestFind(t *testing.T) {
 	rtest.Assert(t, len(lines) == 4, "expected three files found in repo (%v)", datafile)
 }
 
+func TestFilterRules(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	sizes := map[string]int{
+		"small.txt":   10,
+		"large.bin":   2048,
+		"old.tmp":     10,
+		"sub/new.tmp": 10,
+	}
+	for name, size := range sizes {
+		fn := filepath.Join(env.testdata, filepath.FromSlash(name))
+		rtest.OK(t, os.MkdirAll(filepath.Dir(fn), 0755))
+		rtest.OK(t, ioutil.WriteFile(fn, make([]byte, size), 0644))
+	}
+	old := time.Now().AddDate(0, 0, -40)
+	rtest.OK(t, os.Chtimes(filepath.Join(env.testdata, "old.tmp"), old, old))
+
+	opts := BackupOptions{ExcludeRules: []string{`regex:\.tmp$ mtime<30d`}}
+	testRunBackup(t, env.testdata, []string{"."}, opts, env.gopts)
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot, got %v", snapshotIDs)
+
+	files := testRunLs(t, env.gopts, snapshotIDs[0].String())
+	rtest.Assert(t, !includes(files, "/old.tmp"), "file %q was not excluded", "old.tmp")
+	rtest.Assert(t, includes(files, "/sub/new.tmp"), "file %q was excluded", "sub/new.tmp")
+
+	ls := func(rules []string) []string {
+		buf := bytes.NewBuffer(nil)
+		globalOptions.stdout = buf
+		defer func() {
+			globalOptions.stdout = os.Stdout
+		}()
+
+		rtest.OK(t, runLs(LsOptions{Rules: rules}, env.gopts, []string{snapshotIDs[0].String()}))
+		return strings.Split(strings.TrimSpace(buf.String()), "\n")
+	}
+	rtest.Equals(t, []string{"/large.bin"}, ls([]string{"size>1k"}))
+	rtest.Equals(t, []string{"/sub"}, ls([]string{"type:dir"}))
+
+	buf := bytes.NewBuffer(nil)
+	globalOptions.stdout = buf
+	err := runFind(FindOptions{Rules: []string{"*.tmp"}}, env.gopts, nil)
+	globalOptions.stdout = os.Stdout
+	rtest.OK(t, err)
+	rtest.Assert(t, strings.Contains(buf.String(), "/sub/new.tmp"), "file %q not found: %v", "sub/new.tmp", buf.String())
+
+	target := filepath.Join(env.base, "restore")
+	restoreOpts := RestoreOptions{
+		Target:       target,
+		IncludeRules: []string{"size>1k"},
+	}
+	rtest.OK(t, runRestore(restoreOpts, env.gopts, []string{snapshotIDs[0].String()}))
+	_, err = os.Stat(filepath.Join(target, "large.bin"))
+	rtest.OK(t, err)
+	_, err = os.Stat(filepath.Join(target, "small.txt"))
+	rtest.Assert(t, os.IsNotExist(err), "file %q was restored", "small.txt")
+}
+
 type testMatch struct {
 	Path        string    `json:"path,omitempty"`
 	Permissions string    `json:"permissions,omitempty"`
@@ -1040,8 +1138,8 @@ func TestRebuildIndex(t *testing.T) {
 		t.Fatalf("expected no error from checker for test repository, got %v", err)
 	}
 
-	if !strings.Contains(out, "restic rebuild-index") {
-		t.Fatalf("did not find hint for rebuild-index command")
+	if !strings.Contains(out, "restic repair index") {
+		t.Fatalf("did not find hint for repair index command")
 	}
 
 	testRunRebuildIndex(t, env.gopts)
@@ -1056,6 +1154,36 @@ func TestRebuildIndex(t *testing.T) {
 	}
 }
 
+func TestRepairIndexLostIndexFile(t *testing.T) {
+	for _, readAllPacks := range []bool{false, true} {
+		t.Run(fmt.Sprintf("ReadAllPacks=%v", readAllPacks), func(t *testing.T) {
+			env, cleanup := withTestEnvironment(t)
+			defer cleanup()
+
+			testSetupBackupData(t, env)
+			testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9", "2")}, BackupOptions{}, env.gopts)
+			testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9", "3")}, BackupOptions{}, env.gopts)
+
+			// remove one of the index files
+			indexes := testRunList(t, "index", env.gopts)
+			rtest.Assert(t, len(indexes) == 2, "expected two index files, got %v", indexes)
+			id := indexes[0].String()
+			rtest.OK(t, os.Remove(filepath.Join(env.repo, "index", id)))
+
+			_, err := testRunCheckOutput(env.gopts)
+			rtest.Assert(t, err != nil, "check did not detect the missing index file")
+
+			globalOptions.stdout = ioutil.Discard
+			defer func() {
+				globalOptions.stdout = os.Stdout
+			}()
+			rtest.OK(t, runRebuildIndex(RepairIndexOptions{ReadAllPacks: readAllPacks}, env.gopts))
+
+			testRunCheck(t, env.gopts)
+		})
+	}
+}
+
 func TestRebuildIndexAlwaysFull(t *testing.T) {
 	repository.IndexFull = func(*repository.Index) bool { return true }
 	TestRebuildIndex(t)
@@ -1089,6 +1217,36 @@ func TestCheckRestoreNoLock(t *testing.T) {
 }
 
 func TestPrune(t *testing.T) {
+	t.Run("0", func(t *testing.T) {
+		testPrune(t, PruneOptions{MaxUnused: "0%"})
+	})
+
+	t.Run("50", func(t *testing.T) {
+		testPrune(t, PruneOptions{MaxUnused: "50%"})
+	})
+
+	t.Run("500", func(t *testing.T) {
+		testPrune(t, PruneOptions{MaxUnused: "500"})
+	})
+
+	t.Run("Unlimited", func(t *testing.T) {
+		testPrune(t, PruneOptions{MaxUnused: "unlimited"})
+	})
+
+	t.Run("MaxRepackSize", func(t *testing.T) {
+		testPrune(t, PruneOptions{MaxUnused: "0%", MaxRepackSize: "100k"})
+	})
+
+	t.Run("CacheableOnly", func(t *testing.T) {
+		testPrune(t, PruneOptions{MaxUnused: "5%", RepackCacheableOnly: true})
+	})
+
+	t.Run("RebuildIndex", func(t *testing.T) {
+		testPrune(t, PruneOptions{MaxUnused: "0%", RebuildIndex: true})
+	})
+}
+
+func testPrune(t *testing.T, pruneOpts PruneOptions) {
 	env, cleanup := withTestEnvironment(t)
 	defer cleanup()
 
@@ -1120,7 +1278,242 @@ func TestPrune(t *testing.T) {
 
 	testRunForgetJSON(t, env.gopts)
 	testRunForget(t, env.gopts, firstSnapshot[0].String())
-	testRunPrune(t, env.gopts)
+	if !pruneOpts.RebuildIndex {
+		testRunPruneDryRunJSON(t, env.gopts, pruneOpts)
+	}
+	testRunPrune(t, env.gopts, pruneOpts)
+
+	// unused data blobs may be kept unless prune was told to remove all of them
+	checkOpts := CheckOptions{
+		ReadData:    true,
+		CheckUnused: pruneOpts.MaxUnused == "0%" && pruneOpts.MaxRepackSize == "" && !pruneOpts.RepackCacheableOnly,
+	}
+	rtest.OK(t, runCheck(checkOpts, env.gopts, nil))
+}
+
+func testRunPruneDryRunJSON(t testing.TB, gopts GlobalOptions, opts PruneOptions) {
+	packsBefore := testRunList(t, "packs", gopts)
+	indexesBefore := testRunList(t, "index", gopts)
+
+	buf := bytes.NewBuffer(nil)
+	gopts.stdout = buf
+	gopts.JSON = true
+	opts.DryRun = true
+	rtest.OK(t, runPrune(opts, gopts))
+
+	var summary pruneJSONSummary
+	rtest.OK(t, json.Unmarshal(buf.Bytes(), &summary))
+	rtest.Assert(t, summary.DryRun, "dry_run not set in JSON output")
+	rtest.Assert(t, summary.TotalPruneBlobs > 0, "expected blobs to prune, got %v", summary.TotalPruneBlobs)
+	rtest.Equals(t, summary.TotalBlobs-summary.TotalPruneBlobs, summary.RemainingBlobs)
+
+	// the repository must not have been modified
+	rtest.Assert(t, restic.NewIDSet(packsBefore...).Equals(restic.NewIDSet(testRunList(t, "packs", gopts)...)),
+		"dry run modified the pack files")
+	rtest.Assert(t, restic.NewIDSet(indexesBefore...).Equals(restic.NewIDSet(testRunList(t, "index", gopts)...)),
+		"dry run modified the index files")
+}
+
+func testRunCopy(t testing.TB, srcGopts GlobalOptions, dstGopts GlobalOptions) {
+	copyOpts := CopyOptions{
+		secondaryRepoOptions: secondaryRepoOptions{
+			Repo:     dstGopts.Repo,
+			password: dstGopts.password,
+		},
+	}
+
+	rtest.OK(t, runCopy(copyOpts, srcGopts, nil))
+}
+
+func TestCopy(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	env2, cleanup2 := withTestEnvironment(t)
+	defer cleanup2()
+
+	testSetupBackupData(t, env)
+	opts := BackupOptions{}
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9")}, opts, env.gopts)
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9", "2")}, opts, env.gopts)
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9", "3")}, opts, env.gopts)
+	testRunCheck(t, env.gopts)
+
+	testRunInit(t, env2.gopts)
+	testRunCopy(t, env.gopts, env2.gopts)
+
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	copiedSnapshotIDs := testRunList(t, "snapshots", env2.gopts)
+
+	// Check that the copies size seems reasonable
+	rtest.Assert(t, len(snapshotIDs) == len(copiedSnapshotIDs), "expected %v snapshots, found %v",
+		len(snapshotIDs), len(copiedSnapshotIDs))
+	stat := dirStats(env.repo)
+	stat2 := dirStats(env2.repo)
+	sizeDiff := int64(stat.size) - int64(stat2.size)
+	if sizeDiff < 0 {
+		sizeDiff = -sizeDiff
+	}
+	rtest.Assert(t, sizeDiff < int64(stat.size)/50, "expected less than 2%% size difference: %v vs. %v",
+		stat.size, stat2.size)
+
+	// Check integrity of the copy
+	testRunCheck(t, env2.gopts)
+
+	// Check that the copied snapshots have the same tree contents as the old ones (= identical tree hash)
+	origRestores := make(map[string]struct{})
+	for i, snapshotID := range snapshotIDs {
+		restoredir := filepath.Join(env.base, fmt.Sprintf("restore%d", i))
+		origRestores[restoredir] = struct{}{}
+		testRunRestore(t, env.gopts, restoredir, snapshotID)
+	}
+	for i, snapshotID := range copiedSnapshotIDs {
+		restoredir := filepath.Join(env2.base, fmt.Sprintf("restore%d", i))
+		testRunRestore(t, env2.gopts, restoredir, snapshotID)
+		foundMatch := false
+		for cmpdir := range origRestores {
+			if directoriesEqualContents(restoredir, cmpdir) {
+				delete(origRestores, cmpdir)
+				foundMatch = true
+			}
+		}
+
+		rtest.Assert(t, foundMatch, "found no counterpart for snapshot %v", snapshotID)
+	}
+
+	rtest.Assert(t, len(origRestores) == 0, "found not copied snapshots")
+}
+
+func TestCopyIncremental(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	env2, cleanup2 := withTestEnvironment(t)
+	defer cleanup2()
+
+	testSetupBackupData(t, env)
+	opts := BackupOptions{}
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9")}, opts, env.gopts)
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9", "2")}, opts, env.gopts)
+	testRunCheck(t, env.gopts)
+
+	testRunInit(t, env2.gopts)
+	testRunCopy(t, env.gopts, env2.gopts)
+
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	copiedSnapshotIDs := testRunList(t, "snapshots", env2.gopts)
+
+	// Check that the copies size seems reasonable
+	testRunCheck(t, env2.gopts)
+	rtest.Assert(t, len(snapshotIDs) == len(copiedSnapshotIDs), "expected %v snapshots, found %v",
+		len(snapshotIDs), len(copiedSnapshotIDs))
+
+	// check that no snapshots are copied, as there are no new ones
+	testRunCopy(t, env.gopts, env2.gopts)
+	testRunCheck(t, env2.gopts)
+	copiedSnapshotIDs = testRunList(t, "snapshots", env2.gopts)
+	rtest.Assert(t, len(snapshotIDs) == len(copiedSnapshotIDs), "still expected %v snapshots, found %v",
+		len(snapshotIDs), len(copiedSnapshotIDs))
+
+	// check that only new snapshots are copied
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9", "3")}, opts, env.gopts)
+	testRunCopy(t, env.gopts, env2.gopts)
+	testRunCheck(t, env2.gopts)
+	snapshotIDs = testRunList(t, "snapshots", env.gopts)
+	copiedSnapshotIDs = testRunList(t, "snapshots", env2.gopts)
+	rtest.Assert(t, len(snapshotIDs) == len(copiedSnapshotIDs), "still expected %v snapshots, found %v",
+		len(snapshotIDs), len(copiedSnapshotIDs))
+
+	// also test the reverse direction
+	testRunCopy(t, env2.gopts, env.gopts)
+	testRunCheck(t, env.gopts)
+	snapshotIDs = testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == len(copiedSnapshotIDs), "still expected %v snapshot, found %v",
+		len(copiedSnapshotIDs), len(snapshotIDs))
+}
+
+func TestInitCopyChunkerParams(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	env2, cleanup2 := withTestEnvironment(t)
+	defer cleanup2()
+
+	testRunInit(t, env2.gopts)
+
+	initOpts := InitOptions{
+		secondaryRepoOptions: secondaryRepoOptions{
+			Repo:     env2.gopts.Repo,
+			password: env2.gopts.password,
+		},
+	}
+	rtest.Assert(t, runInit(initOpts, env.gopts, nil) != nil, "expected invalid init options to fail")
+
+	initOpts.CopyChunkerParameters = true
+	rtest.OK(t, runInit(initOpts, env.gopts, nil))
+
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
+
+	otherRepo, err := OpenRepository(env2.gopts)
+	rtest.OK(t, err)
+
+	rtest.Assert(t, repo.Config().ChunkerPolynomial == otherRepo.Config().ChunkerPolynomial,
+		"expected equal chunker polynomials, got %v expected %v", repo.Config().ChunkerPolynomial,
+		otherRepo.Config().ChunkerPolynomial)
+
+	// the polynomial as printed by "cat config" is accepted by init
+	buf, err := json.Marshal(otherRepo.Config().ChunkerPolynomial)
+	rtest.OK(t, err)
+
+	env3, cleanup3 := withTestEnvironment(t)
+	defer cleanup3()
+
+	initOpts = InitOptions{ChunkerPolynomial: strings.Trim(string(buf), `"`)}
+	rtest.OK(t, runInit(initOpts, env3.gopts, nil))
+
+	repo3, err := OpenRepository(env3.gopts)
+	rtest.OK(t, err)
+	rtest.Equals(t, otherRepo.Config().ChunkerPolynomial, repo3.Config().ChunkerPolynomial)
+}
+
+func TestInitPackSize(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	repository.TestUseLowSecurityKDFParameters(t)
+	restic.TestDisableCheckPolynomial(t)
+
+	gopts := env.gopts
+	for _, size := range []string{"1M", "129M", "foo"} {
+		gopts.PackSize = size
+		err := runInit(InitOptions{}, gopts, nil)
+		rtest.Assert(t, err != nil, "expected init with pack size %q to fail", size)
+	}
+
+	gopts.PackSize = "16M"
+	rtest.OK(t, runInit(InitOptions{}, gopts, nil))
+
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
+	rtest.Equals(t, uint(16*1024*1024), repo.Config().MinPackSize)
+	rtest.Equals(t, uint(16*1024*1024), repo.PackSize())
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+
+	gopts.PackSize = "1M"
+	err = testRunBackupAssumeFailure(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, gopts)
+	rtest.Assert(t, err != nil, "expected backup with invalid pack size to fail")
+
+	gopts.PackSize = "8M"
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, gopts)
+	testRunCheck(t, env.gopts)
+
+	// the size from the config is overridden for the other commands as well
+	gopts.PackSize = "32M"
+	repo, err = OpenRepository(gopts)
+	rtest.OK(t, err)
+	rtest.Equals(t, uint(32*1024*1024), repo.PackSize())
+
+	testRunPrune(t, gopts, PruneOptions{MaxUnused: "0%"})
 	testRunCheck(t, env.gopts)
 }
 
@@ -1256,3 +1649,241 @@ func TestQuietBackup(t *testing.T) {
 
 	testRunCheck(t, env.gopts)
 }
+
+func testRunRewriteExclude(t testing.TB, gopts GlobalOptions, excludes []string, forget bool) {
+	opts := RewriteOptions{
+		excludePatternOptions: excludePatternOptions{
+			Excludes: excludes,
+		},
+		Forget: forget,
+	}
+
+	rtest.OK(t, runRewrite(opts, gopts, nil))
+}
+
+func testRewrite(t *testing.T, forget bool) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	target := filepath.Join(env.testdata, "0", "0", "9")
+	testRunBackup(t, "", []string{target}, BackupOptions{}, env.gopts)
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot, got %v", snapshotIDs)
+
+	excluded := filepath.ToSlash(filepath.Join(target, "2"))
+	countExcluded := func(snapshotID restic.ID) int {
+		n := 0
+		for _, line := range testRunLs(t, env.gopts, snapshotID.String()) {
+			if line == excluded || strings.HasPrefix(line, excluded+"/") {
+				n++
+			}
+		}
+		return n
+	}
+	rtest.Assert(t, countExcluded(snapshotIDs[0]) > 0, "test data does not contain %v", excluded)
+
+	testRunRewriteExclude(t, env.gopts, []string{excluded}, forget)
+
+	newSnapshotIDs := testRunList(t, "snapshots", env.gopts)
+	if forget {
+		rtest.Assert(t, len(newSnapshotIDs) == 1, "expected one snapshot, got %v", newSnapshotIDs)
+		rtest.Assert(t, !newSnapshotIDs[0].Equal(snapshotIDs[0]), "original snapshot was not removed")
+	} else {
+		rtest.Assert(t, len(newSnapshotIDs) == 2, "expected two snapshots, got %v", newSnapshotIDs)
+	}
+
+	_, snapmap := testRunSnapshots(t, env.gopts)
+	for id, sn := range snapmap {
+		if id.Equal(snapshotIDs[0]) {
+			continue
+		}
+		rtest.Assert(t, sn.Original != nil && sn.Original.Equal(snapshotIDs[0]),
+			"new snapshot does not refer to the original snapshot %v", snapshotIDs[0].Str())
+		rtest.Equals(t, !forget, sn.HasTags([]string{"rewrite"}))
+		rtest.Equals(t, 0, countExcluded(id))
+	}
+
+	if forget {
+		// the excluded data is no longer referenced
+		testRunPrune(t, env.gopts, PruneOptions{MaxUnused: "0%"})
+	}
+	testRunCheck(t, env.gopts)
+}
+
+func TestRewrite(t *testing.T) {
+	testRewrite(t, false)
+}
+
+func TestRewriteForget(t *testing.T) {
+	testRewrite(t, true)
+}
+
+func TestRewriteUnchanged(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9")}, BackupOptions{}, env.gopts)
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+
+	// nothing matches, the snapshot must not be modified
+	testRunRewriteExclude(t, env.gopts, []string{"/does/not/exist"}, true)
+	newSnapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, restic.NewIDSet(snapshotIDs...).Equals(restic.NewIDSet(newSnapshotIDs...)),
+		"snapshots were modified: %v vs. %v", snapshotIDs, newSnapshotIDs)
+}
+
+func testRunRepairSnapshot(t testing.TB, gopts GlobalOptions, forget bool) {
+	opts := RepairSnapshotOptions{
+		Forget: forget,
+	}
+
+	rtest.OK(t, runRepairSnapshots(opts, gopts, nil))
+}
+
+func TestRepairSnapshotsIntact(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9")}, BackupOptions{}, env.gopts)
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+
+	testRunRepairSnapshot(t, env.gopts, true)
+
+	newSnapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, restic.NewIDSet(snapshotIDs...).Equals(restic.NewIDSet(newSnapshotIDs...)),
+		"snapshots were modified: %v vs. %v", snapshotIDs, newSnapshotIDs)
+	testRunCheck(t, env.gopts)
+}
+
+func TestRepairSnapshotsWithLostData(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9", "2")}, BackupOptions{}, env.gopts)
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9", "3")}, BackupOptions{}, env.gopts)
+	testRunCheck(t, env.gopts)
+
+	// remove a pack file and drop it from the index
+	packs := testRunList(t, "packs", env.gopts)
+	rtest.Assert(t, len(packs) > 1, "expected several packs, got %v", packs)
+	id := packs[0].String()
+	rtest.OK(t, os.Remove(filepath.Join(env.repo, "data", id[:2], id)))
+	testRunRebuildIndex(t, env.gopts)
+
+	_, err := testRunCheckOutput(env.gopts)
+	rtest.Assert(t, err != nil, "check did not detect the missing pack")
+
+	// the lost data is reported although the tests run with verbosity zero
+	buf := bytes.NewBuffer(nil)
+	func() {
+		globalOptions.stdout = buf
+		defer func() {
+			globalOptions.stdout = os.Stdout
+		}()
+
+		testRunRepairSnapshot(t, env.gopts, true)
+	}()
+
+	output := buf.String()
+	rtest.Assert(t, strings.Contains(output, "\nsnapshot ") &&
+		(strings.Contains(output, "  file ") || strings.Contains(output, "  dir ")),
+		"lost data was not reported, output: %q", output)
+
+	_, err = testRunCheckOutput(env.gopts)
+	rtest.OK(t, err)
+}
+
+func TestServe(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	gopts := env.gopts
+	gopts.Repo = filepath.Join(env.base, "served")
+	_, err := newRESTServer(ServeOptions{}, gopts)
+	rtest.Assert(t, err != nil, "expected an error for serving without authentication")
+
+	for _, appendOnly := range []bool{false, true} {
+		t.Run(fmt.Sprintf("AppendOnly=%v", appendOnly), func(t *testing.T) {
+			serverGopts := env.gopts
+			serverGopts.Repo = filepath.Join(env.base, fmt.Sprintf("served-%v", appendOnly))
+
+			srv, err := newRESTServer(ServeOptions{NoAuth: true, AppendOnly: appendOnly}, serverGopts)
+			rtest.OK(t, err)
+			httpSrv := httptest.NewServer(srv)
+			defer func() {
+				httpSrv.Close()
+				rtest.OK(t, srv.Close())
+			}()
+
+			// the repository is created in a subdirectory of the served location
+			gopts := env.gopts
+			gopts.Repo = "rest:" + httpSrv.URL + "/user/repo/"
+
+			testRunInit(t, gopts)
+			rtest.SetupTarTestFixture(t, env.testdata, filepath.Join("testdata", "backup-data.tar.gz"))
+			testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, gopts)
+			testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, gopts)
+			testRunCheck(t, gopts)
+
+			_, err = os.Stat(filepath.Join(serverGopts.Repo, "user", "repo", "config"))
+			rtest.OK(t, err)
+
+			snapshotIDs := testRunList(t, "snapshots", gopts)
+			rtest.Assert(t, len(snapshotIDs) == 2, "expected two snapshots, got %v", snapshotIDs)
+
+			if !appendOnly {
+				testRunForget(t, gopts, snapshotIDs[0].String())
+				rtest.Equals(t, 1, len(testRunList(t, "snapshots", gopts)))
+				return
+			}
+
+			// use the backend directly, the client would retry the request
+			be, err := openBackend(gopts.Repo, gopts, gopts.extended)
+			rtest.OK(t, err)
+			h := restic.Handle{Type: restic.SnapshotFile, Name: snapshotIDs[0].String()}
+			rtest.Assert(t, be.Remove(context.TODO(), h) != nil, "expected removing a snapshot to fail in append-only mode")
+			rtest.Equals(t, 2, len(testRunList(t, "snapshots", gopts)))
+		})
+	}
+}
+
+func TestAppendOnly(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+	testRunKeyAddNewKey(t, "john's geheimnis", env.gopts)
+	keyIDs := testRunKeyListOtherIDs(t, env.gopts)
+	rtest.Equals(t, 1, len(keyIDs))
+
+	gopts := env.gopts
+	gopts.AppendOnly = true
+
+	rtest.SetupTarTestFixture(t, env.testdata, filepath.Join("testdata", "backup-data.tar.gz"))
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, gopts)
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, gopts)
+	testRunCheck(t, gopts)
+	_, snapmap := testRunSnapshots(t, gopts)
+	rtest.Equals(t, 2, len(snapmap))
+
+	// commands which remove files must fail before changing the repository
+	checkErr := func(err error) {
+		rtest.Assert(t, err != nil && strings.Contains(err.Error(), "append-only mode"),
+			"expected append-only error, got %v", err)
+	}
+	checkErr(runForget(ForgetOptions{Last: 1}, gopts, nil))
+	checkErr(runPrune(PruneOptions{MaxUnused: "0%"}, gopts))
+	checkErr(runKey(gopts, []string{"remove", keyIDs[0]}))
+
+	// dry runs only read from the repository
+	rtest.OK(t, runForget(ForgetOptions{Last: 1, DryRun: true}, gopts, nil))
+	rtest.OK(t, runPrune(PruneOptions{MaxUnused: "0%", DryRun: true}, gopts))
+
+	rtest.Equals(t, 2, len(testRunList(t, "snapshots", env.gopts)))
+	rtest.Equals(t, keyIDs, testRunKeyListOtherIDs(t, env.gopts))
+	testRunCheck(t, env.gopts)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/spf13/pflag"
+)
+
+// secondaryRepoOptions holds the location and credentials of a second
+// repository which is used by commands such as copy.
+type secondaryRepoOptions struct {
+	Repo            string
+	PasswordFile    string
+	PasswordCommand string
+	KeyHint         string
+	// password is set by tests, it takes precedence over all other sources
+	password string
+}
+
+// initSecondaryRepoOptions registers the flags for the second repository.
+// repoPrefix describes the second repository in help texts, e.g.
+// "destination".
+func initSecondaryRepoOptions(f *pflag.FlagSet, opts *secondaryRepoOptions, repoPrefix string, repoUsage string) {
+	f.StringVarP(&opts.Repo, "repo2", "", os.Getenv("RESTIC_REPOSITORY2"), repoPrefix+" `repository` "+repoUsage+" (default: $RESTIC_REPOSITORY2)")
+	f.StringVarP(&opts.PasswordFile, "password-file2", "", os.Getenv("RESTIC_PASSWORD_FILE2"), "`file` to read the "+repoPrefix+" repository password from (default: $RESTIC_PASSWORD_FILE2)")
+	f.StringVarP(&opts.KeyHint, "key-hint2", "", os.Getenv("RESTIC_KEY_HINT2"), "key ID of key to try decrypting the "+repoPrefix+" repository first (default: $RESTIC_KEY_HINT2)")
+	f.StringVarP(&opts.PasswordCommand, "password-command2", "", os.Getenv("RESTIC_PASSWORD_COMMAND2"), "shell `command` to obtain the "+repoPrefix+" repository password from (default: $RESTIC_PASSWORD_COMMAND2)")
+}
+
+// fillSecondaryGlobalOpts returns a copy of gopts which refers to the second
+// repository. The password is resolved from the flags or $RESTIC_PASSWORD2,
+// if neither is set OpenRepository will prompt for it.
+func fillSecondaryGlobalOpts(opts secondaryRepoOptions, gopts GlobalOptions, repoPrefix string) (GlobalOptions, error) {
+	if opts.Repo == "" {
+		return GlobalOptions{}, errors.Fatalf("Please specify the %s repository location (--repo2)", repoPrefix)
+	}
+
+	dstGopts := gopts
+	dstGopts.Repo = opts.Repo
+	dstGopts.PasswordFile = opts.PasswordFile
+	dstGopts.PasswordCommand = opts.PasswordCommand
+	dstGopts.KeyHint = opts.KeyHint
+
+	if opts.password != "" {
+		dstGopts.password = opts.password
+		return dstGopts, nil
+	}
+
+	pwd, err := resolvePassword(dstGopts, "RESTIC_PASSWORD2")
+	if err != nil {
+		return GlobalOptions{}, err
+	}
+	dstGopts.password = pwd
+
+	return dstGopts, nil
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+)
+
+// The tar package cannot write sparse files, so sparse files are written as
+// raw blocks in the "GNU sparse 1.0" format of the PAX format, which is
+// understood by GNU tar, bsdtar and the tar package itself: The file is
+// preceded by an extended header with the records GNU.sparse.*, its data
+// starts with the sparse map, followed by all non-hole ranges of the file.
+
+const tarBlockSize = 512
+
+// tarWriter writes nodes to a tar archive. If sparse is set, files which
+// contain runs of zero bytes are stored as sparse files.
+type tarWriter struct {
+	*tar.Writer
+
+	// out is the writer the archive is written to
+	out    io.Writer
+	sparse bool
+}
+
+func newTarWriter(out io.Writer, sparse bool) *tarWriter {
+	return &tarWriter{
+		Writer: tar.NewWriter(out),
+		out:    out,
+		sparse: sparse,
+	}
+}
+
+// sparseEntry is a range of data within a sparse file.
+type sparseEntry struct {
+	offset, length int64
+}
+
+// sparseMap returns the ranges of the file which contain data, the remaining
+// ranges consist of all-zero blobs. hasHoles is false if there are no such
+// ranges.
+func sparseMap(repo restic.Repository, node *restic.Node) (entries []sparseEntry, hasHoles bool, err error) {
+	zeroChunk := repository.ZeroChunk()
+
+	var offset int64
+	for _, id := range node.Content {
+		blobs, found := repo.Index().Lookup(id, restic.DataBlob)
+		if !found {
+			return nil, false, errors.Errorf("unknown blob %v", id.Str())
+		}
+		length := int64(blobs[0].DataLength())
+
+		switch {
+		case id.Equal(zeroChunk):
+			hasHoles = true
+		case len(entries) > 0 && entries[len(entries)-1].offset+entries[len(entries)-1].length == offset:
+			entries[len(entries)-1].length += length
+		default:
+			entries = append(entries, sparseEntry{offset: offset, length: length})
+		}
+
+		offset += length
+	}
+
+	// a trailing hole is marked by an empty entry at the end of the file
+	if len(entries) == 0 || entries[len(entries)-1].offset+entries[len(entries)-1].length < offset {
+		entries = append(entries, sparseEntry{offset: offset})
+	}
+
+	return entries, hasHoles, nil
+}
+
+// paxRecord formats a single record of a PAX extended header, the length at
+// the beginning of the record includes the length field itself.
+func paxRecord(key, value string) string {
+	rec := " " + key + "=" + value + "\n"
+	size := len(rec)
+	for {
+		n := len(strconv.Itoa(size)) + len(rec)
+		if n == size {
+			return strconv.Itoa(size) + rec
+		}
+		size = n
+	}
+}
+
+// paxTime formats t as a decimal number of seconds since the epoch.
+func paxTime(t time.Time) string {
+	sec, nsec := t.Unix(), t.Nanosecond()
+	if nsec == 0 {
+		return strconv.FormatInt(sec, 10)
+	}
+
+	sign := ""
+	if sec < 0 {
+		sign = "-"
+		sec = -(sec + 1)
+		nsec = 1e9 - nsec
+	}
+
+	return sign + strconv.FormatInt(sec, 10) + strings.TrimRight(fmt.Sprintf(".%09d", nsec), "0")
+}
+
+// formatOctal writes v as a zero-padded octal number with a trailing NUL to
+// field. Values which do not fit are written as zero and false is returned,
+// they must be stored in the extended header instead.
+func formatOctal(field []byte, v int64) bool {
+	s := strconv.FormatInt(v, 8)
+	ok := v >= 0 && len(s) < len(field)
+	if !ok {
+		s = "0"
+	}
+
+	copy(field, strings.Repeat("0", len(field)-1-len(s))+s+"\x00")
+	return ok
+}
+
+// tarHeaderBlock returns a USTAR header block. Numbers which do not fit into
+// the header are added to records.
+func tarHeaderBlock(name string, typeflag byte, mode, uid, gid, size int64, mtime time.Time, records map[string]string) []byte {
+	blk := make([]byte, tarBlockSize)
+
+	if len(name) > 100 {
+		name = name[:100]
+	}
+	copy(blk[0:100], name)
+
+	formatOctal(blk[100:108], mode)
+	if !formatOctal(blk[108:116], uid) && records != nil {
+		records["uid"] = strconv.FormatInt(uid, 10)
+	}
+	if !formatOctal(blk[116:124], gid) && records != nil {
+		records["gid"] = strconv.FormatInt(gid, 10)
+	}
+	if !formatOctal(blk[124:136], size) && records != nil {
+		records["size"] = strconv.FormatInt(size, 10)
+	}
+	formatOctal(blk[136:148], mtime.Unix())
+
+	blk[156] = typeflag
+	copy(blk[257:265], "ustar\x0000")
+
+	// the checksum is computed with the checksum field set to spaces
+	copy(blk[148:156], "        ")
+	var sum int64
+	for _, c := range blk {
+		sum += int64(c)
+	}
+	copy(blk[148:156], fmt.Sprintf("%06o\x00 ", sum))
+
+	return blk
+}
+
+// padBlock returns the zero bytes which are needed to pad n bytes to a full
+// tar block.
+func padBlock(n int64) []byte {
+	return make([]byte, (tarBlockSize-n%tarBlockSize)%tarBlockSize)
+}
+
+// writeSparseFile writes the file in header as a GNU sparse file with the
+// data ranges in entries.
+func (tw *tarWriter) writeSparseFile(ctx context.Context, repo restic.Repository, header *tar.Header, node *restic.Node, entries []sparseEntry) error {
+	// finish the previous entry, so that the raw blocks can be written
+	// directly to the output
+	err := tw.Flush()
+	if err != nil {
+		return errors.Wrap(err, "Flush")
+	}
+
+	var sparseData bytes.Buffer
+	fmt.Fprintf(&sparseData, "%d\n", len(entries))
+	var dataSize int64
+	for _, e := range entries {
+		fmt.Fprintf(&sparseData, "%d\n%d\n", e.offset, e.length)
+		dataSize += e.length
+	}
+	sparseData.Write(padBlock(int64(sparseData.Len())))
+	size := int64(sparseData.Len()) + dataSize
+
+	records := map[string]string{
+		"GNU.sparse.major":    "1",
+		"GNU.sparse.minor":    "0",
+		"GNU.sparse.name":     header.Name,
+		"GNU.sparse.realsize": strconv.FormatInt(header.Size, 10),
+		"mtime":               paxTime(header.ModTime),
+	}
+	if !header.AccessTime.IsZero() {
+		records["atime"] = paxTime(header.AccessTime)
+	}
+	if !header.ChangeTime.IsZero() {
+		records["ctime"] = paxTime(header.ChangeTime)
+	}
+	for k, v := range header.PAXRecords {
+		records[k] = v
+	}
+
+	dir, file := path.Split(header.Name)
+	name := path.Join(dir, "GNUSparseFile.0", file)
+	fileHeader := tarHeaderBlock(name, tar.TypeReg, header.Mode&07777, int64(header.Uid), int64(header.Gid), size, header.ModTime, records)
+
+	keys := make([]string, 0, len(records))
+	for k := range records {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var paxData bytes.Buffer
+	for _, k := range keys {
+		paxData.WriteString(paxRecord(k, records[k]))
+	}
+	paxHeader := tarHeaderBlock(path.Join(dir, "PaxHeaders.0", file), tar.TypeXHeader, 0644, 0, 0, int64(paxData.Len()), header.ModTime, nil)
+	paxData.Write(padBlock(int64(paxData.Len())))
+
+	for _, buf := range [][]byte{paxHeader, paxData.Bytes(), fileHeader, sparseData.Bytes()} {
+		_, err = tw.out.Write(buf)
+		if err != nil {
+			return errors.Wrap(err, "Write")
+		}
+	}
+
+	zeroChunk := repository.ZeroChunk()
+	var blob []byte
+	for _, id := range node.Content {
+		if id.Equal(zeroChunk) {
+			continue
+		}
+
+		blob, err = repo.LoadBlob(ctx, restic.DataBlob, id, blob)
+		if err != nil {
+			return err
+		}
+
+		_, err = tw.out.Write(blob)
+		if err != nil {
+			return errors.Wrap(err, "Write")
+		}
+	}
+
+	_, err = tw.out.Write(padBlock(dataSize))
+	return errors.Wrap(err, "Write")
+}
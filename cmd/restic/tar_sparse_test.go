@@ -0,0 +1,114 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/restic/chunker"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestTarSparseFile(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	zeros := make([]byte, chunker.MinSize)
+	zeroID, _, err := repo.SaveBlob(ctx, restic.DataBlob, zeros, restic.ID{}, false)
+	rtest.OK(t, err)
+
+	data := []byte("foobar")
+	dataID, _, err := repo.SaveBlob(ctx, restic.DataBlob, data, restic.ID{}, false)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.Flush(ctx))
+
+	var tests = []restic.IDs{
+		{zeroID, dataID, dataID, zeroID, dataID},
+		{dataID, zeroID, zeroID},
+		{zeroID},
+		{dataID, dataID},
+	}
+
+	for _, content := range tests {
+		var want []byte
+		hasHoles := false
+		for _, id := range content {
+			if id.Equal(zeroID) {
+				want = append(want, zeros...)
+				hasHoles = true
+			} else {
+				want = append(want, data...)
+			}
+		}
+
+		node := &restic.Node{
+			Name:       "file",
+			Type:       "file",
+			Path:       "dir/" + strings.Repeat("long", 30),
+			Mode:       0640,
+			UID:        1000,
+			GID:        2000000000,
+			Size:       uint64(len(want)),
+			ModTime:    time.Unix(1500000000, 123456789),
+			AccessTime: time.Unix(1500000001, 0),
+			ChangeTime: time.Unix(1500000002, 0),
+			Content:    content,
+			ExtendedAttributes: []restic.ExtendedAttribute{
+				{Name: "user.foo", Value: []byte("bar")},
+			},
+		}
+
+		buf := &bytes.Buffer{}
+		tw := newTarWriter(buf, true)
+		rtest.OK(t, tarNode(ctx, tw, &restic.Node{Type: "dir", Path: "dir", Mode: 0755}, repo))
+		rtest.OK(t, tarNode(ctx, tw, node, repo))
+		rtest.OK(t, tarNode(ctx, tw, &restic.Node{Type: "symlink", Path: "dir/link", LinkTarget: "file"}, repo))
+		rtest.OK(t, tw.Close())
+
+		if hasHoles && buf.Len() >= len(zeros) {
+			t.Errorf("archive with %d bytes is not sparse", buf.Len())
+		}
+
+		tr := tar.NewReader(buf)
+		hdr, err := tr.Next()
+		rtest.OK(t, err)
+		rtest.Equals(t, "dir", hdr.Name)
+
+		hdr, err = tr.Next()
+		rtest.OK(t, err)
+		rtest.Equals(t, node.Path, hdr.Name)
+		rtest.Equals(t, int64(len(want)), hdr.Size)
+		rtest.Equals(t, int64(0640), hdr.Mode)
+		rtest.Equals(t, 1000, hdr.Uid)
+		rtest.Equals(t, 2000000000, hdr.Gid)
+		rtest.Equals(t, node.ModTime.Unix(), hdr.ModTime.Unix())
+		if hasHoles {
+			rtest.Assert(t, node.ModTime.Equal(hdr.ModTime), "wrong mtime %v", hdr.ModTime)
+			rtest.Assert(t, node.ChangeTime.Equal(hdr.ChangeTime), "wrong ctime %v", hdr.ChangeTime)
+		}
+		rtest.Equals(t, "bar", hdr.PAXRecords["SCHILY.xattr.user.foo"])
+
+		buf2, err := ioutil.ReadAll(tr)
+		rtest.OK(t, err)
+		if !bytes.Equal(want, buf2) {
+			t.Errorf("wrong content for file %v", content)
+		}
+
+		hdr, err = tr.Next()
+		rtest.OK(t, err)
+		rtest.Equals(t, "dir/link", hdr.Name)
+
+		_, err = tr.Next()
+		rtest.Equals(t, io.EOF, err)
+	}
+}
@@ -57,6 +57,10 @@ type Archiver struct {
 	fileSaver *FileSaver
 	treeSaver *TreeSaver
 
+	// checkpoint records the items saved so far, it is nil unless partial
+	// snapshots are written during the backup.
+	checkpoint *checkpoint
+
 	// Error is called for all errors that occur during backup.
 	Error ErrorFunc
 
@@ -78,10 +82,33 @@ type Archiver struct {
 	// WithAtime configures if the access time for files and directories should
 	// be saved. Enabling it may result in much metadata, so it's off by
 	// default.
-	WithAtime   bool
-	IgnoreInode bool
+	WithAtime bool
+
+	// ChangeDetection configures how files which have changed since the
+	// parent snapshot are detected.
+	ChangeDetection ChangeDetection
 }
 
+// ChangeDetection is a set of flags which configure how the archiver detects
+// whether a file has changed since the parent snapshot. By default, the type,
+// modification time, status change time, size and inode are compared.
+type ChangeDetection uint
+
+const (
+	// ChangeIgnoreMtime ignores the modification time.
+	ChangeIgnoreMtime ChangeDetection = 1 << iota
+
+	// ChangeIgnoreCtime ignores the status change time.
+	ChangeIgnoreCtime
+
+	// ChangeIgnoreInode ignores the inode number.
+	ChangeIgnoreInode
+
+	// ChangeReadContent reads all files again, the list of blobs of the
+	// parent is only reused if the content is still the same.
+	ChangeReadContent
+)
+
 // Options is used to configure the archiver.
 type Options struct {
 	// FileReadConcurrency sets how many files are read in concurrently. If
@@ -134,7 +161,6 @@ func New(repo restic.Repository, fs fs.FS, opts Options) *Archiver {
 		CompleteItem: func(string, *restic.Node, *restic.Node, ItemStats, time.Duration) {},
 		StartFile:    func(string) {},
 		CompleteBlob: func(string, uint64) {},
-		IgnoreInode:  false,
 	}
 
 	return arch
@@ -222,6 +248,8 @@ func (arch *Archiver) SaveDir(ctx context.Context, snPath string, fi os.FileInfo
 	}
 	sort.Strings(names)
 
+	arch.checkpoint.startDir(snPath, treeNode)
+
 	nodes := make([]FutureNode, 0, len(names))
 
 	for _, name := range names {
@@ -386,8 +414,10 @@ func (arch *Archiver) Save(ctx context.Context, snPath, target string, previous
 			return FutureNode{}, true, nil
 		}
 
-		// use previous list of blobs if the file hasn't changed
-		if previous != nil && !fileChanged(fi, previous, arch.IgnoreInode) {
+		// use previous list of blobs if the file hasn't changed and all
+		// blobs are still in the repo, a partial parent snapshot may
+		// reference blobs which were not saved
+		if previous != nil && !fileChanged(fi, previous, arch.ChangeDetection) && arch.allBlobsPresent(previous) {
 			debug.Log("%v hasn't changed, using old list of blobs", target)
 			arch.CompleteItem(snPath, previous, previous, ItemStats{}, time.Since(start))
 			arch.CompleteBlob(snPath, previous.Size)
@@ -398,6 +428,7 @@ func (arch *Archiver) Save(ctx context.Context, snPath, target string, previous
 
 			// copy list of blobs
 			fn.node.Content = previous.Content
+			arch.checkpoint.complete(snPath, fn.node)
 
 			_ = file.Close()
 			return fn, false, nil
@@ -409,6 +440,7 @@ func (arch *Archiver) Save(ctx context.Context, snPath, target string, previous
 			arch.StartFile(snPath)
 		}, func(node *restic.Node, stats ItemStats) {
 			arch.CompleteItem(snPath, previous, node, stats, time.Since(start))
+			arch.checkpoint.complete(snPath, node)
 		})
 
 	case fi.IsDir():
@@ -438,6 +470,7 @@ func (arch *Archiver) Save(ctx context.Context, snPath, target string, previous
 		if err != nil {
 			return FutureNode{}, false, err
 		}
+		arch.checkpoint.complete(snPath, fn.node)
 	}
 
 	debug.Log("return after %.3f", time.Since(start).Seconds())
@@ -445,9 +478,21 @@ func (arch *Archiver) Save(ctx context.Context, snPath, target string, previous
 	return fn, false, nil
 }
 
-// fileChanged returns true if the file's content has changed since the node
-// was created.
-func fileChanged(fi os.FileInfo, node *restic.Node, ignoreInode bool) bool {
+// allBlobsPresent returns true if all data blobs of the file referenced by
+// node are known to the index.
+func (arch *Archiver) allBlobsPresent(node *restic.Node) bool {
+	for _, id := range node.Content {
+		if !arch.Repo.Index().Has(id, restic.DataBlob) {
+			debug.Log("blob %v of %v is not in the index", id.Str(), node.Name)
+			return false
+		}
+	}
+	return true
+}
+
+// fileChanged returns true if the file's content may have changed since the
+// node was created. The attributes which are ignored are configured by flags.
+func fileChanged(fi os.FileInfo, node *restic.Node, flags ChangeDetection) bool {
 	if node == nil {
 		return true
 	}
@@ -457,14 +502,19 @@ func fileChanged(fi os.FileInfo, node *restic.Node, ignoreInode bool) bool {
 		return true
 	}
 
+	// the content needs to be read and compared
+	if flags&ChangeReadContent != 0 {
+		return true
+	}
+
 	// check modification timestamp
-	if !fi.ModTime().Equal(node.ModTime) {
+	if flags&ChangeIgnoreMtime == 0 && !fi.ModTime().Equal(node.ModTime) {
 		return true
 	}
 
 	// check status change timestamp
 	extFI := fs.ExtendedStat(fi)
-	if !ignoreInode && !extFI.ChangeTime.Equal(node.ChangeTime) {
+	if flags&ChangeIgnoreCtime == 0 && !extFI.ChangeTime.Equal(node.ChangeTime) {
 		return true
 	}
 
@@ -474,7 +524,7 @@ func fileChanged(fi os.FileInfo, node *restic.Node, ignoreInode bool) bool {
 	}
 
 	// check inode
-	if !ignoreInode && node.Inode != extFI.Inode {
+	if flags&ChangeIgnoreInode == 0 && node.Inode != extFI.Inode {
 		return true
 	}
 
@@ -593,6 +643,7 @@ func (arch *Archiver) SaveTree(ctx context.Context, snPath string, atree *Tree,
 		}
 
 		arch.CompleteItem(snItem, oldNode, node, nodeStats, time.Since(start))
+		arch.checkpoint.complete(join(snPath, name), node)
 	}
 
 	debug.Log("waiting on %d nodes", len(futureNodes))
@@ -687,6 +738,48 @@ type SnapshotOptions struct {
 	Excludes       []string
 	Time           time.Time
 	ParentSnapshot restic.ID
+
+	// CheckpointInterval configures how often a partial snapshot is written
+	// while the backup is running, zero disables partial snapshots.
+	CheckpointInterval time.Duration
+
+	// SkipIfUnchanged prevents saving a new snapshot when the tree is the
+	// same as the one of the parent snapshot.
+	SkipIfUnchanged bool
+}
+
+// parentUnchanged returns true if the snapshot with the id references the tree
+// treeID. Partial snapshots are never considered unchanged.
+func (arch *Archiver) parentUnchanged(ctx context.Context, snapshotID restic.ID, treeID restic.ID) (bool, error) {
+	if snapshotID.IsNull() {
+		return false, nil
+	}
+
+	sn, err := restic.LoadSnapshot(ctx, arch.Repo, snapshotID)
+	if err != nil {
+		return false, err
+	}
+
+	return !sn.Partial && sn.Tree != nil && sn.Tree.Equal(treeID), nil
+}
+
+// loadPartialParent returns the snapshot with the id if it is a partial
+// snapshot, and nil otherwise.
+func (arch *Archiver) loadPartialParent(ctx context.Context, snapshotID restic.ID) *restic.Snapshot {
+	if snapshotID.IsNull() {
+		return nil
+	}
+
+	sn, err := restic.LoadSnapshot(ctx, arch.Repo, snapshotID)
+	if err != nil {
+		debug.Log("unable to load snapshot %v: %v", snapshotID, err)
+		return nil
+	}
+
+	if !sn.Partial {
+		return nil
+	}
+	return sn
 }
 
 // loadParentTree loads a tree referenced by snapshot id. If id is null, nil is returned.
@@ -728,9 +821,14 @@ func (arch *Archiver) runWorkers(ctx context.Context, t *tomb.Tomb) {
 	arch.fileSaver.NodeFromFileInfo = arch.nodeFromFileInfo
 
 	arch.treeSaver = NewTreeSaver(ctx, t, arch.Options.SaveTreeConcurrency, arch.saveTree, arch.Error)
+	if arch.checkpoint != nil {
+		arch.treeSaver.CompleteTree = arch.checkpoint.complete
+	}
 }
 
-// Snapshot saves several targets and returns a snapshot.
+// Snapshot saves several targets and returns a snapshot. If
+// opts.SkipIfUnchanged is set and nothing has changed since the parent
+// snapshot, no snapshot is saved and a nil snapshot is returned.
 func (arch *Archiver) Snapshot(ctx context.Context, targets []string, opts SnapshotOptions) (*restic.Snapshot, restic.ID, error) {
 	cleanTargets, err := resolveRelativeTargets(arch.FS, targets)
 	if err != nil {
@@ -745,8 +843,23 @@ func (arch *Archiver) Snapshot(ctx context.Context, targets []string, opts Snaps
 	var t tomb.Tomb
 	wctx := t.Context(ctx)
 
+	arch.checkpoint = nil
+	if opts.CheckpointInterval > 0 {
+		arch.checkpoint = newCheckpoint()
+	}
+
 	arch.runWorkers(wctx, &t)
 
+	lastCheckpoint := make(chan restic.ID, 1)
+	if arch.checkpoint != nil {
+		c := arch.checkpoint
+		t.Go(func() error {
+			return arch.runCheckpoints(wctx, c, targets, opts, lastCheckpoint)
+		})
+	} else {
+		lastCheckpoint <- restic.ID{}
+	}
+
 	start := time.Now()
 
 	debug.Log("starting snapshot")
@@ -783,13 +896,30 @@ func (arch *Archiver) Snapshot(ctx context.Context, targets []string, opts Snaps
 		return nil, restic.ID{}, err
 	}
 
+	if opts.SkipIfUnchanged {
+		unchanged, err := arch.parentUnchanged(ctx, opts.ParentSnapshot, rootTreeID)
+		if err != nil {
+			return nil, restic.ID{}, err
+		}
+
+		if unchanged {
+			debug.Log("tree %v is unchanged, not saving a snapshot", rootTreeID.Str())
+			arch.removeCheckpoint(ctx, <-lastCheckpoint)
+			return nil, restic.ID{}, nil
+		}
+	}
+
 	sn, err := restic.NewSnapshot(targets, opts.Tags, opts.Hostname, opts.Time)
 	if err != nil {
 		return nil, restic.ID{}, err
 	}
 
 	sn.Excludes = opts.Excludes
-	if !opts.ParentSnapshot.IsNull() {
+	partialParent := arch.loadPartialParent(ctx, opts.ParentSnapshot)
+	if partialParent != nil {
+		// the partial parent is removed below, so reference its parent
+		sn.Parent = partialParent.Parent
+	} else if !opts.ParentSnapshot.IsNull() {
 		id := opts.ParentSnapshot
 		sn.Parent = &id
 	}
@@ -800,5 +930,11 @@ func (arch *Archiver) Snapshot(ctx context.Context, targets []string, opts Snaps
 		return nil, restic.ID{}, err
 	}
 
+	// the partial snapshots are not needed any more
+	arch.removeCheckpoint(ctx, <-lastCheckpoint)
+	if partialParent != nil {
+		arch.removeCheckpoint(ctx, opts.ParentSnapshot)
+	}
+
 	return sn, id, nil
 }
@@ -559,7 +559,7 @@ func TestFileChanged(t *testing.T) {
 		SkipForWindows bool
 		Content        []byte
 		Modify         func(t testing.TB, filename string)
-		IgnoreInode    bool
+		ChangeIgnore   ChangeDetection
 		SameFile       bool
 	}{
 		{
@@ -625,8 +625,47 @@ func TestFileChanged(t *testing.T) {
 				save(t, filename, defaultContent)
 				setTimestamp(t, filename, fi.ModTime(), fi.ModTime())
 			},
-			IgnoreInode: true,
-			SameFile:    true,
+			ChangeIgnore: ChangeIgnoreCtime | ChangeIgnoreInode,
+			SameFile:     true,
+		},
+		{
+			Name: "ignore-mtime-new-timestamp",
+			Modify: func(t testing.TB, filename string) {
+				sleep()
+				save(t, filename, defaultContent)
+			},
+			ChangeIgnore: ChangeIgnoreMtime | ChangeIgnoreInode,
+		},
+		{
+			Name:           "ignore-mtime-new-content-same-timestamp",
+			SkipForWindows: true,
+			Modify: func(t testing.TB, filename string) {
+				fi, err := os.Stat(filename)
+				if err != nil {
+					t.Fatal(err)
+				}
+				extFI := fs.ExtendedStat(fi)
+				save(t, filename, bytes.ToUpper(defaultContent))
+				sleep()
+				setTimestamp(t, filename, extFI.AccessTime, extFI.ModTime)
+			},
+			ChangeIgnore: ChangeIgnoreMtime | ChangeIgnoreInode,
+		},
+		{
+			Name: "size-same-content-new-timestamp",
+			Modify: func(t testing.TB, filename string) {
+				sleep()
+				save(t, filename, defaultContent)
+			},
+			ChangeIgnore: ChangeIgnoreMtime | ChangeIgnoreCtime | ChangeIgnoreInode,
+			SameFile:     true,
+		},
+		{
+			Name: "size-longer-content",
+			Modify: func(t testing.TB, filename string) {
+				save(t, filename, []byte("xxxxxxxxxxxxxxxxxxxxxx"))
+			},
+			ChangeIgnore: ChangeIgnoreMtime | ChangeIgnoreCtime | ChangeIgnoreInode,
 		},
 	}
 
@@ -649,7 +688,7 @@ func TestFileChanged(t *testing.T) {
 			fiBefore := lstat(t, filename)
 			node := nodeFromFI(t, filename, fiBefore)
 
-			if fileChanged(fiBefore, node, false) {
+			if fileChanged(fiBefore, node, 0) {
 				t.Fatalf("unchanged file detected as changed")
 			}
 
@@ -659,12 +698,12 @@ func TestFileChanged(t *testing.T) {
 
 			if test.SameFile {
 				// file should be detected as unchanged
-				if fileChanged(fiAfter, node, test.IgnoreInode) {
+				if fileChanged(fiAfter, node, test.ChangeIgnore) {
 					t.Fatalf("unmodified file detected as changed")
 				}
 			} else {
 				// file should be detected as changed
-				if !fileChanged(fiAfter, node, test.IgnoreInode) && !test.SameFile {
+				if !fileChanged(fiAfter, node, test.ChangeIgnore) && !test.SameFile {
 					t.Fatalf("modified file detected as unchanged")
 				}
 			}
@@ -682,7 +721,7 @@ func TestFilChangedSpecialCases(t *testing.T) {
 
 	t.Run("nil-node", func(t *testing.T) {
 		fi := lstat(t, filename)
-		if !fileChanged(fi, nil, false) {
+		if !fileChanged(fi, nil, 0) {
 			t.Fatal("nil node detected as unchanged")
 		}
 	})
@@ -691,10 +730,18 @@ func TestFilChangedSpecialCases(t *testing.T) {
 		fi := lstat(t, filename)
 		node := nodeFromFI(t, filename, fi)
 		node.Type = "symlink"
-		if !fileChanged(fi, node, false) {
+		if !fileChanged(fi, node, 0) {
 			t.Fatal("node with changed type detected as unchanged")
 		}
 	})
+
+	t.Run("read-content", func(t *testing.T) {
+		fi := lstat(t, filename)
+		node := nodeFromFI(t, filename, fi)
+		if !fileChanged(fi, node, ChangeReadContent) {
+			t.Fatal("file is not read again")
+		}
+	})
 }
 
 func TestArchiverSaveDir(t *testing.T) {
@@ -1657,6 +1704,108 @@ func TestArchiverParent(t *testing.T) {
 	}
 }
 
+func TestArchiverReadContent(t *testing.T) {
+	src := TestDir{
+		"targetfile": TestFile{Content: string(restictest.Random(888, 2*1024*1024+5000))},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tempdir, repo, cleanup := prepareTempdirRepoSrc(t, src)
+	defer cleanup()
+
+	testFS := &MockFS{
+		FS:        fs.Track{FS: fs.Local{}},
+		bytesRead: make(map[string]int),
+	}
+
+	arch := New(repo, testFS, Options{})
+	arch.ChangeDetection = ChangeReadContent
+
+	back := fs.TestChdir(t, tempdir)
+	defer back()
+
+	first, firstSnapshotID, err := arch.Snapshot(ctx, []string{"."}, SnapshotOptions{Time: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := SnapshotOptions{
+		Time:           time.Now(),
+		ParentSnapshot: firstSnapshotID,
+	}
+	second, _, err := arch.Snapshot(ctx, []string{"."}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the file has been read twice, but the content is the same
+	restictest.Equals(t, 2*len(src["targetfile"].(TestFile).Content), testFS.bytesRead["targetfile"])
+	restictest.Equals(t, *first.Tree, *second.Tree)
+
+	checker.TestCheckRepo(t, repo)
+}
+
+func TestArchiverSkipIfUnchanged(t *testing.T) {
+	src := TestDir{
+		"subdir": TestDir{
+			"foo": TestFile{Content: "foo"},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tempdir, repo, cleanup := prepareTempdirRepoSrc(t, src)
+	defer cleanup()
+
+	arch := New(repo, fs.Track{FS: fs.Local{}}, Options{})
+
+	back := fs.TestChdir(t, tempdir)
+	defer back()
+
+	opts := SnapshotOptions{
+		Time:            time.Now(),
+		SkipIfUnchanged: true,
+	}
+	_, firstSnapshotID, err := arch.Snapshot(ctx, []string{"subdir"}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if firstSnapshotID.IsNull() {
+		t.Fatal("no snapshot saved without a parent")
+	}
+
+	opts.ParentSnapshot = firstSnapshotID
+	sn, id, err := arch.Snapshot(ctx, []string{"subdir"}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sn != nil || !id.IsNull() {
+		t.Fatalf("snapshot %v saved although nothing has changed", id.Str())
+	}
+
+	save(t, filepath.Join("subdir", "bar"), []byte("bar"))
+
+	_, id, err = arch.Snapshot(ctx, []string{"subdir"}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id.IsNull() {
+		t.Fatal("no snapshot saved for modified files")
+	}
+
+	snapshots, err := restic.LoadAllSnapshots(ctx, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restictest.Equals(t, 2, len(snapshots))
+}
+
 func TestArchiverErrorReporting(t *testing.T) {
 	ignoreErrorForBasename := func(basename string) ErrorFunc {
 		return func(item string, fi os.FileInfo, err error) error {
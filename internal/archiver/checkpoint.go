@@ -0,0 +1,313 @@
+package archiver
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/restic"
+)
+
+// checkpointDir collects the nodes which have been saved so far for a
+// directory which is still being archived.
+type checkpointDir struct {
+	node  *restic.Node
+	nodes map[string]*restic.Node
+	dirs  map[string]*checkpointDir
+}
+
+func newCheckpointDir() *checkpointDir {
+	return &checkpointDir{
+		nodes: make(map[string]*restic.Node),
+		dirs:  make(map[string]*checkpointDir),
+	}
+}
+
+// checkpoint records the files and directories which have been saved while a
+// backup is running, so that a partial snapshot can be written before all
+// targets have been archived. Once a directory has been saved completely, only
+// its node is kept.
+type checkpoint struct {
+	m    sync.Mutex
+	root *checkpointDir
+}
+
+func newCheckpoint() *checkpoint {
+	return &checkpoint{root: newCheckpointDir()}
+}
+
+// splitPath returns the components of the path within the snapshot.
+func splitPath(snPath string) []string {
+	snPath = strings.Trim(snPath, "/")
+	if snPath == "" {
+		return nil
+	}
+	return strings.Split(snPath, "/")
+}
+
+// dir returns the entry for the directory with the components, it is created
+// if necessary. The caller must hold the lock.
+func (c *checkpoint) dir(components []string) *checkpointDir {
+	dir := c.root
+	for _, name := range components {
+		sub, ok := dir.dirs[name]
+		if !ok {
+			sub = newCheckpointDir()
+			dir.dirs[name] = sub
+		}
+		dir = sub
+	}
+	return dir
+}
+
+// startDir records that the directory at snPath is being archived, node is
+// used for the directory in partial snapshots. Calling startDir on a nil
+// checkpoint is a no-op.
+func (c *checkpoint) startDir(snPath string, node *restic.Node) {
+	components := splitPath(snPath)
+	if c == nil || node == nil || len(components) == 0 {
+		return
+	}
+
+	n := *node
+	n.Name = components[len(components)-1]
+
+	c.m.Lock()
+	c.dir(components).node = &n
+	c.m.Unlock()
+}
+
+// complete records that the item at snPath has been saved. For directories,
+// node must reference the saved subtree. Calling complete on a nil checkpoint
+// is a no-op.
+func (c *checkpoint) complete(snPath string, node *restic.Node) {
+	components := splitPath(snPath)
+	if c == nil || node == nil || len(components) == 0 {
+		return
+	}
+
+	name := components[len(components)-1]
+	n := *node
+	n.Name = name
+
+	c.m.Lock()
+	parent := c.dir(components[:len(components)-1])
+	delete(parent.dirs, name)
+	parent.nodes[name] = &n
+	c.m.Unlock()
+}
+
+// checkpointTree is a copy of a checkpointDir which can be saved without
+// holding the lock.
+type checkpointTree struct {
+	node  restic.Node
+	nodes []*restic.Node
+	dirs  []*checkpointTree
+}
+
+// copyDir returns a copy of dir. The caller must hold the lock.
+func copyDir(name string, dir *checkpointDir) *checkpointTree {
+	t := &checkpointTree{}
+	if dir.node != nil {
+		t.node = *dir.node
+	} else {
+		// the directory has not been inspected yet
+		now := time.Now()
+		t.node = restic.Node{
+			Type:       "dir",
+			Mode:       os.ModeDir | 0755,
+			ModTime:    now,
+			AccessTime: now,
+			ChangeTime: now,
+		}
+	}
+	t.node.Name = name
+
+	for _, node := range dir.nodes {
+		t.nodes = append(t.nodes, node)
+	}
+
+	for name, sub := range dir.dirs {
+		t.dirs = append(t.dirs, copyDir(name, sub))
+	}
+
+	return t
+}
+
+// blobsPresent returns true if all data blobs and the subtree referenced by
+// node have been saved to a pack and are in the index.
+func blobsPresent(idx restic.Index, node *restic.Node) bool {
+	if node.Subtree != nil {
+		if _, ok := idx.Lookup(*node.Subtree, restic.TreeBlob); !ok {
+			return false
+		}
+	}
+
+	for _, id := range node.Content {
+		if _, ok := idx.Lookup(id, restic.DataBlob); !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// saveCheckpointTree stores t and all incomplete subdirectories in the repo.
+// Nodes whose data has not been saved to a pack yet are skipped. The IDs of
+// all trees are added to saved.
+func (arch *Archiver) saveCheckpointTree(ctx context.Context, t *checkpointTree, saved restic.IDSet) (restic.ID, error) {
+	tree := restic.NewTree()
+	idx := arch.Repo.Index()
+
+	for _, node := range t.nodes {
+		if !blobsPresent(idx, node) {
+			debug.Log("data for %v has not been saved yet, skipping", node.Name)
+			continue
+		}
+
+		err := tree.Insert(node)
+		if err != nil {
+			return restic.ID{}, err
+		}
+	}
+
+	for _, sub := range t.dirs {
+		id, err := arch.saveCheckpointTree(ctx, sub, saved)
+		if err != nil {
+			return restic.ID{}, err
+		}
+
+		node := sub.node
+		node.Subtree = &id
+
+		err = tree.Insert(&node)
+		if err != nil {
+			return restic.ID{}, err
+		}
+	}
+
+	id, _, err := arch.saveTree(ctx, tree)
+	if err != nil {
+		return restic.ID{}, err
+	}
+
+	saved.Insert(id)
+	return id, nil
+}
+
+// saveCheckpoint saves the files and directories recorded in c so far as a
+// partial snapshot. If nothing could be saved yet, a null ID is returned.
+func (arch *Archiver) saveCheckpoint(ctx context.Context, c *checkpoint, targets []string, opts SnapshotOptions) (restic.ID, error) {
+	// take the copy first, so that the data of all files in it has been
+	// handed to the repository before the packs are flushed. Files which are
+	// completed later are not part of this checkpoint.
+	c.m.Lock()
+	t := copyDir("", c.root)
+	c.m.Unlock()
+
+	if len(t.nodes) == 0 && len(t.dirs) == 0 {
+		debug.Log("no files saved yet, skipping checkpoint")
+		return restic.ID{}, nil
+	}
+
+	// make sure the data for the files in the copy is in the index
+	err := arch.Repo.FlushPacks(ctx)
+	if err != nil {
+		return restic.ID{}, err
+	}
+
+	saved := restic.NewIDSet()
+	rootTreeID, err := arch.saveCheckpointTree(ctx, t, saved)
+	if err != nil {
+		return restic.ID{}, err
+	}
+
+	err = arch.Repo.Flush(ctx)
+	if err != nil {
+		return restic.ID{}, err
+	}
+
+	// a pack which was in use by another goroutine is not saved by Flush
+	idx := arch.Repo.Index()
+	for id := range saved {
+		if _, ok := idx.Lookup(id, restic.TreeBlob); !ok {
+			debug.Log("tree %v has not been saved yet, skipping checkpoint", id.Str())
+			return restic.ID{}, nil
+		}
+	}
+
+	tags := append([]string{}, opts.Tags...)
+	sn, err := restic.NewSnapshot(targets, append(tags, restic.PartialSnapshotTag), opts.Hostname, opts.Time)
+	if err != nil {
+		return restic.ID{}, err
+	}
+
+	sn.Excludes = opts.Excludes
+	if !opts.ParentSnapshot.IsNull() {
+		id := opts.ParentSnapshot
+		sn.Parent = &id
+	}
+	sn.Tree = &rootTreeID
+	sn.Partial = true
+
+	id, err := arch.Repo.SaveJSONUnpacked(ctx, restic.SnapshotFile, sn)
+	if err != nil {
+		return restic.ID{}, err
+	}
+
+	debug.Log("saved partial snapshot %v", id.Str())
+	return id, nil
+}
+
+// removeCheckpoint removes the partial snapshot with the id. This is done on a
+// best effort basis, e.g. a repository in append-only mode refuses to remove
+// snapshots.
+func (arch *Archiver) removeCheckpoint(ctx context.Context, id restic.ID) {
+	if id.IsNull() {
+		return
+	}
+
+	h := restic.Handle{Type: restic.SnapshotFile, Name: id.String()}
+	err := arch.Repo.Backend().Remove(ctx, h)
+	if err != nil {
+		debug.Log("unable to remove partial snapshot %v: %v", id.Str(), err)
+	}
+}
+
+// runCheckpoints saves a partial snapshot every opts.CheckpointInterval until
+// ctx is cancelled, each one replaces the previous one. The ID of the latest
+// partial snapshot is sent to last when runCheckpoints returns.
+func (arch *Archiver) runCheckpoints(ctx context.Context, c *checkpoint, targets []string, opts SnapshotOptions, last chan<- restic.ID) error {
+	var latest restic.ID
+	defer func() {
+		last <- latest
+	}()
+
+	ticker := time.NewTicker(opts.CheckpointInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		id, err := arch.saveCheckpoint(ctx, c, targets, opts)
+		if err != nil {
+			if ctx.Err() != nil {
+				// the backup is finished or has been interrupted
+				return nil
+			}
+			return err
+		}
+
+		if !id.IsNull() {
+			arch.removeCheckpoint(ctx, latest)
+			latest = id
+		}
+	}
+}
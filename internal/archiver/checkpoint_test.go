@@ -0,0 +1,212 @@
+package archiver
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/checker"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+	restictest "github.com/restic/restic/internal/test"
+	tomb "gopkg.in/tomb.v2"
+)
+
+func TestArchiverSaveCheckpoint(t *testing.T) {
+	src := TestDir{
+		"other": TestFile{Content: "another file"},
+		"subdir": TestDir{
+			"foo": TestFile{Content: "foo"},
+			"bar": TestFile{Content: "bar"},
+			"subsubdir": TestDir{
+				"baz": TestFile{Content: "baz"},
+			},
+		},
+	}
+
+	tempdir, repo, cleanup := prepareTempdirRepoSrc(t, src)
+	defer cleanup()
+
+	back := fs.TestChdir(t, tempdir)
+	defer back()
+
+	var tmb tomb.Tomb
+	ctx := tmb.Context(context.Background())
+
+	arch := New(repo, fs.Track{FS: fs.Local{}}, Options{})
+	arch.checkpoint = newCheckpoint()
+	arch.runWorkers(ctx, &tmb)
+
+	// save some of the files, the directory subdir is still incomplete
+	for _, item := range []string{"other", "subdir/foo", "subdir/subsubdir"} {
+		fn, excluded, err := arch.Save(ctx, "/"+item, item, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if excluded {
+			t.Fatalf("item %v was excluded", item)
+		}
+		fn.wait(ctx)
+	}
+
+	// a file for which not all data has been saved is left out
+	arch.checkpoint.complete("/subdir/missing", &restic.Node{
+		Type:    "file",
+		Content: restic.IDs{restic.NewRandomID()},
+	})
+
+	opts := SnapshotOptions{
+		Time: time.Now(),
+		Tags: []string{"foo"},
+	}
+	id, err := arch.saveCheckpoint(ctx, arch.checkpoint, []string{"other", "subdir"}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmb.Kill(nil)
+	if err := tmb.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	if id.IsNull() {
+		t.Fatal("no partial snapshot was saved")
+	}
+
+	TestEnsureSnapshot(t, repo, id, TestDir{
+		"other": TestFile{Content: "another file"},
+		"subdir": TestDir{
+			"foo": TestFile{Content: "foo"},
+			"subsubdir": TestDir{
+				"baz": TestFile{Content: "baz"},
+			},
+		},
+	})
+
+	sn, err := restic.LoadSnapshot(context.TODO(), repo, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !sn.Partial {
+		t.Errorf("snapshot is not marked as partial")
+	}
+	restictest.Equals(t, []string{"foo", restic.PartialSnapshotTag}, sn.Tags)
+
+	checker.TestCheckRepo(t, repo)
+}
+
+func TestArchiverSnapshotCheckpoints(t *testing.T) {
+	src := TestDir{
+		"subdir": TestDir{
+			"foo": TestFile{Content: string(restictest.Random(23, 2*1024*1024))},
+			"bar": TestFile{Content: "bar"},
+		},
+		"other": TestFile{Content: "another file"},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tempdir, repo, cleanup := prepareTempdirRepoSrc(t, src)
+	defer cleanup()
+
+	back := fs.TestChdir(t, tempdir)
+	defer back()
+
+	arch := New(repo, fs.Track{FS: fs.Local{}}, Options{})
+
+	opts := SnapshotOptions{
+		Time:               time.Now(),
+		CheckpointInterval: time.Millisecond,
+	}
+	_, id, err := arch.Snapshot(ctx, []string{"."}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	TestEnsureSnapshot(t, repo, id, src)
+
+	// all partial snapshots have been removed
+	snapshots, err := restic.LoadAllSnapshots(ctx, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restictest.Equals(t, 1, len(snapshots))
+	if snapshots[0].Partial {
+		t.Errorf("snapshot is marked as partial")
+	}
+}
+
+func TestArchiverPartialParent(t *testing.T) {
+	src := TestDir{
+		"foo": TestFile{Content: "foo"},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tempdir, repo, cleanup := prepareTempdirRepoSrc(t, src)
+	defer cleanup()
+
+	back := fs.TestChdir(t, tempdir)
+	defer back()
+
+	// create a parent snapshot which references data which is not in the repo
+	fi, err := os.Lstat("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	node, err := restic.NodeFromFileInfo("foo", fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.Content = restic.IDs{restic.NewRandomID()}
+
+	tree := restic.NewTree()
+	restictest.OK(t, tree.Insert(node))
+
+	treeID, err := repo.SaveTree(ctx, tree)
+	restictest.OK(t, err)
+	restictest.OK(t, repo.Flush(ctx))
+
+	parent, err := restic.NewSnapshot([]string{"foo"}, []string{restic.PartialSnapshotTag}, "", time.Now())
+	restictest.OK(t, err)
+	parent.Tree = &treeID
+	parent.Partial = true
+
+	parentID, err := repo.SaveJSONUnpacked(ctx, restic.SnapshotFile, parent)
+	restictest.OK(t, err)
+
+	testFS := &MockFS{
+		FS:        fs.Track{FS: fs.Local{}},
+		bytesRead: make(map[string]int),
+	}
+
+	arch := New(repo, testFS, Options{})
+
+	opts := SnapshotOptions{
+		Time:           time.Now(),
+		ParentSnapshot: parentID,
+	}
+	_, id, err := arch.Snapshot(ctx, []string{"foo"}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the file must have been read again
+	restictest.Equals(t, 3, testFS.bytesRead["foo"])
+
+	TestEnsureSnapshot(t, repo, id, src)
+
+	// the partial parent has been replaced by the new snapshot
+	snapshots, err := restic.LoadAllSnapshots(ctx, repo)
+	restictest.OK(t, err)
+	restictest.Equals(t, 1, len(snapshots))
+	if snapshots[0].Parent != nil {
+		t.Errorf("snapshot references the removed partial parent %v", snapshots[0].Parent.Str())
+	}
+}
@@ -43,6 +43,9 @@ type TreeSaver struct {
 	errFn    ErrorFunc
 
 	ch chan<- saveTreeJob
+
+	// CompleteTree is called for all dirs once they have been saved.
+	CompleteTree func(snPath string, node *restic.Node)
 }
 
 // NewTreeSaver returns a new tree saver. A worker pool with treeWorkers is
@@ -54,6 +57,8 @@ func NewTreeSaver(ctx context.Context, t *tomb.Tomb, treeWorkers uint, saveTree
 		ch:       ch,
 		saveTree: saveTree,
 		errFn:    errFn,
+
+		CompleteTree: func(string, *restic.Node) {},
 	}
 
 	for i := uint(0); i < treeWorkers; i++ {
@@ -156,6 +161,8 @@ func (s *TreeSaver) worker(ctx context.Context, jobs <-chan saveTreeJob) error {
 			return err
 		}
 
+		s.CompleteTree(job.snPath, node)
+
 		job.ch <- saveTreeResponse{
 			node:  node,
 			stats: stats,
@@ -2,6 +2,8 @@ package b2
 
 import (
 	"context"
+	"crypto/sha1"
+	"encoding/hex"
 	"io"
 	"net/http"
 	"path"
@@ -12,6 +14,7 @@ import (
 	"github.com/restic/restic/internal/restic"
 
 	"github.com/kurin/blazer/b2"
+	"github.com/minio/sha256-simd"
 )
 
 // b2Backend is a backend which stores its data on Backblaze B2.
@@ -29,6 +32,9 @@ const defaultListMaxItems = 1000
 // ensure statically that *b2Backend implements restic.Backend.
 var _ restic.Backend = &b2Backend{}
 
+// ensure statically that *b2Backend implements restic.Checksummer.
+var _ restic.Checksummer = &b2Backend{}
+
 func newClient(ctx context.Context, cfg Config, rt http.RoundTripper) (*b2.Client, error) {
 	opts := []b2.ClientOption{b2.Transport(rt)}
 
@@ -199,7 +205,22 @@ func (be *b2Backend) Save(ctx context.Context, h restic.Handle, rd restic.Rewind
 	debug.Log("Save %v, name %v", h, name)
 	obj := be.bucket.Object(name)
 
-	w := obj.NewWriter(ctx)
+	// the server verifies the SHA-1 hash, which makes it possible to
+	// return the SHA-256 hash stored with the file in Checksum. The SHA-1
+	// hash is not passed in the attributes, it would then be stored as
+	// "large_file_sha1" and returned instead of the hash verified by the
+	// server.
+	sha1sum, sha256sum, err := hashReader(rd)
+	if err != nil {
+		return err
+	}
+
+	w := obj.NewWriter(ctx).WithAttrs(&b2.Attrs{
+		Info: map[string]string{
+			infoSHA1:   sha1sum,
+			infoSHA256: sha256sum,
+		},
+	})
 	n, err := io.Copy(w, rd)
 	debug.Log("  saved %d bytes, err %v", n, err)
 
@@ -228,6 +249,73 @@ func (be *b2Backend) Stat(ctx context.Context, h restic.Handle) (bi restic.FileI
 	return restic.FileInfo{Size: info.Size, Name: h.Name}, nil
 }
 
+// keys for the hashes of a file stored in the file info
+const (
+	infoSHA1   = "restic-sha1"
+	infoSHA256 = "restic-sha256"
+
+	// infoLargeFileSHA1 is the SHA-1 hash of a large file supplied by the
+	// client, it is not verified by the server
+	infoLargeFileSHA1 = "large_file_sha1"
+)
+
+// hashReader returns the hex encoded SHA-1 and SHA-256 hashes of the data in
+// rd, which is rewound afterwards.
+func hashReader(rd restic.RewindReader) (sha1sum, sha256sum string, err error) {
+	err = rd.Rewind()
+	if err != nil {
+		return "", "", err
+	}
+
+	sha1hash, sha256hash := sha1.New(), sha256.New()
+	_, err = io.Copy(io.MultiWriter(sha1hash, sha256hash), rd)
+	if err != nil {
+		return "", "", errors.Wrap(err, "Copy")
+	}
+
+	return hex.EncodeToString(sha1hash.Sum(nil)), hex.EncodeToString(sha256hash.Sum(nil)), rd.Rewind()
+}
+
+// Checksum returns the SHA-256 hash of the file at h. It is stored with the
+// file together with the SHA-1 hash, which must match the SHA-1 hash the
+// server has verified. Files saved by older versions of restic have no hash.
+// Large files are uploaded in several parts, the server does not compute
+// their SHA-1 hash, so their checksum cannot be verified either.
+func (be *b2Backend) Checksum(ctx context.Context, h restic.Handle) (restic.ID, bool, error) {
+	debug.Log("Checksum %v", h)
+
+	be.sem.GetToken()
+	defer be.sem.ReleaseToken()
+
+	obj := be.bucket.Object(be.Filename(h))
+	info, err := obj.Attrs(ctx)
+	if err != nil {
+		debug.Log("Attrs() err %v", err)
+		return restic.ID{}, false, errors.Wrap(err, "Attrs")
+	}
+
+	sum, ok := info.Info[infoSHA256]
+	if !ok {
+		return restic.ID{}, false, nil
+	}
+
+	if _, ok := info.Info[infoLargeFileSHA1]; ok || info.SHA1 == "" || info.SHA1 == "none" {
+		debug.Log("SHA-1 hash of %v has not been verified by the server", h)
+		return restic.ID{}, false, nil
+	}
+
+	if info.SHA1 != info.Info[infoSHA1] {
+		return restic.ID{}, false, errors.Errorf("SHA-1 hash %v does not match stored hash %v", info.SHA1, info.Info[infoSHA1])
+	}
+
+	id, err := restic.ParseID(sum)
+	if err != nil {
+		return restic.ID{}, false, errors.Wrap(err, "ParseID")
+	}
+
+	return id, true, nil
+}
+
 // Test returns true if a blob of the given type and name exists in the backend.
 func (be *b2Backend) Test(ctx context.Context, h restic.Handle) (bool, error) {
 	debug.Log("Test %v", h)
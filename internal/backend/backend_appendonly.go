@@ -0,0 +1,43 @@
+package backend
+
+import (
+	"context"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// AppendOnlyBackend refuses to remove files from the backend, except for
+// locks. Files can still be added to the repository.
+type AppendOnlyBackend struct {
+	restic.Backend
+}
+
+// statically ensure that AppendOnlyBackend implements restic.Backend.
+var _ restic.Backend = &AppendOnlyBackend{}
+
+// NewAppendOnlyBackend wraps be with a backend that rejects removing files.
+func NewAppendOnlyBackend(be restic.Backend) *AppendOnlyBackend {
+	return &AppendOnlyBackend{Backend: be}
+}
+
+// Remove removes the lock file described by h, removing all other types of
+// files returns an error.
+func (be *AppendOnlyBackend) Remove(ctx context.Context, h restic.Handle) error {
+	if h.Type != restic.LockFile {
+		return errors.Errorf("removing %v is not allowed in append-only mode", h)
+	}
+
+	return be.Backend.Remove(ctx, h)
+}
+
+// Delete returns an error, the repository cannot be deleted in append-only
+// mode.
+func (be *AppendOnlyBackend) Delete(ctx context.Context) error {
+	return errors.New("deleting the repository is not allowed in append-only mode")
+}
+
+// Unwrap returns the wrapped backend.
+func (be *AppendOnlyBackend) Unwrap() restic.Backend {
+	return be.Backend
+}
@@ -0,0 +1,34 @@
+package backend_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestAppendOnlyBackend(t *testing.T) {
+	be := backend.NewAppendOnlyBackend(mem.New())
+
+	for _, tpe := range []restic.FileType{restic.DataFile, restic.KeyFile, restic.LockFile, restic.SnapshotFile, restic.IndexFile} {
+		data := []byte(tpe)
+		h := restic.Handle{Type: tpe, Name: restic.Hash(data).String()}
+		rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader(data)))
+
+		err := be.Remove(context.TODO(), h)
+		if tpe == restic.LockFile {
+			rtest.OK(t, err)
+		} else {
+			rtest.Assert(t, err != nil, "expected error removing %v in append-only mode", h)
+		}
+
+		found, err := be.Test(context.TODO(), h)
+		rtest.OK(t, err)
+		rtest.Equals(t, tpe != restic.LockFile, found)
+	}
+
+	rtest.Assert(t, be.Delete(context.TODO()) != nil, "expected error deleting the repository in append-only mode")
+}
@@ -146,3 +146,8 @@ func (be *RetryBackend) List(ctx context.Context, t restic.FileType, fn func(res
 
 	return err
 }
+
+// Unwrap returns the wrapped backend.
+func (be *RetryBackend) Unwrap() restic.Backend {
+	return be.Backend
+}
@@ -0,0 +1,73 @@
+package ftp
+
+import (
+	"net"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/options"
+)
+
+// Config collects all information required to connect to an FTP server.
+type Config struct {
+	TLS            bool
+	User, Password string
+	Host, Path     string
+
+	Layout      string `option:"layout" help:"use this backend directory layout (default: auto-detect)"`
+	Connections uint   `option:"connections" help:"set a limit for the number of concurrent connections (default: 5)"`
+	ImplicitTLS bool   `option:"implicit-tls" help:"use implicit instead of explicit TLS for ftps (usually on port 990)"`
+}
+
+func init() {
+	options.Register("ftp", Config{})
+}
+
+// NewConfig returns a new Config with the default values filled in.
+func NewConfig() Config {
+	return Config{
+		Connections: 5,
+	}
+}
+
+// ParseConfig parses the string s and extracts the ftp config. The supported
+// formats are ftp://[user[:password]@]host[:port]/directory and the same with
+// ftps:// for FTP secured with TLS. The directory is interpreted relative to
+// the login directory of the user, it can be an absolute path if it starts
+// with a slash (e.g. ftp://host//absolute).
+func ParseConfig(s string) (interface{}, error) {
+	if !strings.HasPrefix(s, "ftp://") && !strings.HasPrefix(s, "ftps://") {
+		return nil, errors.New(`invalid format, does not start with "ftp://" or "ftps://"`)
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, errors.Wrap(err, "url.Parse")
+	}
+
+	if u.Hostname() == "" {
+		return nil, errors.Errorf("invalid backend %q, no host specified", s)
+	}
+
+	if len(u.Path) < 2 {
+		return nil, errors.Errorf("invalid backend %q, no directory specified", s)
+	}
+
+	cfg := NewConfig()
+	cfg.TLS = u.Scheme == "ftps"
+	if u.User != nil {
+		cfg.User = u.User.Username()
+		cfg.Password, _ = u.User.Password()
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = "21"
+	}
+	cfg.Host = net.JoinHostPort(u.Hostname(), port)
+	cfg.Path = path.Clean(u.Path[1:])
+
+	return cfg, nil
+}
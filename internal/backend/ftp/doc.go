@@ -0,0 +1,3 @@
+// Package ftp implements repository storage in a directory on an FTP server,
+// optionally secured with TLS (FTPS).
+package ftp
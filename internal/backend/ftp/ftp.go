@@ -0,0 +1,620 @@
+package ftp
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	stderrors "errors"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+
+	"github.com/jlaffaye/ftp"
+)
+
+// Backend stores data in a directory on an FTP server. An FTP connection can
+// only run one command at a time, so the backend keeps a pool of connections.
+type Backend struct {
+	cfg       Config
+	tlsConfig *tls.Config
+
+	sem   *backend.Semaphore
+	conns chan *conn
+
+	backend.Layout
+}
+
+// make sure that *Backend implements restic.Backend and backend.Filesystem
+var _ restic.Backend = &Backend{}
+var _ backend.Filesystem = &Backend{}
+
+const defaultLayout = "default"
+
+// dialTimeout limits the time for establishing a new connection.
+const dialTimeout = 30 * time.Second
+
+// idleCheck is the time after which an idle connection is tested before it
+// is used again, the server may have closed it in the meantime.
+const idleCheck = 20 * time.Second
+
+// conn is a connection to the server which is currently not in use.
+type conn struct {
+	*ftp.ServerConn
+	idleSince time.Time
+}
+
+func open(cfg Config, tlsConfig *tls.Config) (*Backend, error) {
+	sem, err := backend.NewSemaphore(cfg.Connections)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.TLS {
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		}
+
+		host, _, err := net.SplitHostPort(cfg.Host)
+		if err != nil {
+			return nil, errors.Wrap(err, "SplitHostPort")
+		}
+
+		tlsConfig = tlsConfig.Clone()
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = host
+		}
+
+		// many servers require that data connections resume the TLS session
+		// of the control connection
+		tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	}
+
+	be := &Backend{
+		cfg:       cfg,
+		tlsConfig: tlsConfig,
+		sem:       sem,
+		conns:     make(chan *conn, cfg.Connections),
+	}
+
+	be.Layout, err = backend.ParseLayout(be, cfg.Layout, defaultLayout, cfg.Path)
+	if err != nil {
+		_ = be.Close()
+		return nil, err
+	}
+
+	debug.Log("layout: %v", be.Layout)
+	return be, nil
+}
+
+// Open opens the ftp backend as described by the config. For FTPS, tlsConfig
+// is used to verify the server, it may be nil.
+func Open(cfg Config, tlsConfig *tls.Config) (*Backend, error) {
+	debug.Log("open backend at %v/%v", cfg.Host, cfg.Path)
+	return open(cfg, tlsConfig)
+}
+
+// Create creates the directories for a new repository on the ftp server
+// described by the config.
+func Create(cfg Config, tlsConfig *tls.Config) (*Backend, error) {
+	debug.Log("create backend at %v/%v", cfg.Host, cfg.Path)
+	be, err := open(cfg, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	// test if config file already exists
+	_, err = be.Stat(context.TODO(), restic.Handle{Type: restic.ConfigFile})
+	if err == nil {
+		_ = be.Close()
+		return nil, errors.Fatal("config file already exists")
+	}
+
+	// create paths for data and refs
+	err = be.withConn(context.TODO(), func(c *ftp.ServerConn) error {
+		for _, dir := range be.Paths() {
+			if err := mkdirAll(c, dir); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = be.Close()
+		return nil, err
+	}
+
+	return be, nil
+}
+
+// dial establishes a new connection to the server and logs in.
+func (be *Backend) dial() (*ftp.ServerConn, error) {
+	debug.Log("connect to %v", be.cfg.Host)
+	opts := []ftp.DialOption{ftp.DialWithTimeout(dialTimeout)}
+	if be.cfg.TLS {
+		if be.cfg.ImplicitTLS {
+			opts = append(opts, ftp.DialWithTLS(be.tlsConfig))
+		} else {
+			opts = append(opts, ftp.DialWithExplicitTLS(be.tlsConfig))
+		}
+	}
+
+	c, err := ftp.Dial(be.cfg.Host, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "Dial")
+	}
+
+	user, password := be.cfg.User, be.cfg.Password
+	if user == "" {
+		user, password = "anonymous", "anonymous"
+	}
+
+	if err = c.Login(user, password); err != nil {
+		_ = c.Quit()
+		return nil, errors.Wrap(err, "Login")
+	}
+
+	return c, nil
+}
+
+// getConn returns a connection to the server, either from the pool or a new
+// one. At most cfg.Connections connections are in use at the same time, the
+// connection must be returned with putConn.
+func (be *Backend) getConn(ctx context.Context) (*ftp.ServerConn, error) {
+	be.sem.GetToken()
+	if err := ctx.Err(); err != nil {
+		be.sem.ReleaseToken()
+		return nil, err
+	}
+
+	for {
+		var c *conn
+		select {
+		case c = <-be.conns:
+		default:
+		}
+
+		if c == nil {
+			sc, err := be.dial()
+			if err != nil {
+				be.sem.ReleaseToken()
+				return nil, err
+			}
+			return sc, nil
+		}
+
+		if time.Since(c.idleSince) < idleCheck {
+			return c.ServerConn, nil
+		}
+
+		if err := c.NoOp(); err == nil {
+			return c.ServerConn, nil
+		}
+
+		debug.Log("discarding stale connection")
+		_ = c.Quit()
+	}
+}
+
+// putConn returns the connection c to the pool. When err is set and was not
+// caused by the server rejecting a command, the connection may be broken and
+// is closed instead.
+func (be *Backend) putConn(c *ftp.ServerConn, err error) {
+	defer be.sem.ReleaseToken()
+
+	if err != nil && statusCode(err) == 0 {
+		debug.Log("closing connection after error %v", err)
+		_ = c.Quit()
+		return
+	}
+
+	select {
+	case be.conns <- &conn{ServerConn: c, idleSince: time.Now()}:
+	default:
+		_ = c.Quit()
+	}
+}
+
+// withConn runs fn with a connection from the pool.
+func (be *Backend) withConn(ctx context.Context, fn func(c *ftp.ServerConn) error) error {
+	c, err := be.getConn(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = fn(c)
+	be.putConn(c, err)
+	return err
+}
+
+// statusCode returns the status code of the server response which caused err,
+// or zero if err was not caused by the server.
+func statusCode(err error) int {
+	var e *textproto.Error
+	if stderrors.As(errors.Cause(err), &e) {
+		return e.Code
+	}
+	return 0
+}
+
+// IsNotExist returns true if the error is caused by a not existing file.
+func (be *Backend) IsNotExist(err error) bool {
+	return statusCode(err) == ftp.StatusFileUnavailable
+}
+
+// Location returns this backend's location.
+func (be *Backend) Location() string {
+	scheme := "ftp"
+	if be.cfg.TLS {
+		scheme = "ftps"
+	}
+	return scheme + "://" + be.cfg.Host + "/" + be.cfg.Path
+}
+
+// Join combines path components with slashes.
+func (be *Backend) Join(p ...string) string {
+	return path.Join(p...)
+}
+
+// fileInfo describes a file or directory on the server.
+type fileInfo struct {
+	*ftp.Entry
+}
+
+func (fi fileInfo) Name() string       { return fi.Entry.Name }
+func (fi fileInfo) Size() int64        { return int64(fi.Entry.Size) }
+func (fi fileInfo) ModTime() time.Time { return fi.Entry.Time }
+func (fi fileInfo) IsDir() bool        { return fi.Entry.Type == ftp.EntryTypeFolder }
+func (fi fileInfo) Sys() interface{}   { return nil }
+
+func (fi fileInfo) Mode() os.FileMode {
+	if fi.IsDir() {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+// readDir returns the entries of the directory dir, without "." and "..".
+func readDir(c *ftp.ServerConn, dir string) ([]*ftp.Entry, error) {
+	entries, err := c.List(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "List(%v)", dir)
+	}
+
+	list := entries[:0]
+	for _, e := range entries {
+		if e.Name == "." || e.Name == ".." {
+			continue
+		}
+		// some servers return the full path
+		e.Name = path.Base(e.Name)
+		list = append(list, e)
+	}
+
+	return list, nil
+}
+
+// ReadDir returns the entries for a directory.
+func (be *Backend) ReadDir(dir string) (list []os.FileInfo, err error) {
+	err = be.withConn(context.TODO(), func(c *ftp.ServerConn) error {
+		entries, err := readDir(c, dir)
+		if err != nil {
+			return err
+		}
+
+		for _, e := range entries {
+			list = append(list, fileInfo{e})
+		}
+		return nil
+	})
+
+	return list, err
+}
+
+// dirExists returns true if the directory dir exists on the server.
+func dirExists(c *ftp.ServerConn, dir string) bool {
+	_, err := c.List(dir)
+	return err == nil
+}
+
+// mkdirAll creates the directory dir, including all missing parents.
+func mkdirAll(c *ftp.ServerConn, dir string) error {
+	err := c.MakeDir(dir)
+	if err == nil || dirExists(c, dir) {
+		return nil
+	}
+
+	parent := path.Dir(dir)
+	if parent == dir || parent == "." || parent == "/" {
+		return errors.Wrapf(err, "MakeDir(%v)", dir)
+	}
+
+	if err := mkdirAll(c, parent); err != nil {
+		return err
+	}
+
+	return errors.Wrapf(c.MakeDir(dir), "MakeDir(%v)", dir)
+}
+
+// tempSuffix is appended to the names of files which are still uploaded.
+const tempSuffix = ".tmp"
+
+func tempName(filename string) (string, error) {
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(rand.Reader, buf); err != nil {
+		return "", errors.Wrap(err, "rand.Read")
+	}
+	return filename + "-" + hex.EncodeToString(buf) + tempSuffix, nil
+}
+
+// Save stores data in the backend at the handle. The data is uploaded to a
+// temporary file first, which is then renamed, so that other clients never
+// see incomplete files.
+func (be *Backend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	debug.Log("Save %v", h)
+	if err := h.Valid(); err != nil {
+		return err
+	}
+
+	filename := be.Filename(h)
+	tmpname, err := tempName(filename)
+	if err != nil {
+		return err
+	}
+
+	return be.withConn(ctx, func(c *ftp.ServerConn) error {
+		err := c.Stor(tmpname, rd)
+		if be.IsNotExist(err) {
+			// error is caused by a missing directory, try to create it
+			mkdirErr := mkdirAll(c, be.Dirname(h))
+			if mkdirErr != nil {
+				debug.Log("error creating dir %v: %v", be.Dirname(h), mkdirErr)
+			} else if err = rd.Rewind(); err == nil {
+				// try again
+				err = c.Stor(tmpname, rd)
+			}
+		}
+
+		if err == nil {
+			err = c.Rename(tmpname, filename)
+		}
+
+		if err != nil {
+			if statusCode(err) != 0 {
+				_ = c.Delete(tmpname)
+			}
+			return errors.Wrap(err, "Stor")
+		}
+
+		return nil
+	})
+}
+
+// Load runs fn with a reader that yields the contents of the file at h at the
+// given offset.
+func (be *Backend) Load(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+	return backend.DefaultLoad(ctx, h, length, offset, be.openReader, fn)
+}
+
+// response is the reader for a file transfer, when it is closed the
+// connection is returned to the pool.
+type response struct {
+	io.Reader
+	resp    *ftp.Response
+	be      *Backend
+	c       *ftp.ServerConn
+	limited bool
+}
+
+func (r *response) Close() error {
+	err := r.resp.Close()
+	r.be.putConn(r.c, err)
+
+	// the server may report an error when the transfer was aborted because
+	// only a part of the file was requested, the data read is complete anyway
+	if r.limited {
+		return nil
+	}
+	return errors.Wrap(err, "Close")
+}
+
+func (be *Backend) openReader(ctx context.Context, h restic.Handle, length int, offset int64) (io.ReadCloser, error) {
+	debug.Log("Load %v, length %v, offset %v", h, length, offset)
+	if err := h.Valid(); err != nil {
+		return nil, err
+	}
+
+	if offset < 0 {
+		return nil, errors.New("offset is negative")
+	}
+
+	if length < 0 {
+		return nil, errors.Errorf("invalid length %d", length)
+	}
+
+	c, err := be.getConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.RetrFrom(be.Filename(h), uint64(offset))
+	if err != nil {
+		be.putConn(c, err)
+		return nil, errors.Wrap(err, "Retr")
+	}
+
+	r := &response{Reader: resp, resp: resp, be: be, c: c}
+	if length > 0 {
+		r.Reader = io.LimitReader(resp, int64(length))
+		r.limited = true
+	}
+
+	return r, nil
+}
+
+// Stat returns information about a blob.
+func (be *Backend) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+	debug.Log("Stat(%v)", h)
+	if err := h.Valid(); err != nil {
+		return restic.FileInfo{}, err
+	}
+
+	var size int64
+	err := be.withConn(ctx, func(c *ftp.ServerConn) (err error) {
+		size, err = c.FileSize(be.Filename(h))
+		return err
+	})
+	if err != nil {
+		return restic.FileInfo{}, errors.Wrap(err, "FileSize")
+	}
+
+	return restic.FileInfo{Size: size, Name: h.Name}, nil
+}
+
+// Test returns true if a blob of the given type and name exists in the backend.
+func (be *Backend) Test(ctx context.Context, h restic.Handle) (bool, error) {
+	debug.Log("Test(%v)", h)
+	_, err := be.Stat(ctx, h)
+	if be.IsNotExist(err) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Remove removes the content stored at name.
+func (be *Backend) Remove(ctx context.Context, h restic.Handle) error {
+	debug.Log("Remove(%v)", h)
+	if err := h.Valid(); err != nil {
+		return err
+	}
+
+	return be.withConn(ctx, func(c *ftp.ServerConn) error {
+		return errors.Wrap(c.Delete(be.Filename(h)), "Delete")
+	})
+}
+
+// List runs fn for each file in the backend which has the type t. When an
+// error occurs (or fn returns an error), List stops and returns it.
+func (be *Backend) List(ctx context.Context, t restic.FileType, fn func(restic.FileInfo) error) error {
+	debug.Log("List %v", t)
+
+	// the connection is not held while fn runs, so fn may use the backend
+	list := func(dir string) (entries []*ftp.Entry, err error) {
+		err = be.withConn(ctx, func(c *ftp.ServerConn) error {
+			entries, err = readDir(c, dir)
+			return err
+		})
+		return entries, err
+	}
+
+	send := func(e *ftp.Entry) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		err := fn(restic.FileInfo{Name: e.Name, Size: int64(e.Size)})
+		if err != nil {
+			return err
+		}
+
+		return ctx.Err()
+	}
+
+	basedir, subdirs := be.Basedir(t)
+	entries, err := list(basedir)
+	if be.IsNotExist(err) {
+		debug.Log("ignoring non-existing directory")
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if e.Type == ftp.EntryTypeFile && !strings.HasSuffix(e.Name, tempSuffix) {
+			if err := send(e); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if e.Type != ftp.EntryTypeFolder || !subdirs {
+			continue
+		}
+
+		subEntries, err := list(be.Join(basedir, e.Name))
+		if err != nil {
+			return err
+		}
+
+		for _, e := range subEntries {
+			if e.Type != ftp.EntryTypeFile || strings.HasSuffix(e.Name, tempSuffix) {
+				continue
+			}
+
+			if err := send(e); err != nil {
+				return err
+			}
+		}
+	}
+
+	return ctx.Err()
+}
+
+// Close closes all connections to the server.
+func (be *Backend) Close() error {
+	debug.Log("Close")
+	for {
+		select {
+		case c := <-be.conns:
+			_ = c.Quit()
+		default:
+			return nil
+		}
+	}
+}
+
+func deleteRecursive(c *ftp.ServerConn, dir string) error {
+	entries, err := readDir(c, dir)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		name := path.Join(dir, e.Name)
+		if e.Type == ftp.EntryTypeFolder {
+			if err := deleteRecursive(c, name); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := c.Delete(name); err != nil {
+			return errors.Wrapf(err, "Delete(%v)", name)
+		}
+	}
+
+	return errors.Wrapf(c.RemoveDir(dir), "RemoveDir(%v)", dir)
+}
+
+// Delete removes all data in the backend.
+func (be *Backend) Delete(ctx context.Context) error {
+	return be.withConn(ctx, func(c *ftp.ServerConn) error {
+		return deleteRecursive(c, be.cfg.Path)
+	})
+}
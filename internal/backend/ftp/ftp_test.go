@@ -0,0 +1,171 @@
+package ftp_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/backend/ftp"
+	"github.com/restic/restic/internal/backend/test"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func newTestSuite(t testing.TB, cfg ftp.Config, tlsConfig *tls.Config, minimalData bool) *test.Suite {
+	return &test.Suite{
+		MinimalData: minimalData,
+
+		// NewConfig returns a config for a new temporary backend that will be used in tests.
+		NewConfig: func() (interface{}, error) {
+			return cfg, nil
+		},
+
+		// CreateFn is a function that creates a temporary repository for the tests.
+		Create: func(config interface{}) (restic.Backend, error) {
+			cfg := config.(ftp.Config)
+			return ftp.Create(cfg, tlsConfig)
+		},
+
+		// OpenFn is a function that opens a previously created temporary repository.
+		Open: func(config interface{}) (restic.Backend, error) {
+			cfg := config.(ftp.Config)
+			return ftp.Open(cfg, tlsConfig)
+		},
+
+		// CleanupFn removes data created during the tests.
+		Cleanup: func(config interface{}) error {
+			return nil
+		},
+	}
+}
+
+// newCertificate returns a self-signed certificate for 127.0.0.1.
+func newCertificate(t testing.TB) (tls.Certificate, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rtest.OK(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "restic test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	rtest.OK(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	rtest.OK(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, cert
+}
+
+func TestBackendFTP(t *testing.T) {
+	dir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	srv, cleanup := runFTPServer(t, dir, "user", "secret", nil)
+	defer cleanup()
+
+	cfg := ftp.NewConfig()
+	cfg.Host = srv.Addr()
+	cfg.User = "user"
+	cfg.Password = "secret"
+	cfg.Path = "restic/test"
+
+	newTestSuite(t, cfg, nil, false).RunTests(t)
+}
+
+func TestBackendFTPS(t *testing.T) {
+	dir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	cert, x509Cert := newCertificate(t)
+	srv, cleanup := runFTPServer(t, dir, "user", "secret", &tls.Config{Certificates: []tls.Certificate{cert}})
+	defer cleanup()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(x509Cert)
+
+	cfg := ftp.NewConfig()
+	cfg.TLS = true
+	cfg.Host = srv.Addr()
+	cfg.User = "user"
+	cfg.Password = "secret"
+	cfg.Path = "/restic"
+
+	newTestSuite(t, cfg, &tls.Config{RootCAs: pool}, true).RunTests(t)
+
+	// the server certificate is verified
+	_, err := ftp.Open(cfg, nil)
+	if err == nil {
+		t.Fatal("expected error for untrusted server certificate")
+	}
+}
+
+func TestBackendFTPConnections(t *testing.T) {
+	dir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	srv, cleanup := runFTPServer(t, dir, "user", "secret", nil)
+	defer cleanup()
+
+	// a single connection must be sufficient
+	cfg := ftp.NewConfig()
+	cfg.Host = srv.Addr()
+	cfg.User = "user"
+	cfg.Password = "secret"
+	cfg.Path = "repo"
+	cfg.Connections = 1
+
+	newTestSuite(t, cfg, nil, true).RunTests(t)
+
+	_, err := os.Stat(filepath.Join(dir, "repo"))
+	if !os.IsNotExist(err) {
+		t.Fatalf("repository was not deleted: %v", err)
+	}
+}
+
+func TestBackendFTPExternalServer(t *testing.T) {
+	repostr := os.Getenv("RESTIC_TEST_FTP_REPOSITORY")
+	if repostr == "" {
+		t.Skipf("environment variable %v not set", "RESTIC_TEST_FTP_REPOSITORY")
+	}
+
+	cfg, err := ftp.ParseConfig(repostr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newTestSuite(t, cfg.(ftp.Config), nil, true).RunTests(t)
+}
+
+func BenchmarkBackendFTP(t *testing.B) {
+	dir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	srv, cleanup := runFTPServer(t, dir, "user", "secret", nil)
+	defer cleanup()
+
+	cfg := ftp.NewConfig()
+	cfg.Host = srv.Addr()
+	cfg.User = "user"
+	cfg.Password = "secret"
+	cfg.Path = "restic/test"
+
+	newTestSuite(t, cfg, nil, false).RunBenchmarks(t)
+}
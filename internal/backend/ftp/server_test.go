@@ -0,0 +1,442 @@
+package ftp_test
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testServer is a minimal FTP server which serves a local directory. It
+// implements just enough of the protocol for the backend tests, including
+// explicit TLS when tlsConfig is set.
+type testServer struct {
+	root           string
+	user, password string
+	tlsConfig      *tls.Config
+
+	ln    net.Listener
+	wg    sync.WaitGroup
+	m     sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+func runFTPServer(t testing.TB, root, user, password string, tlsConfig *tls.Config) (*testServer, func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &testServer{
+		root:      root,
+		user:      user,
+		password:  password,
+		tlsConfig: tlsConfig,
+		ln:        ln,
+		conns:     make(map[net.Conn]struct{}),
+	}
+
+	srv.wg.Add(1)
+	go func() {
+		defer srv.wg.Done()
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			srv.m.Lock()
+			srv.conns[c] = struct{}{}
+			srv.m.Unlock()
+
+			srv.wg.Add(1)
+			go func() {
+				defer func() {
+					srv.m.Lock()
+					delete(srv.conns, c)
+					srv.m.Unlock()
+					srv.wg.Done()
+				}()
+
+				s := &session{srv: srv}
+				s.setConn(c)
+				s.serve()
+			}()
+		}
+	}()
+
+	return srv, func() {
+		_ = ln.Close()
+
+		// close the connections of clients which are still connected
+		srv.m.Lock()
+		for c := range srv.conns {
+			_ = c.Close()
+		}
+		srv.m.Unlock()
+
+		srv.wg.Wait()
+	}
+}
+
+// Addr returns the address the server listens on.
+func (srv *testServer) Addr() string {
+	return srv.ln.Addr().String()
+}
+
+// session is the state of a single control connection.
+type session struct {
+	srv  *testServer
+	conn net.Conn
+	rd   *bufio.Reader
+
+	loggedIn   bool
+	protect    bool
+	offset     int64
+	renameFrom string
+	data       chan net.Conn
+	dataLn     net.Listener
+}
+
+func (s *session) setConn(c net.Conn) {
+	s.conn = c
+	s.rd = bufio.NewReader(c)
+}
+
+func (s *session) reply(code int, msg string) {
+	// set a deadline so that a vanished client does not block the server
+	_ = s.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, _ = fmt.Fprintf(s.conn, "%d %s\r\n", code, msg)
+}
+
+// localPath returns the path in the served directory for name.
+func (s *session) localPath(name string) string {
+	return filepath.Join(s.srv.root, filepath.FromSlash(path.Clean("/"+name)))
+}
+
+func (s *session) serve() {
+	defer func() {
+		s.closeData()
+		_ = s.conn.Close()
+	}()
+
+	s.reply(220, "test server ready")
+
+	for {
+		line, err := s.rd.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		cmd, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			cmd, arg = line[:i], line[i+1:]
+		}
+		cmd = strings.ToUpper(cmd)
+
+		if !s.loggedIn && cmd != "USER" && cmd != "PASS" && cmd != "AUTH" && cmd != "QUIT" {
+			s.reply(530, "not logged in")
+			continue
+		}
+
+		if !s.handle(cmd, arg) {
+			return
+		}
+	}
+}
+
+// handle runs a single command, it returns false when the connection is to
+// be closed.
+func (s *session) handle(cmd, arg string) bool {
+	switch cmd {
+	case "AUTH":
+		if s.srv.tlsConfig == nil || strings.ToUpper(arg) != "TLS" {
+			s.reply(502, "TLS not supported")
+			return true
+		}
+		s.reply(234, "start TLS")
+		s.setConn(tls.Server(s.conn, s.srv.tlsConfig))
+	case "USER":
+		if s.srv.tlsConfig != nil {
+			if _, ok := s.conn.(*tls.Conn); !ok {
+				s.reply(530, "TLS required")
+				return true
+			}
+		}
+		if arg != s.srv.user {
+			s.reply(530, "unknown user")
+			return true
+		}
+		s.reply(331, "password required")
+	case "PASS":
+		if arg != s.srv.password {
+			s.reply(530, "wrong password")
+			return true
+		}
+		s.loggedIn = true
+		s.reply(230, "logged in")
+	case "FEAT":
+		_, _ = fmt.Fprintf(s.conn, "211-Features:\r\n MLST type*;size*;\r\n EPSV\r\n UTF8\r\n211 End\r\n")
+	case "TYPE", "OPTS", "NOOP", "PBSZ":
+		s.reply(200, "ok")
+	case "PROT":
+		s.protect = strings.ToUpper(arg) == "P"
+		s.reply(200, "ok")
+	case "PWD":
+		s.reply(257, `"/"`)
+	case "EPSV":
+		port, err := s.listenData()
+		if err != nil {
+			s.reply(425, err.Error())
+			return true
+		}
+		s.reply(229, fmt.Sprintf("Entering Extended Passive Mode (|||%d|)", port))
+	case "REST":
+		offset, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			s.reply(501, err.Error())
+			return true
+		}
+		s.offset = offset
+		s.reply(350, "restarting")
+	case "RETR":
+		s.retr(arg)
+	case "STOR":
+		s.stor(arg)
+	case "MLSD":
+		s.mlsd(arg)
+	case "SIZE":
+		fi, err := os.Stat(s.localPath(arg))
+		if err != nil || !fi.Mode().IsRegular() {
+			s.reply(550, "no such file")
+			return true
+		}
+		s.reply(213, strconv.FormatInt(fi.Size(), 10))
+	case "DELE":
+		fi, err := os.Stat(s.localPath(arg))
+		if err == nil && fi.IsDir() {
+			s.reply(550, "is a directory")
+			return true
+		}
+		s.result(250, os.Remove(s.localPath(arg)))
+	case "MKD":
+		s.result(257, os.Mkdir(s.localPath(arg), 0700))
+	case "RMD":
+		s.result(250, os.Remove(s.localPath(arg)))
+	case "RNFR":
+		if _, err := os.Stat(s.localPath(arg)); err != nil {
+			s.reply(550, "no such file")
+			return true
+		}
+		s.renameFrom = arg
+		s.reply(350, "ready for RNTO")
+	case "RNTO":
+		if s.renameFrom == "" {
+			s.reply(503, "RNFR required")
+			return true
+		}
+		s.result(250, os.Rename(s.localPath(s.renameFrom), s.localPath(arg)))
+		s.renameFrom = ""
+	case "QUIT":
+		s.reply(221, "bye")
+		return false
+	default:
+		s.reply(502, "command not implemented")
+	}
+
+	return true
+}
+
+func (s *session) result(code int, err error) {
+	if err != nil {
+		s.reply(550, err.Error())
+		return
+	}
+	s.reply(code, "ok")
+}
+
+// listenData opens a listener for a data connection. The connection is
+// accepted (and the TLS handshake run) in the background, the client only
+// sends the command after it has established the data connection.
+func (s *session) listenData() (int, error) {
+	s.closeData()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+
+	s.dataLn = ln
+	s.data = make(chan net.Conn, 1)
+	protect := s.protect
+
+	go func(data chan<- net.Conn) {
+		defer close(data)
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+
+		if protect {
+			tc := tls.Server(c, s.srv.tlsConfig)
+			if err := tc.Handshake(); err != nil {
+				_ = c.Close()
+				return
+			}
+			c = tc
+		}
+
+		data <- c
+	}(s.data)
+
+	return ln.Addr().(*net.TCPAddr).Port, nil
+}
+
+// dataConn returns the data connection for the current command.
+func (s *session) dataConn() net.Conn {
+	if s.data == nil {
+		return nil
+	}
+
+	var c net.Conn
+	select {
+	case c = <-s.data:
+	case <-time.After(10 * time.Second):
+	}
+
+	s.closeData()
+	return c
+}
+
+// closeData closes the listener for the data connection, a connection which
+// was not used is closed.
+func (s *session) closeData() {
+	if s.dataLn == nil {
+		return
+	}
+
+	_ = s.dataLn.Close()
+	for c := range s.data {
+		_ = c.Close()
+	}
+	s.dataLn = nil
+	s.data = nil
+}
+
+func (s *session) retr(name string) {
+	offset := s.offset
+	s.offset = 0
+
+	f, err := os.Open(s.localPath(name))
+	if err != nil {
+		s.closeData()
+		s.reply(550, "no such file")
+		return
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		s.closeData()
+		s.reply(550, err.Error())
+		return
+	}
+
+	s.reply(150, "sending file")
+	c := s.dataConn()
+	if c == nil {
+		s.reply(425, "no data connection")
+		return
+	}
+
+	_, err = io.Copy(c, f)
+	cerr := c.Close()
+	if err != nil || cerr != nil {
+		s.reply(426, "transfer aborted")
+		return
+	}
+	s.reply(226, "transfer complete")
+}
+
+func (s *session) stor(name string) {
+	s.offset = 0
+
+	f, err := os.OpenFile(s.localPath(name), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		s.closeData()
+		s.reply(550, "cannot create file")
+		return
+	}
+
+	s.reply(150, "receiving file")
+	c := s.dataConn()
+	if c == nil {
+		_ = f.Close()
+		s.reply(425, "no data connection")
+		return
+	}
+
+	_, err = io.Copy(f, c)
+	_ = c.Close()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		s.reply(451, err.Error())
+		return
+	}
+	s.reply(226, "transfer complete")
+}
+
+func (s *session) mlsd(name string) {
+	dir, err := os.Open(s.localPath(name))
+	if err != nil {
+		s.closeData()
+		s.reply(550, "no such directory")
+		return
+	}
+
+	entries, err := dir.Readdir(-1)
+	_ = dir.Close()
+	if err != nil {
+		s.closeData()
+		s.reply(550, "not a directory")
+		return
+	}
+
+	s.reply(150, "sending listing")
+	c := s.dataConn()
+	if c == nil {
+		s.reply(425, "no data connection")
+		return
+	}
+
+	wr := bufio.NewWriter(c)
+	for _, fi := range entries {
+		tpe := "file"
+		if fi.IsDir() {
+			tpe = "dir"
+		}
+		_, _ = fmt.Fprintf(wr, "type=%s;size=%d; %s\r\n", tpe, fi.Size(), fi.Name())
+	}
+
+	err = wr.Flush()
+	cerr := c.Close()
+	if err != nil || cerr != nil {
+		s.reply(426, "transfer aborted")
+		return
+	}
+	s.reply(226, "transfer complete")
+}
@@ -58,25 +58,10 @@ func readPEMCertKey(filename string) (certs []byte, key []byte, err error) {
 	return certs, key, nil
 }
 
-// Transport returns a new http.RoundTripper with default settings applied. If
-// a custom rootCertFilename is non-empty, it must point to a valid PEM file,
-// otherwise the function will return an error.
-func Transport(opts TransportOptions) (http.RoundTripper, error) {
-	// copied from net/http
-	tr := &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
-		DialContext: (&net.Dialer{
-			Timeout:   30 * time.Second,
-			KeepAlive: 30 * time.Second,
-			DualStack: true,
-		}).DialContext,
-		MaxIdleConns:          100,
-		MaxIdleConnsPerHost:   100,
-		IdleConnTimeout:       90 * time.Second,
-		TLSHandshakeTimeout:   10 * time.Second,
-		ExpectContinueTimeout: 1 * time.Second,
-		TLSClientConfig:       &tls.Config{},
-	}
+// TLSConfig returns a TLS configuration with the root certificates and the
+// client certificate from opts applied.
+func TLSConfig(opts TransportOptions) (*tls.Config, error) {
+	cfg := &tls.Config{}
 
 	if opts.TLSClientCertKeyFilename != "" {
 		certs, key, err := readPEMCertKey(opts.TLSClientCertKeyFilename)
@@ -88,7 +73,7 @@ func Transport(opts TransportOptions) (http.RoundTripper, error) {
 		if err != nil {
 			return nil, errors.Errorf("parse TLS client cert or key: %v", err)
 		}
-		tr.TLSClientConfig.Certificates = []tls.Certificate{crt}
+		cfg.Certificates = []tls.Certificate{crt}
 	}
 
 	if opts.RootCertFilenames != nil {
@@ -105,7 +90,35 @@ func Transport(opts TransportOptions) (http.RoundTripper, error) {
 				return nil, errors.Errorf("cannot parse root certificate from %q", filename)
 			}
 		}
-		tr.TLSClientConfig.RootCAs = pool
+		cfg.RootCAs = pool
+	}
+
+	return cfg, nil
+}
+
+// Transport returns a new http.RoundTripper with default settings applied. If
+// a custom rootCertFilename is non-empty, it must point to a valid PEM file,
+// otherwise the function will return an error.
+func Transport(opts TransportOptions) (http.RoundTripper, error) {
+	tlsConfig, err := TLSConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// copied from net/http
+	tr := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+			DualStack: true,
+		}).DialContext,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig:       tlsConfig,
 	}
 
 	// wrap in the debug round tripper (if active)
@@ -12,6 +12,8 @@ import (
 	"github.com/restic/restic/internal/backend"
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/fs"
+
+	"github.com/minio/sha256-simd"
 )
 
 // Local is a backend in a local directory.
@@ -23,6 +25,9 @@ type Local struct {
 // ensure statically that *Local implements restic.Backend.
 var _ restic.Backend = &Local{}
 
+// ensure statically that *Local implements restic.Checksummer.
+var _ restic.Checksummer = &Local{}
+
 const defaultLayout = "default"
 
 // Open opens the local backend as specified by config.
@@ -178,6 +183,33 @@ func (b *Local) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, err
 	return restic.FileInfo{Size: fi.Size(), Name: h.Name}, nil
 }
 
+// Checksum returns the SHA-256 hash of the file at h.
+func (b *Local) Checksum(ctx context.Context, h restic.Handle) (restic.ID, bool, error) {
+	debug.Log("Checksum %v", h)
+	if err := h.Valid(); err != nil {
+		return restic.ID{}, false, err
+	}
+
+	f, err := fs.Open(b.Filename(h))
+	if err != nil {
+		return restic.ID{}, false, errors.Wrap(err, "Open")
+	}
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, f)
+	if err != nil {
+		_ = f.Close()
+		return restic.ID{}, false, errors.Wrap(err, "Copy")
+	}
+
+	err = f.Close()
+	if err != nil {
+		return restic.ID{}, false, errors.Wrap(err, "Close")
+	}
+
+	return restic.IDFromHash(hash.Sum(nil)), true, nil
+}
+
 // Test returns true if a blob of the given type and name exists in the backend.
 func (b *Local) Test(ctx context.Context, h restic.Handle) (bool, error) {
 	debug.Log("Test %v", h)
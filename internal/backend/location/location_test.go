@@ -6,11 +6,13 @@ import (
 	"testing"
 
 	"github.com/restic/restic/internal/backend/b2"
+	"github.com/restic/restic/internal/backend/ftp"
 	"github.com/restic/restic/internal/backend/local"
 	"github.com/restic/restic/internal/backend/rest"
 	"github.com/restic/restic/internal/backend/s3"
 	"github.com/restic/restic/internal/backend/sftp"
 	"github.com/restic/restic/internal/backend/swift"
+	"github.com/restic/restic/internal/backend/webdav"
 )
 
 func parseURL(s string) *url.URL {
@@ -281,6 +283,37 @@ var parseTests = []struct {
 			},
 		},
 	},
+	{
+		"ftp://user@hostname.foo/dir/repo",
+		Location{Scheme: "ftp",
+			Config: ftp.Config{
+				User:        "user",
+				Host:        "hostname.foo:21",
+				Path:        "dir/repo",
+				Connections: 5,
+			},
+		},
+	},
+	{
+		"ftps://hostname.foo:2121//srv/repo",
+		Location{Scheme: "ftps",
+			Config: ftp.Config{
+				TLS:         true,
+				Host:        "hostname.foo:2121",
+				Path:        "/srv/repo",
+				Connections: 5,
+			},
+		},
+	},
+	{
+		"webdav:https://hostname.foo/remote.php/dav/files/user/restic",
+		Location{Scheme: "webdav",
+			Config: webdav.Config{
+				URL:         parseURL("https://hostname.foo/remote.php/dav/files/user/restic/"),
+				Connections: 5,
+			},
+		},
+	},
 	{
 		"b2:bucketname:/prefix", Location{Scheme: "b2",
 			Config: b2.Config{
@@ -0,0 +1,90 @@
+package s3_test
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/backend/s3"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestBackendS3Checksum(t *testing.T) {
+	data := rtest.Random(42, 1000)
+	id := restic.Hash(data)
+	md5sum := md5.Sum(data)
+	hash := hex.EncodeToString(md5sum[:])
+
+	var tests = []struct {
+		name   string
+		etag   string
+		header map[string]string
+		ok     bool
+		err    bool
+	}{
+		{name: "plain", etag: hash, ok: true},
+		{name: "mismatch", etag: strings.Repeat("0", 32), err: true},
+		{name: "multipart", etag: hash + "-2"},
+		{name: "kms", etag: strings.Repeat("0", 32), header: map[string]string{
+			"X-Amz-Server-Side-Encryption": "aws:kms",
+		}},
+		{name: "sse-c", etag: strings.Repeat("0", 32), header: map[string]string{
+			"X-Amz-Server-Side-Encryption-Customer-Algorithm": "AES256",
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodHead {
+					w.WriteHeader(http.StatusNotImplemented)
+					return
+				}
+
+				w.Header().Set("Content-Length", "1000")
+				w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+				w.Header().Set("ETag", `"`+test.etag+`"`)
+				w.Header().Set("X-Amz-Meta-Restic-Sha256", id.String())
+				w.Header().Set("X-Amz-Meta-Restic-Md5", hash)
+				for k, v := range test.header {
+					w.Header().Set(k, v)
+				}
+			}))
+			defer ts.Close()
+
+			cfg := s3.NewConfig()
+			cfg.Endpoint = strings.TrimPrefix(ts.URL, "http://")
+			cfg.UseHTTP = true
+			cfg.KeyID = "key"
+			cfg.Secret = "secret"
+			cfg.Region = "us-east-1"
+			cfg.Bucket = "bucket"
+			cfg.Prefix = "repo"
+			cfg.Layout = "default"
+
+			be, err := s3.Open(cfg, http.DefaultTransport)
+			rtest.OK(t, err)
+
+			cs, ok := restic.AsChecksummer(be)
+			rtest.Assert(t, ok, "s3 backend does not implement Checksummer")
+
+			h := restic.Handle{Type: restic.DataFile, Name: id.String()}
+			sum, ok, err := cs.Checksum(context.TODO(), h)
+			if test.err {
+				rtest.Assert(t, err != nil, "expected an error for ETag %v", test.etag)
+				return
+			}
+			rtest.OK(t, err)
+			rtest.Equals(t, test.ok, ok)
+			if ok {
+				rtest.Equals(t, id, sum)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"net/url"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/options"
@@ -18,11 +19,15 @@ type Config struct {
 	Bucket        string
 	Prefix        string
 	Layout        string `option:"layout" help:"use this backend layout (default: auto-detect)"`
-	StorageClass  string `option:"storage-class" help:"set S3 storage class (STANDARD, STANDARD_IA, ONEZONE_IA, INTELLIGENT_TIERING or REDUCED_REDUNDANCY)"`
+	StorageClass  string `option:"storage-class" help:"set S3 storage class (STANDARD, STANDARD_IA, ONEZONE_IA, INTELLIGENT_TIERING, REDUCED_REDUNDANCY, GLACIER or DEEP_ARCHIVE)"`
 
 	Connections uint   `option:"connections" help:"set a limit for the number of concurrent connections (default: 5)"`
 	MaxRetries  uint   `option:"retries" help:"set the number of retries attempted"`
 	Region      string `option:"region" help:"set region"`
+
+	RestoreDays         uint          `option:"restore-days" help:"number of days data packs restored from cold storage stay available (default: 7)"`
+	RestoreTier         string        `option:"restore-tier" help:"retrieval tier for restoring data packs from cold storage (Expedited, Standard or Bulk, default: Standard)"`
+	RestorePollInterval time.Duration `option:"restore-poll-interval" help:"interval for checking whether data packs have been restored from cold storage (default: 1m)"`
 }
 
 // NewConfig returns a new Config with the default values filled in.
@@ -32,6 +37,19 @@ func NewConfig() Config {
 	}
 }
 
+// coldStorageClasses contains the storage classes from which objects must be
+// restored before they can be read.
+var coldStorageClasses = map[string]bool{
+	"GLACIER":      true,
+	"DEEP_ARCHIVE": true,
+}
+
+// isColdStorageClass returns true if objects stored in the storage class
+// must be restored before they can be read.
+func isColdStorageClass(class string) bool {
+	return coldStorageClasses[strings.ToUpper(class)]
+}
+
 func init() {
 	options.Register("s3", Config{})
 }
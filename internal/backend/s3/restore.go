@@ -0,0 +1,259 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v6"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+const (
+	defaultRestoreDays         = 7
+	defaultRestoreTier         = "Standard"
+	defaultRestorePollInterval = time.Minute
+)
+
+// make sure that *Backend can restore files from cold storage
+var _ restic.ColdStorage = &Backend{}
+
+// restoreState describes whether an object can be read.
+type restoreState int
+
+const (
+	restoreAvailable  restoreState = iota // the object can be read
+	restoreNeeded                         // the object is in cold storage
+	restoreInProgress                     // the object is being restored
+)
+
+// parseRestoreState returns the restore state of an object from its storage
+// class and the value of the x-amz-restore header, which is either
+// `ongoing-request="true"` while the object is restored or
+// `ongoing-request="false", expiry-date="..."` afterwards.
+func parseRestoreState(class, restore string) restoreState {
+	if !isColdStorageClass(class) {
+		return restoreAvailable
+	}
+
+	switch {
+	case strings.Contains(restore, `ongoing-request="false"`):
+		return restoreAvailable
+	case strings.Contains(restore, `ongoing-request="true"`):
+		return restoreInProgress
+	}
+
+	return restoreNeeded
+}
+
+// do sends a presigned request for the object for h to the server. The minio
+// client neither supports restoring objects nor returns the restore status,
+// so the requests are sent directly.
+func (be *Backend) do(ctx context.Context, method string, h restic.Handle, params url.Values, body []byte) (*http.Response, error) {
+	objName := be.Filename(h)
+
+	u, err := be.client.Presign(method, be.cfg.Bucket, objName, 15*time.Minute, params)
+	if err != nil {
+		return nil, errors.Wrap(err, "client.Presign")
+	}
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "NewRequest")
+	}
+	req = req.WithContext(ctx)
+
+	if body != nil {
+		sum := md5.Sum(body)
+		req.Header.Set("Content-Type", "application/xml")
+		req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+	}
+
+	be.sem.GetToken()
+	defer be.sem.ReleaseToken()
+
+	client := http.Client{Transport: be.rt}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "client.Do")
+	}
+
+	debug.Log("%v %v: %v", method, objName, resp.Status)
+	return resp, nil
+}
+
+// responseError returns the error described by the body of resp.
+func responseError(resp *http.Response) error {
+	e := minio.ErrorResponse{StatusCode: resp.StatusCode}
+	if err := xml.NewDecoder(resp.Body).Decode(&e); err != nil || e.Code == "" {
+		e.Code = resp.Status
+		if resp.StatusCode == http.StatusNotFound {
+			e.Code = "NoSuchKey"
+		}
+	}
+
+	return e
+}
+
+// closeResponse reads the remaining body of resp and closes it.
+func closeResponse(resp *http.Response) {
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	_ = resp.Body.Close()
+}
+
+// restoreState returns whether the object for h can be read.
+func (be *Backend) restoreState(ctx context.Context, h restic.Handle) (restoreState, error) {
+	resp, err := be.do(ctx, http.MethodHead, h, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer closeResponse(resp)
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, errors.Wrap(responseError(resp), "HeadObject")
+	}
+
+	return parseRestoreState(resp.Header.Get("X-Amz-Storage-Class"), resp.Header.Get("X-Amz-Restore")), nil
+}
+
+const restoreRequestBody = `<RestoreRequest xmlns="http://s3.amazonaws.com/doc/2006-03-01/">` +
+	`<Days>%d</Days><GlacierJobParameters><Tier>%s</Tier></GlacierJobParameters></RestoreRequest>`
+
+// requestRestore asks the server to restore the object for h from cold
+// storage.
+func (be *Backend) requestRestore(ctx context.Context, h restic.Handle) error {
+	body := []byte(fmt.Sprintf(restoreRequestBody, be.cfg.RestoreDays, be.cfg.RestoreTier))
+
+	resp, err := be.do(ctx, http.MethodPost, h, url.Values{"restore": []string{""}}, body)
+	if err != nil {
+		return err
+	}
+	defer closeResponse(resp)
+
+	// 202 means that the restore was started, 200 that the object has
+	// already been restored
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusAccepted {
+		return nil
+	}
+
+	err = responseError(resp)
+	if e, ok := err.(minio.ErrorResponse); ok && e.Code == "RestoreAlreadyInProgress" {
+		return nil
+	}
+
+	return errors.Wrap(err, "RestoreObject")
+}
+
+// forEach runs fn for all handles concurrently, using as many goroutines as
+// connections are configured.
+func (be *Backend) forEach(ctx context.Context, handles []restic.Handle, fn func(restic.Handle) error) error {
+	wg, wctx := errgroup.WithContext(ctx)
+	ch := make(chan restic.Handle)
+
+	wg.Go(func() error {
+		defer close(ch)
+		for _, h := range handles {
+			select {
+			case <-wctx.Done():
+				return nil
+			case ch <- h:
+			}
+		}
+		return nil
+	})
+
+	for i := uint(0); i < be.cfg.Connections; i++ {
+		wg.Go(func() error {
+			for h := range ch {
+				if err := fn(h); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	err := wg.Wait()
+	if err == nil {
+		err = ctx.Err()
+	}
+	return err
+}
+
+// Restore requests all data files among handles which are stored in a cold
+// storage class to be restored and waits until all of them can be read.
+func (be *Backend) Restore(ctx context.Context, handles []restic.Handle) error {
+	var m sync.Mutex
+	var pending []restic.Handle
+
+	err := be.forEach(ctx, handles, func(h restic.Handle) error {
+		state, err := be.restoreState(ctx, h)
+		if err != nil {
+			return err
+		}
+
+		switch state {
+		case restoreAvailable:
+			return nil
+		case restoreNeeded:
+			err = be.requestRestore(ctx, h)
+			if err != nil {
+				return err
+			}
+		}
+
+		m.Lock()
+		pending = append(pending, h)
+		m.Unlock()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for len(pending) > 0 {
+		debug.Log("waiting for %d of %d files to be restored", len(pending), len(handles))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(be.cfg.RestorePollInterval):
+		}
+
+		var stillPending []restic.Handle
+		err := be.forEach(ctx, pending, func(h restic.Handle) error {
+			state, err := be.restoreState(ctx, h)
+			if err != nil {
+				return err
+			}
+
+			if state != restoreAvailable {
+				m.Lock()
+				stillPending = append(stillPending, h)
+				m.Unlock()
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		pending = stillPending
+	}
+
+	return nil
+}
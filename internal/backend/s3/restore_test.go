@@ -0,0 +1,172 @@
+package s3_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/backend/s3"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+type coldObject struct {
+	size    int
+	class   string
+	restore string
+	polls   int
+}
+
+// coldStorageServer simulates an S3 server which moves objects to cold
+// storage. Restoring an object takes two status requests. If rejectCold is
+// set, the server behaves like MinIO and rejects the cold storage classes.
+type coldStorageServer struct {
+	m          sync.Mutex
+	objects    map[string]*coldObject
+	restores   int
+	rejectCold bool
+	rejected   int
+}
+
+func (srv *coldStorageServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	srv.m.Lock()
+	defer srv.m.Unlock()
+
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	obj := srv.objects[name]
+
+	switch {
+	case r.Method == http.MethodPut && srv.rejectCold && r.Header.Get("X-Amz-Storage-Class") != "" && r.Header.Get("X-Amz-Storage-Class") != "STANDARD":
+		srv.rejected++
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = io.WriteString(w, "<Error><Code>InvalidStorageClass</Code><Message>Invalid storage class.</Message></Error>")
+	case r.Method == http.MethodPut:
+		buf, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		srv.objects[name] = &coldObject{size: len(buf), class: r.Header.Get("X-Amz-Storage-Class")}
+		w.Header().Set("ETag", `"d41d8cd98f00b204e9800998ecf8427e"`)
+	case obj == nil:
+		w.WriteHeader(http.StatusNotFound)
+	case r.Method == http.MethodHead:
+		if obj.restore == `ongoing-request="true"` {
+			obj.polls++
+			if obj.polls >= 2 {
+				obj.restore = `ongoing-request="false", expiry-date="Fri, 23 Dec 2033 00:00:00 GMT"`
+			}
+		}
+
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", obj.size))
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.Header().Set("ETag", `"d41d8cd98f00b204e9800998ecf8427e"`)
+		if obj.class != "" {
+			w.Header().Set("X-Amz-Storage-Class", obj.class)
+		}
+		if obj.restore != "" {
+			w.Header().Set("X-Amz-Restore", obj.restore)
+		}
+	case r.Method == http.MethodPost && r.URL.Query()["restore"] != nil:
+		if obj.restore != "" {
+			w.WriteHeader(http.StatusConflict)
+			_, _ = io.WriteString(w, "<Error><Code>RestoreAlreadyInProgress</Code></Error>")
+			return
+		}
+		srv.restores++
+		obj.restore = `ongoing-request="true"`
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func newColdStorageBackend(t testing.TB, srv *coldStorageServer) (restic.Backend, func()) {
+	ts := httptest.NewServer(srv)
+
+	cfg := s3.NewConfig()
+	cfg.Endpoint = strings.TrimPrefix(ts.URL, "http://")
+	cfg.UseHTTP = true
+	cfg.KeyID = "key"
+	cfg.Secret = "secret"
+	cfg.Region = "us-east-1"
+	cfg.Bucket = "bucket"
+	cfg.Prefix = "repo"
+	cfg.Layout = "default"
+	cfg.StorageClass = "DEEP_ARCHIVE"
+	cfg.RestorePollInterval = 10 * time.Millisecond
+
+	be, err := s3.Open(cfg, http.DefaultTransport)
+	rtest.OK(t, err)
+
+	return be, ts.Close
+}
+
+func TestBackendS3ColdStorage(t *testing.T) {
+	srv := &coldStorageServer{objects: make(map[string]*coldObject)}
+	be, cleanup := newColdStorageBackend(t, srv)
+	defer cleanup()
+
+	data := rtest.Random(23, 1000)
+	id := restic.Hash(data)
+	dataHandle := restic.Handle{Type: restic.DataFile, Name: id.String()}
+	treeID := restic.Hash(append(data, 'x'))
+	treeHandle := restic.Handle{Type: restic.DataFile, Name: treeID.String()}
+	snapshotHandle := restic.Handle{Type: restic.SnapshotFile, Name: id.String()}
+
+	rtest.OK(t, be.Save(context.TODO(), dataHandle, restic.NewByteReader(data)))
+	rtest.OK(t, be.Save(restic.WithMetadata(context.TODO()), treeHandle, restic.NewByteReader(data)))
+	rtest.OK(t, be.Save(context.TODO(), snapshotHandle, restic.NewByteReader(data)))
+
+	// only data files with file contents are stored in cold storage
+	srv.m.Lock()
+	rtest.Equals(t, "DEEP_ARCHIVE", srv.objects["bucket/repo/data/"+id.String()[:2]+"/"+id.String()].class)
+	rtest.Equals(t, "", srv.objects["bucket/repo/data/"+treeID.String()[:2]+"/"+treeID.String()].class)
+	rtest.Equals(t, "", srv.objects["bucket/repo/snapshots/"+id.String()].class)
+	srv.m.Unlock()
+
+	cs, ok := restic.AsColdStorage(be)
+	rtest.Assert(t, ok, "s3 backend does not implement ColdStorage")
+
+	handles := []restic.Handle{dataHandle, treeHandle, snapshotHandle}
+	rtest.OK(t, cs.Restore(context.TODO(), handles))
+	rtest.Equals(t, 1, srv.restores)
+
+	// the data file is available now
+	rtest.OK(t, cs.Restore(context.TODO(), handles))
+	rtest.Equals(t, 1, srv.restores)
+}
+
+func TestBackendS3ColdStorageRejected(t *testing.T) {
+	srv := &coldStorageServer{objects: make(map[string]*coldObject), rejectCold: true}
+	be, cleanup := newColdStorageBackend(t, srv)
+	defer cleanup()
+
+	var handles []restic.Handle
+	for i := 0; i < 3; i++ {
+		data := rtest.Random(i, 1000)
+		h := restic.Handle{Type: restic.DataFile, Name: restic.Hash(data).String()}
+		rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader(data)))
+		handles = append(handles, h)
+	}
+
+	// the cold storage class is only tried once
+	srv.m.Lock()
+	rtest.Equals(t, 1, srv.rejected)
+	for name, obj := range srv.objects {
+		rtest.Assert(t, obj.class == "", "wrong storage class %q for %v", obj.class, name)
+	}
+	srv.m.Unlock()
+
+	cs, ok := restic.AsColdStorage(be)
+	rtest.Assert(t, ok, "s3 backend does not implement ColdStorage")
+	rtest.OK(t, cs.Restore(context.TODO(), handles))
+	rtest.Equals(t, 0, srv.restores)
+}
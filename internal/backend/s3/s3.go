@@ -2,12 +2,16 @@ package s3
 
 import (
 	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"path"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/restic/restic/internal/backend"
@@ -16,6 +20,7 @@ import (
 
 	"github.com/minio/minio-go/v6"
 	"github.com/minio/minio-go/v6/pkg/credentials"
+	"github.com/minio/sha256-simd"
 
 	"github.com/restic/restic/internal/debug"
 )
@@ -23,14 +28,22 @@ import (
 // Backend stores data on an S3 endpoint.
 type Backend struct {
 	client *minio.Client
+	rt     http.RoundTripper
 	sem    *backend.Semaphore
 	cfg    Config
 	backend.Layout
+
+	// noColdStorage is set to 1 when the server has rejected the cold
+	// storage class, accessed atomically
+	noColdStorage int32
 }
 
 // make sure that *Backend implements backend.Backend
 var _ restic.Backend = &Backend{}
 
+// make sure that *Backend implements restic.Checksummer
+var _ restic.Checksummer = &Backend{}
+
 const defaultLayout = "default"
 
 func open(cfg Config, rt http.RoundTripper) (*Backend, error) {
@@ -40,6 +53,16 @@ func open(cfg Config, rt http.RoundTripper) (*Backend, error) {
 		minio.MaxRetry = int(cfg.MaxRetries)
 	}
 
+	if cfg.RestoreDays == 0 {
+		cfg.RestoreDays = defaultRestoreDays
+	}
+	if cfg.RestoreTier == "" {
+		cfg.RestoreTier = defaultRestoreTier
+	}
+	if cfg.RestorePollInterval == 0 {
+		cfg.RestorePollInterval = defaultRestorePollInterval
+	}
+
 	// Chains all credential types, in the following order:
 	// 	- Static credentials provided by user
 	//	- AWS env vars (i.e. AWS_ACCESS_KEY_ID)
@@ -78,6 +101,7 @@ func open(cfg Config, rt http.RoundTripper) (*Backend, error) {
 
 	be := &Backend{
 		client: client,
+		rt:     rt,
 		sem:    sem,
 		cfg:    cfg,
 	}
@@ -241,20 +265,89 @@ func (be *Backend) Save(ctx context.Context, h restic.Handle, rd restic.RewindRe
 
 	objName := be.Filename(h)
 
+	// the server verifies both hashes, afterwards the SHA-256 hash stored in
+	// the metadata can be used by Checksum
+	md5sum, sha256sum, err := hashReader(rd)
+	if err != nil {
+		return err
+	}
+
+	metadata := map[string]string{
+		"Content-Type": "application/octet-stream",
+		metaSHA256:     hex.EncodeToString(sha256sum),
+		metaMD5:        hex.EncodeToString(md5sum),
+	}
+	class := be.storageClass(ctx, h)
+	if class != "" {
+		metadata["X-Amz-Storage-Class"] = class
+	}
+
+	err = be.putObject(ctx, objName, rd, md5sum, sha256sum, metadata)
+	if err != nil && isColdStorageClass(class) && minio.ToErrorResponse(errors.Cause(err)).Code == "InvalidStorageClass" {
+		// some servers like MinIO do not support cold storage, the files
+		// are stored in the default storage class instead
+		debug.Log("storage class %v rejected, using the default storage class", class)
+		atomic.StoreInt32(&be.noColdStorage, 1)
+		delete(metadata, "X-Amz-Storage-Class")
+
+		err = rd.Rewind()
+		if err != nil {
+			return err
+		}
+		err = be.putObject(ctx, objName, rd, md5sum, sha256sum, metadata)
+	}
+
+	return err
+}
+
+// putObject uploads the data in rd to the object objName.
+func (be *Backend) putObject(ctx context.Context, objName string, rd restic.RewindReader, md5sum, sha256sum []byte, metadata map[string]string) error {
 	be.sem.GetToken()
 	defer be.sem.ReleaseToken()
 
-	opts := minio.PutObjectOptions{StorageClass: be.cfg.StorageClass}
-	opts.ContentType = "application/octet-stream"
-
 	debug.Log("PutObject(%v, %v, %v)", be.cfg.Bucket, objName, rd.Length())
-	n, err := be.client.PutObjectWithContext(ctx, be.cfg.Bucket, objName, ioutil.NopCloser(rd), int64(rd.Length()), opts)
+	coreClient := minio.Core{Client: be.client}
+	info, err := coreClient.PutObjectWithContext(ctx, be.cfg.Bucket, objName, ioutil.NopCloser(rd), int64(rd.Length()),
+		base64.StdEncoding.EncodeToString(md5sum), hex.EncodeToString(sha256sum), metadata, nil)
 
-	debug.Log("%v -> %v bytes, err %#v: %v", objName, n, err, err)
+	debug.Log("%v -> %v bytes, err %#v: %v", objName, info.Size, err, err)
 
 	return errors.Wrap(err, "client.PutObject")
 }
 
+// hashReader returns the MD5 and SHA-256 hashes of the data in rd, which is
+// rewound afterwards.
+func hashReader(rd restic.RewindReader) (md5sum, sha256sum []byte, err error) {
+	err = rd.Rewind()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	md5hash, sha256hash := md5.New(), sha256.New()
+	_, err = io.Copy(io.MultiWriter(md5hash, sha256hash), rd)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Copy")
+	}
+
+	return md5hash.Sum(nil), sha256hash.Sum(nil), rd.Rewind()
+}
+
+// storageClass returns the storage class for the file h. Cold storage classes
+// only apply to data files which contain file contents, all other files
+// including the packs with tree blobs (see restic.WithMetadata) are read
+// regularly and are stored in the default storage class.
+func (be *Backend) storageClass(ctx context.Context, h restic.Handle) string {
+	if !isColdStorageClass(be.cfg.StorageClass) {
+		return be.cfg.StorageClass
+	}
+
+	if h.Type != restic.DataFile || restic.IsMetadata(ctx) || atomic.LoadInt32(&be.noColdStorage) == 1 {
+		return ""
+	}
+
+	return be.cfg.StorageClass
+}
+
 // wrapReader wraps an io.ReadCloser to run an additional function on Close.
 type wrapReader struct {
 	io.ReadCloser
@@ -357,6 +450,67 @@ func (be *Backend) Stat(ctx context.Context, h restic.Handle) (bi restic.FileInf
 	return restic.FileInfo{Size: fi.Size, Name: h.Name}, nil
 }
 
+// metadata keys for the hashes of a file
+const (
+	metaSHA256 = "X-Amz-Meta-Restic-Sha256"
+	metaMD5    = "X-Amz-Meta-Restic-Md5"
+)
+
+// Checksum returns the SHA-256 hash of the file at h, which was verified by
+// the server when the file was saved. The ETag must match the MD5 hash
+// stored with the file, so files which have been replaced are detected.
+// Files saved by older versions of restic have no hash.
+func (be *Backend) Checksum(ctx context.Context, h restic.Handle) (restic.ID, bool, error) {
+	debug.Log("Checksum %v", h)
+	objName := be.Filename(h)
+
+	be.sem.GetToken()
+	info, err := be.client.StatObject(be.cfg.Bucket, objName, minio.StatObjectOptions{})
+	be.sem.ReleaseToken()
+
+	if err != nil {
+		return restic.ID{}, false, errors.Wrap(err, "client.StatObject")
+	}
+
+	sum := info.Metadata.Get(metaSHA256)
+	if sum == "" {
+		return restic.ID{}, false, nil
+	}
+
+	etag := strings.Trim(info.ETag, `"`)
+	if !etagIsMD5(etag, info.Metadata) {
+		debug.Log("ETag %v of %v is not an MD5 hash", etag, h)
+		return restic.ID{}, false, nil
+	}
+
+	if md5sum := info.Metadata.Get(metaMD5); !strings.EqualFold(etag, md5sum) {
+		return restic.ID{}, false, errors.Errorf("ETag %v does not match MD5 hash %v", etag, md5sum)
+	}
+
+	id, err := restic.ParseID(sum)
+	if err != nil {
+		return restic.ID{}, false, errors.Wrap(err, "ParseID")
+	}
+
+	return id, true, nil
+}
+
+// etagIsMD5 returns true if etag is the MD5 hash of the content of an object
+// with the metadata. This is not the case for objects which are encrypted with
+// SSE-KMS or SSE-C, or which were uploaded in several parts.
+func etagIsMD5(etag string, metadata http.Header) bool {
+	if strings.HasPrefix(metadata.Get("X-Amz-Server-Side-Encryption"), "aws:kms") ||
+		metadata.Get("X-Amz-Server-Side-Encryption-Customer-Algorithm") != "" {
+		return false
+	}
+
+	if len(etag) != 2*md5.Size {
+		return false
+	}
+	_, err := hex.DecodeString(etag)
+	return err == nil
+}
+
 // Test returns true if a blob of the given type and name exists in the backend.
 func (be *Backend) Test(ctx context.Context, h restic.Handle) (bool, error) {
 	found := false
@@ -206,6 +206,60 @@ func TestBackendMinio(t *testing.T) {
 	newMinioTestSuite(ctx, t).RunTests(t)
 }
 
+// TestBackendMinioColdStorage checks that a repository with a cold storage
+// class can be used with MinIO, which only supports the storage classes
+// STANDARD and REDUCED_REDUNDANCY.
+func TestBackendMinioColdStorage(t *testing.T) {
+	defer func() {
+		if t.Skipped() {
+			rtest.SkipDisallowed(t, "restic/backend/s3.TestBackendMinioColdStorage")
+		}
+	}()
+
+	// try to find a minio binary
+	_, err := exec.LookPath("minio")
+	if err != nil {
+		t.Skip(err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tr, err := backend.Transport(backend.TransportOptions{})
+	rtest.OK(t, err)
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+	key, secret := newRandomCredentials(t)
+	stopServer := runMinio(ctx, t, tempdir, key, secret)
+	defer stopServer()
+
+	cfg := MinioTestConfig{Config: s3.NewConfig()}
+	cfg.Endpoint = "localhost:9000"
+	cfg.Bucket = "restictestbucket"
+	cfg.Prefix = fmt.Sprintf("test-%d", time.Now().UnixNano())
+	cfg.UseHTTP = true
+	cfg.KeyID = key
+	cfg.Secret = secret
+	cfg.StorageClass = "DEEP_ARCHIVE"
+
+	be, err := createS3(t, cfg, tr)
+	rtest.OK(t, err)
+
+	data := rtest.Random(23, 1000)
+	h := restic.Handle{Type: restic.DataFile, Name: restic.Hash(data).String()}
+	rtest.OK(t, be.Save(ctx, h, restic.NewByteReader(data)))
+
+	cs, ok := restic.AsColdStorage(be)
+	rtest.Assert(t, ok, "s3 backend does not implement ColdStorage")
+	rtest.OK(t, cs.Restore(ctx, []restic.Handle{h}))
+
+	buf, err := backend.LoadAll(ctx, nil, be, h)
+	rtest.OK(t, err)
+	rtest.Equals(t, data, buf)
+}
+
 func BenchmarkBackendMinio(t *testing.B) {
 	// try to find a minio binary
 	_, err := exec.LookPath("minio")
@@ -0,0 +1,32 @@
+package sftp
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestParseSHA256Sums(t *testing.T) {
+	id1 := restic.NewRandomID()
+	id2 := restic.NewRandomID()
+
+	filenames := []string{"/repo/data/a", "/repo/data/b", "/repo/data/c"}
+	stdout := id1.String() + "  /repo/data/a\n" + id2.String() + " */repo/data/c\n"
+	stderr := "sha256sum: /repo/data/b: No such file or directory\n"
+
+	results := parseSHA256Sums(filenames, stdout, stderr)
+	rtest.Equals(t, 3, len(results))
+
+	rtest.OK(t, results[0].Err)
+	rtest.Assert(t, results[0].OK, "no hash returned for %v", filenames[0])
+	rtest.Equals(t, id1, results[0].ID)
+
+	rtest.Assert(t, results[1].Err != nil, "expected an error for %v", filenames[1])
+	rtest.Assert(t, strings.Contains(results[1].Err.Error(), "No such file or directory"),
+		"error does not contain the message from stderr: %v", results[1].Err)
+
+	rtest.OK(t, results[2].Err)
+	rtest.Equals(t, id2, results[2].ID)
+}
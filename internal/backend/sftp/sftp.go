@@ -2,12 +2,14 @@ package sftp
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"path"
+	"strings"
 	"time"
 
 	"github.com/restic/restic/internal/errors"
@@ -33,6 +35,8 @@ type SFTP struct {
 
 var _ restic.Backend = &SFTP{}
 
+var _ restic.BatchChecksummer = &SFTP{}
+
 const defaultLayout = "default"
 
 func startClient(program string, args ...string) (*SFTP, error) {
@@ -189,6 +193,14 @@ func buildSSHCommand(cfg Config) (cmd string, args []string, err error) {
 
 	cmd = "ssh"
 
+	args = buildSSHArgs(cfg)
+	args = append(args, "-s")
+	args = append(args, "sftp")
+	return cmd, args, nil
+}
+
+// buildSSHArgs returns the arguments for ssh to connect to the server.
+func buildSSHArgs(cfg Config) (args []string) {
 	host, port := cfg.Host, cfg.Port
 
 	args = []string{host}
@@ -199,9 +211,7 @@ func buildSSHCommand(cfg Config) (cmd string, args []string, err error) {
 		args = append(args, "-l")
 		args = append(args, cfg.User)
 	}
-	args = append(args, "-s")
-	args = append(args, "sftp")
-	return cmd, args, nil
+	return args
 }
 
 // Create creates an sftp backend as described by the config by running "ssh"
@@ -356,6 +366,115 @@ func (r *SFTP) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, erro
 	return restic.FileInfo{Size: fi.Size(), Name: h.Name}, nil
 }
 
+// Checksum returns the SHA-256 hash of the file at h, see Checksums.
+func (r *SFTP) Checksum(ctx context.Context, h restic.Handle) (restic.ID, bool, error) {
+	res, err := r.Checksums(ctx, []restic.Handle{h})
+	if err != nil {
+		return restic.ID{}, false, err
+	}
+
+	return res[0].ID, res[0].OK, res[0].Err
+}
+
+// Checksums returns the SHA-256 hashes of the files at handles. They are
+// computed on the server by running sha256sum for all files in a single ssh
+// session. This is not possible when a custom command is used to start the
+// sftp subsystem, or when sha256sum is not available on the server.
+func (r *SFTP) Checksums(ctx context.Context, handles []restic.Handle) ([]restic.ChecksumResult, error) {
+	debug.Log("Checksums(%v)", handles)
+	for _, h := range handles {
+		if err := h.Valid(); err != nil {
+			return nil, err
+		}
+	}
+
+	results := make([]restic.ChecksumResult, len(handles))
+	if r.Config.Command != "" {
+		return results, nil
+	}
+
+	filenames := make([]string, 0, len(handles))
+	args := append(buildSSHArgs(r.Config), "sha256sum", "--")
+	for _, h := range handles {
+		filename := r.Filename(h)
+		filenames = append(filenames, filename)
+
+		// ssh passes the command to the shell on the server
+		args = append(args, "'"+strings.Replace(filename, "'", `'\''`, -1)+"'")
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ssh", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		e, ok := err.(*exec.ExitError)
+		switch {
+		case ok && e.ExitCode() == 127:
+			debug.Log("sha256sum is not available on the server")
+			return results, nil
+		case ok && e.ExitCode() == 1:
+			// sha256sum was unable to read some of the files
+		default:
+			return nil, errors.Errorf("sha256sum: %v: %s", err, strings.TrimSpace(stderr.String()))
+		}
+	}
+
+	return parseSHA256Sums(filenames, stdout.String(), stderr.String()), nil
+}
+
+// parseSHA256Sums returns the hashes for filenames from the output of
+// sha256sum. For files without a hash, the error message from stderr is
+// returned.
+func parseSHA256Sums(filenames []string, stdout, stderr string) []restic.ChecksumResult {
+	sums := make(map[string]string)
+	for _, line := range strings.Split(stdout, "\n") {
+		// the hash is followed by a space and a character for the mode
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 || len(fields[1]) < 2 {
+			continue
+		}
+		sums[fields[1][1:]] = fields[0]
+	}
+
+	results := make([]restic.ChecksumResult, len(filenames))
+	for i, filename := range filenames {
+		sum, ok := sums[filename]
+		if !ok {
+			results[i].Err = errors.Errorf("sha256sum: %s", sha256sumError(filename, stderr))
+			continue
+		}
+
+		id, err := restic.ParseID(sum)
+		if err != nil {
+			results[i].Err = errors.Wrap(err, "ParseID")
+			continue
+		}
+
+		results[i].ID = id
+		results[i].OK = true
+	}
+
+	return results
+}
+
+// sha256sumError returns the line in stderr which mentions filename, or all
+// of stderr.
+func sha256sumError(filename, stderr string) string {
+	for _, line := range strings.Split(stderr, "\n") {
+		if strings.Contains(line, filename) {
+			return strings.TrimSpace(line)
+		}
+	}
+
+	if strings.TrimSpace(stderr) == "" {
+		return "no hash returned for " + filename
+	}
+	return strings.TrimSpace(stderr)
+}
+
 // Test returns true if a blob of the given type and name exists in the backend.
 func (r *SFTP) Test(ctx context.Context, h restic.Handle) (bool, error) {
 	debug.Log("Test(%v)", h)
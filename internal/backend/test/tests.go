@@ -600,6 +600,56 @@ func (s *Suite) TestSaveFilenames(t *testing.T) {
 	}
 }
 
+// TestChecksum tests that backends which provide checksums return the hash of
+// the stored data.
+func (s *Suite) TestChecksum(t *testing.T) {
+	b := s.open(t)
+	defer s.close(t, b)
+
+	cs, ok := restic.AsChecksummer(b)
+	if !ok {
+		t.Skipf("backend does not provide checksums")
+	}
+
+	data := test.Random(23, 2*1024*1024)
+	h := store(t, b, restic.DataFile, data)
+
+	want := restic.Hash(data)
+	id, ok, err := cs.Checksum(context.TODO(), h)
+	test.OK(t, err)
+	if ok {
+		if !id.Equal(want) {
+			t.Errorf("wrong checksum returned, want %v, got %v", want.Str(), id.Str())
+		}
+
+		// a missing file is an error
+		_, _, err = cs.Checksum(context.TODO(), restic.Handle{Type: restic.DataFile, Name: "foobar"})
+		if err == nil {
+			t.Errorf("Checksum() for missing file did not return an error")
+		}
+	}
+
+	if bcs, ok := cs.(restic.BatchChecksummer); ok {
+		missing := restic.Handle{Type: restic.DataFile, Name: "foobar"}
+		results, err := bcs.Checksums(context.TODO(), []restic.Handle{h, missing})
+		test.OK(t, err)
+		test.Equals(t, 2, len(results))
+
+		if results[0].OK {
+			test.OK(t, results[0].Err)
+			if !results[0].ID.Equal(want) {
+				t.Errorf("wrong checksum returned, want %v, got %v", want.Str(), results[0].ID.Str())
+			}
+
+			if results[1].Err == nil {
+				t.Errorf("Checksums() for missing file did not return an error")
+			}
+		}
+	}
+
+	test.OK(t, s.delayedRemove(t, b, h))
+}
+
 var testStrings = []struct {
 	id   string
 	data string
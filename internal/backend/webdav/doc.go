@@ -0,0 +1,2 @@
+// Package webdav implements repository storage on a WebDAV server.
+package webdav
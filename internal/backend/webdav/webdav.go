@@ -0,0 +1,627 @@
+package webdav
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context/ctxhttp"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+
+	"github.com/restic/restic/internal/backend"
+)
+
+// Backend stores data in a directory on a WebDAV server.
+type Backend struct {
+	url    *url.URL
+	cfg    Config
+	sem    *backend.Semaphore
+	client *http.Client
+	backend.Layout
+}
+
+// make sure that *Backend implements restic.Backend and backend.Filesystem
+var _ restic.Backend = &Backend{}
+var _ backend.Filesystem = &Backend{}
+
+const defaultLayout = "default"
+
+func open(cfg Config, rt http.RoundTripper) (*Backend, error) {
+	sem, err := backend.NewSemaphore(cfg.Connections)
+	if err != nil {
+		return nil, err
+	}
+
+	be := &Backend{
+		url:    cfg.URL,
+		cfg:    cfg,
+		sem:    sem,
+		client: &http.Client{Transport: rt},
+	}
+
+	be.Layout, err = backend.ParseLayout(be, cfg.Layout, defaultLayout, be.basePath())
+	if err != nil {
+		return nil, err
+	}
+
+	debug.Log("layout: %v", be.Layout)
+	return be, nil
+}
+
+// Open opens the WebDAV backend with the given config.
+func Open(cfg Config, rt http.RoundTripper) (*Backend, error) {
+	debug.Log("open backend at %v", cfg.URL)
+	return open(cfg, rt)
+}
+
+// Create creates the directories for a new repository on the WebDAV server
+// configured in cfg.
+func Create(cfg Config, rt http.RoundTripper) (*Backend, error) {
+	debug.Log("create backend at %v", cfg.URL)
+	be, err := open(cfg, rt)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = be.Stat(context.TODO(), restic.Handle{Type: restic.ConfigFile})
+	if err == nil {
+		return nil, errors.Fatal("config file already exists")
+	}
+
+	if !be.IsNotExist(err) {
+		return nil, err
+	}
+
+	for _, dir := range be.Paths() {
+		if err := be.mkdirAll(context.TODO(), dir); err != nil {
+			return nil, err
+		}
+	}
+
+	return be, nil
+}
+
+// basePath returns the path of the repository on the server.
+func (be *Backend) basePath() string {
+	return path.Clean("/" + be.url.Path)
+}
+
+// Location returns this backend's location (the server's URL).
+func (be *Backend) Location() string {
+	return be.url.String()
+}
+
+// Join combines path components with slashes.
+func (be *Backend) Join(p ...string) string {
+	return path.Join(p...)
+}
+
+// ReadDir returns the entries for a directory.
+func (be *Backend) ReadDir(dir string) ([]os.FileInfo, error) {
+	entries, err := be.readDir(context.TODO(), dir)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]os.FileInfo, 0, len(entries))
+	for _, fi := range entries {
+		list = append(list, fi)
+	}
+	return list, nil
+}
+
+// ErrIsNotExist is returned whenever the requested file or directory does
+// not exist on the server.
+type ErrIsNotExist struct {
+	Path string
+}
+
+func (e ErrIsNotExist) Error() string {
+	return fmt.Sprintf("%v does not exist", e.Path)
+}
+
+// IsNotExist returns true if the error was caused by a non-existing file.
+func (be *Backend) IsNotExist(err error) bool {
+	err = errors.Cause(err)
+	_, ok := err.(ErrIsNotExist)
+	return ok
+}
+
+func unexpectedResponse(method, p string, resp *http.Response) error {
+	return errors.Errorf("%v %v: unexpected server response: %v (%v)", method, p, resp.Status, resp.StatusCode)
+}
+
+// newRequest returns a request for the path p on the server.
+func (be *Backend) newRequest(method, p string, body io.Reader) (*http.Request, error) {
+	u := *be.url
+	u.Path = p
+	u.RawPath = ""
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, errors.Wrap(err, "NewRequest")
+	}
+
+	if be.cfg.User != "" {
+		req.SetBasicAuth(be.cfg.User, be.cfg.Password)
+	}
+
+	return req, nil
+}
+
+// do sends the request to the server, the number of concurrent requests is
+// limited.
+func (be *Backend) do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	be.sem.GetToken()
+	resp, err := ctxhttp.Do(ctx, be.client, req)
+	be.sem.ReleaseToken()
+
+	if err != nil {
+		if resp != nil {
+			_ = drainAndClose(resp)
+		}
+		return nil, errors.Wrapf(err, "%v %v", req.Method, req.URL.Path)
+	}
+
+	return resp, nil
+}
+
+func drainAndClose(resp *http.Response) error {
+	_, err := io.Copy(ioutil.Discard, resp.Body)
+	cerr := resp.Body.Close()
+	if err == nil {
+		err = cerr
+	}
+	return errors.Wrap(err, "Close")
+}
+
+// dirPath returns p with a trailing slash, some servers require this for
+// collections.
+func dirPath(p string) string {
+	if strings.HasSuffix(p, "/") {
+		return p
+	}
+	return p + "/"
+}
+
+// mkdir creates the directory p on the server and returns the status code of
+// the response.
+func (be *Backend) mkdir(ctx context.Context, p string) (int, error) {
+	req, err := be.newRequest("MKCOL", dirPath(p), nil)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := be.do(ctx, req)
+	if err != nil {
+		return 0, err
+	}
+
+	return resp.StatusCode, drainAndClose(resp)
+}
+
+// mkdirAll creates the directory p on the server, including all missing
+// parent directories.
+func (be *Backend) mkdirAll(ctx context.Context, p string) error {
+	p = path.Clean(p)
+	status, err := be.mkdir(ctx, p)
+	if err != nil {
+		return err
+	}
+
+	// the parent directory does not exist
+	if status == http.StatusConflict && path.Dir(p) != p {
+		if err := be.mkdirAll(ctx, path.Dir(p)); err != nil {
+			return err
+		}
+
+		status, err = be.mkdir(ctx, p)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch status {
+	case http.StatusCreated, http.StatusMethodNotAllowed:
+		// the directory was created or exists already
+		return nil
+	}
+
+	return errors.Errorf("MKCOL %v: unexpected server response (%v)", p, status)
+}
+
+// Save stores data in the backend at the handle.
+func (be *Backend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	debug.Log("Save %v", h)
+	if err := h.Valid(); err != nil {
+		return err
+	}
+
+	err := be.put(ctx, be.Filename(h), rd)
+	if be.IsNotExist(err) {
+		// error is caused by a missing directory, try to create it
+		if err := be.mkdirAll(ctx, be.Dirname(h)); err != nil {
+			return err
+		}
+
+		if err := rd.Rewind(); err != nil {
+			return err
+		}
+
+		err = be.put(ctx, be.Filename(h), rd)
+	}
+
+	return err
+}
+
+func (be *Backend) put(ctx context.Context, p string, rd restic.RewindReader) error {
+	// make sure that the client cannot close the reader by wrapping it
+	req, err := be.newRequest(http.MethodPut, p, ioutil.NopCloser(rd))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	// explicitly set the content length, this prevents chunked encoding
+	req.ContentLength = rd.Length()
+
+	resp, err := be.do(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	if err = drainAndClose(resp); err != nil {
+		return err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		return nil
+	case http.StatusNotFound, http.StatusConflict:
+		return ErrIsNotExist{path.Dir(p)}
+	}
+
+	return unexpectedResponse(req.Method, p, resp)
+}
+
+// Load runs fn with a reader that yields the contents of the file at h at the
+// given offset.
+func (be *Backend) Load(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+	return backend.DefaultLoad(ctx, h, length, offset, be.openReader, fn)
+}
+
+func (be *Backend) openReader(ctx context.Context, h restic.Handle, length int, offset int64) (io.ReadCloser, error) {
+	debug.Log("Load %v, length %v, offset %v", h, length, offset)
+	if err := h.Valid(); err != nil {
+		return nil, err
+	}
+
+	if offset < 0 {
+		return nil, errors.New("offset is negative")
+	}
+
+	if length < 0 {
+		return nil, errors.Errorf("invalid length %d", length)
+	}
+
+	p := be.Filename(h)
+	req, err := be.newRequest(http.MethodGet, p, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	byteRange := fmt.Sprintf("bytes=%d-", offset)
+	if length > 0 {
+		byteRange = fmt.Sprintf("bytes=%d-%d", offset, offset+int64(length)-1)
+	}
+	req.Header.Set("Range", byteRange)
+
+	resp, err := be.do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp.Body, nil
+
+	case http.StatusOK:
+		// the server ignored the range header, skip to the requested part
+		if _, err := io.CopyN(ioutil.Discard, resp.Body, offset); err != nil {
+			_ = resp.Body.Close()
+			return nil, errors.Wrap(err, "Discard")
+		}
+
+		if length > 0 {
+			return backend.LimitReadCloser(resp.Body, int64(length)), nil
+		}
+		return resp.Body, nil
+
+	case http.StatusNotFound:
+		_ = drainAndClose(resp)
+		return nil, ErrIsNotExist{p}
+	}
+
+	_ = drainAndClose(resp)
+	return nil, unexpectedResponse(req.Method, p, resp)
+}
+
+// Stat returns information about a blob.
+func (be *Backend) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+	debug.Log("Stat(%v)", h)
+	if err := h.Valid(); err != nil {
+		return restic.FileInfo{}, err
+	}
+
+	p := be.Filename(h)
+	entries, err := be.propfind(ctx, p, "0")
+	if err != nil {
+		return restic.FileInfo{}, err
+	}
+
+	if len(entries) != 1 || entries[0].IsDir() {
+		return restic.FileInfo{}, errors.Errorf("%v is not a file", p)
+	}
+
+	return restic.FileInfo{Size: entries[0].Size(), Name: h.Name}, nil
+}
+
+// Test returns true if a blob of the given type and name exists in the backend.
+func (be *Backend) Test(ctx context.Context, h restic.Handle) (bool, error) {
+	debug.Log("Test(%v)", h)
+	_, err := be.Stat(ctx, h)
+	if be.IsNotExist(err) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// remove removes the file or directory p from the server.
+func (be *Backend) remove(ctx context.Context, p string) error {
+	req, err := be.newRequest(http.MethodDelete, p, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := be.do(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	if err = drainAndClose(resp); err != nil {
+		return err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+		return nil
+	case http.StatusNotFound:
+		return ErrIsNotExist{p}
+	}
+
+	return unexpectedResponse(req.Method, p, resp)
+}
+
+// Remove removes the blob with the given name and type.
+func (be *Backend) Remove(ctx context.Context, h restic.Handle) error {
+	debug.Log("Remove(%v)", h)
+	if err := h.Valid(); err != nil {
+		return err
+	}
+
+	return be.remove(ctx, be.Filename(h))
+}
+
+// List runs fn for each file in the backend which has the type t. When an
+// error occurs (or fn returns an error), List stops and returns it.
+func (be *Backend) List(ctx context.Context, t restic.FileType, fn func(restic.FileInfo) error) error {
+	debug.Log("List %v", t)
+
+	basedir, subdirs := be.Basedir(t)
+	entries, err := be.readDir(ctx, basedir)
+	if be.IsNotExist(err) {
+		debug.Log("ignoring non-existing directory")
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	send := func(fi fileInfo) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		err := fn(restic.FileInfo{Name: fi.Name(), Size: fi.Size()})
+		if err != nil {
+			return err
+		}
+
+		return ctx.Err()
+	}
+
+	for _, fi := range entries {
+		if !fi.IsDir() {
+			if err := send(fi); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if !subdirs {
+			continue
+		}
+
+		subEntries, err := be.readDir(ctx, be.Join(basedir, fi.Name()))
+		if be.IsNotExist(err) {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		for _, fi := range subEntries {
+			if fi.IsDir() {
+				continue
+			}
+
+			if err := send(fi); err != nil {
+				return err
+			}
+		}
+	}
+
+	return ctx.Err()
+}
+
+// Close closes all open files.
+func (be *Backend) Close() error {
+	// this does not need to do anything, all open files are closed within the
+	// same function.
+	return nil
+}
+
+// Delete removes all data in the backend.
+func (be *Backend) Delete(ctx context.Context) error {
+	err := be.remove(ctx, dirPath(be.basePath()))
+	if be.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// fileInfo describes a file or directory on the server.
+type fileInfo struct {
+	name string
+	size int64
+	dir  bool
+}
+
+// statically ensure that fileInfo implements os.FileInfo.
+var _ os.FileInfo = fileInfo{}
+
+func (fi fileInfo) Name() string       { return fi.name }
+func (fi fileInfo) Size() int64        { return fi.size }
+func (fi fileInfo) ModTime() time.Time { return time.Time{} }
+func (fi fileInfo) IsDir() bool        { return fi.dir }
+func (fi fileInfo) Sys() interface{}   { return nil }
+
+func (fi fileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+// propfindRequest asks the server for the properties needed to list files.
+const propfindRequest = `<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:"><D:prop><D:resourcetype/><D:getcontentlength/></D:prop></D:propfind>`
+
+// multistatus is the response of the server to a PROPFIND request.
+type multistatus struct {
+	Responses []struct {
+		Href      string `xml:"href"`
+		Propstats []struct {
+			Status string `xml:"status"`
+			Prop   struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"collection"`
+				} `xml:"resourcetype"`
+				ContentLength string `xml:"getcontentlength"`
+			} `xml:"prop"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+// readDir returns the entries of the directory dir.
+func (be *Backend) readDir(ctx context.Context, dir string) ([]fileInfo, error) {
+	return be.propfind(ctx, dirPath(dir), "1")
+}
+
+// propfind requests the properties of p with the given depth, for a depth of
+// "1" the entries of the directory p are returned without p itself.
+func (be *Backend) propfind(ctx context.Context, p string, depth string) ([]fileInfo, error) {
+	req, err := be.newRequest("PROPFIND", p, strings.NewReader(propfindRequest))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Depth", depth)
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+
+	resp, err := be.do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		_ = drainAndClose(resp)
+		return nil, ErrIsNotExist{p}
+	}
+
+	if resp.StatusCode != http.StatusMultiStatus {
+		_ = drainAndClose(resp)
+		return nil, unexpectedResponse(req.Method, p, resp)
+	}
+
+	var ms multistatus
+	err = xml.NewDecoder(resp.Body).Decode(&ms)
+	if cerr := drainAndClose(resp); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Decode")
+	}
+
+	self := path.Clean(p)
+	entries := make([]fileInfo, 0, len(ms.Responses))
+	for _, r := range ms.Responses {
+		u, err := url.Parse(r.Href)
+		if err != nil {
+			return nil, errors.Wrap(err, "url.Parse")
+		}
+
+		name := path.Clean(u.Path)
+		if depth != "0" && name == self {
+			continue
+		}
+
+		fi := fileInfo{name: path.Base(name)}
+		for _, ps := range r.Propstats {
+			// properties unknown to the server are reported with status 404
+			if !strings.Contains(ps.Status, " 200 ") {
+				continue
+			}
+
+			fi.dir = fi.dir || ps.Prop.ResourceType.Collection != nil
+			if ps.Prop.ContentLength != "" {
+				fi.size, err = strconv.ParseInt(strings.TrimSpace(ps.Prop.ContentLength), 10, 64)
+				if err != nil {
+					return nil, errors.Wrapf(err, "invalid content length for %v", name)
+				}
+			}
+		}
+
+		entries = append(entries, fi)
+	}
+
+	return entries, nil
+}
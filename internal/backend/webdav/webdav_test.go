@@ -0,0 +1,152 @@
+package webdav_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/test"
+	"github.com/restic/restic/internal/backend/webdav"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+
+	davserver "golang.org/x/net/webdav"
+)
+
+// runWebDAVServer starts a WebDAV server for dir and returns the config to
+// access a repository on it.
+func runWebDAVServer(t testing.TB, dir string) (webdav.Config, func()) {
+	handler := &davserver.Handler{
+		FileSystem: davserver.Dir(dir),
+		LockSystem: davserver.NewMemLS(),
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if !ok || user != "user" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+
+	cfg, err := webdav.ParseConfig("webdav:" + srv.URL + "/restic/test/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := cfg.(webdav.Config)
+	c.User = "user"
+	c.Password = "secret"
+	return c, srv.Close
+}
+
+func newTestSuite(t testing.TB, cfg webdav.Config, minimalData bool) *test.Suite {
+	tr, err := backend.Transport(backend.TransportOptions{})
+	if err != nil {
+		t.Fatalf("cannot create transport for tests: %v", err)
+	}
+
+	return &test.Suite{
+		MinimalData: minimalData,
+
+		// NewConfig returns a config for a new temporary backend that will be used in tests.
+		NewConfig: func() (interface{}, error) {
+			return cfg, nil
+		},
+
+		// CreateFn is a function that creates a temporary repository for the tests.
+		Create: func(config interface{}) (restic.Backend, error) {
+			cfg := config.(webdav.Config)
+			return webdav.Create(cfg, tr)
+		},
+
+		// OpenFn is a function that opens a previously created temporary repository.
+		Open: func(config interface{}) (restic.Backend, error) {
+			cfg := config.(webdav.Config)
+			return webdav.Open(cfg, tr)
+		},
+
+		// CleanupFn removes data created during the tests.
+		Cleanup: func(config interface{}) error {
+			return nil
+		},
+	}
+}
+
+func TestBackendWebDAV(t *testing.T) {
+	dir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	cfg, cleanup := runWebDAVServer(t, dir)
+	defer cleanup()
+
+	newTestSuite(t, cfg, false).RunTests(t)
+}
+
+func TestBackendWebDAVExternalServer(t *testing.T) {
+	repostr := os.Getenv("RESTIC_TEST_WEBDAV_REPOSITORY")
+	if repostr == "" {
+		t.Skipf("environment variable %v not set", "RESTIC_TEST_WEBDAV_REPOSITORY")
+	}
+
+	cfg, err := webdav.ParseConfig(repostr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newTestSuite(t, cfg.(webdav.Config), true).RunTests(t)
+}
+
+func TestBackendWebDAVRangeIgnored(t *testing.T) {
+	dir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	handler := &davserver.Handler{
+		FileSystem: davserver.Dir(dir),
+		LockSystem: davserver.NewMemLS(),
+	}
+
+	// simulate a server which does not support range requests
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Del("Range")
+		handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	cfg, err := webdav.ParseConfig("webdav:" + srv.URL + "/repo/")
+	rtest.OK(t, err)
+
+	be, err := webdav.Create(cfg.(webdav.Config), http.DefaultTransport)
+	rtest.OK(t, err)
+
+	data := rtest.Random(23, 10000)
+	h := restic.Handle{Type: restic.DataFile, Name: restic.Hash(data).String()}
+	rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader(data)))
+
+	buf, err := backend.LoadAll(context.TODO(), nil, be, h)
+	rtest.OK(t, err)
+	rtest.Equals(t, data, buf)
+
+	buf = make([]byte, 500)
+	err = be.Load(context.TODO(), h, len(buf), 1234, func(rd io.Reader) error {
+		_, err := io.ReadFull(rd, buf)
+		return err
+	})
+	rtest.OK(t, err)
+	rtest.Equals(t, data[1234:1734], buf)
+}
+
+func BenchmarkBackendWebDAV(t *testing.B) {
+	dir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	cfg, cleanup := runWebDAVServer(t, dir)
+	defer cleanup()
+
+	newTestSuite(t, cfg, false).RunBenchmarks(t)
+}
@@ -229,3 +229,31 @@ func (b *Backend) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, e
 func (b *Backend) IsNotExist(err error) bool {
 	return b.Backend.IsNotExist(err)
 }
+
+// Restore restores the files which are not in the cache from cold storage, if
+// the wrapped backend supports it.
+func (b *Backend) Restore(ctx context.Context, handles []restic.Handle) error {
+	cs, ok := restic.AsColdStorage(b.Backend)
+	if !ok {
+		return nil
+	}
+
+	var missing []restic.Handle
+	for _, h := range handles {
+		if !b.Cache.Has(h) {
+			missing = append(missing, h)
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	debug.Log("restore %d of %d files which are not cached", len(missing), len(handles))
+	return cs.Restore(ctx, missing)
+}
+
+// Unwrap returns the wrapped backend.
+func (b *Backend) Unwrap() restic.Backend {
+	return b.Backend
+}
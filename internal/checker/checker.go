@@ -1,18 +1,24 @@
 package checker
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"fmt"
 	"io"
-	"os"
+	"io/ioutil"
+	"sort"
 	"sync"
 
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/hashing"
 	"github.com/restic/restic/internal/pack"
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 	"golang.org/x/sync/errgroup"
+
+	"github.com/minio/sha256-simd"
 )
 
 // Checker runs various checks on a repository. It is advisable to create an
@@ -206,9 +212,10 @@ func (c *Checker) LoadIndex(ctx context.Context) (hints []error, errs []error) {
 
 // PackError describes an error with a specific pack.
 type PackError struct {
-	ID       restic.ID
-	Orphaned bool
-	Err      error
+	ID         restic.ID
+	Orphaned   bool
+	NoChecksum bool
+	Err        error
 }
 
 func (e PackError) Error() string {
@@ -225,6 +232,16 @@ func IsOrphanedPack(err error) bool {
 	return false
 }
 
+// IsNoChecksum returns true if the error describes a pack for which the
+// backend has no checksum.
+func IsNoChecksum(err error) bool {
+	if e, ok := errors.Cause(err).(PackError); ok && e.NoChecksum {
+		return true
+	}
+
+	return false
+}
+
 // Packs checks that all packs referenced in the index are still available and
 // there are no packs that aren't in an index. errChan is closed after all
 // packs have been checked.
@@ -698,21 +715,102 @@ func (c *Checker) GetPacks() restic.IDSet {
 	return c.packs
 }
 
-// checkPack reads a pack and checks the integrity of all blobs.
-func checkPack(ctx context.Context, r restic.Repository, id restic.ID) error {
+// checkPack reads a pack and checks the integrity of all blobs. The pack file
+// is read sequentially and only once, the blobs are located using the list of
+// blobs from the index. Afterwards the header of the pack is compared to the
+// index.
+func checkPack(ctx context.Context, r restic.Repository, id restic.ID, blobs []restic.Blob) error {
 	debug.Log("checking pack %v", id)
 	h := restic.Handle{Type: restic.DataFile, Name: id.String()}
 
-	packfile, hash, size, err := repository.DownloadAndHash(ctx, r.Backend(), h)
+	sort.Slice(blobs, func(i, j int) bool {
+		return blobs[i].Offset < blobs[j].Offset
+	})
+
+	// the same blob may be listed in several indexes
+	var indexErrs []error
+	list := blobs[:0]
+	var end uint
+	for _, blob := range blobs {
+		if len(list) > 0 && blob.Offset == list[len(list)-1].Offset && blob.ID == list[len(list)-1].ID {
+			continue
+		}
+		if blob.Offset < end {
+			debug.Log("  blob %v overlaps with the previous blob", blob.ID)
+			indexErrs = append(indexErrs, errors.Errorf("blob %v overlaps with the previous blob in the index", blob.ID.Str()))
+			continue
+		}
+		list = append(list, blob)
+		end = blob.Offset + blob.Length
+	}
+	blobs = list
+
+	var (
+		errs   []error
+		hash   restic.ID
+		header []byte
+	)
+
+	err := r.Backend().Load(ctx, h, 0, 0, func(rd io.Reader) error {
+		// the load may be retried, start from scratch
+		errs = errs[:0]
+
+		hrd := hashing.NewReader(rd, sha256.New())
+		bufRd := bufio.NewReaderSize(hrd, 1024*1024)
+
+		var pos uint
+		var buf []byte
+		for i, blob := range blobs {
+			debug.Log("  check blob %d: %v", i, blob)
+
+			if uint(cap(buf)) < blob.Length {
+				buf = make([]byte, blob.Length)
+			}
+			buf = buf[:blob.Length]
+
+			_, err := bufRd.Discard(int(blob.Offset - pos))
+			if err == nil {
+				_, err = io.ReadFull(bufRd, buf)
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				// the pack is too short, the hash check below fails
+				debug.Log("  pack ends before blob %v", blob.ID)
+				break
+			}
+			if err != nil {
+				return errors.Wrap(err, "ReadFull")
+			}
+			pos = blob.Offset + blob.Length
+
+			plaintext, err := repository.DecryptBlob(r.Key(), blob, buf)
+			if err != nil {
+				debug.Log("  error decrypting blob %v: %v", blob.ID, err)
+				errs = append(errs, errors.Errorf("blob %v: %v", i, err))
+				continue
+			}
+
+			hash := restic.Hash(plaintext)
+			if !hash.Equal(blob.ID) {
+				debug.Log("  Blob ID does not match, want %v, got %v", blob.ID, hash)
+				errs = append(errs, errors.Errorf("Blob ID does not match, want %v, got %v", blob.ID.Str(), hash.Str()))
+				continue
+			}
+		}
+
+		// the remaining data contains the header of the pack
+		var err error
+		header, err = ioutil.ReadAll(bufRd)
+		if err != nil {
+			return errors.Wrap(err, "ReadAll")
+		}
+
+		hash = restic.IDFromHash(hrd.Sum(nil))
+		return nil
+	})
 	if err != nil {
 		return errors.Wrap(err, "checkPack")
 	}
 
-	defer func() {
-		_ = packfile.Close()
-		_ = os.Remove(packfile.Name())
-	}()
-
 	debug.Log("hash for pack %v is %v", id, hash)
 
 	if !hash.Equal(id) {
@@ -720,55 +818,50 @@ func checkPack(ctx context.Context, r restic.Repository, id restic.ID) error {
 		return errors.Errorf("Pack ID does not match, want %v, got %v", id.Str(), hash.Str())
 	}
 
-	blobs, err := pack.List(r.Key(), packfile, size)
+	errs = append(errs, indexErrs...)
+
+	entries, err := pack.List(r.Key(), bytes.NewReader(header), int64(len(header)))
 	if err != nil {
-		return err
+		errs = append(errs, errors.Errorf("unable to read pack header: %v", err))
+	} else {
+		errs = append(errs, compareHeader(entries, blobs)...)
 	}
 
-	var errs []error
-	var buf []byte
-	for i, blob := range blobs {
-		debug.Log("  check blob %d: %v", i, blob)
-
-		buf = buf[:cap(buf)]
-		if uint(len(buf)) < blob.Length {
-			buf = make([]byte, blob.Length)
-		}
-		buf = buf[:blob.Length]
+	if len(errs) > 0 {
+		return errors.Errorf("pack %v contains %v errors: %v", id.Str(), len(errs), errs)
+	}
 
-		_, err := packfile.Seek(int64(blob.Offset), 0)
-		if err != nil {
-			return errors.Errorf("Seek(%v): %v", blob.Offset, err)
-		}
+	return nil
+}
 
-		_, err = io.ReadFull(packfile, buf)
-		if err != nil {
-			debug.Log("  error loading blob %v: %v", blob.ID, err)
-			errs = append(errs, errors.Errorf("blob %v: %v", i, err))
-			continue
-		}
+// compareHeader checks that all blobs listed in the index for a pack are
+// contained in the pack header at the same position, and vice versa.
+func compareHeader(entries []restic.Blob, blobs []restic.Blob) (errs []error) {
+	inIndex := make(map[restic.BlobHandle]restic.Blob, len(blobs))
+	for _, blob := range blobs {
+		inIndex[restic.BlobHandle{ID: blob.ID, Type: blob.Type}] = blob
+	}
 
-		nonce, ciphertext := buf[:r.Key().NonceSize()], buf[r.Key().NonceSize():]
-		plaintext, err := r.Key().Open(ciphertext[:0], nonce, ciphertext, nil)
-		if err != nil {
-			debug.Log("  error decrypting blob %v: %v", blob.ID, err)
-			errs = append(errs, errors.Errorf("blob %v: %v", i, err))
+	for _, entry := range entries {
+		h := restic.BlobHandle{ID: entry.ID, Type: entry.Type}
+		blob, ok := inIndex[h]
+		if !ok {
+			errs = append(errs, errors.Errorf("blob %v is contained in the pack header but not in the index", entry.ID.Str()))
 			continue
 		}
+		delete(inIndex, h)
 
-		hash := restic.Hash(plaintext)
-		if !hash.Equal(blob.ID) {
-			debug.Log("  Blob ID does not match, want %v, got %v", blob.ID, hash)
-			errs = append(errs, errors.Errorf("Blob ID does not match, want %v, got %v", blob.ID.Str(), hash.Str()))
-			continue
+		if blob.Offset != entry.Offset || blob.Length != entry.Length {
+			errs = append(errs, errors.Errorf("blob %v: position in the pack header (offset %v, length %v) does not match the index (offset %v, length %v)",
+				entry.ID.Str(), entry.Offset, entry.Length, blob.Offset, blob.Length))
 		}
 	}
 
-	if len(errs) > 0 {
-		return errors.Errorf("pack %v contains %v errors: %v", id.Str(), len(errs), errs)
+	for h := range inIndex {
+		errs = append(errs, errors.Errorf("blob %v is contained in the index but not in the pack header", h.ID.Str()))
 	}
 
-	return nil
+	return errs
 }
 
 // ReadData loads all data from the repository and checks the integrity.
@@ -783,6 +876,15 @@ func (c *Checker) ReadPacks(ctx context.Context, packs restic.IDSet, p *restic.P
 	p.Start()
 	defer p.Done()
 
+	// collect the blobs of the packs from the index, they are needed to read
+	// each pack sequentially
+	packBlobs := make(map[restic.ID][]restic.Blob, len(packs))
+	for pb := range c.repo.Index().Each(ctx) {
+		if packs.Has(pb.PackID) {
+			packBlobs[pb.PackID] = append(packBlobs[pb.PackID], pb.Blob)
+		}
+	}
+
 	g, ctx := errgroup.WithContext(ctx)
 	ch := make(chan restic.ID)
 
@@ -802,7 +904,7 @@ func (c *Checker) ReadPacks(ctx context.Context, packs restic.IDSet, p *restic.P
 					}
 				}
 
-				err := checkPack(ctx, c.repo, id)
+				err := checkPack(ctx, c.repo, id, packBlobs[id])
 				p.Report(restic.Stat{Blobs: 1})
 				if err == nil {
 					continue
@@ -835,3 +937,137 @@ func (c *Checker) ReadPacks(ctx context.Context, packs restic.IDSet, p *restic.P
 		}
 	}
 }
+
+// checksumBatchSize is the number of packs for which the checksums are
+// requested at once from a BatchChecksummer.
+const checksumBatchSize = 100
+
+// VerifyChecksums compares the checksums of the specified packs provided by
+// the backend with the pack IDs, without downloading the packs. The contents
+// of the packs are not decrypted, use ReadPacks for that.
+func (c *Checker) VerifyChecksums(ctx context.Context, packs restic.IDSet, p *restic.Progress, errChan chan<- error) {
+	defer close(errChan)
+
+	cs, ok := restic.AsChecksummer(c.repo.Backend())
+	if !ok {
+		errChan <- errors.New("the backend does not provide checksums")
+		return
+	}
+
+	batchSize := 1
+	if _, ok := cs.(restic.BatchChecksummer); ok {
+		batchSize = checksumBatchSize
+	}
+
+	p.Start()
+	defer p.Done()
+
+	g, ctx := errgroup.WithContext(ctx)
+	ch := make(chan restic.IDs)
+
+	// run workers
+	for i := 0; i < defaultParallelism; i++ {
+		g.Go(func() error {
+			for ids := range ch {
+				for _, err := range verifyChecksums(ctx, cs, ids) {
+					p.Report(restic.Stat{Blobs: 1})
+					if err == nil {
+						continue
+					}
+
+					select {
+					case <-ctx.Done():
+						return nil
+					case errChan <- err:
+					}
+				}
+			}
+			return nil
+		})
+	}
+
+	// push batches of packs to ch
+	var batch restic.IDs
+	for pack := range packs {
+		batch = append(batch, pack)
+		if len(batch) < batchSize {
+			continue
+		}
+
+		select {
+		case ch <- batch:
+		case <-ctx.Done():
+		}
+		batch = nil
+	}
+	if len(batch) > 0 {
+		select {
+		case ch <- batch:
+		case <-ctx.Done():
+		}
+	}
+	close(ch)
+
+	err := g.Wait()
+	if err != nil {
+		select {
+		case <-ctx.Done():
+			return
+		case errChan <- err:
+		}
+	}
+}
+
+// verifyChecksums compares the checksums of the packs with the IDs, it
+// returns an error or nil for each pack.
+func verifyChecksums(ctx context.Context, cs restic.Checksummer, ids restic.IDs) []error {
+	errs := make([]error, len(ids))
+
+	bcs, ok := cs.(restic.BatchChecksummer)
+	if !ok {
+		for i, id := range ids {
+			debug.Log("verifying checksum of pack %v", id)
+			h := restic.Handle{Type: restic.DataFile, Name: id.String()}
+
+			sum, ok, err := cs.Checksum(ctx, h)
+			errs[i] = checkChecksum(id, sum, ok, err)
+		}
+		return errs
+	}
+
+	debug.Log("verifying checksums of %d packs", len(ids))
+	handles := make([]restic.Handle, 0, len(ids))
+	for _, id := range ids {
+		handles = append(handles, restic.Handle{Type: restic.DataFile, Name: id.String()})
+	}
+
+	results, err := bcs.Checksums(ctx, handles)
+	for i, id := range ids {
+		if err != nil {
+			errs[i] = PackError{ID: id, Err: err}
+			continue
+		}
+		errs[i] = checkChecksum(id, results[i].ID, results[i].OK, results[i].Err)
+	}
+
+	return errs
+}
+
+// checkChecksum returns an error if the checksum sum returned by the backend
+// for the pack with the ID is not available or does not match the ID.
+func checkChecksum(id restic.ID, sum restic.ID, ok bool, err error) error {
+	if err != nil {
+		return PackError{ID: id, Err: err}
+	}
+
+	if !ok {
+		return PackError{ID: id, NoChecksum: true, Err: errors.New("no checksum available")}
+	}
+
+	if !sum.Equal(id) {
+		debug.Log("Checksum ID does not match, want %v, got %v", id, sum)
+		return PackError{ID: id, Err: errors.Errorf("checksum does not match: %v", sum.Str())}
+	}
+
+	return nil
+}
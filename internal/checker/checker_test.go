@@ -10,10 +10,12 @@ import (
 	"sort"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/restic/restic/internal/archiver"
+	"github.com/restic/restic/internal/backend/local"
 	"github.com/restic/restic/internal/checker"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/repository"
@@ -109,6 +111,97 @@ func TestMissingPack(t *testing.T) {
 	}
 }
 
+// batchChecksumBackend adds a BatchChecksummer implementation to the local
+// backend.
+type batchChecksumBackend struct {
+	*local.Local
+	calls int32
+}
+
+func (be *batchChecksumBackend) Checksums(ctx context.Context, handles []restic.Handle) ([]restic.ChecksumResult, error) {
+	atomic.AddInt32(&be.calls, 1)
+
+	results := make([]restic.ChecksumResult, len(handles))
+	for i, h := range handles {
+		results[i].ID, results[i].OK, results[i].Err = be.Checksum(ctx, h)
+	}
+	return results, nil
+}
+
+func TestVerifyChecksums(t *testing.T) {
+	t.Run("single", func(t *testing.T) {
+		testVerifyChecksums(t, false)
+	})
+	t.Run("batch", func(t *testing.T) {
+		testVerifyChecksums(t, true)
+	})
+}
+
+func testVerifyChecksums(t *testing.T, batch bool) {
+	repodir, cleanup := test.TempDir(t)
+	defer cleanup()
+
+	lbe, err := local.Create(local.Config{Path: repodir})
+	test.OK(t, err)
+
+	var be restic.Backend = lbe
+	bbe := &batchChecksumBackend{Local: lbe}
+	if batch {
+		be = bbe
+	}
+
+	repo, cleanup := repository.TestRepositoryWithBackend(t, be)
+	defer cleanup()
+
+	archiver.TestSnapshot(t, repo, ".", nil)
+
+	chkr := checker.New(repo)
+	hints, errs := chkr.LoadIndex(context.TODO())
+	if len(errs) > 0 {
+		t.Fatalf("expected no errors, got %v: %v", len(errs), errs)
+	}
+
+	if len(hints) > 0 {
+		t.Errorf("expected no hints, got %v: %v", len(hints), hints)
+	}
+
+	packs := restic.NewIDSet()
+	test.OK(t, repo.List(context.TODO(), restic.DataFile, func(id restic.ID, size int64) error {
+		packs.Insert(id)
+		return nil
+	}))
+
+	verify := func(ctx context.Context, errChan chan<- error) {
+		chkr.VerifyChecksums(ctx, packs, nil, errChan)
+	}
+
+	test.OKs(t, collectErrors(context.TODO(), verify))
+	if batch {
+		// all packs fit into a single batch
+		test.Equals(t, int32(1), atomic.LoadInt32(&bbe.calls))
+	}
+
+	// modify a pack file
+	packID := packs.List()[0].String()
+	f, err := os.OpenFile(filepath.Join(repodir, "data", packID[:2], packID), os.O_WRONLY|os.O_APPEND, 0)
+	test.OK(t, err)
+	_, err = f.Write([]byte("foo"))
+	test.OK(t, err)
+	test.OK(t, f.Close())
+
+	errs = collectErrors(context.TODO(), verify)
+
+	test.Assert(t, len(errs) == 1,
+		"expected exactly one error, got %v", len(errs))
+
+	if err, ok := errs[0].(checker.PackError); ok {
+		test.Equals(t, packID, err.ID.String())
+		test.Assert(t, !checker.IsNoChecksum(err), "unexpected NoChecksum error: %v", err)
+	} else {
+		t.Errorf("expected error returned by checker.VerifyChecksums() to be PackError, got %v", errs[0])
+	}
+}
+
 func TestUnreferencedPack(t *testing.T) {
 	repodir, cleanup := test.Env(t, checkerTestData)
 	defer cleanup()
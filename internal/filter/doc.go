@@ -2,4 +2,7 @@
 // in contrast to filepath.Glob a pattern may specify directories.
 //
 // For a list of valid patterns please see the documentation on filepath.Glob.
+//
+// In addition, a Rule selects files by a combination of glob patterns, regular
+// expressions, the size, the modification time and the type.
 package filter
@@ -0,0 +1,171 @@
+package filter
+
+import (
+	"bufio"
+	"bytes"
+	"path"
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// ignorePattern is a single pattern from an ignore file.
+type ignorePattern struct {
+	// components of the pattern, a leading "**" matches in all directories
+	components []string
+	// negate re-includes items which have been excluded by previous patterns
+	negate bool
+	// dirOnly patterns only match directories
+	dirOnly bool
+}
+
+// IgnorePatterns is a list of patterns read from an ignore file, which follows
+// the syntax of gitignore files:
+//
+//   - Blank lines and lines starting with '#' are ignored.
+//   - A leading '!' negates the pattern, items excluded by a previous pattern
+//     are included again.
+//   - A trailing '/' only matches directories.
+//   - A pattern containing a '/' at the beginning or in the middle is relative
+//     to the directory of the ignore file, otherwise it matches on all levels
+//     below that directory.
+//   - '*', '?' and '[...]' match within one path component, '**' matches an
+//     arbitrary number of directories.
+//   - A backslash escapes the next character, e.g. "\#" or "\!" at the
+//     beginning of a pattern and "\ " for trailing spaces.
+type IgnorePatterns struct {
+	patterns []ignorePattern
+}
+
+// ParseIgnorePatterns parses the content of an ignore file.
+func ParseIgnorePatterns(data []byte) (*IgnorePatterns, error) {
+	p := &IgnorePatterns{}
+
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for lineNo := 1; sc.Scan(); lineNo++ {
+		pat, ok, err := parseIgnoreLine(sc.Text())
+		if err != nil {
+			return nil, errors.Errorf("line %d: %v", lineNo, err)
+		}
+
+		if ok {
+			p.patterns = append(p.patterns, pat)
+		}
+	}
+
+	if err := sc.Err(); err != nil {
+		return nil, errors.Wrap(err, "Scan")
+	}
+
+	return p, nil
+}
+
+// trimTrailingSpaces removes trailing spaces from line unless they are
+// escaped with a backslash.
+func trimTrailingSpaces(line string) string {
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	return line
+}
+
+// parseIgnoreLine parses a single line of an ignore file. The second return
+// value is false for blank lines and comments.
+func parseIgnoreLine(line string) (pat ignorePattern, ok bool, err error) {
+	line = trimTrailingSpaces(strings.TrimSuffix(line, "\r"))
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignorePattern{}, false, nil
+	}
+
+	if strings.HasPrefix(line, "!") {
+		pat.negate = true
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		pat.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+
+	// a single slash does not match anything
+	if line == "" {
+		return ignorePattern{}, false, nil
+	}
+	orig := line
+
+	// a pattern without a slash matches in all directories
+	if !strings.Contains(line, "/") {
+		line = "**/" + line
+	}
+	line = strings.TrimPrefix(line, "/")
+
+	for _, c := range strings.Split(line, "/") {
+		if c == "" {
+			continue
+		}
+
+		// check the pattern is well-formed
+		if _, err := path.Match(c, ""); err != nil {
+			return ignorePattern{}, false, errors.Errorf("invalid pattern %q: %v", orig, err)
+		}
+
+		pat.components = append(pat.components, c)
+	}
+
+	return pat, true, nil
+}
+
+// matchComponents returns true if the components of a pattern match all
+// components of a path.
+func matchComponents(patterns, strs []string) bool {
+	if len(patterns) == 0 {
+		return len(strs) == 0
+	}
+
+	if patterns[0] == "**" {
+		// a trailing "**" matches everything below the directory
+		if len(patterns) == 1 {
+			return len(strs) > 0
+		}
+
+		for i := 0; i <= len(strs); i++ {
+			if matchComponents(patterns[1:], strs[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(strs) == 0 {
+		return false
+	}
+
+	// the pattern has been checked in parseIgnoreLine, so there is no error
+	ok, _ := path.Match(patterns[0], strs[0])
+	return ok && matchComponents(patterns[1:], strs[1:])
+}
+
+// Match checks the item against the patterns. The item must be relative to
+// the directory of the ignore file and use '/' as the separator. The last
+// pattern which matches the item decides whether it is ignored. If no pattern
+// matches, matched is false.
+func (p *IgnorePatterns) Match(item string, isDir bool) (ignored, matched bool) {
+	if p == nil {
+		return false, false
+	}
+
+	strs := strings.Split(strings.Trim(item, "/"), "/")
+
+	for i := len(p.patterns) - 1; i >= 0; i-- {
+		pat := p.patterns[i]
+		if pat.dirOnly && !isDir {
+			continue
+		}
+
+		if matchComponents(pat.components, strs) {
+			return !pat.negate, true
+		}
+	}
+
+	return false, false
+}
@@ -0,0 +1,84 @@
+package filter_test
+
+import (
+	"testing"
+
+	"github.com/restic/restic/internal/filter"
+)
+
+var ignoreTests = []struct {
+	patterns string
+	item     string
+	isDir    bool
+	ignored  bool
+	matched  bool
+}{
+	{"", "foo", false, false, false},
+	{"# comment", "# comment", false, false, false},
+	{"\\#foo", "#foo", false, true, true},
+	{"foo", "foo", false, true, true},
+	{"foo", "foo", true, true, true},
+	{"foo", "bar/foo", false, true, true},
+	{"foo", "bar/foobar", false, false, false},
+	{"foo   ", "foo", false, true, true},
+	{"foo\\ ", "foo ", false, true, true},
+	{"foo\r", "foo", false, true, true},
+	{"*.o", "x/y/main.o", false, true, true},
+	{"*.o", "main.c", false, false, false},
+	{"ma?n.[co]", "src/main.c", false, true, true},
+
+	// directory only
+	{"build/", "build", true, true, true},
+	{"build/", "build", false, false, false},
+	{"build/", "src/build", true, true, true},
+
+	// anchoring
+	{"/foo", "foo", false, true, true},
+	{"/foo", "bar/foo", false, false, false},
+	{"doc/frotz", "doc/frotz", false, true, true},
+	{"doc/frotz", "a/doc/frotz", false, false, false},
+	{"doc/frotz/", "doc/frotz", true, true, true},
+	{"doc/*.txt", "doc/notes.txt", false, true, true},
+	{"doc/*.txt", "doc/sub/notes.txt", false, false, false},
+
+	// double wildcard
+	{"**/foo", "foo", false, true, true},
+	{"**/foo", "a/b/foo", false, true, true},
+	{"**/foo/bar", "a/foo/bar", false, true, true},
+	{"abc/**", "abc", true, false, false},
+	{"abc/**", "abc/x/y", false, true, true},
+	{"a/**/b", "a/b", false, true, true},
+	{"a/**/b", "a/x/y/b", false, true, true},
+	{"a/**/b", "x/a/b", false, false, false},
+
+	// negation, the last matching pattern wins
+	{"*.log\n!important.log", "debug.log", false, true, true},
+	{"*.log\n!important.log", "important.log", false, false, true},
+	{"!important.log\n*.log", "important.log", false, true, true},
+	{"\\!foo", "!foo", false, true, true},
+	{"tmp/\n!tmp/", "tmp", true, false, true},
+}
+
+func TestIgnorePatterns(t *testing.T) {
+	for _, test := range ignoreTests {
+		t.Run("", func(t *testing.T) {
+			p, err := filter.ParseIgnorePatterns([]byte(test.patterns))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ignored, matched := p.Match(test.item, test.isDir)
+			if ignored != test.ignored || matched != test.matched {
+				t.Errorf("patterns %q, item %q (dir %v): want ignored %v, matched %v, got %v, %v",
+					test.patterns, test.item, test.isDir, test.ignored, test.matched, ignored, matched)
+			}
+		})
+	}
+}
+
+func TestIgnorePatternsInvalid(t *testing.T) {
+	_, err := filter.ParseIgnorePatterns([]byte("foo\n[a-\n"))
+	if err == nil {
+		t.Fatal("invalid pattern was accepted")
+	}
+}
@@ -0,0 +1,389 @@
+package filter
+
+import (
+	"os"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// Item contains the attributes of a file or directory which are checked by a
+// Rule.
+type Item struct {
+	// Path of the item, using '/' as the separator
+	Path string
+	// Type of the item, one of the node types "file", "dir", "symlink",
+	// "dev", "chardev", "fifo" or "socket"
+	Type    string
+	Size    uint64
+	ModTime time.Time
+}
+
+// ItemFromNode returns the Item for a node stored at path in a snapshot.
+func ItemFromNode(path string, node *restic.Node) Item {
+	return Item{
+		Path:    path,
+		Type:    node.Type,
+		Size:    node.Size,
+		ModTime: node.ModTime,
+	}
+}
+
+// ItemFromFileInfo returns the Item for a file in the local file system.
+func ItemFromFileInfo(path string, fi os.FileInfo) Item {
+	item := Item{
+		Path:    path,
+		ModTime: fi.ModTime(),
+	}
+
+	switch m := fi.Mode(); {
+	case m.IsRegular():
+		item.Type = "file"
+		item.Size = uint64(fi.Size())
+	case m.IsDir():
+		item.Type = "dir"
+	case m&os.ModeSymlink != 0:
+		item.Type = "symlink"
+	case m&os.ModeDevice != 0 && m&os.ModeCharDevice != 0:
+		item.Type = "chardev"
+	case m&os.ModeDevice != 0:
+		item.Type = "dev"
+	case m&os.ModeNamedPipe != 0:
+		item.Type = "fifo"
+	case m&os.ModeSocket != 0:
+		item.Type = "socket"
+	}
+
+	return item
+}
+
+var itemTypes = []string{"file", "dir", "symlink", "dev", "chardev", "fifo", "socket"}
+
+// predicate is a single condition of a rule.
+type predicate struct {
+	// match returns true if the item fulfills the condition
+	match func(item Item) (bool, error)
+	// childMayMatch returns true if children of the directory at path may
+	// fulfill the condition, it is nil for conditions which do not depend
+	// on the path
+	childMayMatch func(path string) (bool, error)
+}
+
+// Rule selects items by a list of predicates, an item matches the rule if it
+// fulfills all predicates. A rule is written as a list of predicates separated
+// by white space:
+//
+//	glob:PATTERN  the path matches the pattern, see Match
+//	regex:EXPR    the path matches the regular expression
+//	size<SIZE     a regular file smaller than SIZE
+//	size>SIZE     a regular file larger than SIZE
+//	mtime<TIME    modified before TIME
+//	mtime>TIME    modified after TIME
+//	type:TYPE     the item has the type file, dir, symlink, dev, chardev,
+//	              fifo or socket
+//
+// A predicate without a prefix is a glob pattern. Predicates which contain
+// white space must be quoted with single or double quotes, or the white space
+// escaped with a backslash. SIZE is a number of bytes, optionally with one of
+// the suffixes b, k, m, g or t (binary units), see restic.ParseSize. TIME is
+// either a date like 2006-01-02 or 2006-01-02T15:04:05 in the local time
+// zone, or a duration like 30d or 1y6m (see restic.ParseDuration) which is
+// relative to the time the rule was parsed.
+type Rule struct {
+	str   string
+	preds []predicate
+}
+
+// String returns the rule as it was passed to ParseRule.
+func (r Rule) String() string {
+	return r.str
+}
+
+// ParseRule parses a rule, relative times are evaluated against the current
+// time.
+func ParseRule(str string) (Rule, error) {
+	return parseRule(str, time.Now())
+}
+
+func parseRule(str string, now time.Time) (Rule, error) {
+	r := Rule{str: str}
+
+	fields, err := splitRule(str)
+	if err != nil {
+		return Rule{}, errors.Errorf("invalid rule %q: %v", str, err)
+	}
+
+	for _, s := range fields {
+		pred, err := parsePredicate(s, now)
+		if err != nil {
+			return Rule{}, errors.Errorf("invalid rule %q: %v", str, err)
+		}
+		r.preds = append(r.preds, pred)
+	}
+
+	if len(r.preds) == 0 {
+		return Rule{}, errors.New("empty rule")
+	}
+
+	return r, nil
+}
+
+// splitRule splits a rule into predicates at white space. Single and double
+// quotes group characters including white space, a backslash before white
+// space or a quote removes its special meaning. All other backslashes are
+// kept, so that escapes in regular expressions and glob patterns work
+// unchanged.
+func splitRule(str string) ([]string, error) {
+	var (
+		fields  []string
+		field   strings.Builder
+		inField bool
+		quote   rune
+		escaped bool
+	)
+
+	for _, c := range str {
+		switch {
+		case escaped:
+			if !unicode.IsSpace(c) && c != '"' && c != '\'' {
+				field.WriteRune('\\')
+			}
+			field.WriteRune(c)
+			escaped = false
+		case quote == '\'':
+			if c == quote {
+				quote = 0
+			} else {
+				field.WriteRune(c)
+			}
+		case c == '\\':
+			escaped = true
+			inField = true
+		case quote == '"':
+			if c == quote {
+				quote = 0
+			} else {
+				field.WriteRune(c)
+			}
+		case c == '"' || c == '\'':
+			quote = c
+			inField = true
+		case unicode.IsSpace(c):
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteRune(c)
+			inField = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, errors.Errorf("unterminated quote %c", quote)
+	}
+
+	if escaped {
+		field.WriteRune('\\')
+	}
+
+	if inField {
+		fields = append(fields, field.String())
+	}
+
+	return fields, nil
+}
+
+func parsePredicate(s string, now time.Time) (predicate, error) {
+	switch {
+	case strings.HasPrefix(s, "glob:"):
+		return globPredicate(strings.TrimPrefix(s, "glob:"))
+
+	case strings.HasPrefix(s, "regex:"):
+		re, err := regexp.Compile(strings.TrimPrefix(s, "regex:"))
+		if err != nil {
+			return predicate{}, err
+		}
+		return predicate{
+			match: func(item Item) (bool, error) {
+				return re.MatchString(item.Path), nil
+			},
+			childMayMatch: func(string) (bool, error) {
+				return true, nil
+			},
+		}, nil
+
+	case strings.HasPrefix(s, "size<"), strings.HasPrefix(s, "size>"):
+		n, err := restic.ParseSize(s[len("size<"):])
+		if err != nil {
+			return predicate{}, err
+		}
+		size := uint64(n)
+		smaller := s[len("size")] == '<'
+		return predicate{
+			match: func(item Item) (bool, error) {
+				if item.Type != "file" {
+					return false, nil
+				}
+				if smaller {
+					return item.Size < size, nil
+				}
+				return item.Size > size, nil
+			},
+		}, nil
+
+	case strings.HasPrefix(s, "mtime<"), strings.HasPrefix(s, "mtime>"):
+		t, err := parseRuleTime(s[len("mtime<"):], now)
+		if err != nil {
+			return predicate{}, err
+		}
+		before := s[len("mtime")] == '<'
+		return predicate{
+			match: func(item Item) (bool, error) {
+				if before {
+					return item.ModTime.Before(t), nil
+				}
+				return item.ModTime.After(t), nil
+			},
+		}, nil
+
+	case strings.HasPrefix(s, "type:"):
+		typ := strings.TrimPrefix(s, "type:")
+		valid := false
+		for _, t := range itemTypes {
+			if typ == t {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return predicate{}, errors.Errorf("unknown type %q, must be one of %v", typ, strings.Join(itemTypes, ", "))
+		}
+		return predicate{
+			match: func(item Item) (bool, error) {
+				return item.Type == typ, nil
+			},
+		}, nil
+	}
+
+	return globPredicate(s)
+}
+
+func globPredicate(pattern string) (predicate, error) {
+	if pattern == "" {
+		return predicate{}, errors.New("empty glob pattern")
+	}
+
+	// check the pattern is well-formed
+	if _, err := Match(pattern, "/"); err != nil {
+		return predicate{}, err
+	}
+
+	return predicate{
+		match: func(item Item) (bool, error) {
+			return Match(pattern, item.Path)
+		},
+		childMayMatch: func(path string) (bool, error) {
+			return ChildMatch(pattern, path)
+		},
+	}, nil
+}
+
+var ruleTimeFormats = []string{
+	"2006-01-02",
+	"2006-01-02T15:04",
+	"2006-01-02T15:04:05",
+}
+
+// parseRuleTime parses an absolute date or a duration before now.
+func parseRuleTime(s string, now time.Time) (time.Time, error) {
+	for _, format := range ruleTimeFormats {
+		if t, err := time.ParseInLocation(format, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	d, err := restic.ParseDuration(s)
+	if err != nil || d.Zero() {
+		return time.Time{}, errors.Errorf("invalid time %q, must be a date or a duration", s)
+	}
+
+	return now.AddDate(-d.Years, -d.Months, -d.Days).Add(-time.Duration(d.Hours) * time.Hour), nil
+}
+
+// Match returns true if the item fulfills all predicates of the rule.
+func (r Rule) Match(item Item) (bool, error) {
+	for _, pred := range r.preds {
+		ok, err := pred.match(item)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// ChildMayMatch returns true if children of the directory at path may match
+// the rule.
+func (r Rule) ChildMayMatch(path string) (bool, error) {
+	for _, pred := range r.preds {
+		if pred.childMayMatch == nil {
+			continue
+		}
+
+		ok, err := pred.childMayMatch(path)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// Rules is a list of rules, an item matches the list if it matches one of the
+// rules.
+type Rules []Rule
+
+// ParseRules parses all rules in strs.
+func ParseRules(strs []string) (Rules, error) {
+	var rules Rules
+	for _, str := range strs {
+		r, err := ParseRule(str)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+
+	return rules, nil
+}
+
+// Match returns true if the item matches one of the rules, childMayMatch is
+// true if children of item may match one of the rules (like List).
+func (rs Rules) Match(item Item) (matched bool, childMayMatch bool, err error) {
+	for _, r := range rs {
+		m, err := r.Match(item)
+		if err != nil {
+			return false, false, err
+		}
+
+		c, err := r.ChildMayMatch(item.Path)
+		if err != nil {
+			return false, false, err
+		}
+
+		matched = matched || m
+		childMayMatch = childMayMatch || c
+
+		if matched && childMayMatch {
+			return true, true, nil
+		}
+	}
+
+	return matched, childMayMatch, nil
+}
@@ -0,0 +1,151 @@
+package filter_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/filter"
+)
+
+func TestRuleMatch(t *testing.T) {
+	now := time.Now()
+	old := filter.Item{Path: "/home/user/work/report.tmp", Type: "file", Size: 2048, ModTime: now.AddDate(0, 0, -40)}
+	recent := filter.Item{Path: "/home/user/work/notes.bak", Type: "file", Size: 10, ModTime: now.AddDate(0, 0, -1)}
+	dir := filter.Item{Path: "/home/user/work", Type: "dir", ModTime: now.AddDate(-1, 0, 0)}
+	link := filter.Item{Path: "/home/user/link.tmp", Type: "symlink", ModTime: now}
+	space := filter.Item{Path: "/home/user/my work/it's.tmp", Type: "file", Size: 10, ModTime: now}
+
+	var tests = []struct {
+		rule  string
+		item  filter.Item
+		match bool
+	}{
+		{"*.tmp", old, true},
+		{"glob:*.tmp", old, true},
+		{"glob:*.tmp", recent, false},
+		{"/home/*/work", dir, true},
+		{`regex:\.(tmp|bak)$`, old, true},
+		{`regex:\.(tmp|bak)$`, recent, true},
+		{`regex:\.(tmp|bak)$`, dir, false},
+		{`regex:^/home/user/work$`, dir, true},
+		{"size>1k", old, true},
+		{"size>1k", recent, false},
+		{"size<1k", recent, true},
+		{"size<1k", dir, false},
+		{"size>2048", old, false},
+		{"mtime<30d", old, true},
+		{"mtime<30d", recent, false},
+		{"mtime>30d", recent, true},
+		{"mtime<2000-01-01", old, false},
+		{"mtime>2000-01-01T10:00", old, true},
+		{"type:file", old, true},
+		{"type:dir", dir, true},
+		{"type:dir", old, false},
+		{"type:symlink", link, true},
+
+		// all predicates must match
+		{`regex:\.(tmp|bak)$ mtime<30d`, old, true},
+		{`regex:\.(tmp|bak)$ mtime<30d`, recent, false},
+		{"*.tmp type:file", link, false},
+		{"*.tmp  type:symlink", link, true},
+		{"work/* size>1k mtime<1m", old, true},
+
+		// quoted and escaped white space
+		{`regex:"^/home/user/my work/" type:file`, space, true},
+		{`'/home/user/my work/*' type:file`, space, true},
+		{`/home/user/my\ work/* type:file`, space, true},
+		{`"/home/user/my work/it's.tmp"`, space, true},
+		{`/home/user/my\ work/it\'s.tmp`, space, true},
+		{`regex:"^/home/user/my work/" type:dir`, space, false},
+	}
+
+	for _, test := range tests {
+		t.Run("", func(t *testing.T) {
+			r, err := filter.ParseRule(test.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			match, err := r.Match(test.item)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if match != test.match {
+				t.Errorf("rule %q, item %v: want match %v, got %v", test.rule, test.item.Path, test.match, match)
+			}
+		})
+	}
+}
+
+func TestRuleParseInvalid(t *testing.T) {
+	for _, rule := range []string{
+		"",
+		"  ",
+		"regex:(",
+		"glob:",
+		"glob:[",
+		"size>",
+		"size<foo",
+		"mtime<foo",
+		"mtime>0d",
+		"type:foo",
+		"size>16777216T",
+		"size<99999999999999999999",
+		`regex:"foo bar`,
+		"'foo",
+	} {
+		t.Run("", func(t *testing.T) {
+			_, err := filter.ParseRule(rule)
+			if err == nil {
+				t.Errorf("invalid rule %q was accepted", rule)
+			}
+		})
+	}
+}
+
+func TestRulesMatch(t *testing.T) {
+	rules, err := filter.ParseRules([]string{"/home/user/*.go", "size>1M type:file"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		item          filter.Item
+		match         bool
+		childMayMatch bool
+	}{
+		{filter.Item{Path: "/home/user/main.go", Type: "file"}, true, true},
+		{filter.Item{Path: "/home/user/image.iso", Type: "file", Size: 2 << 20}, true, true},
+		{filter.Item{Path: "/home/user/small", Type: "file", Size: 10}, false, true},
+		{filter.Item{Path: "/home", Type: "dir"}, false, true},
+	}
+
+	for _, test := range tests {
+		t.Run("", func(t *testing.T) {
+			match, childMayMatch, err := rules.Match(test.item)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if match != test.match || childMayMatch != test.childMayMatch {
+				t.Errorf("item %v: want %v, %v, got %v, %v", test.item.Path, test.match, test.childMayMatch, match, childMayMatch)
+			}
+		})
+	}
+
+	// without a rule independent of the path, children of other directories cannot match
+	rules, err = filter.ParseRules([]string{"/home/user/*.go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, childMayMatch, err := rules.Match(filter.Item{Path: "/var", Type: "dir"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if childMayMatch {
+		t.Errorf("children of /var may match %v", rules)
+	}
+}
@@ -49,6 +49,26 @@ type Lister interface {
 // New creates a new index for repo from scratch. InvalidFiles contains all IDs
 // of files  that cannot be listed successfully.
 func New(ctx context.Context, repo Lister, ignorePacks restic.IDSet, p *restic.Progress) (idx *Index, invalidFiles restic.IDs, err error) {
+	return Update(ctx, repo, nil, ignorePacks, p)
+}
+
+// packSize returns the size of the pack file which contains the blobs in
+// entries.
+func packSize(entries []restic.Blob) int64 {
+	size := int64(pack.HeaderSize)
+	for _, entry := range entries {
+		size += int64(pack.PackedSizeOfBlob(entry))
+	}
+	return size
+}
+
+// Update creates a new index for repo and reuses the packs listed in the
+// existing index old. Only the headers of packs which are missing from old,
+// or whose size does not match the entries in old, are read. Packs listed in
+// old which do not exist in the repo any more are dropped. If old is nil, the
+// headers of all packs are read. InvalidFiles contains all IDs of files that
+// cannot be listed successfully.
+func Update(ctx context.Context, repo Lister, old *Index, ignorePacks restic.IDSet, p *restic.Progress) (idx *Index, invalidFiles restic.IDs, err error) {
 	p.Start()
 	defer p.Done()
 
@@ -68,7 +88,8 @@ func New(ctx context.Context, repo Lister, ignorePacks restic.IDSet, p *restic.P
 	outputCh := make(chan Result)
 	wg, ctx := errgroup.WithContext(ctx)
 
-	// list the files in the repo, send to inputCh
+	// list the files in the repo, send to inputCh. Packs which can be reused
+	// from the old index are sent to outputCh directly.
 	wg.Go(func() error {
 		defer close(inputCh)
 		return repo.List(ctx, restic.DataFile, func(id restic.ID, size int64) error {
@@ -76,6 +97,18 @@ func New(ctx context.Context, repo Lister, ignorePacks restic.IDSet, p *restic.P
 				return nil
 			}
 
+			if old != nil {
+				if oldPack, ok := old.Packs[id]; ok && packSize(oldPack.Entries) == size {
+					// the index entries are still valid, no need to read the header
+					res := Result{PackID: id, Size: size, Entries: oldPack.Entries}
+					select {
+					case outputCh <- res:
+					case <-ctx.Done():
+					}
+					return nil
+				}
+			}
+
 			job := Job{
 				PackID: id,
 				Size:   size,
@@ -157,10 +190,11 @@ type packJSON struct {
 }
 
 type blobJSON struct {
-	ID     restic.ID       `json:"id"`
-	Type   restic.BlobType `json:"type"`
-	Offset uint            `json:"offset"`
-	Length uint            `json:"length"`
+	ID                 restic.ID       `json:"id"`
+	Type               restic.BlobType `json:"type"`
+	Offset             uint            `json:"offset"`
+	Length             uint            `json:"length"`
+	UncompressedLength uint            `json:"uncompressed_length,omitempty"`
 }
 
 type indexJSON struct {
@@ -218,10 +252,11 @@ func Load(ctx context.Context, repo ListLoader, p *restic.Progress) (*Index, err
 			entries := make([]restic.Blob, 0, len(jpack.Blobs))
 			for _, blob := range jpack.Blobs {
 				entry := restic.Blob{
-					ID:     blob.ID,
-					Type:   blob.Type,
-					Offset: blob.Offset,
-					Length: blob.Length,
+					ID:                 blob.ID,
+					Type:               blob.Type,
+					Offset:             blob.Offset,
+					Length:             blob.Length,
+					UncompressedLength: blob.UncompressedLength,
 				}
 				entries = append(entries, entry)
 			}
@@ -255,6 +290,60 @@ func Load(ctx context.Context, repo ListLoader, p *restic.Progress) (*Index, err
 	return index, nil
 }
 
+// LoadValid loads all index files from the repo like Load, but index files
+// which cannot be loaded are skipped and returned in invalidIndexes. Packs
+// which are listed in several index files are only added once.
+func LoadValid(ctx context.Context, repo ListLoader, p *restic.Progress) (idx *Index, invalidIndexes restic.IDs, err error) {
+	debug.Log("loading valid indexes")
+
+	p.Start()
+	defer p.Done()
+
+	idx = newIndex()
+
+	err = repo.List(ctx, restic.IndexFile, func(id restic.ID, size int64) error {
+		p.Report(restic.Stat{Blobs: 1})
+
+		debug.Log("Load index %v", id)
+		jsonIdx, err := loadIndexJSON(ctx, repo, id)
+		if err != nil {
+			debug.Log("unable to load index %v: %v", id, err)
+			invalidIndexes = append(invalidIndexes, id)
+			return nil
+		}
+
+		for _, jpack := range jsonIdx.Packs {
+			if _, ok := idx.Packs[jpack.ID]; ok {
+				debug.Log("pack %v is listed in several indexes", jpack.ID)
+				continue
+			}
+
+			entries := make([]restic.Blob, 0, len(jpack.Blobs))
+			for _, blob := range jpack.Blobs {
+				entries = append(entries, restic.Blob{
+					ID:                 blob.ID,
+					Type:               blob.Type,
+					Offset:             blob.Offset,
+					Length:             blob.Length,
+					UncompressedLength: blob.UncompressedLength,
+				})
+			}
+
+			if err = idx.AddPack(jpack.ID, 0, entries); err != nil {
+				return err
+			}
+		}
+
+		idx.IndexIDs.Insert(id)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return idx, invalidIndexes, nil
+}
+
 // AddPack adds a pack to the index. If this pack is already in the index, an
 // error is returned.
 func (idx *Index) AddPack(id restic.ID, size int64, entries []restic.Blob) error {
@@ -336,10 +425,11 @@ func (idx *Index) Save(ctx context.Context, repo Saver, supersedes restic.IDs) (
 		b := make([]blobJSON, 0, len(pack.Entries))
 		for _, blob := range pack.Entries {
 			b = append(b, blobJSON{
-				ID:     blob.ID,
-				Type:   blob.Type,
-				Offset: blob.Offset,
-				Length: blob.Length,
+				ID:                 blob.ID,
+				Type:               blob.Type,
+				Offset:             blob.Offset,
+				Length:             blob.Length,
+				UncompressedLength: blob.UncompressedLength,
 			})
 		}
 
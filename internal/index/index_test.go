@@ -155,6 +155,66 @@ func TestIndexNewPackErrors(t *testing.T) {
 	}
 }
 
+// countingRepo counts the calls to ListPack.
+type countingRepo struct {
+	restic.Repository
+
+	listPackCalls restic.IDSet
+	m             sync.Mutex
+}
+
+func (repo *countingRepo) ListPack(ctx context.Context, id restic.ID, size int64) ([]restic.Blob, int64, error) {
+	repo.m.Lock()
+	repo.listPackCalls.Insert(id)
+	repo.m.Unlock()
+
+	return repo.Repository.ListPack(ctx, id, size)
+}
+
+func TestIndexUpdate(t *testing.T) {
+	repo, cleanup := createFilledRepo(t, 3, 0)
+	defer cleanup()
+
+	oldIdx, invalid, err := LoadValid(context.TODO(), repo, nil)
+	if err != nil {
+		t.Fatalf("LoadValid() returned error %v", err)
+	}
+	if len(invalid) > 0 {
+		t.Fatalf("LoadValid() returned invalid indexes: %v", invalid)
+	}
+
+	// remove one pack from the index and add one which does not exist
+	var missingID restic.ID
+	for id := range oldIdx.Packs {
+		missingID = id
+		break
+	}
+	test.OK(t, oldIdx.RemovePack(missingID))
+
+	nonExistingID := restic.NewRandomID()
+	test.OK(t, oldIdx.AddPack(nonExistingID, 0, nil))
+
+	crepo := &countingRepo{Repository: repo, listPackCalls: restic.NewIDSet()}
+	idx, invalid, err := Update(context.TODO(), crepo, oldIdx, restic.NewIDSet(), nil)
+	if err != nil {
+		t.Fatalf("Update() returned error %v", err)
+	}
+	if len(invalid) > 0 {
+		t.Fatalf("Update() returned invalid files: %v", invalid)
+	}
+
+	validateIndex(t, repo, idx)
+
+	if _, ok := idx.Packs[nonExistingID]; ok {
+		t.Errorf("non-existing pack %v was not removed from the index", nonExistingID.Str())
+	}
+
+	// only the header of the pack missing from the index must have been read
+	if !crepo.listPackCalls.Equals(restic.NewIDSet(missingID)) {
+		t.Errorf("wrong packs read, want %v, got %v", missingID.Str(), crepo.listPackCalls)
+	}
+}
+
 func TestIndexLoad(t *testing.T) {
 	repo, cleanup := createFilledRepo(t, 3, 0)
 	defer cleanup()
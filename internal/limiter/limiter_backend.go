@@ -66,3 +66,8 @@ func (l limitedReadCloser) Close() error {
 }
 
 var _ restic.Backend = (*rateLimitedBackend)(nil)
+
+// Unwrap returns the wrapped backend.
+func (r rateLimitedBackend) Unwrap() restic.Backend {
+	return r.Backend
+}
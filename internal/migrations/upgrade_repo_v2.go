@@ -0,0 +1,91 @@
+package migrations
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+func init() {
+	register(&UpgradeRepoV2{})
+}
+
+// UpgradeRepoV2 upgrades a repository from version 1 to version 2, which
+// allows storing compressed blobs.
+type UpgradeRepoV2 struct{}
+
+// Check tests whether the migration can be applied.
+func (m *UpgradeRepoV2) Check(ctx context.Context, repo restic.Repository) (bool, error) {
+	if repo.Config().Version != 1 {
+		debug.Log("repository has version %v", repo.Config().Version)
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// Apply runs the migration.
+func (m *UpgradeRepoV2) Apply(ctx context.Context, repo restic.Repository) error {
+	cfg := repo.Config()
+	if cfg.Version != 1 {
+		return errors.Errorf("repository has version %v, only version 1 can be upgraded", cfg.Version)
+	}
+
+	h := restic.Handle{Type: restic.ConfigFile}
+
+	// keep a copy of the old config file so that it can be restored if
+	// saving the new config fails
+	var oldConfig []byte
+	err := repo.Backend().Load(ctx, h, 0, 0, func(rd io.Reader) (ierr error) {
+		oldConfig, ierr = ioutil.ReadAll(rd)
+		return ierr
+	})
+	if err != nil {
+		return errors.Wrap(err, "load old config")
+	}
+
+	err = repo.Backend().Remove(ctx, h)
+	if err != nil {
+		return errors.Wrap(err, "remove old config")
+	}
+
+	cfg.Version = 2
+	_, err = repo.SaveJSONUnpacked(ctx, restic.ConfigFile, cfg)
+	if err == nil {
+		return nil
+	}
+
+	debug.Log("saving new config failed: %v", err)
+	rerr := repo.Backend().Save(ctx, h, restic.NewByteReader(oldConfig))
+	if rerr == nil {
+		return errors.Wrap(err, "save new config")
+	}
+
+	// last resort: store the old config in a local file
+	f, ferr := ioutil.TempFile("", "restic-config-backup-")
+	if ferr == nil {
+		_, ferr = f.Write(oldConfig)
+		if cerr := f.Close(); ferr == nil {
+			ferr = cerr
+		}
+	}
+	if ferr != nil {
+		return errors.Errorf("saving new config failed: %v, restoring the old config failed: %v, unable to save a local copy: %v", err, rerr, ferr)
+	}
+
+	return errors.Errorf("saving new config failed: %v, restoring the old config failed: %v, the old config was saved to %v", err, rerr, f.Name())
+}
+
+// Name returns the name for this migration.
+func (m *UpgradeRepoV2) Name() string {
+	return "upgrade_repo_v2"
+}
+
+// Desc returns a short description what the migration does.
+func (m *UpgradeRepoV2) Desc() string {
+	return "upgrade a repository to version 2, which supports compression"
+}
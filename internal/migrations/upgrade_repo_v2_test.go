@@ -0,0 +1,29 @@
+package migrations
+
+import (
+	"context"
+	"testing"
+
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestUpgradeRepoV2(t *testing.T) {
+	repo, cleanup := repository.TestRepositoryWithVersion(t, 1)
+	defer cleanup()
+
+	m := &UpgradeRepoV2{}
+
+	ok, err := m.Check(context.TODO(), repo)
+	rtest.OK(t, err)
+	rtest.Assert(t, ok, "migration check returned false for a version 1 repository")
+
+	rtest.OK(t, m.Apply(context.TODO(), repo))
+
+	cfg, err := restic.LoadConfig(context.TODO(), repo)
+	rtest.OK(t, err)
+	rtest.Equals(t, uint(2), cfg.Version)
+	rtest.Equals(t, repo.Config().ID, cfg.ID)
+	rtest.Equals(t, repo.Config().ChunkerPolynomial, cfg.ChunkerPolynomial)
+}
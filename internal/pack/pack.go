@@ -18,9 +18,10 @@ import (
 type Packer struct {
 	blobs []restic.Blob
 
-	bytes uint
-	k     *crypto.Key
-	wr    io.Writer
+	bytes       uint
+	headerBytes uint
+	k           *crypto.Key
+	wr          io.Writer
 
 	m sync.Mutex
 }
@@ -31,23 +32,36 @@ func NewPacker(k *crypto.Key, wr io.Writer) *Packer {
 }
 
 // Add saves the data read from rd as a new blob to the packer. Returned is the
-// number of bytes written to the pack.
-func (p *Packer) Add(t restic.BlobType, id restic.ID, data []byte) (int, error) {
+// number of bytes written to the pack. If data is compressed,
+// uncompressedLength must be set to the length of the data before
+// compression, otherwise it must be zero.
+func (p *Packer) Add(t restic.BlobType, id restic.ID, data []byte, uncompressedLength int) (int, error) {
 	p.m.Lock()
 	defer p.m.Unlock()
 
-	c := restic.Blob{Type: t, ID: id}
+	c := restic.Blob{Type: t, ID: id, UncompressedLength: uint(uncompressedLength)}
 
 	n, err := p.wr.Write(data)
 	c.Length = uint(n)
 	c.Offset = p.bytes
 	p.bytes += uint(n)
 	p.blobs = append(p.blobs, c)
+	if c.IsCompressed() {
+		p.headerBytes += compressedEntrySize
+	} else {
+		p.headerBytes += entrySize
+	}
 
 	return n, errors.Wrap(err, "Write")
 }
 
-var entrySize = uint(binary.Size(restic.BlobType(0)) + binary.Size(uint32(0)) + len(restic.ID{}))
+var (
+	// size of a header entry for an uncompressed blob
+	entrySize = uint(binary.Size(restic.BlobType(0)) + binary.Size(uint32(0)) + len(restic.ID{}))
+	// size of a header entry for a compressed blob, which additionally
+	// records the uncompressed length
+	compressedEntrySize = entrySize + uint(binary.Size(uint32(0)))
+)
 
 // headerEntry is used with encoding/binary to read and write header entries
 type headerEntry struct {
@@ -56,6 +70,24 @@ type headerEntry struct {
 	ID     restic.ID
 }
 
+// compressedHeaderEntry is used with encoding/binary to read and write header
+// entries for compressed blobs
+type compressedHeaderEntry struct {
+	Type               uint8
+	Length             uint32
+	UncompressedLength uint32
+	ID                 restic.ID
+}
+
+// Header entry types, the compressed types are only used in repositories
+// with version 2 or later.
+const (
+	entryTypeData           = 0
+	entryTypeTree           = 1
+	entryTypeCompressedData = 2
+	entryTypeCompressedTree = 3
+)
+
 // Finalize writes the header for all added blobs and finalizes the pack.
 // Returned are the number of bytes written, including the header.
 func (p *Packer) Finalize() (uint, error) {
@@ -89,7 +121,7 @@ func (p *Packer) Finalize() (uint, error) {
 	bytesWritten += uint(hdrBytes)
 
 	// write length
-	err = binary.Write(p.wr, binary.LittleEndian, uint32(restic.CiphertextLength(len(p.blobs)*int(entrySize))))
+	err = binary.Write(p.wr, binary.LittleEndian, uint32(hdrBytes))
 	if err != nil {
 		return 0, errors.Wrap(err, "binary.Write")
 	}
@@ -102,16 +134,24 @@ func (p *Packer) Finalize() (uint, error) {
 // writeHeader constructs and writes the header to wr.
 func (p *Packer) writeHeader(wr io.Writer) (bytesWritten uint, err error) {
 	for _, b := range p.blobs {
-		entry := headerEntry{
-			Length: uint32(b.Length),
-			ID:     b.ID,
-		}
-
-		switch b.Type {
-		case restic.DataBlob:
-			entry.Type = 0
-		case restic.TreeBlob:
-			entry.Type = 1
+		var entry interface{}
+		var size uint
+
+		switch {
+		case b.Type == restic.DataBlob && !b.IsCompressed():
+			entry = headerEntry{Type: entryTypeData, Length: uint32(b.Length), ID: b.ID}
+			size = entrySize
+		case b.Type == restic.TreeBlob && !b.IsCompressed():
+			entry = headerEntry{Type: entryTypeTree, Length: uint32(b.Length), ID: b.ID}
+			size = entrySize
+		case b.Type == restic.DataBlob:
+			entry = compressedHeaderEntry{Type: entryTypeCompressedData, Length: uint32(b.Length),
+				UncompressedLength: uint32(b.UncompressedLength), ID: b.ID}
+			size = compressedEntrySize
+		case b.Type == restic.TreeBlob:
+			entry = compressedHeaderEntry{Type: entryTypeCompressedTree, Length: uint32(b.Length),
+				UncompressedLength: uint32(b.UncompressedLength), ID: b.ID}
+			size = compressedEntrySize
 		default:
 			return 0, errors.Errorf("invalid blob type %v", b.Type)
 		}
@@ -121,7 +161,7 @@ func (p *Packer) writeHeader(wr io.Writer) (bytesWritten uint, err error) {
 			return bytesWritten, errors.Wrap(err, "binary.Write")
 		}
 
-		bytesWritten += entrySize
+		bytesWritten += size
 	}
 
 	return
@@ -135,6 +175,15 @@ func (p *Packer) Size() uint {
 	return p.bytes
 }
 
+// HeaderFull returns true if the header cannot hold another entry without
+// growing larger than maxHeaderSize. No more blobs must be added then.
+func (p *Packer) HeaderFull() bool {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	return restic.CiphertextLength(int(p.headerBytes+compressedEntrySize)) > maxHeaderSize
+}
+
 // Count returns the number of blobs in this packer.
 func (p *Packer) Count() int {
 	p.m.Lock()
@@ -167,20 +216,30 @@ var (
 	minFileSize = entrySize + crypto.Extension + uint(headerLengthSize)
 )
 
+// HeaderSize is the size of the header of a pack file without the header
+// entries: the encryption overhead and the header length field.
+var HeaderSize = crypto.Extension + headerLengthSize
+
+// PackedSizeOfBlob returns the number of bytes blob occupies in a pack file,
+// including its header entry.
+func PackedSizeOfBlob(blob restic.Blob) uint {
+	if blob.IsCompressed() {
+		return blob.Length + compressedEntrySize
+	}
+	return blob.Length + entrySize
+}
+
 const (
 	maxHeaderSize = 16 * 1024 * 1024
 	// number of header enries to download as part of header-length request
 	eagerEntries = 15
 )
 
-// readRecords reads up to max records from the underlying ReaderAt, returning
-// the raw header, the total number of records in the header, and any error.
-// If the header contains fewer than max entries, the header is truncated to
+// readRecords reads up to bufsize bytes from the end of the underlying
+// ReaderAt, returning the raw header, the length of the header, and any
+// error. If the header is shorter than bufsize, the header is truncated to
 // the appropriate size.
-func readRecords(rd io.ReaderAt, size int64, max int) ([]byte, int, error) {
-	var bufsize int
-	bufsize += max * int(entrySize)
-	bufsize += crypto.Extension
+func readRecords(rd io.ReaderAt, size int64, bufsize int) ([]byte, int, error) {
 	bufsize += headerLengthSize
 
 	if bufsize > int(size) {
@@ -203,8 +262,6 @@ func readRecords(rd io.ReaderAt, size int64, max int) ([]byte, int, error) {
 		err = InvalidFileError{Message: "header length is zero"}
 	case hlen < crypto.Extension:
 		err = InvalidFileError{Message: "header length is too small"}
-	case (hlen-crypto.Extension)%uint32(entrySize) != 0:
-		err = InvalidFileError{Message: "header length is invalid"}
 	case int64(hlen) > size-int64(headerLengthSize):
 		err = InvalidFileError{Message: "header is larger than file"}
 	case int64(hlen) > maxHeaderSize:
@@ -214,13 +271,12 @@ func readRecords(rd io.ReaderAt, size int64, max int) ([]byte, int, error) {
 		return nil, 0, errors.Wrap(err, "readHeader")
 	}
 
-	total := (int(hlen) - crypto.Extension) / int(entrySize)
-	if total < max {
+	if int(hlen) <= len(b) {
 		// truncate to the beginning of the pack header
 		b = b[len(b)-int(hlen):]
 	}
 
-	return b, total, nil
+	return b, int(hlen), nil
 }
 
 // readHeader reads the header at the end of rd. size is the length of the
@@ -236,15 +292,16 @@ func readHeader(rd io.ReaderAt, size int64) ([]byte, error) {
 	// eagerly download eagerEntries header entries as part of header-length request.
 	// only make second request if actual number of entries is greater than eagerEntries
 
-	b, c, err := readRecords(rd, size, eagerEntries)
+	eagerSize := eagerEntries*int(compressedEntrySize) + crypto.Extension
+	b, hlen, err := readRecords(rd, size, eagerSize)
 	if err != nil {
 		return nil, err
 	}
-	if c <= eagerEntries {
+	if hlen <= eagerSize {
 		// eager read sufficed, return what we got
 		return b, nil
 	}
-	b, _, err = readRecords(rd, size, c)
+	b, _, err = readRecords(rd, size, hlen)
 	if err != nil {
 		return nil, err
 	}
@@ -277,41 +334,60 @@ func List(k *crypto.Key, rd io.ReaderAt, size int64) (entries []restic.Blob, err
 		return nil, err
 	}
 
-	hdrRd := bytes.NewReader(buf)
-
 	entries = make([]restic.Blob, 0, uint(len(buf))/entrySize)
 
 	pos := uint(0)
-	for {
-		e := headerEntry{}
-		err = binary.Read(hdrRd, binary.LittleEndian, &e)
-		if errors.Cause(err) == io.EOF {
-			break
-		}
-
+	for len(buf) > 0 {
+		entry, n, err := parseHeaderEntry(buf)
 		if err != nil {
-			return nil, errors.Wrap(err, "binary.Read")
-		}
-
-		entry := restic.Blob{
-			Length: uint(e.Length),
-			ID:     e.ID,
-			Offset: pos,
-		}
-
-		switch e.Type {
-		case 0:
-			entry.Type = restic.DataBlob
-		case 1:
-			entry.Type = restic.TreeBlob
-		default:
-			return nil, errors.Errorf("invalid type %d", e.Type)
+			return nil, err
 		}
+		buf = buf[n:]
 
+		entry.Offset = pos
 		entries = append(entries, entry)
 
-		pos += uint(e.Length)
+		pos += entry.Length
 	}
 
 	return entries, nil
 }
+
+// parseHeaderEntry decodes the first header entry in buf. Returned is the
+// blob described by the entry (without offset) and the number of bytes
+// consumed.
+func parseHeaderEntry(buf []byte) (restic.Blob, uint, error) {
+	var b restic.Blob
+	var size uint
+
+	switch buf[0] {
+	case entryTypeData, entryTypeTree:
+		size = entrySize
+	case entryTypeCompressedData, entryTypeCompressedTree:
+		size = compressedEntrySize
+	default:
+		return b, 0, errors.Errorf("invalid type %d", buf[0])
+	}
+
+	if uint(len(buf)) < size {
+		return b, 0, errors.Errorf("header entry too short, want %d bytes, got %d", size, len(buf))
+	}
+
+	switch buf[0] {
+	case entryTypeData, entryTypeCompressedData:
+		b.Type = restic.DataBlob
+	case entryTypeTree, entryTypeCompressedTree:
+		b.Type = restic.TreeBlob
+	}
+
+	b.Length = uint(binary.LittleEndian.Uint32(buf[1:5]))
+	if size == compressedEntrySize {
+		b.UncompressedLength = uint(binary.LittleEndian.Uint32(buf[5:9]))
+		if b.UncompressedLength == 0 {
+			return b, 0, errors.New("invalid uncompressed length zero in header entry")
+		}
+	}
+	copy(b.ID[:], buf[size-uint(len(b.ID)):size])
+
+	return b, size, nil
+}
@@ -22,8 +22,8 @@ func (rd *countingReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
 
 func TestReadHeaderEagerLoad(t *testing.T) {
 
-	testReadHeader := func(dataSize, entryCount, expectedReadInvocationCount int) {
-		expectedHeader := rtest.Random(0, entryCount*int(entrySize)+crypto.Extension)
+	testReadHeader := func(dataSize, headerSize, expectedReadInvocationCount int) {
+		expectedHeader := rtest.Random(0, headerSize)
 
 		buf := &bytes.Buffer{}
 		buf.Write(rtest.Random(0, dataSize))                                // pack blobs data
@@ -40,29 +40,30 @@ func TestReadHeaderEagerLoad(t *testing.T) {
 	}
 
 	// basic
-	testReadHeader(100, 1, 1)
+	testReadHeader(100, int(entrySize)+crypto.Extension, 1)
+	testReadHeader(100, int(compressedEntrySize)+crypto.Extension, 1)
 
-	// header entries == eager entries
-	testReadHeader(100, eagerEntries-1, 1)
-	testReadHeader(100, eagerEntries, 1)
-	testReadHeader(100, eagerEntries+1, 2)
+	// header size ~ eager load size
+	eagerLoadSize := int(eagerEntries*compressedEntrySize) + crypto.Extension
+	testReadHeader(100, eagerLoadSize-1, 1)
+	testReadHeader(100, eagerLoadSize, 1)
+	testReadHeader(100, eagerLoadSize+1, 2)
 
 	// file size == eager header load size
-	eagerLoadSize := int((eagerEntries * entrySize) + crypto.Extension)
 	headerSize := int(1*entrySize) + crypto.Extension
 	dataSize := eagerLoadSize - headerSize - binary.Size(uint32(0))
-	testReadHeader(dataSize-1, 1, 1)
-	testReadHeader(dataSize, 1, 1)
-	testReadHeader(dataSize+1, 1, 1)
-	testReadHeader(dataSize+2, 1, 1)
-	testReadHeader(dataSize+3, 1, 1)
-	testReadHeader(dataSize+4, 1, 1)
+	testReadHeader(dataSize-1, headerSize, 1)
+	testReadHeader(dataSize, headerSize, 1)
+	testReadHeader(dataSize+1, headerSize, 1)
+	testReadHeader(dataSize+2, headerSize, 1)
+	testReadHeader(dataSize+3, headerSize, 1)
+	testReadHeader(dataSize+4, headerSize, 1)
 }
 
 func TestReadRecords(t *testing.T) {
-	testReadRecords := func(dataSize, entryCount, totalRecords int) {
-		totalHeader := rtest.Random(0, totalRecords*int(entrySize)+crypto.Extension)
-		off := len(totalHeader) - (entryCount*int(entrySize) + crypto.Extension)
+	testReadRecords := func(dataSize, bufSize, totalHeaderSize int) {
+		totalHeader := rtest.Random(0, totalHeaderSize)
+		off := len(totalHeader) - bufSize
 		if off < 0 {
 			off = 0
 		}
@@ -75,36 +76,40 @@ func TestReadRecords(t *testing.T) {
 
 		rd := bytes.NewReader(buf.Bytes())
 
-		header, count, err := readRecords(rd, int64(rd.Len()), entryCount)
+		header, hlen, err := readRecords(rd, int64(rd.Len()), bufSize)
 		rtest.OK(t, err)
 		rtest.Equals(t, expectedHeader, header)
-		rtest.Equals(t, totalRecords, count)
+		rtest.Equals(t, totalHeaderSize, hlen)
+	}
+
+	entries := func(n int) int {
+		return n*int(entrySize) + crypto.Extension
 	}
 
 	// basic
-	testReadRecords(100, 1, 1)
-	testReadRecords(100, 0, 1)
-	testReadRecords(100, 1, 0)
+	testReadRecords(100, entries(1), entries(1))
+	testReadRecords(100, entries(0), entries(1))
+	testReadRecords(100, entries(1), entries(0))
 
 	// header entries ~ eager entries
-	testReadRecords(100, eagerEntries, eagerEntries-1)
-	testReadRecords(100, eagerEntries, eagerEntries)
-	testReadRecords(100, eagerEntries, eagerEntries+1)
+	testReadRecords(100, entries(eagerEntries), entries(eagerEntries-1))
+	testReadRecords(100, entries(eagerEntries), entries(eagerEntries))
+	testReadRecords(100, entries(eagerEntries), entries(eagerEntries+1))
 
 	// file size == eager header load size
-	eagerLoadSize := int((eagerEntries * entrySize) + crypto.Extension)
-	headerSize := int(1*entrySize) + crypto.Extension
+	eagerLoadSize := entries(eagerEntries)
+	headerSize := entries(1)
 	dataSize := eagerLoadSize - headerSize - binary.Size(uint32(0))
-	testReadRecords(dataSize-1, 1, 1)
-	testReadRecords(dataSize, 1, 1)
-	testReadRecords(dataSize+1, 1, 1)
-	testReadRecords(dataSize+2, 1, 1)
-	testReadRecords(dataSize+3, 1, 1)
-	testReadRecords(dataSize+4, 1, 1)
+	testReadRecords(dataSize-1, entries(1), headerSize)
+	testReadRecords(dataSize, entries(1), headerSize)
+	testReadRecords(dataSize+1, entries(1), headerSize)
+	testReadRecords(dataSize+2, entries(1), headerSize)
+	testReadRecords(dataSize+3, entries(1), headerSize)
+	testReadRecords(dataSize+4, entries(1), headerSize)
 
 	for i := 0; i < 2; i++ {
 		for j := 0; j < 2; j++ {
-			testReadRecords(dataSize, i, j)
+			testReadRecords(dataSize, entries(i), entries(j))
 		}
 	}
 }
@@ -38,7 +38,7 @@ func newPack(t testing.TB, k *crypto.Key, lengths []int) ([]Buf, []byte, uint) {
 	// pack blobs
 	p := pack.NewPacker(k, new(bytes.Buffer))
 	for _, b := range bufs {
-		p.Add(restic.TreeBlob, b.id, b.data)
+		p.Add(restic.TreeBlob, b.id, b.data, 0)
 	}
 
 	_, err := p.Finalize()
@@ -85,6 +85,42 @@ func verifyBlobs(t testing.TB, bufs []Buf, k *crypto.Key, rd io.ReaderAt, packSi
 	}
 }
 
+func TestCreatePackCompressed(t *testing.T) {
+	k := crypto.NewRandomKey()
+
+	// pack blobs, every other blob is marked as compressed
+	p := pack.NewPacker(k, new(bytes.Buffer))
+	for i, l := range testLens {
+		data := rtest.Random(i, l)
+		var uncompressedLength int
+		if i%2 == 0 {
+			uncompressedLength = 2 * l
+		}
+		_, err := p.Add(restic.DataBlob, restic.Hash(data), data, uncompressedLength)
+		rtest.OK(t, err)
+	}
+
+	_, err := p.Finalize()
+	rtest.OK(t, err)
+
+	packData := p.Writer().(*bytes.Buffer).Bytes()
+	rtest.Equals(t, uint(len(packData)), p.Size())
+
+	entries, err := pack.List(k, bytes.NewReader(packData), int64(len(packData)))
+	rtest.OK(t, err)
+	rtest.Equals(t, p.Blobs(), entries)
+
+	size := uint(pack.HeaderSize)
+	for i, e := range entries {
+		rtest.Equals(t, i%2 == 0, e.IsCompressed())
+		if e.IsCompressed() {
+			rtest.Equals(t, uint(2*testLens[i]), e.DataLength())
+		}
+		size += pack.PackedSizeOfBlob(e)
+	}
+	rtest.Equals(t, uint(len(packData)), size)
+}
+
 func TestCreatePack(t *testing.T) {
 	// create random keys
 	k := crypto.NewRandomKey()
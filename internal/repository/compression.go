@@ -0,0 +1,138 @@
+package repository
+
+import (
+	"sync"
+
+	"github.com/restic/restic/internal/crypto"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// CompressionMode configures if and how blobs are compressed before they are
+// saved. Compression is only used for repositories with version 2 or later.
+type CompressionMode uint
+
+// Constants for the different compression modes.
+const (
+	CompressionAuto CompressionMode = iota
+	CompressionOff
+	CompressionMax
+)
+
+// Set implements the method needed for pflag command flag parsing.
+func (c *CompressionMode) Set(s string) error {
+	switch s {
+	case "auto":
+		*c = CompressionAuto
+	case "off":
+		*c = CompressionOff
+	case "max":
+		*c = CompressionMax
+	default:
+		return errors.Errorf("invalid compression mode %q, must be one of (auto|off|max)", s)
+	}
+
+	return nil
+}
+
+func (c *CompressionMode) String() string {
+	switch *c {
+	case CompressionAuto:
+		return "auto"
+	case CompressionOff:
+		return "off"
+	case CompressionMax:
+		return "max"
+	}
+
+	return "invalid"
+}
+
+// Type returns the type name for pflag.
+func (c *CompressionMode) Type() string {
+	return "mode"
+}
+
+// newZstdEncoder returns an encoder configured for mode. The returned encoder
+// can be used concurrently by calling EncodeAll.
+func newZstdEncoder(mode CompressionMode) *zstd.Encoder {
+	level := zstd.SpeedDefault
+	if mode == CompressionMax {
+		level = zstd.SpeedBestCompression
+	}
+
+	enc, err := zstd.NewWriter(nil,
+		zstd.WithEncoderLevel(level),
+		zstd.WithEncoderConcurrency(1),
+		zstd.WithZeroFrames(true),
+	)
+	if err != nil {
+		panic(err)
+	}
+	return enc
+}
+
+var (
+	zstdDecoder     *zstd.Decoder
+	zstdDecoderOnce sync.Once
+)
+
+// getZstdDecoder returns the shared decoder, DecodeAll can be called
+// concurrently.
+func getZstdDecoder() *zstd.Decoder {
+	zstdDecoderOnce.Do(func() {
+		dec, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+		if err != nil {
+			panic(err)
+		}
+		zstdDecoder = dec
+	})
+	return zstdDecoder
+}
+
+// compress returns the compressed data, or nil if compressing does not
+// reduce the size of data.
+func (r *Repository) compress(data []byte) []byte {
+	r.encOnce.Do(func() {
+		r.enc = newZstdEncoder(r.compression)
+	})
+
+	compressed := r.enc.EncodeAll(data, make([]byte, 0, len(data)))
+	if len(compressed) >= len(data) {
+		return nil
+	}
+	return compressed
+}
+
+// DecryptBlob decrypts buf, which contains blob as it is stored in a pack
+// file, and decompresses the plaintext if the blob is compressed. The
+// contents of buf are overwritten.
+func DecryptBlob(key *crypto.Key, blob restic.Blob, buf []byte) ([]byte, error) {
+	if len(buf) < key.NonceSize() {
+		return nil, errors.Errorf("blob %v is too short", blob.ID.Str())
+	}
+
+	nonce, ciphertext := buf[:key.NonceSize()], buf[key.NonceSize():]
+	plaintext, err := key.Open(ciphertext[:0], nonce, ciphertext, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if !blob.IsCompressed() {
+		return plaintext, nil
+	}
+
+	data, err := getZstdDecoder().DecodeAll(plaintext, make([]byte, 0, blob.UncompressedLength))
+	if err != nil {
+		return nil, errors.Wrap(err, "decompress")
+	}
+
+	if uint(len(data)) != blob.UncompressedLength {
+		return nil, errors.Errorf("decompressed blob has wrong length, want %d, got %d",
+			blob.UncompressedLength, len(data))
+	}
+
+	return data, nil
+}
@@ -19,7 +19,7 @@ import (
 // Hence the index data structure defined here is one of the main contributions
 // to the total memory requirements of restic.
 //
-// We store the index entries in indexMaps. In these maps, entries take 56
+// We store the index entries in indexMaps. In these maps, entries take 64
 // bytes each, plus 8/4 = 2 bytes of unused pointers on average, not counting
 // malloc and header struct overhead and ignoring duplicates (those are only
 // present in edge cases and are also removed by prune runs).
@@ -33,12 +33,12 @@ import (
 // size is 1.5 MB and the minimum pack size is 4 MB)
 //
 // We have the following sizes:
-// indexEntry:  56 bytes  (on amd64)
+// indexEntry:  64 bytes  (on amd64)
 // each packID: 32 bytes
 //
 // To save N index entries, we therefore need:
-// N * (56 + 2) bytes + N * 32 bytes / BP = N * 62 bytes,
-// i.e., fewer than 64 bytes per blob in an index.
+// N * (64 + 2) bytes + N * 32 bytes / BP = N * 70 bytes,
+// i.e., fewer than 72 bytes per blob in an index.
 
 // Index holds lookup tables for id -> pack.
 type Index struct {
@@ -74,12 +74,12 @@ const maxuint32 = 1<<32 - 1
 
 func (idx *Index) store(packIndex int, blob restic.Blob) {
 	// assert that offset and length fit into uint32!
-	if blob.Offset > maxuint32 || blob.Length > maxuint32 {
+	if blob.Offset > maxuint32 || blob.Length > maxuint32 || blob.UncompressedLength > maxuint32 {
 		panic("offset or length does not fit in uint32. You have packs > 4GB!")
 	}
 
 	m := &idx.byType[blob.Type]
-	m.add(blob.ID, packIndex, uint32(blob.Offset), uint32(blob.Length))
+	m.add(blob.ID, packIndex, uint32(blob.Offset), uint32(blob.Length), uint32(blob.UncompressedLength))
 }
 
 // Final returns true iff the index is already written to the repository, it is
@@ -165,10 +165,11 @@ func (idx *Index) StorePack(id restic.ID, blobs []restic.Blob) {
 func (idx *Index) toPackedBlob(e *indexEntry, typ restic.BlobType) restic.PackedBlob {
 	return restic.PackedBlob{
 		Blob: restic.Blob{
-			ID:     e.id,
-			Type:   typ,
-			Length: uint(e.length),
-			Offset: uint(e.offset),
+			ID:                 e.id,
+			Type:               typ,
+			Length:             uint(e.length),
+			Offset:             uint(e.offset),
+			UncompressedLength: uint(e.uncompressedLength),
 		},
 		PackID: idx.packs[e.packIndex],
 	}
@@ -222,6 +223,9 @@ func (idx *Index) LookupSize(id restic.ID, tpe restic.BlobType) (plaintextLength
 	if e == nil {
 		return 0, false
 	}
+	if e.uncompressedLength != 0 {
+		return uint(e.uncompressedLength), true
+	}
 	return uint(restic.PlaintextLength(int(e.length))), true
 }
 
@@ -274,6 +278,60 @@ func (idx *Index) Each(ctx context.Context) <-chan restic.PackedBlob {
 	return ch
 }
 
+// EachByPackResult is the list of blobs of a pack returned by EachByPack.
+type EachByPackResult struct {
+	PackID restic.ID
+	Blobs  []restic.Blob
+}
+
+// EachByPack returns a channel that yields all blobs known to the index
+// grouped by pack, leaving out packs contained in packBlacklist. When the
+// context is cancelled, the background goroutine terminates. This blocks any
+// modification of the index.
+func (idx *Index) EachByPack(ctx context.Context, packBlacklist restic.IDSet) <-chan EachByPackResult {
+	idx.m.Lock()
+
+	ch := make(chan EachByPackResult)
+
+	go func() {
+		defer idx.m.Unlock()
+		defer func() {
+			close(ch)
+		}()
+
+		// a pack may be listed several times in merged indexes, only use the
+		// entries of the first occurrence
+		packIndex := make(map[restic.ID]int)
+		byPack := make(map[int][]restic.Blob)
+
+		for typ := range idx.byType {
+			m := &idx.byType[typ]
+			m.foreach(func(e *indexEntry) bool {
+				packID := idx.packs[e.packIndex]
+				if packBlacklist.Has(packID) {
+					return true
+				}
+				if first, ok := packIndex[packID]; ok && first != e.packIndex {
+					return true
+				}
+				packIndex[packID] = e.packIndex
+				byPack[e.packIndex] = append(byPack[e.packIndex], idx.toPackedBlob(e, restic.BlobType(typ)).Blob)
+				return true
+			})
+		}
+
+		for i, blobs := range byPack {
+			select {
+			case <-ctx.Done():
+				return
+			case ch <- EachByPackResult{PackID: idx.packs[i], Blobs: blobs}:
+			}
+		}
+	}()
+
+	return ch
+}
+
 // Packs returns all packs in this index
 func (idx *Index) Packs() restic.IDSet {
 	idx.m.Lock()
@@ -302,10 +360,11 @@ type packJSON struct {
 }
 
 type blobJSON struct {
-	ID     restic.ID       `json:"id"`
-	Type   restic.BlobType `json:"type"`
-	Offset uint            `json:"offset"`
-	Length uint            `json:"length"`
+	ID                 restic.ID       `json:"id"`
+	Type               restic.BlobType `json:"type"`
+	Offset             uint            `json:"offset"`
+	Length             uint            `json:"length"`
+	UncompressedLength uint            `json:"uncompressed_length,omitempty"`
 }
 
 // generatePackList returns a list of packs.
@@ -336,10 +395,11 @@ func (idx *Index) generatePackList() ([]*packJSON, error) {
 
 			// add blob
 			p.Blobs = append(p.Blobs, blobJSON{
-				ID:     e.id,
-				Type:   restic.BlobType(typ),
-				Offset: uint(e.offset),
-				Length: uint(e.length),
+				ID:                 e.id,
+				Type:               restic.BlobType(typ),
+				Offset:             uint(e.offset),
+				Length:             uint(e.length),
+				UncompressedLength: uint(e.uncompressedLength),
 			})
 
 			return true
@@ -481,7 +541,7 @@ func (idx *Index) merge(idx2 *Index) error {
 		m := &idx.byType[typ]
 		m2.foreach(func(entry *indexEntry) bool {
 			// packIndex is changed as idx2.pack is appended to idx.pack, see below
-			m.add(entry.id, entry.packIndex+packlen, entry.offset, entry.length)
+			m.add(entry.id, entry.packIndex+packlen, entry.offset, entry.length, entry.uncompressedLength)
 			return true
 		})
 	}
@@ -531,10 +591,11 @@ func DecodeIndex(buf []byte) (idx *Index, err error) {
 
 		for _, blob := range pack.Blobs {
 			idx.store(packID, restic.Blob{
-				Type:   blob.Type,
-				ID:     blob.ID,
-				Offset: blob.Offset,
-				Length: blob.Length,
+				Type:               blob.Type,
+				ID:                 blob.ID,
+				Offset:             blob.Offset,
+				Length:             blob.Length,
+				UncompressedLength: blob.UncompressedLength,
 			})
 
 			switch blob.Type {
@@ -574,10 +635,11 @@ func DecodeOldIndex(buf []byte) (idx *Index, err error) {
 
 		for _, blob := range pack.Blobs {
 			idx.store(packID, restic.Blob{
-				Type:   blob.Type,
-				ID:     blob.ID,
-				Offset: blob.Offset,
-				Length: blob.Length,
+				Type:               blob.Type,
+				ID:                 blob.ID,
+				Offset:             blob.Offset,
+				Length:             blob.Length,
+				UncompressedLength: blob.UncompressedLength,
 			})
 
 			switch blob.Type {
@@ -313,3 +313,68 @@ func (mi *MasterIndex) RebuildIndex(packBlacklist restic.IDSet) (*Index, error)
 
 	return newIndex, nil
 }
+
+// Save writes the contents of all known indexes to new index files, leaving
+// out any packs whose ID is contained in packBlacklist. The new index files
+// supersede all known final indexes, whose IDs are returned in obsolete. The
+// obsolete index files should be removed afterwards.
+func (mi *MasterIndex) Save(ctx context.Context, repo restic.Repository, packBlacklist restic.IDSet) (obsolete restic.IDSet, err error) {
+	mi.idxMutex.Lock()
+	defer mi.idxMutex.Unlock()
+
+	debug.Log("start rebuilding index of %d indexes, pack blacklist: %v", len(mi.idx), packBlacklist)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	obsolete = restic.NewIDSet()
+	newIndex := NewIndex()
+
+	save := func() error {
+		newIndex.Finalize()
+		id, err := SaveIndex(ctx, repo, newIndex)
+		if err != nil {
+			return err
+		}
+		debug.Log("saved new index as %v", id)
+		newIndex = NewIndex()
+		return nil
+	}
+
+	for i, idx := range mi.idx {
+		if idx.Final() {
+			ids, err := idx.IDs()
+			if err != nil {
+				debug.Log("index %d does not have an ID: %v", i, err)
+				return nil, err
+			}
+
+			debug.Log("adding index ids %v to supersedes field", ids)
+
+			err = newIndex.AddToSupersedes(ids...)
+			if err != nil {
+				return nil, err
+			}
+			obsolete.Merge(restic.NewIDSet(ids...))
+		} else {
+			debug.Log("index %d isn't final, don't add to supersedes field", i)
+		}
+
+		for pbs := range idx.EachByPack(ctx, packBlacklist) {
+			newIndex.StorePack(pbs.PackID, pbs.Blobs)
+			if IndexFull(newIndex) {
+				if err := save(); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	if newIndex.Count(restic.DataBlob) > 0 || newIndex.Count(restic.TreeBlob) > 0 || len(newIndex.Supersedes()) > 0 {
+		if err := save(); err != nil {
+			return nil, err
+		}
+	}
+
+	return obsolete, nil
+}
@@ -1,6 +1,7 @@
 package repository_test
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"testing"
@@ -109,6 +110,45 @@ func TestMasterMergeFinalIndexes(t *testing.T) {
 	rtest.Assert(t, blobs == nil, "Expected no blobs when fetching with a random id")
 }
 
+func TestMasterIndexSave(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	data := rtest.Random(23, 1000)
+	dataID, _, err := repo.SaveBlob(context.TODO(), restic.DataBlob, data, restic.ID{}, false)
+	rtest.OK(t, err)
+	tree := []byte(`{"nodes":[]}`)
+	treeID, _, err := repo.SaveBlob(context.TODO(), restic.TreeBlob, tree, restic.ID{}, false)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.Flush(context.TODO()))
+
+	blobs, found := repo.Index().Lookup(treeID, restic.TreeBlob)
+	rtest.Assert(t, found, "tree blob not found in index")
+	treePack := blobs[0].PackID
+
+	oldIndexes := restic.NewIDSet()
+	rtest.OK(t, repo.List(context.TODO(), restic.IndexFile, func(id restic.ID, size int64) error {
+		oldIndexes.Insert(id)
+		return nil
+	}))
+
+	mi := repo.Index().(*repository.MasterIndex)
+	obsolete, err := mi.Save(context.TODO(), repo, restic.NewIDSet(treePack))
+	rtest.OK(t, err)
+	rtest.Equals(t, oldIndexes, obsolete)
+
+	for id := range obsolete {
+		rtest.OK(t, repo.Backend().Remove(context.TODO(), restic.Handle{Type: restic.IndexFile, Name: id.String()}))
+	}
+
+	repo2 := repository.New(repo.Backend())
+	rtest.OK(t, repo2.SearchKey(context.TODO(), rtest.TestPassword, 1, ""))
+	rtest.OK(t, repo2.LoadIndex(context.TODO()))
+
+	rtest.Assert(t, repo2.Index().Has(dataID, restic.DataBlob), "data blob missing from saved index")
+	rtest.Assert(t, !repo2.Index().Has(treeID, restic.TreeBlob), "blacklisted tree blob still in saved index")
+}
+
 func createRandomMasterIndex(rng *rand.Rand, num, size int) (*repository.MasterIndex, restic.ID) {
 	mIdx := repository.NewMasterIndex()
 	for i := 0; i < num-1; i++ {
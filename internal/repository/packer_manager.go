@@ -37,7 +37,17 @@ type packerManager struct {
 	packers []*Packer
 }
 
-const minPackSize = 4 * 1024 * 1024
+const (
+	// MinPackSize is the smallest allowed target size for pack files.
+	MinPackSize = 4 * 1024 * 1024
+
+	// MaxPackSize is the largest allowed target size for pack files.
+	MaxPackSize = 128 * 1024 * 1024
+
+	// DefaultPackSize is the target size for pack files which is used if
+	// neither the repository config nor the user selects a different one.
+	DefaultPackSize = MinPackSize
+)
 
 // newPackerManager returns an new packer manager which writes temporary files
 // to a temporary directory
@@ -104,7 +114,12 @@ func (r *Repository) savePacker(ctx context.Context, t restic.BlobType, p *Packe
 		return err
 	}
 
-	err = r.be.Save(ctx, h, rd)
+	saveCtx := ctx
+	if t == restic.TreeBlob {
+		saveCtx = restic.WithMetadata(ctx)
+	}
+
+	err = r.be.Save(saveCtx, h, rd)
 	if err != nil {
 		debug.Log("Save(%v) error: %v", h, err)
 		return err
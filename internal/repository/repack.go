@@ -1,14 +1,13 @@
 package repository
 
 import (
+	"bufio"
 	"context"
-	"fmt"
-	"os"
+	"io"
+	"sort"
 
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
-	"github.com/restic/restic/internal/fs"
-	"github.com/restic/restic/internal/pack"
 	"github.com/restic/restic/internal/restic"
 )
 
@@ -16,78 +15,125 @@ import (
 // these packs. Each pack is loaded and the blobs listed in keepBlobs is saved
 // into a new pack. Returned is the list of obsolete packs which can then
 // be removed.
+//
+// The blobs are located using the index of repo. Only the part of a pack
+// which contains blobs to keep is downloaded, and it is processed while it is
+// read, so large packs are never stored in memory or in a temporary file.
 func Repack(ctx context.Context, repo restic.Repository, packs restic.IDSet, keepBlobs restic.BlobSet, p *restic.Progress) (obsoletePacks restic.IDSet, err error) {
 	debug.Log("repacking %d packs while keeping %d blobs", len(packs), len(keepBlobs))
 
-	for packID := range packs {
-		// load the complete pack into a temp file
-		h := restic.Handle{Type: restic.DataFile, Name: packID.String()}
+	// find the blobs to keep for each pack, a blob which is contained in
+	// several packs is only read once
+	packBlobs := make(map[restic.ID][]restic.Blob)
+	for h := range keepBlobs {
+		list, found := repo.Index().Lookup(h.ID, h.Type)
+		if !found {
+			continue
+		}
 
-		tempfile, hash, packLength, err := DownloadAndHash(ctx, repo.Backend(), h)
-		if err != nil {
-			return nil, errors.Wrap(err, "Repack")
+		for _, pb := range list {
+			if packs.Has(pb.PackID) {
+				packBlobs[pb.PackID] = append(packBlobs[pb.PackID], pb.Blob)
+				break
+			}
 		}
+	}
 
-		debug.Log("pack %v loaded (%d bytes), hash %v", packID, packLength, hash)
+	// packs in cold storage must be restored before they can be read
+	restorePacks := restic.NewIDSet()
+	for packID := range packBlobs {
+		restorePacks.Insert(packID)
+	}
+	if err := restic.RestorePacks(ctx, repo.Backend(), restorePacks); err != nil {
+		return nil, err
+	}
 
-		if !packID.Equal(hash) {
-			return nil, errors.Errorf("hash does not match id: want %v, got %v", packID, hash)
-		}
+	for packID := range packs {
+		blobs := packBlobs[packID]
+		debug.Log("processing pack %v, blobs: %v", packID, len(blobs))
 
-		_, err = tempfile.Seek(0, 0)
-		if err != nil {
-			return nil, errors.Wrap(err, "Seek")
+		if len(blobs) > 0 {
+			err = repackBlobs(ctx, repo, packID, blobs, keepBlobs)
+			if err != nil {
+				return nil, err
+			}
 		}
 
-		blobs, err := pack.List(repo.Key(), tempfile, packLength)
-		if err != nil {
-			return nil, err
+		if p != nil {
+			p.Report(restic.Stat{Blobs: 1})
 		}
+	}
 
-		debug.Log("processing pack %v, blobs: %v", packID, len(blobs))
+	if err := repo.Flush(ctx); err != nil {
+		return nil, err
+	}
+
+	return packs, nil
+}
+
+// repackBlobs loads the blobs from the pack packID and saves them again in the
+// repository. Only the range of the pack file which contains the blobs is
+// loaded. Saved blobs are removed from keepBlobs.
+func repackBlobs(ctx context.Context, repo restic.Repository, packID restic.ID, blobs []restic.Blob, keepBlobs restic.BlobSet) error {
+	sort.Slice(blobs, func(i, j int) bool {
+		return blobs[i].Offset < blobs[j].Offset
+	})
+
+	start := blobs[0].Offset
+	last := blobs[len(blobs)-1]
+	length := last.Offset + last.Length - start
+
+	h := restic.Handle{Type: restic.DataFile, Name: packID.String()}
+	err := repo.Backend().Load(ctx, h, int(length), int64(start), func(rd io.Reader) error {
+		bufRd := bufio.NewReaderSize(rd, 1024*1024)
+
+		pos := start
 		var buf []byte
 		for _, entry := range blobs {
-			h := restic.BlobHandle{ID: entry.ID, Type: entry.Type}
-			if !keepBlobs.Has(h) {
+			if entry.Offset < pos {
+				// duplicate index entry for a blob in the same pack
 				continue
 			}
 
-			debug.Log("  process blob %v", h)
+			if _, err := bufRd.Discard(int(entry.Offset - pos)); err != nil {
+				return errors.Wrap(err, "Discard")
+			}
 
-			buf = buf[:]
-			if uint(len(buf)) < entry.Length {
+			if uint(cap(buf)) < entry.Length {
 				buf = make([]byte, entry.Length)
 			}
 			buf = buf[:entry.Length]
 
-			n, err := tempfile.ReadAt(buf, int64(entry.Offset))
-			if err != nil {
-				return nil, errors.Wrap(err, "ReadAt")
+			if _, err := io.ReadFull(bufRd, buf); err != nil {
+				return errors.Wrap(err, "ReadFull")
 			}
+			pos = entry.Offset + entry.Length
 
-			if n != len(buf) {
-				return nil, errors.Errorf("read blob %v from %v: not enough bytes read, want %v, got %v",
-					h, tempfile.Name(), len(buf), n)
+			h := restic.BlobHandle{ID: entry.ID, Type: entry.Type}
+			if !keepBlobs.Has(h) {
+				// the blob has already been saved, the load was retried
+				continue
 			}
 
-			nonce, ciphertext := buf[:repo.Key().NonceSize()], buf[repo.Key().NonceSize():]
-			plaintext, err := repo.Key().Open(ciphertext[:0], nonce, ciphertext, nil)
+			debug.Log("  process blob %v", h)
+
+			plaintext, err := DecryptBlob(repo.Key(), entry, buf)
 			if err != nil {
-				return nil, err
+				return err
 			}
 
 			id := restic.Hash(plaintext)
 			if !id.Equal(entry.ID) {
 				debug.Log("read blob %v/%v from %v: wrong data returned, hash is %v",
-					h.Type, h.ID, tempfile.Name(), id)
-				fmt.Fprintf(os.Stderr, "read blob %v from %v: wrong data returned, hash is %v",
-					h, tempfile.Name(), id)
+					h.Type, h.ID, packID, id)
+				return errors.Errorf("read blob %v from %v: wrong data returned, hash is %v",
+					h, packID.Str(), id)
 			}
 
 			// We do want to save already saved blobs!
 			_, _, err = repo.SaveBlob(ctx, entry.Type, plaintext, entry.ID, true)
 			if err != nil {
-				return nil, err
+				return err
 			}
 
 			debug.Log("  saved blob %v", entry.ID)
@@ -95,21 +141,8 @@ func Repack(ctx context.Context, repo restic.Repository, packs restic.IDSet, kee
 			keepBlobs.Delete(h)
 		}
 
-		if err = tempfile.Close(); err != nil {
-			return nil, errors.Wrap(err, "Close")
-		}
-
-		if err = fs.RemoveIfExists(tempfile.Name()); err != nil {
-			return nil, errors.Wrap(err, "Remove")
-		}
-		if p != nil {
-			p.Report(restic.Stat{Blobs: 1})
-		}
-	}
-
-	if err := repo.Flush(ctx); err != nil {
-		return nil, err
-	}
+		return nil
+	})
 
-	return packs, nil
+	return errors.Wrap(err, "Repack")
 }
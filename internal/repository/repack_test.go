@@ -2,9 +2,13 @@ package repository_test
 
 import (
 	"context"
+	"io"
 	"math/rand"
+	"sync"
 	"testing"
 
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/index"
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
@@ -239,3 +243,59 @@ func TestRepack(t *testing.T) {
 		}
 	}
 }
+
+// coldBackend keeps all data files in cold storage once cold is set, they
+// can only be loaded after they have been restored.
+type coldBackend struct {
+	restic.Backend
+
+	m        sync.Mutex
+	cold     bool
+	restored restic.IDSet
+}
+
+func (be *coldBackend) Restore(ctx context.Context, handles []restic.Handle) error {
+	be.m.Lock()
+	defer be.m.Unlock()
+
+	for _, h := range handles {
+		id, err := restic.ParseID(h.Name)
+		if err != nil {
+			return err
+		}
+		be.restored.Insert(id)
+	}
+	return nil
+}
+
+func (be *coldBackend) Load(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+	if h.Type == restic.DataFile {
+		be.m.Lock()
+		cold := be.cold && !be.restored.Has(restic.TestParseID(h.Name))
+		be.m.Unlock()
+
+		if cold {
+			return errors.Errorf("pack %v is in cold storage", h.Name)
+		}
+	}
+	return be.Backend.Load(ctx, h, length, offset, fn)
+}
+
+func TestRepackColdStorage(t *testing.T) {
+	be := &coldBackend{Backend: mem.New(), restored: restic.NewIDSet()}
+	repo, cleanup := repository.TestRepositoryWithBackend(t, be)
+	defer cleanup()
+
+	createRandomBlobs(t, repo, 100, 0.7)
+	saveIndex(t, repo)
+
+	removeBlobs, keepBlobs := selectBlobs(t, repo, 0.2)
+	removePacks := findPacksForBlobs(t, repo, removeBlobs)
+
+	be.m.Lock()
+	be.cold = true
+	be.m.Unlock()
+
+	// repack fails if a pack is loaded before it has been restored
+	repack(t, repo, removePacks, keepBlobs)
+}
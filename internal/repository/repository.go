@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sync"
 
 	"github.com/restic/restic/internal/cache"
 	"github.com/restic/restic/internal/crypto"
@@ -17,7 +18,9 @@ import (
 	"github.com/restic/restic/internal/pack"
 	"github.com/restic/restic/internal/restic"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/minio/sha256-simd"
+	"github.com/restic/chunker"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -33,6 +36,12 @@ type Repository struct {
 
 	treePM *packerManager
 	dataPM *packerManager
+
+	compression CompressionMode
+	enc         *zstd.Encoder
+	encOnce     sync.Once
+
+	packSize uint
 }
 
 // New returns a new repository with backend be.
@@ -51,6 +60,45 @@ func (r *Repository) DisableAutoIndexUpdate() {
 	r.noAutoIndexUpdate = true
 }
 
+// SetCompression configures how blobs are compressed. It must be called
+// before any blobs are saved. Blobs are never compressed in repositories with
+// version 1.
+func (r *Repository) SetCompression(mode CompressionMode) {
+	r.compression = mode
+}
+
+// SetPackSize overrides the target size for new pack files, which is read
+// from the repository config otherwise. A size of zero restores the default.
+func (r *Repository) SetPackSize(size uint) error {
+	if size != 0 {
+		if err := checkPackSize(size); err != nil {
+			return err
+		}
+	}
+	r.packSize = size
+	return nil
+}
+
+// PackSize returns the target size for new pack files.
+func (r *Repository) PackSize() uint {
+	if r.packSize != 0 {
+		return r.packSize
+	}
+	if r.cfg.MinPackSize != 0 {
+		return r.cfg.MinPackSize
+	}
+	return DefaultPackSize
+}
+
+// checkPackSize returns an error if size is not a valid pack size.
+func checkPackSize(size uint) error {
+	if size < MinPackSize || size > MaxPackSize {
+		return errors.Fatalf("pack size must be between %d MiB and %d MiB",
+			MinPackSize/(1024*1024), MaxPackSize/(1024*1024))
+	}
+	return nil
+}
+
 // Config returns the repository configuration.
 func (r *Repository) Config() restic.Config {
 	return r.cfg
@@ -183,9 +231,8 @@ func (r *Repository) LoadBlob(ctx context.Context, t restic.BlobType, id restic.
 			continue
 		}
 
-		// decrypt
-		nonce, ciphertext := buf[:r.key.NonceSize()], buf[r.key.NonceSize():]
-		plaintext, err := r.key.Open(ciphertext[:0], nonce, ciphertext, nil)
+		// decrypt and decompress
+		plaintext, err := DecryptBlob(r.key, blob.Blob, buf)
 		if err != nil {
 			lastError = errors.Errorf("decrypting blob %v failed: %v", id, err)
 			continue
@@ -197,9 +244,14 @@ func (r *Repository) LoadBlob(ctx context.Context, t restic.BlobType, id restic.
 			continue
 		}
 
+		if len(plaintext) > cap(buf) {
+			return plaintext, nil
+		}
+
 		// move decrypted data to the start of the buffer
+		buf = buf[:len(plaintext)]
 		copy(buf, plaintext)
-		return buf[:len(plaintext)], nil
+		return buf, nil
 	}
 
 	if lastError != nil {
@@ -226,11 +278,21 @@ func (r *Repository) LookupBlobSize(id restic.ID, tpe restic.BlobType) (uint, bo
 }
 
 // SaveAndEncrypt encrypts data and stores it to the backend as type t. If data
-// is small enough, it will be packed together with other small blobs.
+// is small enough, it will be packed together with other small blobs. If the
+// repository supports it, data is compressed before it is encrypted.
 // The caller must ensure that the id matches the data.
 func (r *Repository) SaveAndEncrypt(ctx context.Context, t restic.BlobType, data []byte, id restic.ID) error {
 	debug.Log("save id %v (%v, %d bytes)", id, t, len(data))
 
+	uncompressedLength := 0
+	if r.cfg.Version > 1 && r.compression != CompressionOff {
+		if compressed := r.compress(data); compressed != nil {
+			debug.Log("compressed %v from %d to %d bytes", id, len(data), len(compressed))
+			uncompressedLength = len(data)
+			data = compressed
+		}
+	}
+
 	nonce := crypto.NewRandomNonce()
 
 	ciphertext := make([]byte, 0, restic.CiphertextLength(len(data)))
@@ -257,13 +319,13 @@ func (r *Repository) SaveAndEncrypt(ctx context.Context, t restic.BlobType, data
 	}
 
 	// save ciphertext
-	_, err = packer.Add(t, id, ciphertext)
+	_, err = packer.Add(t, id, ciphertext, uncompressedLength)
 	if err != nil {
 		return err
 	}
 
 	// if the pack is not full enough, put back to the list
-	if packer.Size() < minPackSize {
+	if packer.Size() < r.PackSize() && !packer.HeaderFull() {
 		debug.Log("pack is not full enough (%d bytes)", packer.Size())
 		pm.insertPacker(packer)
 		return nil
@@ -397,6 +459,10 @@ func (r *Repository) saveIndex(ctx context.Context, indexes ...*Index) error {
 		}
 
 		debug.Log("Saved index %d as %v", i, sid)
+
+		if err := idx.SetID(sid); err != nil {
+			return err
+		}
 	}
 	r.idx.MergeFinalIndexes()
 
@@ -602,12 +668,19 @@ func (r *Repository) SearchKey(ctx context.Context, password string, maxKeys int
 	if err != nil {
 		return errors.Fatalf("config cannot be loaded: %v", err)
 	}
+	if r.cfg.MinPackSize != 0 {
+		if err = checkPackSize(r.cfg.MinPackSize); err != nil {
+			return errors.Fatalf("config contains invalid pack size %d", r.cfg.MinPackSize)
+		}
+	}
 	return nil
 }
 
 // Init creates a new master key with the supplied password, initializes and
-// saves the repository config.
-func (r *Repository) Init(ctx context.Context, password string) error {
+// saves the repository config for the given repository version. If
+// chunkerPolynomial is not nil, it is used instead of a random polynomial. A
+// pack size set before with SetPackSize is stored in the config.
+func (r *Repository) Init(ctx context.Context, version uint, password string, chunkerPolynomial *chunker.Pol) error {
 	has, err := r.be.Test(ctx, restic.Handle{Type: restic.ConfigFile})
 	if err != nil {
 		return err
@@ -616,10 +689,14 @@ func (r *Repository) Init(ctx context.Context, password string) error {
 		return errors.New("repository master key and config already initialized")
 	}
 
-	cfg, err := restic.CreateConfig()
+	cfg, err := restic.CreateConfig(version)
 	if err != nil {
 		return err
 	}
+	if chunkerPolynomial != nil {
+		cfg.ChunkerPolynomial = *chunkerPolynomial
+	}
+	cfg.MinPackSize = r.packSize
 
 	return r.init(ctx, password, cfg)
 }
@@ -789,3 +866,16 @@ func DownloadAndHash(ctx context.Context, be Loader, h restic.Handle) (tmpfile *
 
 	return tmpfile, hash, size, err
 }
+
+var zeroChunkOnce sync.Once
+var zeroChunkID restic.ID
+
+// ZeroChunk computes and returns (cached) the ID of an all-zero chunk with size chunker.MinSize.
+// The chunker cuts long runs of zero bytes into chunks of exactly this size, so
+// all of them are stored as a single blob with this ID.
+func ZeroChunk() restic.ID {
+	zeroChunkOnce.Do(func() {
+		zeroChunkID = restic.Hash(make([]byte, chunker.MinSize))
+	})
+	return zeroChunkID
+}
@@ -4,15 +4,20 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
 	"io"
 	"math/rand"
 	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/restic/restic/internal/archiver"
+	"github.com/restic/restic/internal/backend/mem"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/pack"
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 	rtest "github.com/restic/restic/internal/test"
@@ -57,6 +62,151 @@ func TestSave(t *testing.T) {
 	}
 }
 
+func TestSaveCompressed(t *testing.T) {
+	for _, version := range []uint{1, 2} {
+		t.Run(fmt.Sprintf("v%d", version), func(t *testing.T) {
+			repo, cleanup := repository.TestRepositoryWithVersion(t, version)
+			defer cleanup()
+
+			for _, test := range []struct {
+				buf          []byte
+				compressible bool
+			}{
+				{bytes.Repeat([]byte("compressible data "), 100000), true},
+				// random data cannot be compressed and is stored as is
+				{rtest.Random(23, 100000), false},
+			} {
+				buf := test.buf
+				id, _, err := repo.SaveBlob(context.TODO(), restic.DataBlob, buf, restic.ID{}, false)
+				rtest.OK(t, err)
+				rtest.OK(t, repo.Flush(context.Background()))
+
+				blobs, found := repo.Index().Lookup(id, restic.DataBlob)
+				rtest.Assert(t, found, "blob %v not found in index", id.Str())
+
+				rtest.Equals(t, version >= 2 && test.compressible, blobs[0].IsCompressed())
+				rtest.Equals(t, uint(len(buf)), blobs[0].DataLength())
+
+				size, found := repo.LookupBlobSize(id, restic.DataBlob)
+				rtest.Assert(t, found, "blob %v not found in index", id.Str())
+				rtest.Equals(t, uint(len(buf)), size)
+
+				loaded, err := repo.LoadBlob(context.TODO(), restic.DataBlob, id, nil)
+				rtest.OK(t, err)
+				rtest.Assert(t, bytes.Equal(buf, loaded), "loaded data does not match")
+			}
+		})
+	}
+}
+
+func TestPackSize(t *testing.T) {
+	r, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	repo := r.(*repository.Repository)
+
+	rtest.Equals(t, uint(repository.DefaultPackSize), repo.PackSize())
+
+	for _, size := range []uint{1, repository.MinPackSize - 1, repository.MaxPackSize + 1} {
+		rtest.Assert(t, repo.SetPackSize(size) != nil, "expected error for pack size %v", size)
+	}
+
+	countPacks := func() (n int) {
+		rtest.OK(t, repo.List(context.TODO(), restic.DataFile, func(restic.ID, int64) error {
+			n++
+			return nil
+		}))
+		return n
+	}
+
+	// save 12 MiB of data, which is split into several packs by default
+	saveBlobs := func() {
+		for i := 0; i < 12; i++ {
+			_, _, err := repo.SaveBlob(context.TODO(), restic.DataBlob, rtest.Random(rnd.Int(), 1<<20), restic.ID{}, false)
+			rtest.OK(t, err)
+		}
+		rtest.OK(t, repo.Flush(context.TODO()))
+	}
+
+	saveBlobs()
+	packs := countPacks()
+	rtest.Assert(t, packs >= 3, "expected at least 3 packs, got %v", packs)
+
+	rtest.OK(t, repo.SetPackSize(16*1024*1024))
+	rtest.Equals(t, uint(16*1024*1024), repo.PackSize())
+
+	saveBlobs()
+	rtest.Equals(t, packs+1, countPacks())
+}
+
+func TestPackHeaderSize(t *testing.T) {
+	r, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	repo := r.(*repository.Repository)
+	rtest.OK(t, repo.SetPackSize(repository.MaxPackSize))
+
+	// tiny blobs fill the pack header long before the pack reaches its target
+	// size, the header must still stay small enough to be read back
+	const blobs = 500000
+	buf := make([]byte, 8)
+	for i := 0; i < blobs; i++ {
+		binary.LittleEndian.PutUint64(buf, uint64(i))
+		_, _, err := repo.SaveBlob(context.TODO(), restic.DataBlob, buf, restic.ID{}, false)
+		rtest.OK(t, err)
+	}
+	rtest.OK(t, repo.Flush(context.TODO()))
+
+	packs, n := 0, 0
+	rtest.OK(t, repo.List(context.TODO(), restic.DataFile, func(id restic.ID, size int64) error {
+		h := restic.Handle{Type: restic.DataFile, Name: id.String()}
+		entries, err := pack.List(repo.Key(), restic.ReaderAt(repo.Backend(), h), size)
+		if err != nil {
+			return err
+		}
+		packs++
+		n += len(entries)
+		return nil
+	}))
+	rtest.Assert(t, packs >= 2, "expected at least 2 packs, got %v", packs)
+	rtest.Equals(t, blobs, n)
+}
+
+// metadataBackend records which data files are saved with restic.WithMetadata.
+type metadataBackend struct {
+	restic.Backend
+
+	m        sync.Mutex
+	metadata map[string]bool
+}
+
+func (be *metadataBackend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	if h.Type == restic.DataFile {
+		be.m.Lock()
+		be.metadata[h.Name] = restic.IsMetadata(ctx)
+		be.m.Unlock()
+	}
+	return be.Backend.Save(ctx, h, rd)
+}
+
+func TestSaveMetadataPacks(t *testing.T) {
+	be := &metadataBackend{Backend: mem.New(), metadata: make(map[string]bool)}
+	repo, cleanup := repository.TestRepositoryWithBackend(t, be)
+	defer cleanup()
+
+	dataID, _, err := repo.SaveBlob(context.TODO(), restic.DataBlob, []byte("foo"), restic.ID{}, false)
+	rtest.OK(t, err)
+	treeID, err := repo.SaveTree(context.TODO(), restic.NewTree())
+	rtest.OK(t, err)
+	rtest.OK(t, repo.Flush(context.TODO()))
+
+	for _, blob := range []restic.BlobHandle{{ID: dataID, Type: restic.DataBlob}, {ID: treeID, Type: restic.TreeBlob}} {
+		pbs, found := repo.Index().Lookup(blob.ID, blob.Type)
+		rtest.Assert(t, found && len(pbs) == 1, "blob %v not found in the index", blob)
+		rtest.Equals(t, blob.Type == restic.TreeBlob, be.metadata[pbs[0].PackID.String()])
+	}
+}
+
 func TestSaveFrom(t *testing.T) {
 	repo, cleanup := repository.TestRepository(t)
 	defer cleanup()
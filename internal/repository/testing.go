@@ -42,6 +42,18 @@ const testChunkerPol = chunker.Pol(0x3DA3358B4DC173)
 // password. If be is nil, an in-memory backend is used. A constant polynomial
 // is used for the chunker and low-security test parameters.
 func TestRepositoryWithBackend(t testing.TB, be restic.Backend) (r restic.Repository, cleanup func()) {
+	t.Helper()
+	return testRepositoryWithBackend(t, be, restic.StableRepoVersion)
+}
+
+// TestRepositoryWithVersion returns a repository with the given format
+// version, initialized with a test password on an in-memory backend.
+func TestRepositoryWithVersion(t testing.TB, version uint) (r restic.Repository, cleanup func()) {
+	t.Helper()
+	return testRepositoryWithBackend(t, nil, version)
+}
+
+func testRepositoryWithBackend(t testing.TB, be restic.Backend, version uint) (r restic.Repository, cleanup func()) {
 	t.Helper()
 	TestUseLowSecurityKDFParameters(t)
 	restic.TestDisableCheckPolynomial(t)
@@ -53,7 +65,7 @@ func TestRepositoryWithBackend(t testing.TB, be restic.Backend) (r restic.Reposi
 
 	repo := New(be)
 
-	cfg := restic.TestCreateConfig(t, testChunkerPol)
+	cfg := restic.TestCreateConfig(t, testChunkerPol, version)
 	err := repo.init(context.TODO(), test.TestPassword, cfg)
 	if err != nil {
 		t.Fatalf("TestRepository(): initialize repo failed: %v", err)
@@ -54,6 +54,30 @@ type Backend interface {
 	Delete(ctx context.Context) error
 }
 
+// BackendUnwrapper is implemented by backends which wrap another backend.
+type BackendUnwrapper interface {
+	// Unwrap returns the wrapped backend.
+	Unwrap() Backend
+}
+
+// findBackend returns be or the first backend wrapped by be for which fn
+// returns true.
+func findBackend(be Backend, fn func(Backend) bool) Backend {
+	for be != nil {
+		if fn(be) {
+			return be
+		}
+
+		u, ok := be.(BackendUnwrapper)
+		if !ok {
+			break
+		}
+		be = u.Unwrap()
+	}
+
+	return nil
+}
+
 // FileInfo is contains information about a file in the backend.
 type FileInfo struct {
 	Size int64
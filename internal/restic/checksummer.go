@@ -0,0 +1,41 @@
+package restic
+
+import "context"
+
+// Checksummer is implemented by backends which can provide the SHA-256 hash
+// of a file without downloading it, either because the server computes it
+// or because the hash has been verified by the server during the upload.
+type Checksummer interface {
+	// Checksum returns the SHA-256 hash of the file described by h. If the
+	// backend has no hash for the file, ok is false.
+	Checksum(ctx context.Context, h Handle) (id ID, ok bool, err error)
+}
+
+// AsChecksummer returns be or the first backend wrapped by be which
+// implements Checksummer.
+func AsChecksummer(be Backend) (Checksummer, bool) {
+	cs, ok := findBackend(be, func(be Backend) bool {
+		_, ok := be.(Checksummer)
+		return ok
+	}).(Checksummer)
+	return cs, ok
+}
+
+// ChecksumResult is the result of BatchChecksummer.Checksums for a single
+// file.
+type ChecksumResult struct {
+	ID  ID
+	OK  bool
+	Err error
+}
+
+// BatchChecksummer is implemented by Checksummers which can compute the
+// hashes of several files at once more efficiently than one at a time.
+type BatchChecksummer interface {
+	Checksummer
+
+	// Checksums returns the SHA-256 hashes of the files described by
+	// handles, in the same order. Errors for individual files are returned
+	// in the results, err is only set if no hash could be computed at all.
+	Checksums(ctx context.Context, handles []Handle) (results []ChecksumResult, err error)
+}
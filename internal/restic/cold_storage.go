@@ -0,0 +1,77 @@
+package restic
+
+import "context"
+
+// ColdStorage is implemented by backends which may keep files in a cold
+// storage tier. Such files must be restored before they can be loaded.
+type ColdStorage interface {
+	// Restore requests the files to be restored from cold storage and blocks
+	// until all of them can be loaded. Files which are not in cold storage
+	// are skipped.
+	Restore(ctx context.Context, handles []Handle) error
+}
+
+type metadataKey struct{}
+
+// WithMetadata returns a context for saving a data file which only contains
+// tree blobs. Backends with a cold storage tier keep such files in the
+// default storage, so that they can always be loaded.
+func WithMetadata(ctx context.Context) context.Context {
+	return context.WithValue(ctx, metadataKey{}, true)
+}
+
+// IsMetadata returns true if ctx has been returned by WithMetadata.
+func IsMetadata(ctx context.Context) bool {
+	v, _ := ctx.Value(metadataKey{}).(bool)
+	return v
+}
+
+// AsColdStorage returns be or the first backend wrapped by be which
+// implements ColdStorage.
+func AsColdStorage(be Backend) (ColdStorage, bool) {
+	cs, ok := findBackend(be, func(be Backend) bool {
+		_, ok := be.(ColdStorage)
+		return ok
+	}).(ColdStorage)
+	return cs, ok
+}
+
+// RestorePacks makes sure that the packs can be loaded from be. When be
+// keeps data files in cold storage, the packs are restored first, which may
+// take several hours. For other backends, nothing is done.
+func RestorePacks(ctx context.Context, be Backend, packs IDSet) error {
+	cs, ok := AsColdStorage(be)
+	if !ok || len(packs) == 0 {
+		return nil
+	}
+
+	handles := make([]Handle, 0, len(packs))
+	for id := range packs {
+		handles = append(handles, Handle{Type: DataFile, Name: id.String()})
+	}
+
+	return cs.Restore(ctx, handles)
+}
+
+// RestoreBlobs makes sure that the packs which contain the data blobs in
+// blobs can be loaded from the backend of repo, see RestorePacks. Tree blobs
+// are never stored in cold storage and are ignored.
+func RestoreBlobs(ctx context.Context, repo Repository, blobs BlobSet) error {
+	if _, ok := AsColdStorage(repo.Backend()); !ok {
+		return nil
+	}
+
+	packs := NewIDSet()
+	for h := range blobs {
+		if h.Type != DataBlob {
+			continue
+		}
+
+		list, found := repo.Index().Lookup(h.ID, h.Type)
+		if found {
+			packs.Insert(list[0].PackID)
+		}
+	}
+
+	return RestorePacks(ctx, repo.Backend(), packs)
+}
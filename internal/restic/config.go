@@ -16,11 +16,24 @@ type Config struct {
 	Version           uint        `json:"version"`
 	ID                string      `json:"id"`
 	ChunkerPolynomial chunker.Pol `json:"chunker_polynomial"`
+
+	// MinPackSize is the target size of new pack files in bytes, zero means
+	// that the default size is used.
+	MinPackSize uint `json:"min_pack_size,omitempty"`
 }
 
-// RepoVersion is the version that is written to the config when a repository
-// is newly created with Init().
-const RepoVersion = 1
+const (
+	// MinRepoVersion is the oldest repository version that can be read.
+	MinRepoVersion = 1
+
+	// MaxRepoVersion is the newest repository version that can be read.
+	// Repository version 2 adds support for compressed blobs.
+	MaxRepoVersion = 2
+
+	// StableRepoVersion is the version that is written to the config when a
+	// repository is newly created with Init().
+	StableRepoVersion = 2
+)
 
 // JSONUnpackedLoader loads unpacked JSON.
 type JSONUnpackedLoader interface {
@@ -28,31 +41,35 @@ type JSONUnpackedLoader interface {
 }
 
 // CreateConfig creates a config file with a randomly selected polynomial and
-// ID.
-func CreateConfig() (Config, error) {
+// ID for the given repository version.
+func CreateConfig(version uint) (Config, error) {
 	var (
 		err error
 		cfg Config
 	)
 
+	if version < MinRepoVersion || version > MaxRepoVersion {
+		return Config{}, errors.Errorf("unsupported repository version %v", version)
+	}
+
 	cfg.ChunkerPolynomial, err = chunker.RandomPolynomial()
 	if err != nil {
 		return Config{}, errors.Wrap(err, "chunker.RandomPolynomial")
 	}
 
 	cfg.ID = NewRandomID().String()
-	cfg.Version = RepoVersion
+	cfg.Version = version
 
 	debug.Log("New config: %#v", cfg)
 	return cfg, nil
 }
 
 // TestCreateConfig creates a config for use within tests.
-func TestCreateConfig(t testing.TB, pol chunker.Pol) (cfg Config) {
+func TestCreateConfig(t testing.TB, pol chunker.Pol, version uint) (cfg Config) {
 	cfg.ChunkerPolynomial = pol
 
 	cfg.ID = NewRandomID().String()
-	cfg.Version = RepoVersion
+	cfg.Version = version
 
 	return cfg
 }
@@ -77,8 +94,8 @@ func LoadConfig(ctx context.Context, r JSONUnpackedLoader) (Config, error) {
 		return Config{}, err
 	}
 
-	if cfg.Version != RepoVersion {
-		return Config{}, errors.New("unsupported repository version")
+	if cfg.Version < MinRepoVersion || cfg.Version > MaxRepoVersion {
+		return Config{}, errors.Errorf("unsupported repository version %v", cfg.Version)
 	}
 
 	if checkPolynomial {
@@ -35,6 +35,7 @@ type Repository interface {
 	ListPack(context.Context, ID, int64) ([]Blob, int64, error)
 
 	Flush(context.Context) error
+	FlushPacks(context.Context) error
 
 	SaveUnpacked(context.Context, FileType, []byte) (ID, error)
 	SaveJSONUnpacked(context.Context, FileType, interface{}) (ID, error)
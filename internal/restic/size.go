@@ -0,0 +1,49 @@
+package restic
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// ParseSize parses a size with an optional unit suffix (b, k, m, g or t, based
+// on 1024) and returns the number of bytes.
+func ParseSize(s string) (int64, error) {
+	if s == "" {
+		return 0, errors.New("expected size, got empty string")
+	}
+
+	numStr := s[:len(s)-1]
+	var unit int64 = 1
+
+	switch s[len(s)-1] {
+	case 'b', 'B':
+		// use initialized values, do nothing here
+	case 'k', 'K':
+		unit = 1024
+	case 'm', 'M':
+		unit = 1024 * 1024
+	case 'g', 'G':
+		unit = 1024 * 1024 * 1024
+	case 't', 'T':
+		unit = 1024 * 1024 * 1024 * 1024
+	default:
+		numStr = s
+	}
+
+	value, err := strconv.ParseInt(numStr, 10, 64)
+	if err != nil {
+		return 0, errors.Errorf("invalid size %q", s)
+	}
+
+	if value < 0 {
+		return 0, errors.Errorf("invalid size %q, must not be negative", s)
+	}
+
+	if value > math.MaxInt64/unit {
+		return 0, errors.Errorf("invalid size %q, too large", s)
+	}
+
+	return value * unit, nil
+}
@@ -0,0 +1,56 @@
+package restic_test
+
+import (
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestParseSize(t *testing.T) {
+	sizeStrTests := []struct {
+		in       string
+		expected int64
+	}{
+		{"1024", 1024},
+		{"1024b", 1024},
+		{"1024B", 1024},
+		{"1k", 1024},
+		{"100k", 102400},
+		{"100K", 102400},
+		{"10M", 10485760},
+		{"100m", 104857600},
+		{"20G", 21474836480},
+		{"10g", 10737418240},
+		{"2T", 2199023255552},
+		{"2t", 2199023255552},
+		{"8388607T", 9223370937343148032},
+		{"9223372036854775807", 9223372036854775807},
+	}
+
+	for _, tt := range sizeStrTests {
+		actual, err := restic.ParseSize(tt.in)
+		rtest.OK(t, err)
+		rtest.Equals(t, tt.expected, actual)
+	}
+}
+
+func TestParseInvalidSize(t *testing.T) {
+	invalidSizes := []string{
+		"",
+		" ",
+		"foobar",
+		"zzz",
+		"-1k",
+		"8388608T",
+		"9223372036854775808",
+		"1x",
+	}
+
+	for _, s := range invalidSizes {
+		v, err := restic.ParseSize(s)
+		if err == nil {
+			t.Errorf("no error returned for %q, value %v", s, v)
+		}
+	}
+}
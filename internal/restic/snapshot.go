@@ -24,9 +24,16 @@ type Snapshot struct {
 	Tags     []string  `json:"tags,omitempty"`
 	Original *ID       `json:"original,omitempty"`
 
+	// Partial is set for snapshots which were written while a backup was
+	// still running, they do not contain all files.
+	Partial bool `json:"partial,omitempty"`
+
 	id *ID // plaintext ID, used during restore
 }
 
+// PartialSnapshotTag is added to the tags of partial snapshots.
+const PartialSnapshotTag = "partial"
+
 // NewSnapshot returns an initialized snapshot struct for the current user and
 // time.
 func NewSnapshot(paths []string, tags []string, hostname string, time time.Time) (*Snapshot, error) {
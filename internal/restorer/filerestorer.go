@@ -5,12 +5,14 @@ import (
 	"context"
 	"io"
 	"math"
+	"os"
 	"path/filepath"
 	"sync"
 
 	"github.com/restic/restic/internal/crypto"
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 )
 
@@ -35,6 +37,12 @@ type fileInfo struct {
 	flags    int
 	location string      // file on local filesystem relative to restorer basedir
 	blobs    interface{} // blobs of the file
+	size     int64       // size of the file
+
+	// existing files are updated in place, the blobs at the offsets in
+	// unchanged are already contained in the file
+	inplace   bool
+	unchanged map[int64]struct{}
 }
 
 type fileBlobInfo struct {
@@ -54,8 +62,20 @@ type fileRestorer struct {
 	idx        func(restic.ID, restic.BlobType) ([]restic.PackedBlob, bool)
 	packLoader func(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error
 
+	// restorePacks, if set, is called with all packs before they are
+	// downloaded, e.g. to restore them from cold storage
+	restorePacks func(ctx context.Context, packs restic.IDSet) error
+
 	filesWriter *filesWriter
 
+	// progress, if set, is notified about the restored data
+	progress Progress
+
+	// sparse files are restored by skipping all-zero blobs, which are
+	// recognized by zeroChunk
+	sparse    bool
+	zeroChunk restic.ID
+
 	dst   string
 	files []*fileInfo
 }
@@ -63,19 +83,46 @@ type fileRestorer struct {
 func newFileRestorer(dst string,
 	packLoader func(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error,
 	key *crypto.Key,
-	idx func(restic.ID, restic.BlobType) ([]restic.PackedBlob, bool)) *fileRestorer {
+	idx func(restic.ID, restic.BlobType) ([]restic.PackedBlob, bool),
+	sparse bool) *fileRestorer {
 
 	return &fileRestorer{
 		key:         key,
 		idx:         idx,
 		packLoader:  packLoader,
 		filesWriter: newFilesWriter(workerCount),
+		sparse:      sparse,
+		zeroChunk:   repository.ZeroChunk(),
 		dst:         dst,
 	}
 }
 
-func (r *fileRestorer) addFile(location string, content restic.IDs) {
-	r.files = append(r.files, &fileInfo{location: location, blobs: content})
+func (r *fileRestorer) addFile(location string, content restic.IDs, size int64) {
+	r.files = append(r.files, &fileInfo{location: location, blobs: content, size: size})
+}
+
+// updateFile adds an existing file which is updated in place. Only the blobs
+// which are not at their offset in unchanged are written to the file.
+func (r *fileRestorer) updateFile(location string, content restic.IDs, size int64, unchanged map[int64]struct{}) {
+	r.files = append(r.files, &fileInfo{location: location, blobs: content, size: size, inplace: true, unchanged: unchanged})
+}
+
+// reportProgress reports that bytes of the file have been restored.
+func (r *fileRestorer) reportProgress(file *fileInfo, bytes uint64) {
+	if r.progress != nil {
+		r.progress.AddProgress(file.location, bytes)
+	}
+}
+
+// skipBlob returns true if the blob at offset does not need to be written to
+// the file, either because the file already contains it or because it
+// consists of zero bytes only and the file is restored as a sparse file.
+func (r *fileRestorer) skipBlob(file *fileInfo, id restic.ID, offset int64) bool {
+	if file.inplace {
+		_, ok := file.unchanged[offset]
+		return ok
+	}
+	return r.sparse && id.Equal(r.zeroChunk)
 }
 
 func (r *fileRestorer) targetPath(location string) string {
@@ -102,6 +149,23 @@ func (r *fileRestorer) restoreFiles(ctx context.Context) error {
 
 	packs := make(map[restic.ID]*packInfo) // all packs
 
+	// files which consist of holes only, they are not contained in any pack
+	var holeFiles []*fileInfo
+
+	// existing files are truncated to their final size, after that they are
+	// handled like files which have already been created
+	for _, file := range r.files {
+		if !file.inplace {
+			continue
+		}
+
+		err := os.Truncate(r.targetPath(file.location), file.size)
+		if err != nil {
+			return errors.Wrap(err, "Truncate")
+		}
+		file.flags |= fileProgress
+	}
+
 	// create packInfo from fileInfo
 	for _, file := range r.files {
 		fileBlobs := file.blobs.(restic.IDs)
@@ -111,10 +175,17 @@ func (r *fileRestorer) restoreFiles(ctx context.Context) error {
 			packsMap = make(map[restic.ID][]fileBlobInfo)
 		}
 		fileOffset := int64(0)
+		hasData := false
 		err := r.forEachBlob(fileBlobs, func(packID restic.ID, blob restic.Blob) {
+			offset := fileOffset
+			fileOffset += int64(blob.DataLength())
+			if r.skipBlob(file, blob.ID, offset) {
+				r.reportProgress(file, uint64(blob.DataLength()))
+				return
+			}
+			hasData = true
 			if largeFile {
-				packsMap[packID] = append(packsMap[packID], fileBlobInfo{id: blob.ID, offset: fileOffset})
-				fileOffset += int64(blob.Length) - crypto.Extension
+				packsMap[packID] = append(packsMap[packID], fileBlobInfo{id: blob.ID, offset: offset})
 			}
 			pack, ok := packs[packID]
 			if !ok {
@@ -133,6 +204,28 @@ func (r *fileRestorer) restoreFiles(ctx context.Context) error {
 		if largeFile {
 			file.blobs = packsMap
 		}
+		if !hasData && !file.inplace {
+			holeFiles = append(holeFiles, file)
+		}
+	}
+
+	for _, file := range holeFiles {
+		err := r.filesWriter.writeToFile(r.targetPath(file.location), nil, 0, file.size, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	if r.restorePacks != nil {
+		ids := restic.NewIDSet()
+		for id := range packs {
+			ids.Insert(id)
+		}
+
+		err := r.restorePacks(ctx, ids)
+		if err != nil {
+			return err
+		}
 	}
 
 	var wg sync.WaitGroup
@@ -177,9 +270,8 @@ func (r *fileRestorer) downloadPack(ctx context.Context, pack *packInfo) {
 	// calculate pack byte range and blob->[]files->[]offsets mappings
 	start, end := int64(math.MaxInt64), int64(0)
 	blobs := make(map[restic.ID]struct {
-		offset int64                 // offset of the blob in the pack
-		length int                   // length of the blob
-		files  map[*fileInfo][]int64 // file -> offsets (plural!) of the blob in the file
+		blob  restic.Blob           // the blob as stored in the pack
+		files map[*fileInfo][]int64 // file -> offsets (plural!) of the blob in the file
 	})
 	for file := range pack.files {
 		addBlob := func(blob restic.Blob, fileOffset int64) {
@@ -191,8 +283,7 @@ func (r *fileRestorer) downloadPack(ctx context.Context, pack *packInfo) {
 			}
 			blobInfo, ok := blobs[blob.ID]
 			if !ok {
-				blobInfo.offset = int64(blob.Offset)
-				blobInfo.length = int(blob.Length)
+				blobInfo.blob = blob
 				blobInfo.files = make(map[*fileInfo][]int64)
 				blobs[blob.ID] = blobInfo
 			}
@@ -201,10 +292,10 @@ func (r *fileRestorer) downloadPack(ctx context.Context, pack *packInfo) {
 		if fileBlobs, ok := file.blobs.(restic.IDs); ok {
 			fileOffset := int64(0)
 			r.forEachBlob(fileBlobs, func(packID restic.ID, blob restic.Blob) {
-				if packID.Equal(pack.id) {
+				if packID.Equal(pack.id) && !r.skipBlob(file, blob.ID, fileOffset) {
 					addBlob(blob, fileOffset)
 				}
-				fileOffset += int64(blob.Length) - crypto.Extension
+				fileOffset += int64(blob.DataLength())
 			})
 		} else if packsMap, ok := file.blobs.(map[restic.ID][]fileBlobInfo); ok {
 			for _, blob := range packsMap[pack.id] {
@@ -252,8 +343,8 @@ func (r *fileRestorer) downloadPack(ctx context.Context, pack *packInfo) {
 
 	rd := bytes.NewReader(packData)
 
-	for blobID, blob := range blobs {
-		blobData, err := r.loadBlob(rd, blobID, blob.offset-start, blob.length)
+	for _, blob := range blobs {
+		blobData, err := r.loadBlob(rd, blob.blob, int64(blob.blob.Offset)-start)
 		if err != nil {
 			for file := range blob.files {
 				markFileError(file, err)
@@ -270,49 +361,50 @@ func (r *fileRestorer) downloadPack(ctx context.Context, pack *packInfo) {
 					// so write the first blob while holding file lock
 					// write other blobs after releasing the lock
 					file.lock.Lock()
-					create := file.flags&fileProgress == 0
-					if create {
+					createSize := int64(-1)
+					if file.flags&fileProgress == 0 {
 						defer file.lock.Unlock()
 						file.flags |= fileProgress
+						createSize = file.size
 					} else {
 						file.lock.Unlock()
 					}
-					return r.filesWriter.writeToFile(r.targetPath(file.location), blobData, offset, create)
+					return r.filesWriter.writeToFile(r.targetPath(file.location), blobData, offset, createSize, r.sparse)
 				}
 				err := writeToFile()
 				if err != nil {
 					markFileError(file, err)
 					break
 				}
+				r.reportProgress(file, uint64(len(blobData)))
 			}
 		}
 	}
 }
 
-func (r *fileRestorer) loadBlob(rd io.ReaderAt, blobID restic.ID, offset int64, length int) ([]byte, error) {
+func (r *fileRestorer) loadBlob(rd io.ReaderAt, blob restic.Blob, offset int64) ([]byte, error) {
 	// TODO reconcile with Repository#loadBlob implementation
 
-	buf := make([]byte, length)
+	buf := make([]byte, blob.Length)
 
 	n, err := rd.ReadAt(buf, offset)
 	if err != nil {
 		return nil, err
 	}
 
-	if n != length {
-		return nil, errors.Errorf("error loading blob %v: wrong length returned, want %d, got %d", blobID.Str(), length, n)
+	if n != int(blob.Length) {
+		return nil, errors.Errorf("error loading blob %v: wrong length returned, want %d, got %d", blob.ID.Str(), blob.Length, n)
 	}
 
-	// decrypt
-	nonce, ciphertext := buf[:r.key.NonceSize()], buf[r.key.NonceSize():]
-	plaintext, err := r.key.Open(ciphertext[:0], nonce, ciphertext, nil)
+	// decrypt and decompress
+	plaintext, err := repository.DecryptBlob(r.key, blob, buf)
 	if err != nil {
-		return nil, errors.Errorf("decrypting blob %v failed: %v", blobID, err)
+		return nil, errors.Errorf("decrypting blob %v failed: %v", blob.ID, err)
 	}
 
 	// check hash
-	if !restic.Hash(plaintext).Equal(blobID) {
-		return nil, errors.Errorf("blob %v returned invalid hash", blobID)
+	if !restic.Hash(plaintext).Equal(blob.ID) {
+		return nil, errors.Errorf("blob %v returned invalid hash", blob.ID)
 	}
 
 	return plaintext, nil
@@ -5,8 +5,10 @@ import (
 	"context"
 	"io"
 	"io/ioutil"
+	"path/filepath"
 	"testing"
 
+	"github.com/restic/chunker"
 	"github.com/restic/restic/internal/crypto"
 	"github.com/restic/restic/internal/restic"
 	rtest "github.com/restic/restic/internal/test"
@@ -126,7 +128,8 @@ func newTestRepo(content []TestFile) *TestRepo {
 		for _, blob := range file.blobs {
 			content = append(content, restic.Hash([]byte(blob.data)))
 		}
-		files = append(files, &fileInfo{location: file.name, blobs: content})
+		size := int64(len(filesPathToContent[file.name]))
+		files = append(files, &fileInfo{location: file.name, blobs: content, size: size})
 	}
 
 	repo := &TestRepo{
@@ -150,10 +153,10 @@ func newTestRepo(content []TestFile) *TestRepo {
 	return repo
 }
 
-func restoreAndVerify(t *testing.T, tempdir string, content []TestFile) {
+func restoreAndVerify(t *testing.T, tempdir string, content []TestFile, sparse bool) {
 	repo := newTestRepo(content)
 
-	r := newFileRestorer(tempdir, repo.loader, repo.key, repo.Lookup)
+	r := newFileRestorer(tempdir, repo.loader, repo.key, repo.Lookup, sparse)
 	r.files = repo.files
 
 	err := r.restoreFiles(context.TODO())
@@ -201,5 +204,81 @@ func TestFileRestorerBasic(t *testing.T) {
 				TestBlob{"data3-1", "pack3-1"},
 			},
 		},
+	}, false)
+}
+
+func TestFileRestorerSparse(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	zeros := string(make([]byte, chunker.MinSize))
+
+	var largeFile []TestBlob
+	for i := 0; i < largeFileBlobCount+5; i++ {
+		largeFile = append(largeFile, TestBlob{zeros, "pack3-1"})
+	}
+	largeFile = append(largeFile, TestBlob{"data3-1", "pack3-2"})
+
+	restoreAndVerify(t, tempdir, []TestFile{
+		TestFile{
+			name: "file1",
+			blobs: []TestBlob{
+				TestBlob{"data1-1", "pack1-1"},
+				TestBlob{zeros, "pack1-2"},
+				TestBlob{"data1-2", "pack1-2"},
+				TestBlob{zeros, "pack1-2"},
+			},
+		},
+		TestFile{
+			name: "file2",
+			blobs: []TestBlob{
+				// only holes
+				TestBlob{zeros, "pack2-1"},
+				TestBlob{zeros, "pack2-1"},
+			},
+		},
+		TestFile{
+			name:  "file3",
+			blobs: largeFile,
+		},
+	}, true)
+}
+
+func TestFileRestorerInplace(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	repo := newTestRepo([]TestFile{
+		TestFile{
+			name: "file1",
+			blobs: []TestBlob{
+				TestBlob{"data1-1", "pack1"},
+				TestBlob{"data1-2", "pack2"},
+				TestBlob{"data1-3", "pack3"},
+			},
+		},
 	})
+
+	// the first and the last blob are already contained in the file
+	file := repo.files[0]
+	file.inplace = true
+	file.unchanged = map[int64]struct{}{0: {}, 14: {}}
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(tempdir, "file1"), []byte("data1-1XXXXXXXdata1-3 and more"), 0600))
+
+	loaded := make(map[string]struct{})
+	loader := func(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+		id, err := restic.ParseID(h.Name)
+		rtest.OK(t, err)
+		loaded[repo.packsIDToName[id]] = struct{}{}
+		return repo.loader(ctx, h, length, offset, fn)
+	}
+
+	r := newFileRestorer(tempdir, loader, repo.key, repo.Lookup, false)
+	r.files = repo.files
+	rtest.OK(t, r.restoreFiles(context.TODO()))
+
+	data, err := ioutil.ReadFile(filepath.Join(tempdir, "file1"))
+	rtest.OK(t, err)
+	rtest.Equals(t, "data1-1data1-2data1-3", string(data))
+	rtest.Equals(t, map[string]struct{}{"pack2": {}}, loaded)
 }
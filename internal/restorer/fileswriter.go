@@ -33,7 +33,11 @@ func newFilesWriter(count int) *filesWriter {
 	}
 }
 
-func (w *filesWriter) writeToFile(path string, blob []byte, offset int64, create bool) error {
+// writeToFile writes blob at offset to the file at path. If createSize is not
+// negative, the file is created (or truncated) first. For sparse files, the
+// file is then extended to createSize, so that ranges which are never written
+// remain holes.
+func (w *filesWriter) writeToFile(path string, blob []byte, offset int64, createSize int64, sparse bool) error {
 	bucket := &w.buckets[uint(xxhash.Sum64String(path))%uint(len(w.buckets))]
 
 	acquireWriter := func() (*os.File, error) {
@@ -46,7 +50,7 @@ func (w *filesWriter) writeToFile(path string, blob []byte, offset int64, create
 		}
 
 		var flags int
-		if create {
+		if createSize >= 0 {
 			flags = os.O_CREATE | os.O_TRUNC | os.O_WRONLY
 		} else {
 			flags = os.O_WRONLY
@@ -57,6 +61,14 @@ func (w *filesWriter) writeToFile(path string, blob []byte, offset int64, create
 			return nil, err
 		}
 
+		if createSize >= 0 && sparse {
+			err = truncateSparse(wr, createSize)
+			if err != nil {
+				_ = wr.Close()
+				return nil, err
+			}
+		}
+
 		bucket.files[path] = wr
 		bucket.users[path] = 1
 
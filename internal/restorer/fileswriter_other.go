@@ -0,0 +1,11 @@
+// +build !windows
+
+package restorer
+
+import "os"
+
+// truncateSparse extends the file to size without allocating the new space,
+// on most file systems the unwritten ranges become holes.
+func truncateSparse(f *os.File, size int64) error {
+	return f.Truncate(size)
+}
@@ -0,0 +1,28 @@
+// +build windows
+
+package restorer
+
+import (
+	"os"
+
+	"github.com/restic/restic/internal/debug"
+	"golang.org/x/sys/windows"
+)
+
+// fsctlSetSparse is the control code to mark a file as sparse, see
+// https://docs.microsoft.com/en-us/windows/win32/api/winioctl/ni-winioctl-fsctl_set_sparse
+const fsctlSetSparse = 0x000900c4
+
+// truncateSparse marks the file as sparse and extends it to size. Ranges of
+// a sparse file which are never written are not allocated on disk.
+func truncateSparse(f *os.File, size int64) error {
+	var n uint32
+	err := windows.DeviceIoControl(windows.Handle(f.Fd()), fsctlSetSparse, nil, 0, nil, 0, &n, nil)
+	if err != nil {
+		// not all file systems support sparse files, the file is restored
+		// nevertheless
+		debug.Log("unable to mark %v as sparse: %v", f.Name(), err)
+	}
+
+	return f.Truncate(size)
+}
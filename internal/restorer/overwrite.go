@@ -0,0 +1,59 @@
+package restorer
+
+import "github.com/restic/restic/internal/errors"
+
+// OverwriteBehavior configures how files which already exist in the target
+// directory are handled.
+type OverwriteBehavior uint
+
+// Constants for the different overwrite behaviors.
+const (
+	// OverwriteAlways replaces existing files
+	OverwriteAlways OverwriteBehavior = iota
+	// OverwriteIfChanged updates existing files in place, only the parts
+	// of a file which differ from the snapshot are downloaded
+	OverwriteIfChanged
+	// OverwriteIfNewer replaces existing files which are older than the
+	// file in the snapshot
+	OverwriteIfNewer
+	// OverwriteNever keeps existing files
+	OverwriteNever
+)
+
+// Set implements the method needed for pflag command flag parsing.
+func (c *OverwriteBehavior) Set(s string) error {
+	switch s {
+	case "always":
+		*c = OverwriteAlways
+	case "if-changed":
+		*c = OverwriteIfChanged
+	case "if-newer":
+		*c = OverwriteIfNewer
+	case "never":
+		*c = OverwriteNever
+	default:
+		return errors.Errorf("invalid overwrite behavior %q, must be one of (always|if-changed|if-newer|never)", s)
+	}
+
+	return nil
+}
+
+func (c *OverwriteBehavior) String() string {
+	switch *c {
+	case OverwriteAlways:
+		return "always"
+	case OverwriteIfChanged:
+		return "if-changed"
+	case OverwriteIfNewer:
+		return "if-newer"
+	case OverwriteNever:
+		return "never"
+	}
+
+	return "invalid"
+}
+
+// Type returns the type name for pflag.
+func (c *OverwriteBehavior) Type() string {
+	return "behavior"
+}
@@ -0,0 +1,21 @@
+package restorer
+
+// Progress is notified about the progress of a restore. All methods may be
+// called concurrently.
+type Progress interface {
+	// AddFile is called for each file which is going to be restored.
+	AddFile(location string, size uint64)
+
+	// AddProgress is called when bytes of the file at location have been
+	// restored, either because they were written or because the file
+	// already contained them.
+	AddProgress(location string, bytes uint64)
+
+	// ReportSkipped is called for existing items which are not
+	// overwritten.
+	ReportSkipped(location string)
+
+	// ReportDeleted is called for items which have been removed from the
+	// target directory because they are not contained in the snapshot.
+	ReportDeleted(location string)
+}
@@ -2,10 +2,11 @@ package restorer
 
 import (
 	"context"
+	"io"
 	"os"
 	"path/filepath"
 
-	"github.com/restic/restic/internal/crypto"
+	"github.com/restic/chunker"
 	"github.com/restic/restic/internal/errors"
 
 	"github.com/restic/restic/internal/debug"
@@ -20,6 +21,21 @@ type Restorer struct {
 
 	Error        func(location string, err error) error
 	SelectFilter func(item string, dstpath string, node *restic.Node) (selectedForRestore bool, childMayBeSelected bool)
+
+	// Sparse restores files as sparse files, ranges of zero bytes are not
+	// written but left as holes.
+	Sparse bool
+
+	// Overwrite configures how files which already exist in the target
+	// directory are handled.
+	Overwrite OverwriteBehavior
+
+	// Delete removes files in the target directory which are not contained
+	// in the snapshot.
+	Delete bool
+
+	// Progress, if set, is notified about the progress of the restore.
+	Progress Progress
 }
 
 var restorerAbortOnAllErrors = func(location string, err error) error { return err }
@@ -46,6 +62,10 @@ type treeVisitor struct {
 	enterDir  func(node *restic.Node, target, location string) error
 	visitNode func(node *restic.Node, target, location string) error
 	leaveDir  func(node *restic.Node, target, location string) error
+
+	// leaveTree, if set, is called after all nodes of a tree have been
+	// visited
+	leaveTree func(tree *restic.Tree, target, location string) error
 }
 
 // traverseTree traverses a tree from the repo and calls treeVisitor.
@@ -137,6 +157,10 @@ func (res *Restorer) traverseTree(ctx context.Context, target, location string,
 		}
 	}
 
+	if visitor.leaveTree != nil {
+		return visitor.leaveTree(tree, target, location)
+	}
+
 	return nil
 }
 
@@ -188,6 +212,168 @@ func (res *Restorer) restoreEmptyFileAt(node *restic.Node, target, location stri
 	return res.restoreNodeMetadataTo(node, target, location)
 }
 
+// skipExisting returns true if an item exists at target which must not be
+// overwritten with node.
+func (res *Restorer) skipExisting(node *restic.Node, target string) (bool, error) {
+	if res.Overwrite != OverwriteIfNewer && res.Overwrite != OverwriteNever {
+		return false, nil
+	}
+
+	fi, err := fs.Lstat(target)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "Lstat")
+	}
+
+	if res.Overwrite == OverwriteIfNewer {
+		return !node.ModTime.After(fi.ModTime()), nil
+	}
+
+	return true, nil
+}
+
+// unchangedBlobs splits the existing file at target into chunks, using the
+// chunker polynomial of the repository, and returns the offsets of the blobs
+// of node which the file already contains at the right position. If there is
+// no regular file at target, ok is false.
+func (res *Restorer) unchangedBlobs(ctx context.Context, target string, node *restic.Node, buf []byte) (unchanged map[int64]struct{}, ok bool, err error) {
+	fi, err := fs.Lstat(target)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, errors.Wrap(err, "Lstat")
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, false, nil
+	}
+
+	expected := make(map[int64]restic.ID, len(node.Content))
+	var size int64
+	for _, id := range node.Content {
+		blobs, found := res.repo.Index().Lookup(id, restic.DataBlob)
+		if !found {
+			return nil, false, errors.Errorf("unknown blob %v", id.Str())
+		}
+		expected[size] = id
+		size += int64(blobs[0].DataLength())
+	}
+
+	f, err := fs.Open(target)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "Open")
+	}
+
+	unchanged = make(map[int64]struct{})
+	chnkr := chunker.New(f, res.repo.Config().ChunkerPolynomial)
+	for {
+		if ctx.Err() != nil {
+			_ = f.Close()
+			return nil, false, ctx.Err()
+		}
+
+		chunk, err := chnkr.Next(buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			_ = f.Close()
+			return nil, false, errors.Wrap(err, "chunker.Next")
+		}
+
+		offset := int64(chunk.Start)
+		if offset >= size {
+			// the remaining data is cut off anyway
+			break
+		}
+
+		if id, ok := expected[offset]; ok && id.Equal(restic.Hash(chunk.Data)) {
+			unchanged[offset] = struct{}{}
+		}
+	}
+
+	err = f.Close()
+	if err != nil {
+		return nil, false, errors.Wrap(err, "Close")
+	}
+
+	return unchanged, true, nil
+}
+
+// removeUnexpected removes the items in the directory target which are not
+// contained in tree. Items which would not be selected for restore are kept.
+func (res *Restorer) removeUnexpected(tree *restic.Tree, target, location string) error {
+	if _, err := fs.Lstat(target); os.IsNotExist(err) {
+		// nothing has been restored to the directory
+		return nil
+	}
+
+	f, err := fs.Open(target)
+	if err != nil {
+		return res.Error(location, errors.Wrap(err, "Open"))
+	}
+
+	names, err := f.Readdirnames(-1)
+	_ = f.Close()
+	if err != nil {
+		return res.Error(location, errors.Wrap(err, "Readdirnames"))
+	}
+
+	expected := make(map[string]struct{}, len(tree.Nodes))
+	for _, node := range tree.Nodes {
+		expected[node.Name] = struct{}{}
+	}
+
+	for _, name := range names {
+		if _, ok := expected[name]; ok {
+			continue
+		}
+
+		itemTarget := filepath.Join(target, name)
+		itemLocation := filepath.Join(location, name)
+
+		fi, err := fs.Lstat(itemTarget)
+		if err != nil {
+			err = res.Error(itemLocation, errors.Wrap(err, "Lstat"))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		node, err := restic.NodeFromFileInfo(itemTarget, fi)
+		if err != nil {
+			err = res.Error(itemLocation, err)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if selected, _ := res.SelectFilter(itemLocation, itemTarget, node); !selected {
+			continue
+		}
+
+		debug.Log("removing %v, it is not contained in the snapshot", itemTarget)
+		err = fs.RemoveAll(itemTarget)
+		if err != nil {
+			err = res.Error(itemLocation, errors.Wrap(err, "RemoveAll"))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if res.Progress != nil {
+			res.Progress.ReportDeleted(itemLocation)
+		}
+	}
+
+	return nil
+}
+
 // RestoreTo creates the directories and files in the snapshot below dst.
 // Before an item is created, res.Filter is called.
 func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
@@ -206,7 +392,24 @@ func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
 
 	idx := restic.NewHardlinkIndex()
 
-	filerestorer := newFileRestorer(dst, res.repo.Backend().Load, res.repo.Key(), res.repo.Index().Lookup)
+	// locations of existing files which are not overwritten
+	skipped := make(map[string]struct{})
+
+	var chunkBuf []byte
+	if res.Overwrite == OverwriteIfChanged {
+		chunkBuf = make([]byte, chunker.MaxSize)
+	}
+
+	var removeUnexpected func(tree *restic.Tree, target, location string) error
+	if res.Delete {
+		removeUnexpected = res.removeUnexpected
+	}
+
+	filerestorer := newFileRestorer(dst, res.repo.Backend().Load, res.repo.Key(), res.repo.Index().Lookup, res.Sparse)
+	filerestorer.progress = res.Progress
+	filerestorer.restorePacks = func(ctx context.Context, packs restic.IDSet) error {
+		return restic.RestorePacks(ctx, res.repo.Backend(), packs)
+	}
 
 	// first tree pass: create directories and collect all files to restore
 	err = res.traverseTree(ctx, dst, string(filepath.Separator), *res.sn.Tree, treeVisitor{
@@ -228,7 +431,22 @@ func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
 				return nil
 			}
 
+			skip, err := res.skipExisting(node, target)
+			if err != nil {
+				return err
+			}
+			if skip {
+				skipped[location] = struct{}{}
+				if res.Progress != nil {
+					res.Progress.ReportSkipped(location)
+				}
+				return nil
+			}
+
 			if node.Size == 0 {
+				if res.Progress != nil {
+					res.Progress.AddFile(location, 0)
+				}
 				return nil // deal with empty files later
 			}
 
@@ -239,11 +457,27 @@ func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
 				idx.Add(node.Inode, node.DeviceID, location)
 			}
 
-			filerestorer.addFile(location, node.Content)
+			if res.Progress != nil {
+				res.Progress.AddFile(location, node.Size)
+			}
+
+			if res.Overwrite == OverwriteIfChanged {
+				unchanged, ok, err := res.unchangedBlobs(ctx, target, node, chunkBuf)
+				if err != nil {
+					return err
+				}
+				if ok {
+					filerestorer.updateFile(location, node.Content, int64(node.Size), unchanged)
+					return nil
+				}
+			}
+
+			filerestorer.addFile(location, node.Content, int64(node.Size))
 
 			return nil
 		},
-		leaveDir: noop,
+		leaveDir:  noop,
+		leaveTree: removeUnexpected,
 	})
 	if err != nil {
 		return err
@@ -259,9 +493,17 @@ func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
 		enterDir: noop,
 		visitNode: func(node *restic.Node, target, location string) error {
 			if node.Type != "file" {
+				skip, err := res.skipExisting(node, target)
+				if err != nil || skip {
+					return err
+				}
 				return res.restoreNodeTo(ctx, node, target, location)
 			}
 
+			if _, ok := skipped[location]; ok {
+				return nil
+			}
+
 			// create empty files, but not hardlinks to empty files
 			if node.Size == 0 && (node.Links < 2 || !idx.Has(node.Inode, node.DeviceID)) {
 				if node.Links > 1 {
@@ -314,7 +556,7 @@ func (res *Restorer) VerifyFiles(ctx context.Context, dst string) (int, error) {
 			offset := int64(0)
 			for _, blobID := range node.Content {
 				blobs, _ := res.repo.Index().Lookup(blobID, restic.DataBlob)
-				length := blobs[0].Length - uint(crypto.Extension)
+				length := blobs[0].DataLength()
 				buf := make([]byte, length) // TODO do I want to reuse the buffer somehow?
 				_, err = file.ReadAt(buf, offset)
 				if err != nil {
@@ -3,13 +3,16 @@ package restorer
 import (
 	"bytes"
 	"context"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/restic/chunker"
 	"github.com/restic/restic/internal/fs"
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
@@ -24,9 +27,10 @@ type Snapshot struct {
 }
 
 type File struct {
-	Data  string
-	Links uint64
-	Inode uint64
+	Data    string
+	Links   uint64
+	Inode   uint64
+	ModTime time.Time
 }
 
 type Dir struct {
@@ -34,16 +38,31 @@ type Dir struct {
 	Mode  os.FileMode
 }
 
-func saveFile(t testing.TB, repo restic.Repository, node File) restic.ID {
+// saveFile splits the data of the file into chunks and saves them.
+func saveFile(t testing.TB, repo restic.Repository, node File) restic.IDs {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	id, _, err := repo.SaveBlob(ctx, restic.DataBlob, []byte(node.Data), restic.ID{}, false)
-	if err != nil {
-		t.Fatal(err)
+	var ids restic.IDs
+	chnkr := chunker.New(strings.NewReader(node.Data), repo.Config().ChunkerPolynomial)
+	buf := make([]byte, chunker.MaxSize)
+	for {
+		chunk, err := chnkr.Next(buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		id, _, err := repo.SaveBlob(ctx, restic.DataBlob, chunk.Data, restic.ID{}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
 	}
 
-	return id
+	return ids
 }
 
 func saveDir(t testing.TB, repo restic.Repository, nodes map[string]Node, inode uint64) restic.ID {
@@ -65,7 +84,7 @@ func saveDir(t testing.TB, repo restic.Repository, nodes map[string]Node, inode
 			}
 			fc := []restic.ID{}
 			if len(n.(File).Data) > 0 {
-				fc = append(fc, saveFile(t, repo, node))
+				fc = append(fc, saveFile(t, repo, node)...)
 			}
 			tree.Insert(&restic.Node{
 				Type:    "file",
@@ -77,6 +96,7 @@ func saveDir(t testing.TB, repo restic.Repository, nodes map[string]Node, inode
 				Size:    uint64(len(n.(File).Data)),
 				Inode:   fi,
 				Links:   lc,
+				ModTime: node.ModTime,
 			})
 		case Dir:
 			id := saveDir(t, repo, node.Nodes, inode)
@@ -689,3 +709,309 @@ func TestRestorerTraverseTree(t *testing.T) {
 		})
 	}
 }
+
+func TestRestorerOverwrite(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+
+	snapshot := Snapshot{
+		Nodes: map[string]Node{
+			"older": File{Data: "new content: older\n", ModTime: now},
+			"dir": Dir{
+				Nodes: map[string]Node{
+					"newer": File{Data: "new content: newer\n", ModTime: now},
+					"empty": File{ModTime: now},
+				},
+			},
+			"missing": File{Data: "new content: missing\n", ModTime: now},
+		},
+	}
+
+	var tests = []struct {
+		overwrite OverwriteBehavior
+		files     map[string]string
+	}{
+		{
+			overwrite: OverwriteAlways,
+			files: map[string]string{
+				"older":     "new content: older\n",
+				"dir/newer": "new content: newer\n",
+				"dir/empty": "",
+				"missing":   "new content: missing\n",
+			},
+		},
+		{
+			overwrite: OverwriteIfChanged,
+			files: map[string]string{
+				"older":     "new content: older\n",
+				"dir/newer": "new content: newer\n",
+				"dir/empty": "",
+				"missing":   "new content: missing\n",
+			},
+		},
+		{
+			overwrite: OverwriteIfNewer,
+			files: map[string]string{
+				"older":     "new content: older\n",
+				"dir/newer": "old content: newer, which is longer\n",
+				"dir/empty": "old content: empty\n",
+				"missing":   "new content: missing\n",
+			},
+		},
+		{
+			overwrite: OverwriteNever,
+			files: map[string]string{
+				"older":     "old content: older\n",
+				"dir/newer": "old content: newer, which is longer\n",
+				"dir/empty": "old content: empty\n",
+				"missing":   "new content: missing\n",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.overwrite.String(), func(t *testing.T) {
+			repo, cleanup := repository.TestRepository(t)
+			defer cleanup()
+			_, id := saveSnapshot(t, repo, snapshot)
+
+			res, err := NewRestorer(repo, id)
+			rtest.OK(t, err)
+			res.Overwrite = test.overwrite
+
+			tempdir, cleanup := rtest.TempDir(t)
+			defer cleanup()
+
+			for filename, data := range map[string]string{
+				"older":     "old content: older\n",
+				"dir/newer": "old content: newer, which is longer\n",
+				"dir/empty": "old content: empty\n",
+			} {
+				filename = filepath.Join(tempdir, filepath.FromSlash(filename))
+				rtest.OK(t, os.MkdirAll(filepath.Dir(filename), 0700))
+				rtest.OK(t, ioutil.WriteFile(filename, []byte(data), 0600))
+
+				mtime := now.Add(time.Hour)
+				if strings.HasSuffix(filename, "older") {
+					mtime = now.Add(-time.Hour)
+				}
+				rtest.OK(t, os.Chtimes(filename, mtime, mtime))
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+			for filename, content := range test.files {
+				data, err := ioutil.ReadFile(filepath.Join(tempdir, filepath.FromSlash(filename)))
+				rtest.OK(t, err)
+				rtest.Equals(t, content, string(data))
+			}
+		})
+	}
+}
+
+func TestRestorerIfChanged(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	data := rtest.Random(23, 8*chunker.MinSize)
+	_, id := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"file": File{Data: string(data)},
+		},
+	})
+
+	res, err := NewRestorer(repo, id)
+	rtest.OK(t, err)
+	res.Overwrite = OverwriteIfChanged
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tree, err := repo.LoadTree(ctx, *res.sn.Tree)
+	rtest.OK(t, err)
+	node := tree.Nodes[0]
+	if len(node.Content) < 4 {
+		t.Fatalf("test data was split into %d chunks only", len(node.Content))
+	}
+
+	// modify the middle of the second chunk and append some data, which
+	// changes the last chunk as well
+	var offsets []int64
+	var offset int64
+	for _, id := range node.Content {
+		offsets = append(offsets, offset)
+		blobs, _ := repo.Index().Lookup(id, restic.DataBlob)
+		offset += int64(blobs[0].DataLength())
+	}
+
+	modified := append([]byte{}, data...)
+	pos := (offsets[1] + offsets[2]) / 2
+	modified[pos] ^= 0xff
+	modified = append(modified, []byte("additional data")...)
+
+	target := filepath.Join(tempdir, "file")
+	rtest.OK(t, ioutil.WriteFile(target, modified, 0600))
+
+	unchanged, ok, err := res.unchangedBlobs(ctx, target, node, make([]byte, chunker.MaxSize))
+	rtest.OK(t, err)
+	rtest.Assert(t, ok, "existing file was not found")
+	for i, offset := range offsets {
+		_, found := unchanged[offset]
+		rtest.Assert(t, found == (i != 1 && i != len(offsets)-1), "blob %d at offset %d: unchanged %v", i, offset, found)
+	}
+
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+	buf, err := ioutil.ReadFile(target)
+	rtest.OK(t, err)
+	if !bytes.Equal(data, buf) {
+		t.Fatalf("restored file has wrong content")
+	}
+
+	count, err := res.VerifyFiles(ctx, tempdir)
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, count)
+}
+
+func TestRestorerDelete(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	_, id := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"foo": File{Data: "content: foo\n"},
+			"dir": Dir{
+				Nodes: map[string]Node{
+					"file": File{Data: "content: file\n"},
+				},
+			},
+		},
+	})
+
+	res, err := NewRestorer(repo, id)
+	rtest.OK(t, err)
+	res.Delete = true
+	res.SelectFilter = func(item string, dstpath string, node *restic.Node) (bool, bool) {
+		selected := item != "/keep"
+		return selected, selected && node.Type == "dir"
+	}
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	for _, filename := range []string{"foo", "extra", "keep", "dir/extra", "dir/subdir/extra"} {
+		filename = filepath.Join(tempdir, filepath.FromSlash(filename))
+		rtest.OK(t, os.MkdirAll(filepath.Dir(filename), 0700))
+		rtest.OK(t, ioutil.WriteFile(filename, []byte("old content"), 0600))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+	for filename, exists := range map[string]bool{
+		"foo":        true,
+		"dir/file":   true,
+		"keep":       true,
+		"extra":      false,
+		"dir/extra":  false,
+		"dir/subdir": false,
+	} {
+		_, err := os.Lstat(filepath.Join(tempdir, filepath.FromSlash(filename)))
+		if exists {
+			rtest.OK(t, err)
+		} else {
+			rtest.Assert(t, os.IsNotExist(err), "%v was not removed: %v", filename, err)
+		}
+	}
+}
+
+type testProgress struct {
+	sync.Mutex
+	files    map[string]uint64
+	restored map[string]uint64
+	skipped  []string
+	deleted  []string
+}
+
+func (p *testProgress) AddFile(location string, size uint64) {
+	p.Lock()
+	defer p.Unlock()
+	p.files[location] = size
+}
+
+func (p *testProgress) AddProgress(location string, bytes uint64) {
+	p.Lock()
+	defer p.Unlock()
+	p.restored[location] += bytes
+}
+
+func (p *testProgress) ReportSkipped(location string) {
+	p.Lock()
+	defer p.Unlock()
+	p.skipped = append(p.skipped, location)
+}
+
+func (p *testProgress) ReportDeleted(location string) {
+	p.Lock()
+	defer p.Unlock()
+	p.deleted = append(p.deleted, location)
+}
+
+func TestRestorerProgress(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	_, id := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"foo":   File{Data: "content: foo\n"},
+			"empty": File{Data: ""},
+			"keep":  File{Data: "content: keep\n"},
+			"dir": Dir{
+				Nodes: map[string]Node{
+					"file": File{Data: "content: file\n"},
+				},
+			},
+		},
+	})
+
+	res, err := NewRestorer(repo, id)
+	rtest.OK(t, err)
+	res.Overwrite = OverwriteNever
+	res.Delete = true
+
+	p := &testProgress{
+		files:    make(map[string]uint64),
+		restored: make(map[string]uint64),
+	}
+	res.Progress = p
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	for _, filename := range []string{"keep", "extra"} {
+		rtest.OK(t, ioutil.WriteFile(filepath.Join(tempdir, filename), []byte("old content"), 0600))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+	files := map[string]uint64{
+		"/foo":      uint64(len("content: foo\n")),
+		"/empty":    0,
+		"/dir/file": uint64(len("content: file\n")),
+	}
+	rtest.Equals(t, files, p.files)
+	delete(files, "/empty")
+	rtest.Equals(t, files, p.restored)
+	rtest.Equals(t, []string{"/keep"}, p.skipped)
+	rtest.Equals(t, []string{"/extra"}, p.deleted)
+}
@@ -3,12 +3,16 @@
 package restorer
 
 import (
+	"bytes"
 	"context"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"syscall"
 	"testing"
+	"time"
 
+	"github.com/restic/chunker"
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 	rtest "github.com/restic/restic/internal/test"
@@ -59,3 +63,81 @@ func TestRestorerRestoreEmptyHardlinkedFileds(t *testing.T) {
 		rtest.Equals(t, s1.Ino, s2.Ino)
 	}
 }
+
+func TestRestorerSparseFiles(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	zeros := make([]byte, chunker.MinSize)
+	zeroID, _, err := repo.SaveBlob(ctx, restic.DataBlob, zeros, restic.ID{}, false)
+	rtest.OK(t, err)
+	rtest.Equals(t, repository.ZeroChunk(), zeroID)
+
+	data := []byte("foobar")
+	dataID, _, err := repo.SaveBlob(ctx, restic.DataBlob, data, restic.ID{}, false)
+	rtest.OK(t, err)
+
+	content := restic.IDs{zeroID, zeroID, dataID, zeroID}
+	var want []byte
+	for _, id := range content {
+		if id.Equal(zeroID) {
+			want = append(want, zeros...)
+		} else {
+			want = append(want, data...)
+		}
+	}
+
+	tree := &restic.Tree{}
+	rtest.OK(t, tree.Insert(&restic.Node{
+		Type:    "file",
+		Mode:    0644,
+		Name:    "sparse",
+		UID:     uint32(os.Getuid()),
+		GID:     uint32(os.Getgid()),
+		Content: content,
+		Size:    uint64(len(want)),
+	}))
+	treeID, err := repo.SaveTree(ctx, tree)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.Flush(ctx))
+
+	sn, err := restic.NewSnapshot([]string{"test"}, nil, "", time.Now())
+	rtest.OK(t, err)
+	sn.Tree = &treeID
+	id, err := repo.SaveJSONUnpacked(ctx, restic.SnapshotFile, sn)
+	rtest.OK(t, err)
+
+	res, err := NewRestorer(repo, id)
+	rtest.OK(t, err)
+	res.Sparse = true
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+	filename := filepath.Join(tempdir, "sparse")
+	buf, err := ioutil.ReadFile(filename)
+	rtest.OK(t, err)
+	if !bytes.Equal(want, buf) {
+		t.Fatalf("restored file has wrong content")
+	}
+
+	fi, err := os.Stat(filename)
+	rtest.OK(t, err)
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+
+	// st.Blocks is the number of 512 byte blocks allocated for the file, not
+	// all file systems support sparse files
+	allocated := int64(st.Blocks) * 512
+	t.Logf("restored %d bytes, %d bytes allocated", len(want), allocated)
+	if allocated >= int64(len(want)) {
+		t.Skipf("file system does not support sparse files")
+	}
+}
@@ -0,0 +1,136 @@
+package restserver
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// cacheDuration is the time for which successfully verified credentials are
+// cached, checking bcrypt hashes is expensive.
+const cacheDuration = time.Minute
+
+// Htpasswd checks user credentials against the entries of an htpasswd file.
+// Passwords hashed with bcrypt and with SHA1 ("{SHA}") are supported.
+type Htpasswd struct {
+	users map[string]string
+
+	m     sync.Mutex
+	cache map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	digest  [sha256.Size]byte
+	expires time.Time
+}
+
+// LoadHtpasswd reads the htpasswd file filename.
+func LoadHtpasswd(filename string) (*Htpasswd, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "Open")
+	}
+
+	h, err := ParseHtpasswd(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, errors.Wrapf(err, "htpasswd file %v", filename)
+	}
+
+	return h, errors.Wrap(f.Close(), "Close")
+}
+
+// ParseHtpasswd parses the entries of an htpasswd file read from rd. Empty
+// lines and lines starting with "#" are ignored.
+func ParseHtpasswd(rd io.Reader) (*Htpasswd, error) {
+	h := &Htpasswd{
+		users: make(map[string]string),
+		cache: make(map[string]cacheEntry),
+	}
+
+	sc := bufio.NewScanner(rd)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		data := strings.SplitN(text, ":", 2)
+		if len(data) != 2 || data[0] == "" {
+			return nil, errors.Errorf("line %d: invalid entry", line)
+		}
+
+		user, hash := data[0], data[1]
+		if !isBcryptHash(hash) && !strings.HasPrefix(hash, "{SHA}") {
+			return nil, errors.Errorf("line %d: unsupported password hash for user %q", line, user)
+		}
+
+		h.users[user] = hash
+	}
+
+	if err := sc.Err(); err != nil {
+		return nil, errors.Wrap(err, "Scan")
+	}
+
+	return h, nil
+}
+
+func isBcryptHash(hash string) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+		if strings.HasPrefix(hash, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify returns true if password is the password of user.
+func (h *Htpasswd) Verify(user, password string) bool {
+	hash, ok := h.users[user]
+	if !ok {
+		return false
+	}
+
+	digest := sha256.Sum256([]byte(hash + ":" + password))
+
+	h.m.Lock()
+	entry, ok := h.cache[user]
+	h.m.Unlock()
+
+	if ok && time.Now().Before(entry.expires) &&
+		subtle.ConstantTimeCompare(entry.digest[:], digest[:]) == 1 {
+		return true
+	}
+
+	if !verifyHash(hash, password) {
+		return false
+	}
+
+	h.m.Lock()
+	h.cache[user] = cacheEntry{digest: digest, expires: time.Now().Add(cacheDuration)}
+	h.m.Unlock()
+
+	return true
+}
+
+// verifyHash checks password against a hash from an htpasswd file.
+func verifyHash(hash, password string) bool {
+	if isBcryptHash(hash) {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	}
+
+	sum := sha1.Sum([]byte(password))
+	want := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(want)) == 1
+}
@@ -0,0 +1,50 @@
+package restserver_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/restic/restic/internal/restserver"
+	rtest "github.com/restic/restic/internal/test"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHtpasswd(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("bcrypt-secret"), bcrypt.MinCost)
+	rtest.OK(t, err)
+
+	h, err := restserver.ParseHtpasswd(strings.NewReader(
+		"# comment\n" +
+			"\n" +
+			"alice:" + string(hash) + "\n" +
+			"bob:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n"))
+	rtest.OK(t, err)
+
+	for _, test := range []struct {
+		user, password string
+		valid          bool
+	}{
+		{"alice", "bcrypt-secret", true},
+		{"alice", "bcrypt-secret", true}, // cached
+		{"alice", "secret", false},
+		{"bob", "secret", true},
+		{"bob", "bcrypt-secret", false},
+		{"carol", "secret", false},
+		{"", "", false},
+	} {
+		rtest.Equals(t, test.valid, h.Verify(test.user, test.password))
+	}
+}
+
+func TestHtpasswdInvalid(t *testing.T) {
+	for _, data := range []string{
+		"alice\n",
+		":{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n",
+		"alice:$apr1$abcdefgh$0123456789abcdefghijkl\n",
+		"alice:plaintext\n",
+	} {
+		_, err := restserver.ParseHtpasswd(strings.NewReader(data))
+		rtest.Assert(t, err != nil, "expected error for htpasswd data %q", data)
+	}
+}
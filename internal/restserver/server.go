@@ -0,0 +1,474 @@
+// Package restserver implements a server for the REST protocol which is used
+// by the rest backend.
+package restserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/restic/restic/internal/backend/rest"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/hashing"
+	"github.com/restic/restic/internal/restic"
+
+	"github.com/minio/sha256-simd"
+)
+
+// OpenFunc returns the backend for the repository at path, which is relative
+// to the served location and uses "/" as separator. The empty path refers to
+// the served location itself. With create set, the repository is created.
+type OpenFunc func(ctx context.Context, path string, create bool) (restic.Backend, error)
+
+// Options configures a Server.
+type Options struct {
+	// Open is called to access a repository for the first time.
+	Open OpenFunc
+
+	// Auth checks the credentials of all requests, if it is nil no
+	// authentication is required.
+	Auth *Htpasswd
+
+	// AppendOnly refuses to remove or overwrite files, except for locks.
+	AppendOnly bool
+
+	// PrivateRepos only allows users to access repositories below a
+	// directory named after the user. It requires Auth to be set.
+	PrivateRepos bool
+
+	// NoVerifyUpload disables checking that the name of an uploaded file
+	// matches the SHA-256 hash of its content.
+	NoVerifyUpload bool
+}
+
+// Server serves repositories via the REST protocol. Backends are opened on
+// first access and kept open until the server is closed.
+type Server struct {
+	opts Options
+
+	m     sync.Mutex
+	repos map[string]restic.Backend
+}
+
+// New returns a new server.
+func New(opts Options) (*Server, error) {
+	if opts.Open == nil {
+		return nil, errors.New("no function to open repositories given")
+	}
+
+	if opts.PrivateRepos && opts.Auth == nil {
+		return nil, errors.New("private repositories require authentication")
+	}
+
+	return &Server{
+		opts:  opts,
+		repos: make(map[string]restic.Backend),
+	}, nil
+}
+
+// Close closes all open backends.
+func (s *Server) Close() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	var firstErr error
+	for path, be := range s.repos {
+		if err := be.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(s.repos, path)
+	}
+
+	return firstErr
+}
+
+// the directories used for the different file types, as in backend.RESTLayout
+var typeDirs = map[string]restic.FileType{
+	"data":      restic.DataFile,
+	"keys":      restic.KeyFile,
+	"locks":     restic.LockFile,
+	"snapshots": restic.SnapshotFile,
+	"index":     restic.IndexFile,
+}
+
+type requestKind int
+
+const (
+	requestCreate requestKind = iota
+	requestList
+	requestFile
+)
+
+// request describes a parsed request path.
+type request struct {
+	kind requestKind
+	repo string
+	h    restic.Handle
+}
+
+// parseRequest determines the repository and the file (or directory) which is
+// accessed by r.
+func parseRequest(r *http.Request) (request, error) {
+	p := strings.Trim(r.URL.Path, "/")
+	isDir := strings.HasSuffix(r.URL.Path, "/")
+
+	var segments []string
+	if p != "" {
+		segments = strings.Split(p, "/")
+	}
+
+	for _, s := range segments {
+		if !validName(s) {
+			return request{}, errors.Errorf("invalid path %q", r.URL.Path)
+		}
+	}
+
+	repo := func(n int) string {
+		return strings.Join(segments[:len(segments)-n], "/")
+	}
+
+	if r.Method == http.MethodPost && r.URL.Query().Get("create") == "true" {
+		return request{kind: requestCreate, repo: p}, nil
+	}
+
+	n := len(segments)
+	switch {
+	case n >= 1 && !isDir && segments[n-1] == "config":
+		return request{kind: requestFile, repo: repo(1), h: restic.Handle{Type: restic.ConfigFile}}, nil
+	case n >= 1 && isDir && typeDirs[segments[n-1]] != "":
+		return request{kind: requestList, repo: repo(1), h: restic.Handle{Type: typeDirs[segments[n-1]]}}, nil
+	case n >= 2 && !isDir && typeDirs[segments[n-2]] != "":
+		return request{kind: requestFile, repo: repo(2), h: restic.Handle{Type: typeDirs[segments[n-2]], Name: segments[n-1]}}, nil
+	}
+
+	return request{}, errors.Errorf("invalid path %q", r.URL.Path)
+}
+
+// validName returns true if s can be used safely as a path component.
+func validName(s string) bool {
+	return s != "" && s != "." && s != ".." && !strings.ContainsAny(s, `\`)
+}
+
+// allowedFor returns true if user may access the repository at repo.
+func (s *Server) allowedFor(user string, repo string) bool {
+	if !s.opts.PrivateRepos {
+		return true
+	}
+	return repo == user || strings.HasPrefix(repo, user+"/")
+}
+
+func httpError(w http.ResponseWriter, code int) {
+	http.Error(w, http.StatusText(code), code)
+}
+
+// ServeHTTP handles a request.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	debug.Log("%v %v", r.Method, r.URL)
+
+	var user string
+	if s.opts.Auth != nil {
+		username, password, ok := r.BasicAuth()
+		if !ok || !s.opts.Auth.Verify(username, password) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="restic"`)
+			httpError(w, http.StatusUnauthorized)
+			return
+		}
+		user = username
+	}
+
+	req, err := parseRequest(r)
+	if err != nil {
+		debug.Log("%v", err)
+		httpError(w, http.StatusNotFound)
+		return
+	}
+
+	if !s.allowedFor(user, req.repo) {
+		debug.Log("user %q may not access repository %q", user, req.repo)
+		httpError(w, http.StatusForbidden)
+		return
+	}
+
+	if req.kind == requestCreate {
+		s.createRepo(w, r, req.repo)
+		return
+	}
+
+	be, err := s.backend(r.Context(), req.repo)
+	if err != nil {
+		debug.Log("unable to open repository %q: %v", req.repo, err)
+		httpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	switch {
+	case req.kind == requestList && r.Method == http.MethodGet:
+		s.listFiles(w, r, be, req.h.Type)
+	case req.kind == requestFile && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		s.getFile(w, r, be, req.h)
+	case req.kind == requestFile && r.Method == http.MethodPost:
+		s.saveFile(w, r, be, req.h)
+	case req.kind == requestFile && r.Method == http.MethodDelete:
+		s.removeFile(w, r, be, req.h)
+	default:
+		httpError(w, http.StatusMethodNotAllowed)
+	}
+}
+
+// backend returns the backend for the repository at path, it is opened if
+// necessary.
+func (s *Server) backend(ctx context.Context, path string) (restic.Backend, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if be, ok := s.repos[path]; ok {
+		return be, nil
+	}
+
+	be, err := s.opts.Open(ctx, path, false)
+	if err != nil {
+		return nil, err
+	}
+
+	s.repos[path] = be
+	return be, nil
+}
+
+func (s *Server) createRepo(w http.ResponseWriter, r *http.Request, path string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	be, err := s.opts.Open(r.Context(), path, true)
+	if err != nil {
+		debug.Log("unable to create repository %q: %v", path, err)
+		httpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	if old, ok := s.repos[path]; ok {
+		_ = old.Close()
+	}
+	s.repos[path] = be
+}
+
+// backendError sends the response for an error returned by be.
+func backendError(w http.ResponseWriter, be restic.Backend, err error) {
+	if be.IsNotExist(err) {
+		httpError(w, http.StatusNotFound)
+		return
+	}
+
+	debug.Log("backend returned error: %v", err)
+	httpError(w, http.StatusInternalServerError)
+}
+
+type fileInfo struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+func (s *Server) listFiles(w http.ResponseWriter, r *http.Request, be restic.Backend, t restic.FileType) {
+	v2 := r.Header.Get("Accept") == rest.ContentTypeV2
+
+	var names []string
+	var files []fileInfo
+	err := be.List(r.Context(), t, func(fi restic.FileInfo) error {
+		if v2 {
+			files = append(files, fileInfo{Name: fi.Name, Size: fi.Size})
+		} else {
+			names = append(names, fi.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		backendError(w, be, err)
+		return
+	}
+
+	// an empty list is encoded as "[]", not "null"
+	var data interface{}
+	if v2 {
+		if files == nil {
+			files = []fileInfo{}
+		}
+		data = files
+		w.Header().Set("Content-Type", rest.ContentTypeV2)
+	} else {
+		if names == nil {
+			names = []string{}
+		}
+		data = names
+		w.Header().Set("Content-Type", rest.ContentTypeV1)
+	}
+
+	buf, err := json.Marshal(data)
+	if err != nil {
+		debug.Log("json.Marshal: %v", err)
+		httpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	_, _ = w.Write(buf)
+}
+
+// parseRange parses the value of a Range header for a file with the given
+// size. Only a single range is supported.
+func parseRange(s string, size int64) (offset, length int64, err error) {
+	if s == "" {
+		return 0, size, nil
+	}
+
+	if !strings.HasPrefix(s, "bytes=") || strings.Contains(s, ",") {
+		return 0, 0, errors.Errorf("unsupported range %q", s)
+	}
+
+	data := strings.SplitN(strings.TrimPrefix(s, "bytes="), "-", 2)
+	if len(data) != 2 || data[0] == "" {
+		return 0, 0, errors.Errorf("unsupported range %q", s)
+	}
+
+	offset, err = strconv.ParseInt(data[0], 10, 64)
+	if err != nil || offset < 0 || offset > size {
+		return 0, 0, errors.Errorf("invalid range %q", s)
+	}
+
+	end := size - 1
+	if data[1] != "" {
+		end, err = strconv.ParseInt(data[1], 10, 64)
+		if err != nil || end < offset {
+			return 0, 0, errors.Errorf("invalid range %q", s)
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+
+	return offset, end - offset + 1, nil
+}
+
+func (s *Server) getFile(w http.ResponseWriter, r *http.Request, be restic.Backend, h restic.Handle) {
+	fi, err := be.Stat(r.Context(), h)
+	if err != nil {
+		backendError(w, be, err)
+		return
+	}
+
+	offset, length, err := parseRange(r.Header.Get("Range"), fi.Size)
+	if err != nil {
+		debug.Log("%v", err)
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", fi.Size))
+		httpError(w, http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	status := http.StatusOK
+	if r.Header.Get("Range") != "" && length > 0 {
+		status = http.StatusPartialContent
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, fi.Size))
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+
+	if r.Method == http.MethodHead || length == 0 {
+		w.WriteHeader(status)
+		return
+	}
+
+	written := false
+	err = be.Load(r.Context(), h, int(length), offset, func(rd io.Reader) error {
+		if written {
+			return errors.New("unable to retry sending the response")
+		}
+		written = true
+
+		w.WriteHeader(status)
+		_, err := io.Copy(w, rd)
+		return err
+	})
+	if err != nil {
+		debug.Log("Load(%v) returned error: %v", h, err)
+		if !written {
+			backendError(w, be, err)
+			return
+		}
+		// the status has already been sent, abort the response
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func (s *Server) saveFile(w http.ResponseWriter, r *http.Request, be restic.Backend, h restic.Handle) {
+	if s.opts.AppendOnly && h.Type != restic.LockFile {
+		exists, err := be.Test(r.Context(), h)
+		if err != nil {
+			backendError(w, be, err)
+			return
+		}
+		if exists {
+			debug.Log("append-only mode: refusing to overwrite %v", h)
+			httpError(w, http.StatusForbidden)
+			return
+		}
+	}
+
+	tmpfile, err := fs.TempFile("", "restic-serve-")
+	if err != nil {
+		debug.Log("TempFile: %v", err)
+		httpError(w, http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		_ = tmpfile.Close()
+		_ = os.Remove(tmpfile.Name())
+	}()
+
+	hw := hashing.NewWriter(tmpfile, sha256.New())
+	n, err := io.Copy(hw, r.Body)
+	if err != nil || (r.ContentLength >= 0 && n != r.ContentLength) {
+		debug.Log("reading the body for %v failed, %d bytes read: %v", h, n, err)
+		httpError(w, http.StatusBadRequest)
+		return
+	}
+
+	if !s.opts.NoVerifyUpload && h.Type != restic.ConfigFile {
+		id, err := restic.ParseID(h.Name)
+		if err != nil || !id.Equal(restic.IDFromHash(hw.Sum(nil))) {
+			debug.Log("hash of the content does not match the name %v", h)
+			httpError(w, http.StatusBadRequest)
+			return
+		}
+	}
+
+	rd, err := restic.NewFileReader(tmpfile)
+	if err != nil {
+		debug.Log("NewFileReader: %v", err)
+		httpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	if err = be.Save(r.Context(), h, rd); err != nil {
+		backendError(w, be, err)
+		return
+	}
+}
+
+func (s *Server) removeFile(w http.ResponseWriter, r *http.Request, be restic.Backend, h restic.Handle) {
+	if s.opts.AppendOnly && h.Type != restic.LockFile {
+		debug.Log("append-only mode: refusing to remove %v", h)
+		httpError(w, http.StatusForbidden)
+		return
+	}
+
+	if err := be.Remove(r.Context(), h); err != nil {
+		backendError(w, be, err)
+		return
+	}
+}
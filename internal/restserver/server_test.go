@@ -0,0 +1,203 @@
+package restserver_test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/backend/rest"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/restserver"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// memRepos returns an OpenFunc which keeps one memory backend per path.
+func memRepos() restserver.OpenFunc {
+	var m sync.Mutex
+	repos := make(map[string]*mem.MemoryBackend)
+
+	return func(ctx context.Context, path string, create bool) (restic.Backend, error) {
+		m.Lock()
+		defer m.Unlock()
+
+		be, ok := repos[path]
+		if !ok || create {
+			be = mem.New()
+			repos[path] = be
+		}
+		return be, nil
+	}
+}
+
+func runServer(t testing.TB, opts restserver.Options) (string, func()) {
+	if opts.Open == nil {
+		opts.Open = memRepos()
+	}
+
+	srv, err := restserver.New(opts)
+	rtest.OK(t, err)
+
+	httpSrv := httptest.NewServer(srv)
+	return httpSrv.URL, func() {
+		httpSrv.Close()
+		rtest.OK(t, srv.Close())
+	}
+}
+
+func openREST(t testing.TB, rawurl string, create bool) restic.Backend {
+	u, err := url.Parse(rawurl)
+	rtest.OK(t, err)
+
+	cfg := rest.NewConfig()
+	cfg.URL = u
+
+	var be restic.Backend
+	if create {
+		be, err = rest.Create(cfg, http.DefaultTransport)
+	} else {
+		be, err = rest.Open(cfg, http.DefaultTransport)
+	}
+	rtest.OK(t, err)
+	return be
+}
+
+func save(t testing.TB, be restic.Backend, tpe restic.FileType, data []byte) restic.Handle {
+	h := restic.Handle{Type: tpe, Name: restic.Hash(data).String()}
+	rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader(data)))
+	return h
+}
+
+func TestServerRoundTrip(t *testing.T) {
+	serverURL, cleanup := runServer(t, restserver.Options{})
+	defer cleanup()
+
+	be := openREST(t, serverURL+"/foo/bar", true)
+
+	data := rtest.Random(23, 100000)
+	h := save(t, be, restic.DataFile, data)
+
+	buf, err := backend.LoadAll(context.TODO(), nil, be, h)
+	rtest.OK(t, err)
+	rtest.Equals(t, data, buf)
+
+	err = be.Load(context.TODO(), h, 100, 5000, func(rd io.Reader) error {
+		buf, err := ioutil.ReadAll(rd)
+		if err != nil {
+			return err
+		}
+		rtest.Equals(t, data[5000:5100], buf)
+		return nil
+	})
+	rtest.OK(t, err)
+
+	fi, err := be.Stat(context.TODO(), h)
+	rtest.OK(t, err)
+	rtest.Equals(t, int64(len(data)), fi.Size)
+
+	var names []string
+	rtest.OK(t, be.List(context.TODO(), restic.DataFile, func(fi restic.FileInfo) error {
+		names = append(names, fi.Name)
+		return nil
+	}))
+	rtest.Equals(t, []string{h.Name}, names)
+
+	// other repositories are independent
+	other := openREST(t, serverURL+"/foo", true)
+	found, err := other.Test(context.TODO(), h)
+	rtest.OK(t, err)
+	rtest.Assert(t, !found, "file %v found in the wrong repository", h)
+
+	rtest.OK(t, be.Remove(context.TODO(), h))
+	_, err = be.Stat(context.TODO(), h)
+	rtest.Assert(t, be.IsNotExist(err), "expected not found error, got %v", err)
+}
+
+func TestServerVerifyUpload(t *testing.T) {
+	serverURL, cleanup := runServer(t, restserver.Options{})
+	defer cleanup()
+
+	be := openREST(t, serverURL+"/repo", true)
+
+	h := restic.Handle{Type: restic.SnapshotFile, Name: restic.NewRandomID().String()}
+	err := be.Save(context.TODO(), h, restic.NewByteReader([]byte("foobar")))
+	rtest.Assert(t, err != nil, "expected error for file with wrong hash")
+
+	// the config is not named after its content
+	h = restic.Handle{Type: restic.ConfigFile}
+	rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader([]byte("config"))))
+}
+
+func TestServerAppendOnly(t *testing.T) {
+	serverURL, cleanup := runServer(t, restserver.Options{AppendOnly: true})
+	defer cleanup()
+
+	be := openREST(t, serverURL+"/repo", true)
+
+	data := []byte("snapshot")
+	h := save(t, be, restic.SnapshotFile, data)
+
+	err := be.Save(context.TODO(), h, restic.NewByteReader(data))
+	rtest.Assert(t, err != nil, "expected error overwriting a file in append-only mode")
+
+	err = be.Remove(context.TODO(), h)
+	rtest.Assert(t, err != nil, "expected error removing a file in append-only mode")
+
+	found, err := be.Test(context.TODO(), h)
+	rtest.OK(t, err)
+	rtest.Assert(t, found, "file %v was removed in append-only mode", h)
+
+	// locks can be removed
+	lock := save(t, be, restic.LockFile, []byte("lock"))
+	rtest.OK(t, be.Remove(context.TODO(), lock))
+}
+
+func TestServerPrivateRepos(t *testing.T) {
+	htpasswd, err := restserver.ParseHtpasswd(strings.NewReader(
+		// passwords are "secret" and "password"
+		"alice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n" +
+			"bob:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"))
+	rtest.OK(t, err)
+
+	_, err = restserver.New(restserver.Options{Open: memRepos(), PrivateRepos: true})
+	rtest.Assert(t, err != nil, "expected error for private repositories without authentication")
+
+	serverURL, cleanup := runServer(t, restserver.Options{Auth: htpasswd, PrivateRepos: true})
+	defer cleanup()
+
+	u, err := url.Parse(serverURL)
+	rtest.OK(t, err)
+
+	withUser := func(user, password, path string) string {
+		u := *u
+		u.User = url.UserPassword(user, password)
+		u.Path = path
+		return u.String()
+	}
+
+	be := openREST(t, withUser("alice", "secret", "/alice/repo"), true)
+	save(t, be, restic.SnapshotFile, []byte("snapshot"))
+
+	for _, test := range []struct {
+		user, password, path string
+	}{
+		{"alice", "wrong", "/alice/repo"},
+		{"bob", "password", "/alice/repo"},
+		{"alice", "secret", "/bob"},
+		{"alice", "secret", "/"},
+	} {
+		be := openREST(t, withUser(test.user, test.password, test.path), false)
+		_, err := be.Stat(context.TODO(), restic.Handle{Type: restic.ConfigFile})
+		rtest.Assert(t, err != nil && !be.IsNotExist(err), "expected access to %v to be denied for %v, got %v", test.path, test.user, err)
+	}
+
+	be = openREST(t, withUser("bob", "password", "/bob"), true)
+	save(t, be, restic.SnapshotFile, []byte("snapshot"))
+}
@@ -338,6 +338,13 @@ func (b *Backup) CompleteItem(item string, previous, current *restic.Node, s arc
 	}
 }
 
+// ReportExcluded is called by the archiver for items which have been excluded
+// based on their file info, it prints the item in verbose mode (verbosity 2,
+// set by --verbose).
+func (b *Backup) ReportExcluded(item string, fi os.FileInfo) {
+	b.V("excluded  %v", item)
+}
+
 // ReportTotal sets the total stats up to now
 func (b *Backup) ReportTotal(item string, s archiver.ScanStats) {
 	select {
@@ -341,6 +341,27 @@ func (b *Backup) CompleteItem(item string, previous, current *restic.Node, s arc
 	}
 }
 
+// ReportExcluded is called by the archiver for items which have been excluded
+// based on their file info, it prints the item in verbose mode. Like the text
+// output, it requires --verbose (verbosity 2).
+func (b *Backup) ReportExcluded(item string, fi os.FileInfo) {
+	if b.v < 2 {
+		return
+	}
+
+	var size uint64
+	if fi != nil && fi.Mode().IsRegular() {
+		size = uint64(fi.Size())
+	}
+
+	b.print(verboseUpdate{
+		MessageType: "verbose_status",
+		Action:      "excluded",
+		Item:        item,
+		DataSize:    size,
+	})
+}
+
 // ReportTotal sets the total stats up to now
 func (b *Backup) ReportTotal(item string, s archiver.ScanStats) {
 	select {
@@ -366,6 +387,13 @@ func (b *Backup) ReportTotal(item string, s archiver.ScanStats) {
 // Finish prints the finishing messages.
 func (b *Backup) Finish(snapshotID restic.ID) {
 	close(b.finished)
+
+	// no snapshot is saved when nothing has changed and --skip-if-unchanged is used
+	var id string
+	if !snapshotID.IsNull() {
+		id = snapshotID.Str()
+	}
+
 	b.print(summaryOutput{
 		MessageType:         "summary",
 		FilesNew:            b.summary.Files.New,
@@ -380,7 +408,7 @@ func (b *Backup) Finish(snapshotID restic.ID) {
 		TotalFilesProcessed: b.summary.Files.New + b.summary.Files.Changed + b.summary.Files.Unchanged,
 		TotalBytesProcessed: b.summary.ProcessedBytes,
 		TotalDuration:       time.Since(b.start).Seconds(),
-		SnapshotID:          snapshotID.Str(),
+		SnapshotID:          id,
 	})
 }
 
@@ -434,5 +462,5 @@ type summaryOutput struct {
 	TotalFilesProcessed uint    `json:"total_files_processed"`
 	TotalBytesProcessed uint64  `json:"total_bytes_processed"`
 	TotalDuration       float64 `json:"total_duration"` // in seconds
-	SnapshotID          string  `json:"snapshot_id"`
+	SnapshotID          string  `json:"snapshot_id,omitempty"`
 }
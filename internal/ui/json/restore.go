@@ -0,0 +1,305 @@
+package json
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/ui"
+	"github.com/restic/restic/internal/ui/termstatus"
+)
+
+type restoreFile struct {
+	location string
+	size     uint64
+}
+
+type restoreBlob struct {
+	location string
+	bytes    uint64
+}
+
+// Restore reports progress for the `restore` command in JSON.
+type Restore struct {
+	*ui.Message
+	*ui.StdioWrapper
+
+	MinUpdatePause time.Duration
+
+	term  *termstatus.Terminal
+	v     uint
+	start time.Time
+
+	fileCh      chan restoreFile
+	processedCh chan restoreBlob
+	errCh       chan struct{}
+	finished    chan struct{}
+
+	summary struct {
+		sync.Mutex
+		Files struct {
+			Restored uint
+			Skipped  uint
+			Deleted  uint
+		}
+		TotalFiles    uint
+		TotalBytes    uint64
+		RestoredBytes uint64
+		Errors        uint
+	}
+}
+
+// NewRestore returns a new restore progress reporter.
+func NewRestore(term *termstatus.Terminal, verbosity uint) *Restore {
+	return &Restore{
+		Message:      ui.NewMessage(term, verbosity),
+		StdioWrapper: ui.NewStdioWrapper(term),
+		term:         term,
+		v:            verbosity,
+		start:        time.Now(),
+
+		// limit to 60fps by default
+		MinUpdatePause: time.Second / 60,
+
+		fileCh:      make(chan restoreFile),
+		processedCh: make(chan restoreBlob),
+		errCh:       make(chan struct{}),
+		finished:    make(chan struct{}),
+	}
+}
+
+func (r *Restore) print(status interface{}) {
+	r.term.Print(toJSONString(status))
+}
+
+func (r *Restore) error(status interface{}) {
+	r.term.Error(toJSONString(status))
+}
+
+// Run regularly updates the status lines. It should be called in a separate
+// goroutine.
+func (r *Restore) Run(ctx context.Context) error {
+	var (
+		lastUpdate       time.Time
+		total, processed counter
+		errors           uint
+		started          bool
+		remaining        = make(map[string]uint64)
+		secondsRemaining uint64
+	)
+
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-r.finished:
+			started = false
+		case f := <-r.fileCh:
+			total.Files++
+			total.Bytes += f.size
+			if f.size == 0 {
+				processed.Files++
+				r.completeFile(f.location)
+			} else {
+				remaining[f.location] = f.size
+			}
+			r.summary.Lock()
+			r.summary.TotalFiles++
+			r.summary.TotalBytes += f.size
+			r.summary.Unlock()
+			started = true
+		case b := <-r.processedCh:
+			processed.Bytes += b.bytes
+			r.summary.Lock()
+			r.summary.RestoredBytes += b.bytes
+			r.summary.Unlock()
+
+			if b.bytes >= remaining[b.location] {
+				delete(remaining, b.location)
+				processed.Files++
+				r.completeFile(b.location)
+			} else {
+				remaining[b.location] -= b.bytes
+			}
+			started = true
+		case <-r.errCh:
+			errors++
+			r.summary.Lock()
+			r.summary.Errors++
+			r.summary.Unlock()
+			started = true
+		case <-t.C:
+			if !started {
+				continue
+			}
+
+			if processed.Bytes > 0 && processed.Bytes < total.Bytes {
+				secs := float64(time.Since(r.start) / time.Second)
+				todo := float64(total.Bytes - processed.Bytes)
+				secondsRemaining = uint64(secs / float64(processed.Bytes) * todo)
+			}
+		}
+
+		// limit update frequency
+		if time.Since(lastUpdate) < r.MinUpdatePause {
+			continue
+		}
+		lastUpdate = time.Now()
+
+		r.update(total, processed, errors, secondsRemaining)
+	}
+}
+
+// completeFile is called from Run when all data of a file has been restored.
+func (r *Restore) completeFile(location string) {
+	if r.v >= 3 {
+		r.print(verboseUpdate{
+			MessageType: "verbose_status",
+			Action:      "restored",
+			Item:        location,
+		})
+	}
+	r.summary.Lock()
+	r.summary.Files.Restored++
+	r.summary.Unlock()
+}
+
+// update prints a status message, nothing is printed with --quiet.
+func (r *Restore) update(total, processed counter, errors uint, secs uint64) {
+	if r.v < 1 {
+		return
+	}
+
+	status := restoreStatusUpdate{
+		MessageType:      "status",
+		SecondsElapsed:   uint64(time.Since(r.start) / time.Second),
+		SecondsRemaining: secs,
+		TotalFiles:       total.Files,
+		FilesRestored:    processed.Files,
+		TotalBytes:       total.Bytes,
+		BytesRestored:    processed.Bytes,
+		ErrorCount:       errors,
+	}
+
+	if total.Bytes > 0 {
+		status.PercentDone = float64(processed.Bytes) / float64(total.Bytes)
+	}
+
+	r.print(status)
+}
+
+// Error is the error callback function for the restorer, it prints the error
+// and returns nil.
+func (r *Restore) Error(location string, err error) error {
+	r.error(errorUpdate{
+		MessageType: "error",
+		Error:       err,
+		During:      "restore",
+		Item:        location,
+	})
+	select {
+	case r.errCh <- struct{}{}:
+	case <-r.finished:
+	}
+	return nil
+}
+
+// AddFile is called by the restorer for each file which is going to be
+// restored.
+func (r *Restore) AddFile(location string, size uint64) {
+	select {
+	case r.fileCh <- restoreFile{location: location, size: size}:
+	case <-r.finished:
+	}
+}
+
+// AddProgress is called by the restorer when data of a file has been
+// restored.
+func (r *Restore) AddProgress(location string, bytes uint64) {
+	select {
+	case r.processedCh <- restoreBlob{location: location, bytes: bytes}:
+	case <-r.finished:
+	}
+}
+
+// ReportSkipped is called by the restorer for existing items which are not
+// overwritten, it prints the item in verbose mode.
+func (r *Restore) ReportSkipped(location string) {
+	if r.v >= 2 {
+		r.print(verboseUpdate{
+			MessageType: "verbose_status",
+			Action:      "skipped",
+			Item:        location,
+		})
+	}
+	r.summary.Lock()
+	r.summary.Files.Skipped++
+	r.summary.Unlock()
+}
+
+// ReportDeleted is called by the restorer for items which have been removed
+// from the target directory, it prints the item in verbose mode.
+func (r *Restore) ReportDeleted(location string) {
+	if r.v >= 2 {
+		r.print(verboseUpdate{
+			MessageType: "verbose_status",
+			Action:      "deleted",
+			Item:        location,
+		})
+	}
+	r.summary.Lock()
+	r.summary.Files.Deleted++
+	r.summary.Unlock()
+}
+
+// Finish prints the finishing messages.
+func (r *Restore) Finish() {
+	close(r.finished)
+
+	r.summary.Lock()
+	defer r.summary.Unlock()
+
+	r.print(restoreSummaryOutput{
+		MessageType:   "summary",
+		TotalFiles:    r.summary.TotalFiles,
+		FilesRestored: r.summary.Files.Restored,
+		FilesSkipped:  r.summary.Files.Skipped,
+		FilesDeleted:  r.summary.Files.Deleted,
+		TotalBytes:    r.summary.TotalBytes,
+		BytesRestored: r.summary.RestoredBytes,
+		ErrorCount:    r.summary.Errors,
+		TotalDuration: time.Since(r.start).Seconds(),
+	})
+}
+
+// SetMinUpdatePause sets r.MinUpdatePause.
+func (r *Restore) SetMinUpdatePause(d time.Duration) {
+	r.MinUpdatePause = d
+}
+
+type restoreStatusUpdate struct {
+	MessageType      string  `json:"message_type"` // "status"
+	SecondsElapsed   uint64  `json:"seconds_elapsed,omitempty"`
+	SecondsRemaining uint64  `json:"seconds_remaining,omitempty"`
+	PercentDone      float64 `json:"percent_done"`
+	TotalFiles       uint64  `json:"total_files,omitempty"`
+	FilesRestored    uint64  `json:"files_restored,omitempty"`
+	TotalBytes       uint64  `json:"total_bytes,omitempty"`
+	BytesRestored    uint64  `json:"bytes_restored,omitempty"`
+	ErrorCount       uint    `json:"error_count,omitempty"`
+}
+
+type restoreSummaryOutput struct {
+	MessageType   string  `json:"message_type"` // "summary"
+	TotalFiles    uint    `json:"total_files"`
+	FilesRestored uint    `json:"files_restored"`
+	FilesSkipped  uint    `json:"files_skipped"`
+	FilesDeleted  uint    `json:"files_deleted"`
+	TotalBytes    uint64  `json:"total_bytes"`
+	BytesRestored uint64  `json:"bytes_restored"`
+	ErrorCount    uint    `json:"error_count"`
+	TotalDuration float64 `json:"total_duration"` // in seconds
+}
@@ -0,0 +1,251 @@
+package ui
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/ui/termstatus"
+)
+
+type restoreFile struct {
+	location string
+	size     uint64
+}
+
+type restoreBlob struct {
+	location string
+	bytes    uint64
+}
+
+// Restore reports progress for the `restore` command.
+type Restore struct {
+	*Message
+	*StdioWrapper
+
+	MinUpdatePause time.Duration
+
+	term  *termstatus.Terminal
+	v     uint
+	start time.Time
+
+	fileCh      chan restoreFile
+	processedCh chan restoreBlob
+	errCh       chan struct{}
+	finished    chan struct{}
+
+	summary struct {
+		sync.Mutex
+		Files struct {
+			Restored uint
+			Skipped  uint
+			Deleted  uint
+		}
+		RestoredBytes uint64
+	}
+}
+
+// NewRestore returns a new restore progress reporter.
+func NewRestore(term *termstatus.Terminal, verbosity uint) *Restore {
+	return &Restore{
+		Message:      NewMessage(term, verbosity),
+		StdioWrapper: NewStdioWrapper(term),
+		term:         term,
+		v:            verbosity,
+		start:        time.Now(),
+
+		// limit to 60fps by default
+		MinUpdatePause: time.Second / 60,
+
+		fileCh:      make(chan restoreFile),
+		processedCh: make(chan restoreBlob),
+		errCh:       make(chan struct{}),
+		finished:    make(chan struct{}),
+	}
+}
+
+// Run regularly updates the status lines. It should be called in a separate
+// goroutine.
+func (r *Restore) Run(ctx context.Context) error {
+	var (
+		lastUpdate       time.Time
+		total, processed counter
+		errors           uint
+		started          bool
+		remaining        = make(map[string]uint64)
+		secondsRemaining uint64
+	)
+
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-r.finished:
+			started = false
+			r.term.SetStatus([]string{""})
+		case f := <-r.fileCh:
+			total.Files++
+			total.Bytes += f.size
+			if f.size == 0 {
+				processed.Files++
+				r.completeFile(f.location)
+			} else {
+				remaining[f.location] = f.size
+			}
+			started = true
+		case b := <-r.processedCh:
+			processed.Bytes += b.bytes
+			r.summary.Lock()
+			r.summary.RestoredBytes += b.bytes
+			r.summary.Unlock()
+
+			if b.bytes >= remaining[b.location] {
+				delete(remaining, b.location)
+				processed.Files++
+				r.completeFile(b.location)
+			} else {
+				remaining[b.location] -= b.bytes
+			}
+			started = true
+		case <-r.errCh:
+			errors++
+			started = true
+		case <-t.C:
+			if !started {
+				continue
+			}
+
+			if processed.Bytes > 0 && processed.Bytes < total.Bytes {
+				secs := float64(time.Since(r.start) / time.Second)
+				todo := float64(total.Bytes - processed.Bytes)
+				secondsRemaining = uint64(secs / float64(processed.Bytes) * todo)
+			}
+		}
+
+		// limit update frequency
+		if time.Since(lastUpdate) < r.MinUpdatePause {
+			continue
+		}
+		lastUpdate = time.Now()
+
+		r.update(total, processed, errors, secondsRemaining)
+	}
+}
+
+// completeFile is called from Run when all data of a file has been restored.
+func (r *Restore) completeFile(location string) {
+	r.VV("restored  %v", location)
+	r.summary.Lock()
+	r.summary.Files.Restored++
+	r.summary.Unlock()
+}
+
+// update updates the status lines.
+func (r *Restore) update(total, processed counter, errors uint, secs uint64) {
+	var eta, percent string
+
+	if secs > 0 && processed.Bytes < total.Bytes {
+		eta = fmt.Sprintf(" ETA %s", formatSeconds(secs))
+		percent = formatPercent(processed.Bytes, total.Bytes)
+		percent += "  "
+	}
+
+	status := fmt.Sprintf("[%s] %s%v files %s, total %v files %v, %d errors, %s%s",
+		formatDuration(time.Since(r.start)),
+		percent,
+		processed.Files,
+		formatBytes(processed.Bytes),
+		total.Files,
+		formatBytes(total.Bytes),
+		errors,
+		formatRate(processed.Bytes, time.Since(r.start)),
+		eta,
+	)
+
+	r.term.SetStatus([]string{status})
+}
+
+// formatRate returns the throughput for bytes processed in d.
+func formatRate(bytes uint64, d time.Duration) string {
+	if d < time.Second {
+		d = time.Second
+	}
+
+	return formatBytes(uint64(float64(bytes)/d.Seconds())) + "/s"
+}
+
+// Error is the error callback function for the restorer, it prints the error
+// and returns nil.
+func (r *Restore) Error(location string, err error) error {
+	r.E("ignoring error for %s: %s\n", location, err)
+	select {
+	case r.errCh <- struct{}{}:
+	case <-r.finished:
+	}
+	return nil
+}
+
+// AddFile is called by the restorer for each file which is going to be
+// restored.
+func (r *Restore) AddFile(location string, size uint64) {
+	select {
+	case r.fileCh <- restoreFile{location: location, size: size}:
+	case <-r.finished:
+	}
+}
+
+// AddProgress is called by the restorer when data of a file has been
+// restored.
+func (r *Restore) AddProgress(location string, bytes uint64) {
+	select {
+	case r.processedCh <- restoreBlob{location: location, bytes: bytes}:
+	case <-r.finished:
+	}
+}
+
+// ReportSkipped is called by the restorer for existing items which are not
+// overwritten, it prints the item in verbose mode.
+func (r *Restore) ReportSkipped(location string) {
+	r.V("skipped   %v", location)
+	r.summary.Lock()
+	r.summary.Files.Skipped++
+	r.summary.Unlock()
+}
+
+// ReportDeleted is called by the restorer for items which have been removed
+// from the target directory, it prints the item in verbose mode.
+func (r *Restore) ReportDeleted(location string) {
+	r.V("deleted   %v", location)
+	r.summary.Lock()
+	r.summary.Files.Deleted++
+	r.summary.Unlock()
+}
+
+// Finish prints the finishing messages.
+func (r *Restore) Finish() {
+	close(r.finished)
+
+	r.summary.Lock()
+	defer r.summary.Unlock()
+
+	d := time.Since(r.start)
+
+	r.P("\n")
+	r.P("Files:       %5d restored, %5d skipped, %5d deleted\n", r.summary.Files.Restored, r.summary.Files.Skipped, r.summary.Files.Deleted)
+	r.P("\n")
+	r.P("restored %v files, %v in %s (%s)\n",
+		r.summary.Files.Restored,
+		formatBytes(r.summary.RestoredBytes),
+		formatDuration(d),
+		formatRate(r.summary.RestoredBytes, d),
+	)
+}
+
+// SetMinUpdatePause sets r.MinUpdatePause.
+func (r *Restore) SetMinUpdatePause(d time.Duration) {
+	r.MinUpdatePause = d
+}
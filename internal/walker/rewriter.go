@@ -0,0 +1,122 @@
+package walker
+
+import (
+	"context"
+	"path"
+
+	"github.com/pkg/errors"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/restic"
+)
+
+// TreeLoadSaver loads trees from and saves trees to a repository.
+type TreeLoadSaver interface {
+	TreeLoader
+	SaveTree(context.Context, *restic.Tree) (restic.ID, error)
+}
+
+// SelectByNameFunc returns true for all items that should be kept in the
+// tree. If false is returned for a dir, it is removed together with all its
+// contents. The path is the slash-separated path from the root node.
+type SelectByNameFunc func(path string) bool
+
+// TreeFilterVisitor configures FilterTree. All fields are optional.
+type TreeFilterVisitor struct {
+	// SelectByName decides which nodes are kept, if it is nil all nodes are
+	// kept.
+	SelectByName SelectByNameFunc
+	// PrintExclude is called for each node which is removed by SelectByName.
+	PrintExclude func(path string)
+	// RewriteNode is called for each node which is kept and returns the node
+	// to store in the new tree, or nil to remove the node. The node must not
+	// be modified in place, a modified copy has to be returned instead.
+	RewriteNode func(node *restic.Node, path string) *restic.Node
+	// RewriteFailedTree is called when the tree nodeID cannot be loaded. It
+	// returns the ID of the tree which replaces it. If the null ID is
+	// returned, the dir node is removed. If RewriteFailedTree is nil, the
+	// error is returned.
+	RewriteFailedTree func(nodeID restic.ID, path string, err error) (restic.ID, error)
+}
+
+// FilterTree removes all nodes rejected by visitor.SelectByName from the tree
+// nodeID and its subtrees, rewrites the remaining nodes using
+// visitor.RewriteNode and returns the ID of the new tree. Only trees which
+// have been modified are saved, if nothing was changed nodeID is returned
+// unchanged.
+func FilterTree(ctx context.Context, repo TreeLoadSaver, nodepath string, nodeID restic.ID, visitor *TreeFilterVisitor) (newNodeID restic.ID, err error) {
+	curTree, err := repo.LoadTree(ctx, nodeID)
+	if err != nil {
+		if visitor.RewriteFailedTree != nil {
+			return visitor.RewriteFailedTree(nodeID, nodepath, err)
+		}
+		return restic.ID{}, err
+	}
+
+	changed := false
+	tb := restic.NewTree()
+	for _, node := range curTree.Nodes {
+		if ctx.Err() != nil {
+			return restic.ID{}, ctx.Err()
+		}
+
+		p := path.Join(nodepath, node.Name)
+		if visitor.SelectByName != nil && !visitor.SelectByName(p) {
+			debug.Log("removing %v", p)
+			if visitor.PrintExclude != nil {
+				visitor.PrintExclude(p)
+			}
+			changed = true
+			continue
+		}
+
+		if visitor.RewriteNode != nil {
+			newNode := visitor.RewriteNode(node, p)
+			if newNode != node {
+				changed = true
+			}
+			if newNode == nil {
+				debug.Log("removing %v", p)
+				continue
+			}
+			node = newNode
+		}
+
+		if node.Type == "dir" {
+			if node.Subtree == nil {
+				return restic.ID{}, errors.Errorf("dir node %v has no subtree", p)
+			}
+
+			newID, err := FilterTree(ctx, repo, p, *node.Subtree, visitor)
+			if err != nil {
+				return restic.ID{}, err
+			}
+
+			if newID.IsNull() {
+				// the subtree could not be loaded and is removed
+				debug.Log("removing %v", p)
+				changed = true
+				continue
+			}
+
+			if !newID.Equal(*node.Subtree) {
+				// do not modify the node of the loaded tree, it may be cached
+				newNode := *node
+				newNode.Subtree = &newID
+				node = &newNode
+				changed = true
+			}
+		}
+
+		err = tb.Insert(node)
+		if err != nil {
+			return restic.ID{}, err
+		}
+	}
+
+	if !changed {
+		return nodeID, nil
+	}
+
+	return repo.SaveTree(ctx, tb)
+}
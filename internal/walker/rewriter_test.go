@@ -0,0 +1,229 @@
+package walker
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// WritableTreeMap also supports saving trees.
+type WritableTreeMap struct {
+	TreeMap
+}
+
+func (t WritableTreeMap) SaveTree(ctx context.Context, tree *restic.Tree) (restic.ID, error) {
+	buf, err := json.Marshal(tree)
+	if err != nil {
+		return restic.ID{}, err
+	}
+
+	id := restic.Hash(buf)
+	t.TreeMap[id] = tree
+	return id, nil
+}
+
+// collectPaths returns all paths in the tree id, in the order they are
+// visited by Walk.
+func collectPaths(t testing.TB, repo TreeLoader, id restic.ID) []string {
+	var paths []string
+	err := Walk(context.TODO(), repo, id, nil, func(_ restic.ID, path string, node *restic.Node, err error) (bool, error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if node != nil {
+			paths = append(paths, path)
+		}
+		return false, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return paths
+}
+
+func TestFilterTree(t *testing.T) {
+	var tests = []struct {
+		tree     TestTree
+		exclude  []string
+		want     []string
+		excluded []string
+	}{
+		{
+			tree: TestTree{
+				"foo": TestFile{},
+				"bar": TestFile{},
+			},
+			want: []string{"/bar", "/foo"},
+		},
+		{
+			tree: TestTree{
+				"foo":    TestFile{},
+				"secret": TestFile{},
+			},
+			exclude:  []string{"/secret"},
+			want:     []string{"/foo"},
+			excluded: []string{"/secret"},
+		},
+		{
+			tree: TestTree{
+				"home": TestTree{
+					"user": TestTree{
+						"build": TestTree{
+							"artifact": TestFile{},
+						},
+						"work": TestFile{},
+					},
+				},
+				"etc": TestTree{
+					"passwd": TestFile{},
+				},
+			},
+			exclude:  []string{"/home/user/build"},
+			want:     []string{"/etc", "/etc/passwd", "/home", "/home/user", "/home/user/work"},
+			excluded: []string{"/home/user/build"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run("", func(t *testing.T) {
+			m, root := BuildTreeMap(test.tree)
+			repo := WritableTreeMap{m}
+
+			var excluded []string
+			visitor := &TreeFilterVisitor{
+				SelectByName: func(path string) bool {
+					for _, pattern := range test.exclude {
+						if strings.HasPrefix(path, pattern) {
+							return false
+						}
+					}
+					return true
+				},
+				PrintExclude: func(path string) {
+					excluded = append(excluded, path)
+				},
+			}
+
+			newRoot, err := FilterTree(context.TODO(), repo, "/", root, visitor)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(test.exclude) == 0 && !newRoot.Equal(root) {
+				t.Errorf("tree was modified although nothing was excluded")
+			}
+
+			got := collectPaths(t, repo, newRoot)
+			if strings.Join(got, ",") != strings.Join(test.want, ",") {
+				t.Errorf("wrong paths, want %v, got %v", test.want, got)
+			}
+
+			if strings.Join(excluded, ",") != strings.Join(test.excluded, ",") {
+				t.Errorf("wrong excluded paths, want %v, got %v", test.excluded, excluded)
+			}
+
+			// the original tree must not have been modified
+			paths := collectPaths(t, repo, root)
+			for _, p := range test.excluded {
+				found := false
+				for _, q := range paths {
+					if p == q {
+						found = true
+					}
+				}
+				if !found {
+					t.Errorf("path %v missing in the original tree", p)
+				}
+			}
+		})
+	}
+}
+
+func TestFilterTreeRewriteNode(t *testing.T) {
+	m, root := BuildTreeMap(TestTree{
+		"foo": TestFile{},
+		"subdir": TestTree{
+			"lost":   TestFile{},
+			"subfoo": TestFile{},
+		},
+		"broken": TestTree{
+			"bar": TestFile{},
+		},
+		"removed": TestTree{
+			"baz": TestFile{},
+		},
+	})
+	repo := WritableTreeMap{m}
+
+	// find the subtrees which fail to load
+	rootTree, err := repo.LoadTree(context.TODO(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failing := make(map[restic.ID]bool)
+	for _, node := range rootTree.Nodes {
+		if node.Name == "broken" || node.Name == "removed" {
+			failing[*node.Subtree] = true
+		}
+	}
+
+	loader := failingTreeMap{WritableTreeMap: repo, failing: failing}
+	newRoot, err := FilterTree(context.TODO(), loader, "/", root, &TreeFilterVisitor{
+		RewriteNode: func(node *restic.Node, path string) *restic.Node {
+			if path == "/subdir/lost" {
+				return nil
+			}
+			if path == "/foo" {
+				newNode := *node
+				newNode.Size = 42
+				return &newNode
+			}
+			return node
+		},
+		RewriteFailedTree: func(nodeID restic.ID, path string, err error) (restic.ID, error) {
+			if path == "/removed" {
+				return restic.ID{}, nil
+			}
+			return repo.SaveTree(context.TODO(), restic.NewTree())
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"/broken", "/foo", "/subdir", "/subdir/subfoo"}
+	got := collectPaths(t, repo, newRoot)
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("wrong paths, want %v, got %v", want, got)
+	}
+
+	newTree, err := repo.LoadTree(context.TODO(), newRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node := newTree.Find("foo"); node == nil || node.Size != 42 {
+		t.Errorf("node foo was not rewritten: %v", node)
+	}
+
+	// the original tree must be unchanged
+	if node := rootTree.Find("foo"); node.Size != 0 {
+		t.Errorf("original node foo was modified: %v", node)
+	}
+}
+
+// failingTreeMap returns an error for all trees in failing.
+type failingTreeMap struct {
+	WritableTreeMap
+	failing map[restic.ID]bool
+}
+
+func (t failingTreeMap) LoadTree(ctx context.Context, id restic.ID) (*restic.Tree, error) {
+	if t.failing[id] {
+		return nil, errors.New("tree not readable")
+	}
+	return t.WritableTreeMap.LoadTree(ctx, id)
+}